@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/signals"
+	"github.com/falcosecurity/driverkit/validate"
+	"github.com/go-playground/validator/v10"
+	"github.com/olekukonko/tablewriter"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// NewAuditMirrorsCmd creates the `driverkit audit-mirrors` command.
+func NewAuditMirrorsCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	auditMirrorsCmd := &cobra.Command{
+		Use:   "audit-mirrors",
+		Short: "Resolve the kernel headers of a list of kernels against each mirror and report their availability and checksums.",
+		Run: func(c *cobra.Command, args []string) {
+			logger.Info("auditing mirrors, it will take a while")
+			if err := auditMirrorsRun(os.Stdout, rootOpts); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+		},
+	}
+
+	// Add audit-mirrors options flags
+	flags := auditMirrorsCmd.Flags()
+	addAuditMirrorsFlags(flags)
+	auditMirrorsCmd.PersistentFlags().AddFlagSet(flags)
+	// Add root flags: they act as defaults for each kernel
+	auditMirrorsCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return auditMirrorsCmd
+}
+
+func auditMirrorsRun(out io.Writer, rootOpts *RootOptions) error {
+	if err := validate.V.Struct(auditMirrorsOptions); err != nil {
+		for _, e := range err.(validator.ValidationErrors) {
+			logger.WithError(fmt.Errorf(e.Translate(validate.T))).Error("error validating audit-mirrors options")
+		}
+		return fmt.Errorf("exiting for validation errors")
+	}
+
+	builds, err := loadKernelBuilds(auditMirrorsOptions.Kernels, rootOpts)
+	if err != nil {
+		return err
+	}
+	if configOptions.DryRun {
+		return nil
+	}
+
+	ctx := signals.WithStandardSignals(context.Background())
+	inconsistent := 0
+	for _, b := range builds {
+		v, err := builder.BuilderForTarget(b.TargetType)
+		if err != nil {
+			return err
+		}
+		kr := b.KernelReleaseFromBuildConfig()
+		audit, err := builder.AuditMirrors(ctx, v, b.ToConfig(), kr, auditMirrorsOptions.Mirrors)
+		if err != nil {
+			return fmt.Errorf("kernel %s: %w", b.KernelRelease, err)
+		}
+		if len(audit.Packages) == 0 {
+			logger.WithField("kernelrelease", b.KernelRelease).Warn("kernel headers not found into any mirror")
+		}
+		inconsistent += writeMirrorAudit(out, b, audit)
+	}
+
+	if inconsistent > 0 {
+		return fmt.Errorf("%d packages differ across the mirrors", inconsistent)
+	}
+	return nil
+}
+
+// writeMirrorAudit writes the availability and checksum matrix of the packages of the kernel of b
+// across the mirrors, one column per mirror, returning the number of packages differing across them.
+func writeMirrorAudit(out io.Writer, b *builder.Build, audit *builder.MirrorAudit) int {
+	fmt.Fprintf(out, "\n%s %s (%s)\n", b.TargetType, b.KernelRelease, b.Architecture)
+	table := tablewriter.NewWriter(out)
+	table.SetHeader(append(append([]string{"Package"}, audit.Mirrors...), "Checksums"))
+	table.SetAutoFormatHeaders(false)
+	table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+	table.SetCenterSeparator("|")
+
+	inconsistent := 0
+	for _, name := range audit.PackageNames() {
+		row := []string{name}
+		for _, p := range audit.Packages[name] {
+			if !p.Available {
+				row = append(row, "missing")
+				continue
+			}
+			row = append(row, p.SHA256[:12])
+		}
+		if audit.Consistent(name) {
+			row = append(row, "match")
+		} else {
+			row = append(row, "MISMATCH")
+			inconsistent++
+		}
+		table.Append(row)
+	}
+	table.Render()
+	return inconsistent
+}
@@ -0,0 +1,18 @@
+package cmd
+
+import (
+	flag "github.com/spf13/pflag"
+)
+
+var auditMirrorsOptions = &AuditMirrorsOptions{}
+
+// AuditMirrorsOptions represent the flags of the audit-mirrors command.
+type AuditMirrorsOptions struct {
+	Kernels string   `validate:"required,file" name:"kernels file"`
+	Mirrors []string `validate:"omitempty,dive,url" name:"mirrors"`
+}
+
+func addAuditMirrorsFlags(flags *flag.FlagSet) {
+	flags.StringVar(&auditMirrorsOptions.Kernels, "kernels", "", "yaml file listing the kernels to audit under the 'kernels' key, each one with the same format of the config file")
+	flags.StringSliceVar(&auditMirrorsOptions.Mirrors, "mirror", nil, "list of base urls of the mirrors to audit, in place of the default ones of the targets (e.g. --mirror https://mirrors.edge.kernel.org/ubuntu/pool/main/l)")
+}
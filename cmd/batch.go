@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/signals"
+	"github.com/falcosecurity/driverkit/validate"
+	"github.com/go-playground/validator/v10"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+type batchFile struct {
+	Builds []yaml.Node `yaml:"builds"`
+}
+
+type batchPriority struct {
+	Priority int `yaml:"priority"`
+}
+
+// NewBatchCmd creates the `driverkit batch` command.
+func NewBatchCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	batchCmd := &cobra.Command{
+		Use:   "batch",
+		Short: "Build a batch of Falco kernel modules and eBPF probes against a docker daemon.",
+		Run: func(c *cobra.Command, args []string) {
+			logger.WithField("processor", c.Name()).Info("driver building, it will take a while")
+			if err := batchRun(rootOpts); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+		},
+	}
+
+	// Add batch options flags
+	flags := batchCmd.Flags()
+	addBatchFlags(flags)
+	batchCmd.PersistentFlags().AddFlagSet(flags)
+	// Add root flags: they act as defaults for each build of the batch
+	batchCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return batchCmd
+}
+
+func batchRun(rootOpts *RootOptions) error {
+	if err := validate.V.Struct(batchOptions); err != nil {
+		for _, e := range err.(validator.ValidationErrors) {
+			logger.WithError(fmt.Errorf(e.Translate(validate.T))).Error("error validating batch options")
+		}
+		return fmt.Errorf("exiting for validation errors")
+	}
+
+	jobs, err := loadBatchJobs(batchOptions.File, rootOpts)
+	if err != nil {
+		return err
+	}
+	if configOptions.DryRun {
+		return nil
+	}
+
+	bp := driverbuilder.NewBatchBuildProcessor(
+		driverbuilder.NewDockerBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy")),
+		batchOptions.Parallelism,
+		batchOptions.Deadline,
+		batchOptions.DeadlineGrace,
+	)
+	report := bp.Run(signals.WithStandardSignals(context.Background()), jobs)
+
+	for _, j := range report.Unmet {
+		logger.WithField("kernelrelease", j.Build.KernelRelease).
+			WithField("target", j.Build.TargetType).
+			Error("build did not complete in time")
+	}
+	logger.WithField("built", len(report.Built)).
+		WithField("failed", len(report.Failed)).
+		WithField("unmet", len(report.Unmet)).
+		Info("batch completed")
+	if len(report.Unmet) > 0 {
+		return fmt.Errorf("%d builds did not complete in time", len(report.Unmet))
+	}
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d builds failed", len(report.Failed))
+	}
+	return nil
+}
+
+// loadBatchJobs reads the builds of the batch from the given file,
+// using the values of rootOpts as defaults for each one of them.
+func loadBatchJobs(path string, rootOpts *RootOptions) ([]driverbuilder.BatchJob, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var bf batchFile
+	if err := yaml.Unmarshal(data, &bf); err != nil {
+		return nil, err
+	}
+
+	var jobs []driverbuilder.BatchJob
+	for i, node := range bf.Builds {
+		opts := *rootOpts
+		if err := node.Decode(&opts); err != nil {
+			return nil, fmt.Errorf("build #%d: %w", i, err)
+		}
+		var p batchPriority
+		if err := node.Decode(&p); err != nil {
+			return nil, fmt.Errorf("build #%d: %w", i, err)
+		}
+		if opts.Target != rootOpts.Target {
+			// the mirrors of the alias of the default target do not apply to the others
+			opts.mirrors = nil
+		}
+		if err := opts.applyTargetAlias(); err != nil {
+			return nil, fmt.Errorf("build #%d: %w", i, err)
+		}
+		opts.normalizeTarget()
+		opts.normalizeArchitecture()
+		opts.fillFromKernelConfig()
+		opts.applyOutputLoader()
+		if errs := opts.Validate(); errs != nil {
+			for _, err := range errs {
+				logger.WithError(err).WithField("build", i).Error("error validating build options")
+			}
+			return nil, fmt.Errorf("exiting for validation errors")
+		}
+		jobs = append(jobs, driverbuilder.BatchJob{Build: opts.toBuild(), Priority: p.Priority})
+	}
+	return jobs, nil
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var batchOptions = &BatchOptions{}
+
+// BatchOptions represent the flags of the batch command.
+type BatchOptions struct {
+	File          string        `validate:"required,file" name:"batch file"`
+	Deadline      time.Duration `validate:"min=0" name:"deadline"`
+	DeadlineGrace time.Duration `validate:"min=0" name:"deadline grace period"`
+	Parallelism   int           `validate:"min=1" name:"parallelism"`
+}
+
+func addBatchFlags(flags *flag.FlagSet) {
+	flags.StringVarP(&batchOptions.File, "file", "f", "", "yaml file listing the builds of the batch under the 'builds' key, each one with the same format of the config file plus an optional 'priority'")
+	flags.DurationVar(&batchOptions.Deadline, "deadline", 0, "time window the whole batch must complete within (e.g. 2h30m), unlimited if zero")
+	flags.DurationVar(&batchOptions.DeadlineGrace, "deadline-grace", 0, "period before the deadline during which builds with a priority lower or equal to zero are cancelled and not started anymore")
+	flags.IntVar(&batchOptions.Parallelism, "parallelism", 1, "maximum number of builds running at the same time")
+}
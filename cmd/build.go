@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/signals"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// NewBuildCmd creates the `driverkit build` command.
+func NewBuildCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	buildCmd := &cobra.Command{
+		Use:   "build",
+		Short: "Build Falco kernel modules and eBPF probes picking the processor automatically.",
+		Long:  "Build Falco kernel modules and eBPF probes picking the processor automatically.\n\nWith --dryrun, the build script gets printed to stdout, rendered for the given options, without building anything.",
+		Run: func(c *cobra.Command, args []string) {
+			if configOptions.DryRun {
+				if err := printScript(c.OutOrStdout(), rootOpts); err != nil {
+					logger.WithError(err).Fatal("exiting")
+				}
+				return
+			}
+			bp, err := selectBuildProcessor(buildOptions.Processor, driverbuilder.NewLocalBuildProcessor(viper.GetInt("timeout")))
+			if err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+			logger.WithField("processor", bp.String()).Info("driver building, it will take a few seconds")
+			if err := buildRun(bp, rootOpts); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+		},
+	}
+
+	// Add build options flags
+	flags := buildCmd.Flags()
+	addBuildFlags(flags)
+	buildCmd.PersistentFlags().AddFlagSet(flags)
+	// Add root flags
+	buildCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return buildCmd
+}
+
+// autoBuildProcessor is the name picking the processor building on the running host by itself.
+const autoBuildProcessor = "auto"
+
+// selectBuildProcessor returns the processor with the given name, building on the running host,
+// or with autoBuildProcessor the local one when the host has the toolchain, the docker one otherwise.
+func selectBuildProcessor(name string, local *driverbuilder.LocalBuildProcessor) (driverbuilder.BuildProcessor, error) {
+	switch name {
+	case autoBuildProcessor:
+		if local.HasToolchain() {
+			return local, nil
+		}
+		logger.Debug("the host lacks the toolchain of the local builds, building with docker")
+		fallthrough
+	case driverbuilder.DockerBuildProcessorName:
+		return driverbuilder.NewDockerBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy")), nil
+	case driverbuilder.LocalBuildProcessorName:
+		return local, nil
+	}
+	return nil, fmt.Errorf("unknown processor %q, expected one of %s, %s and %s", name, autoBuildProcessor, driverbuilder.DockerBuildProcessorName, driverbuilder.LocalBuildProcessorName)
+}
+
+func buildRun(bp driverbuilder.BuildProcessor, rootOpts *RootOptions) error {
+	for _, p := range []string{rootOpts.Output.Module, rootOpts.Output.Probe} {
+		if len(p) > 0 {
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return err
+			}
+		}
+	}
+	return bp.Start(rootOpts.toBuild())
+}
+
+// printScript writes to w the build script the build of rootOpts would run.
+func printScript(w io.Writer, rootOpts *RootOptions) error {
+	b := rootOpts.toBuild()
+	script, err := builder.RenderScript(signals.WithStandardSignals(context.Background()), b.TargetType, b.ToConfig(), b.KernelReleaseFromBuildConfig())
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(w, script)
+	return err
+}
+
+// fillFromRunningHost fills the build options not explicitly set on c with the ones of the running host.
+func fillFromRunningHost(c *cobra.Command, rootOpts *RootOptions) error {
+	h, err := detectHost("/")
+	if err != nil {
+		return err
+	}
+	home, _ := os.UserHomeDir()
+	if err := rootOpts.fillFromHost(h, home, c.Flags().Changed); err != nil {
+		return err
+	}
+	logger.WithField("target", rootOpts.Target).
+		WithField("kernelrelease", rootOpts.KernelRelease).
+		WithField("kernelversion", rootOpts.KernelVersion).
+		Info("build options detected from the running host")
+	return nil
+}
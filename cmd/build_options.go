@@ -0,0 +1,18 @@
+package cmd
+
+import (
+	flag "github.com/spf13/pflag"
+)
+
+var buildOptions = &BuildOptions{}
+
+// BuildOptions represent the flags of the build command.
+type BuildOptions struct {
+	Here      bool
+	Processor string
+}
+
+func addBuildFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&buildOptions.Here, "here", false, "build for the kernel of the running host, detecting the options not set from it (os-release, /proc/version and kernel config) and writing the kernel module where the Falco driver loader looks for it, unless an output is set")
+	flags.StringVar(&buildOptions.Processor, "processor", autoBuildProcessor, "processor building the drivers, either docker, local or auto: the local one when the host has the toolchain (bash, curl, tar, make and gcc), the docker one otherwise")
+}
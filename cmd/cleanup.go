@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewCleanupCmd creates the `driverkit cleanup` command.
+func NewCleanupCmd() *cobra.Command {
+	dryRun := false
+	cleanupCmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Remove the docker build containers left behind by driverkit runs that are not alive anymore.",
+		Long: `Remove the docker build containers left behind by driverkit runs that are not alive anymore.
+
+Only the docker builds of the running host are cleaned up. The pods and config maps of the kubernetes builds
+are labeled with org.falcosecurity/driverkit-uid too, their leftovers can be removed with:
+
+  kubectl delete pods,configmaps -l org.falcosecurity/driverkit-uid`,
+		Run: func(c *cobra.Command, args []string) {
+			logger.WithField("processor", "docker").Info("looking for dangling build containers")
+			removed, err := driverbuilder.CleanupDockerContainers(context.Background(), dryRun)
+			if err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+			logger.WithField("count", len(removed)).WithField("dryrun", dryRun).Info("cleanup done")
+		},
+	}
+	cleanupCmd.Flags().BoolVar(&dryRun, "dryrun", dryRun, "only list the dangling build containers, without removing them")
+
+	return cleanupCmd
+}
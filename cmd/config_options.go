@@ -15,11 +15,14 @@ var configOptions *ConfigOptions
 
 // ConfigOptions represent the persistent configuration flags of driverkit.
 type ConfigOptions struct {
-	ConfigFile string
-	LogLevel   string `validate:"logrus" name:"log level" default:"info"`
-	Timeout    int    `validate:"number,min=30" default:"120" name:"timeout"`
-	ProxyURL   string `validate:"omitempty,proxy" name:"proxy url"`
-	DryRun     bool
+	ConfigFile   string
+	LogLevel     string `validate:"logrus" name:"log level" default:"info"`
+	LogFormat    string `validate:"oneof=text json" name:"log format" default:"text"`
+	Timeout      int    `validate:"number,min=30" default:"120" name:"timeout"`
+	ProxyURL     string `validate:"omitempty,proxy" name:"proxy url"`
+	OTelEndpoint string `validate:"omitempty,url" name:"otel endpoint"`
+	DryRun       bool
+	NoInput      bool
 
 	configErrors bool
 }
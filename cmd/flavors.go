@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/olekukonko/tablewriter"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// NewFlavorsCmd creates the `driverkit flavors` command.
+func NewFlavorsCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	flavorsCmd := &cobra.Command{
+		Use:   "flavors",
+		Short: "List the kernel flavors whose headers the resolution of the target is known to handle.",
+		Run: func(c *cobra.Command, args []string) {
+			if err := flavorsRun(os.Stdout, rootOpts); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+		},
+	}
+	// Add root flags
+	flavorsCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return flavorsCmd
+}
+
+func flavorsRun(out io.Writer, rootOpts *RootOptions) error {
+	if len(rootOpts.Target) == 0 {
+		return fmt.Errorf("missing the target")
+	}
+	v, err := builder.BuilderForTarget(builder.Type(rootOpts.Target))
+	if err != nil {
+		return err
+	}
+	fb, ok := v.(builder.FlavorsBuilder)
+	if !ok {
+		return fmt.Errorf("target %s resolves the headers of all the kernels the same way, whatever their flavor", rootOpts.Target)
+	}
+
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{"Flavor", "Example", "Description"})
+	table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+	table.SetCenterSeparator("|")
+	table.SetAutoWrapText(false)
+	for _, f := range fb.Flavors() {
+		table.Append([]string{f.Name, f.Example, f.Description})
+	}
+	table.Render()
+	return nil
+}
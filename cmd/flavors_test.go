@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestFlavors(t *testing.T) {
+	ro := NewRootOptions()
+	ro.Target = "ubuntu"
+	var out bytes.Buffer
+	assert.NilError(t, flavorsRun(&out, ro))
+	for _, flavor := range []string{"generic", "aws", "gcp", "intel-iotg", "realtime"} {
+		assert.Assert(t, strings.Contains(out.String(), "| "+flavor+" "), "missing the %s flavor:\n%s", flavor, out.String())
+	}
+
+	// the targets resolving all the kernels the same way have none
+	ro.Target = "centos"
+	assert.ErrorContains(t, flavorsRun(&out, ro), "target centos resolves the headers of all the kernels the same way")
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/validate"
+	"github.com/go-playground/validator/v10"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// buildMatrix is a GitHub Actions build matrix, to be consumed by `strategy.matrix` through `fromJSON`.
+type buildMatrix struct {
+	Include []buildMatrixEntry `json:"include"`
+}
+
+// buildMatrixEntry is the build key of a job of the matrix.
+type buildMatrixEntry struct {
+	Target        string `json:"target"`
+	Arch          string `json:"arch"`
+	Release       string `json:"release"`
+	KernelVersion string `json:"kernelversion"`
+	DriverVersion string `json:"driverversion"`
+}
+
+// NewGenMatrixCmd creates the `driverkit gen-matrix` command.
+func NewGenMatrixCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	genMatrixCmd := &cobra.Command{
+		Use:   "gen-matrix",
+		Short: "Print the GitHub Actions build matrix, as JSON, of a list of kernels.",
+		Run: func(c *cobra.Command, args []string) {
+			if err := genMatrixRun(os.Stdout, rootOpts); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+		},
+	}
+
+	// Add gen-matrix options flags
+	flags := genMatrixCmd.Flags()
+	addGenMatrixFlags(flags)
+	genMatrixCmd.PersistentFlags().AddFlagSet(flags)
+	// Add root flags: they act as defaults for each kernel
+	genMatrixCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return genMatrixCmd
+}
+
+func genMatrixRun(out io.Writer, rootOpts *RootOptions) error {
+	if err := validate.V.Struct(genMatrixOptions); err != nil {
+		for _, e := range err.(validator.ValidationErrors) {
+			logger.WithError(fmt.Errorf(e.Translate(validate.T))).Error("error validating gen-matrix options")
+		}
+		return fmt.Errorf("exiting for validation errors")
+	}
+
+	builds, err := loadKernelBuilds(genMatrixOptions.Kernels, rootOpts)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(out).Encode(newBuildMatrix(builds))
+}
+
+// newBuildMatrix returns the matrix with a job for each one of the given builds, in the same order.
+func newBuildMatrix(builds []*builder.Build) buildMatrix {
+	m := buildMatrix{Include: make([]buildMatrixEntry, 0, len(builds))}
+	for _, b := range builds {
+		m.Include = append(m.Include, buildMatrixEntry{
+			Target:        b.TargetType.String(),
+			Arch:          b.Architecture,
+			Release:       b.KernelRelease,
+			KernelVersion: b.KernelVersion,
+			DriverVersion: b.DriverVersion,
+		})
+	}
+	return m
+}
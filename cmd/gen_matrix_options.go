@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	flag "github.com/spf13/pflag"
+)
+
+var genMatrixOptions = &GenMatrixOptions{}
+
+// GenMatrixOptions represent the flags of the gen-matrix command.
+type GenMatrixOptions struct {
+	Kernels string `validate:"required,file" name:"kernels file"`
+}
+
+func addGenMatrixFlags(flags *flag.FlagSet) {
+	flags.StringVar(&genMatrixOptions.Kernels, "kernels", "", "yaml file listing the kernels to build under the 'kernels' key, each one with the same format of the config file")
+}
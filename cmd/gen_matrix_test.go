@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestGenMatrix(t *testing.T) {
+	kernels := filepath.Join(t.TempDir(), "kernels.yaml")
+	assert.NilError(t, os.WriteFile(kernels, []byte(`kernels:
+  - target: ubuntu-generic
+    architecture: amd64
+    kernelrelease: 5.15.0-52-generic
+    kernelversion: "58"
+  - target: centos
+    architecture: arm64
+    kernelrelease: 4.18.0-372.9.1.el8.aarch64
+  - target: debian
+    architecture: amd64
+    kernelrelease: 5.10.0-19-amd64
+    kernelversion: "1"
+    driverversion: 4.0.0+driver
+`), 0644))
+
+	ro := NewRootOptions()
+	ro.DriverVersion = "master"
+	ro.KernelVersion = "1"
+	genMatrixOptions.Kernels = kernels
+	defer func() { genMatrixOptions.Kernels = "" }()
+
+	var out bytes.Buffer
+	assert.NilError(t, genMatrixRun(&out, ro))
+
+	var m map[string][]map[string]string
+	assert.NilError(t, json.Unmarshal(out.Bytes(), &m))
+	assert.DeepEqual(t, m, map[string][]map[string]string{
+		"include": {
+			{"target": "ubuntu", "arch": "amd64", "release": "5.15.0-52-generic", "kernelversion": "58", "driverversion": "master"},
+			{"target": "centos", "arch": "arm64", "release": "4.18.0-372.9.1.el8.aarch64", "kernelversion": "1", "driverversion": "master"},
+			{"target": "debian", "arch": "amd64", "release": "5.10.0-19-amd64", "kernelversion": "1", "driverversion": "4.0.0+driver"},
+		},
+	})
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	logger "github.com/sirupsen/logrus"
+)
+
+// driverLoaderDir is where the Falco driver loader looks for the prebuilt drivers, relative to the home directory.
+const driverLoaderDir = ".falco"
+
+// hostInfo is what identifies the kernel of the running host.
+type hostInfo struct {
+	Target        string
+	KernelRelease string
+	KernelVersion string
+	Architecture  string
+	// KernelConfig is the content of the kernel config, if found.
+	KernelConfig []byte
+}
+
+// hostTargets maps the os-release IDs differing from the names of their targets.
+var hostTargets = map[string]builder.Type{
+	"rhel":                builder.TargetTypeRedhat,
+	"opensuse-leap":       builder.TargetTypeOpenSUSE,
+	"opensuse-tumbleweed": builder.TargetTypeOpenSUSE,
+}
+
+// detectHost reads the host info of the system whose root filesystem is at root, i.e. "/" for the running one.
+func detectHost(root string) (*hostInfo, error) {
+	osRelease, err := readOSRelease(root)
+	if err != nil {
+		return nil, err
+	}
+	procVersion, err := os.ReadFile(filepath.Join(root, "proc", "version"))
+	if err != nil {
+		return nil, err
+	}
+	pv, err := kernelrelease.ParseProcVersion(string(procVersion))
+	if err != nil {
+		return nil, err
+	}
+
+	h := &hostInfo{
+		Target:        hostTarget(osRelease["ID"], osRelease["VERSION_ID"]).String(),
+		KernelRelease: pv.Fullversion + pv.FullExtraversion,
+		KernelVersion: pv.KernelVersion,
+		Architecture:  runtime.GOARCH,
+	}
+	h.KernelConfig, err = readKernelConfig(root, h.KernelRelease)
+	if err != nil {
+		logger.WithError(err).Debug("kernel config not found")
+	}
+	return h, nil
+}
+
+// hostTarget returns the target of the given os-release ID and version.
+func hostTarget(id, versionID string) builder.Type {
+	if id == "amzn" {
+		switch versionID {
+		case "2":
+			return builder.TargetTypeAmazonLinux2
+		case "2022":
+			return builder.TargetTypeAmazonLinux2022
+		case "2023":
+			return builder.TargetTypeAmazonLinux2023
+		}
+		return builder.TargetTypeAmazonLinux
+	}
+	if t, ok := hostTargets[id]; ok {
+		return t
+	}
+	return builder.Type(id)
+}
+
+func readOSRelease(root string) (map[string]string, error) {
+	data, err := os.ReadFile(filepath.Join(root, "etc", "os-release"))
+	if os.IsNotExist(err) {
+		data, err = os.ReadFile(filepath.Join(root, "usr", "lib", "os-release"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		if k, v, ok := strings.Cut(strings.TrimSpace(s.Text()), "="); ok && !strings.HasPrefix(k, "#") {
+			res[k] = strings.Trim(v, `"'`)
+		}
+	}
+	return res, s.Err()
+}
+
+// readKernelConfig reads the config of the given kernel release, either from /boot or from /proc/config.gz.
+func readKernelConfig(root, kernelRelease string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(root, "boot", "config-"+kernelRelease))
+	if err == nil {
+		return data, nil
+	}
+	f, err := os.Open(filepath.Join(root, "proc", "config.gz"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// fillFromHost fills the build options not explicitly set with the ones of the given host,
+// writing the module where the driver loader looks for it into home, unless an output is set.
+func (ro *RootOptions) fillFromHost(h *hostInfo, home string, changed func(name string) bool) error {
+	for _, f := range []struct {
+		name  string
+		value string
+		opt   *string
+	}{
+		{"target", h.Target, &ro.Target},
+		{"kernelrelease", h.KernelRelease, &ro.KernelRelease},
+		{"kernelversion", h.KernelVersion, &ro.KernelVersion},
+		{"architecture", h.Architecture, &ro.Architecture},
+	} {
+		if !changed(f.name) {
+			*f.opt = f.value
+		}
+	}
+	if !changed("kernelconfigdata") && len(h.KernelConfig) > 0 {
+		ro.KernelConfigData = base64.StdEncoding.EncodeToString(h.KernelConfig)
+	}
+	ro.normalizeTarget()
+	ro.normalizeArchitecture()
+	ro.applyOutputLoader()
+
+	if len(ro.Output.Module) > 0 || len(ro.Output.Probe) > 0 {
+		return nil
+	}
+	if len(home) == 0 {
+		return fmt.Errorf("no home directory to write the driver into, set an output")
+	}
+	ro.Output.Module = filepath.Join(home, driverLoaderDir, driverbuilder.CanonicalArtifactPath(&builder.Build{
+		TargetType:       builder.Type(ro.Target),
+		DriverVersion:    ro.DriverVersion,
+		KernelRelease:    ro.KernelRelease,
+		KernelVersion:    ro.KernelVersion,
+		Architecture:     ro.Architecture,
+		ModuleDriverName: ro.ModuleDriverName,
+		NamingStrategy:   builder.NamingStrategy(ro.Output.NamingStrategy),
+	}, ".ko"))
+	return nil
+}
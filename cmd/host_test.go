@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	"gotest.tools/assert"
+)
+
+const hostKernelConfig = "CONFIG_LOCALVERSION=\"\"\nCONFIG_MODULES=y\n"
+
+// writeHostFixture lays out the files identifying the kernel of a host under root.
+func writeHostFixture(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		assert.NilError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.NilError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+}
+
+func TestBuildHere(t *testing.T) {
+	root := t.TempDir()
+	writeHostFixture(t, root, map[string]string{
+		"etc/os-release":                "NAME=\"Ubuntu\"\nID=ubuntu\nID_LIKE=debian\nVERSION_ID=\"22.04\"\n",
+		"proc/version":                  "Linux version 5.15.0-52-generic (buildd@lcy02-amd64-045) (gcc (Ubuntu 11.2.0-19ubuntu1) 11.2.0, GNU ld (GNU Binutils for Ubuntu) 2.38) #58-Ubuntu SMP Thu Oct 13 08:03:55 UTC 2022\n",
+		"boot/config-5.15.0-52-generic": hostKernelConfig,
+	})
+
+	h, err := detectHost(root)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, &hostInfo{
+		Target:        "ubuntu",
+		KernelRelease: "5.15.0-52-generic",
+		KernelVersion: "58",
+		Architecture:  runtime.GOARCH,
+		KernelConfig:  []byte(hostKernelConfig),
+	}, h)
+
+	home := t.TempDir()
+	opts := NewRootOptions()
+	opts.Output.Loader = "falco-modern"
+	assert.NilError(t, opts.fillFromHost(h, home, func(string) bool { return false }))
+	assert.Assert(t, opts.Validate() == nil, "%v", opts.Validate())
+	assert.Equal(t, "ubuntu", opts.Target)
+	assert.Equal(t, "5.15.0-52-generic", opts.KernelRelease)
+	assert.Equal(t, "58", opts.KernelVersion)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte(hostKernelConfig)), opts.KernelConfigData)
+	arch := kernelrelease.Architecture(runtime.GOARCH).ToNonDeb()
+	assert.Equal(t, filepath.Join(home, ".falco", "master", arch, "falco_ubuntu_5.15.0-52-generic_58.ko"), opts.Output.Module)
+
+	// the options explicitly set win over the detected ones, as the outputs do
+	opts = NewRootOptions()
+	opts.KernelVersion = "60"
+	opts.Output.Probe = "/tmp/falco.o"
+	assert.NilError(t, opts.fillFromHost(h, home, func(name string) bool { return name == "kernelversion" }))
+	assert.Equal(t, "60", opts.KernelVersion)
+	assert.Equal(t, "", opts.Output.Module)
+}
+
+func TestDetectHostConfigGz(t *testing.T) {
+	root := t.TempDir()
+	writeHostFixture(t, root, map[string]string{
+		"usr/lib/os-release": "ID=amzn\nVERSION_ID=\"2\"\n",
+		"proc/version":       "Linux version 5.10.147-133.644.amzn2.x86_64 (mockbuild@ip-10-0-1-219) (gcc10-gcc (GCC) 10.4.1 20221124 (Red Hat 10.4.1-2), GNU ld version 2.35.2-9.amzn2.0.1) #1 SMP Thu Nov 24 17:53:01 UTC 2022\n",
+	})
+	f, err := os.Create(filepath.Join(root, "proc", "config.gz"))
+	assert.NilError(t, err)
+	w := gzip.NewWriter(f)
+	_, err = w.Write([]byte(hostKernelConfig))
+	assert.NilError(t, err)
+	assert.NilError(t, w.Close())
+	assert.NilError(t, f.Close())
+
+	h, err := detectHost(root)
+	assert.NilError(t, err)
+	assert.Equal(t, "amazonlinux2", h.Target)
+	assert.Equal(t, "5.10.147-133.644.amzn2.x86_64", h.KernelRelease)
+	assert.Equal(t, "1", h.KernelVersion)
+	assert.Equal(t, hostKernelConfig, string(h.KernelConfig))
+}
+
+func TestSelectBuildProcessor(t *testing.T) {
+	local := driverbuilder.NewLocalBuildProcessor(60)
+	for name, expected := range map[string]string{
+		"docker": driverbuilder.DockerBuildProcessorName,
+		"local":  driverbuilder.LocalBuildProcessorName,
+	} {
+		bp, err := selectBuildProcessor(name, local)
+		assert.NilError(t, err)
+		assert.Equal(t, expected, bp.String())
+	}
+	_, err := selectBuildProcessor("kubernetes", local)
+	assert.ErrorContains(t, err, `unknown processor "kubernetes"`)
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewIndexCmd creates the `driverkit index` command.
+func NewIndexCmd() *cobra.Command {
+	indexCmd := &cobra.Command{
+		Use:   "index <dir>",
+		Short: "Print the JSON catalog of the artifacts of a drivers directory, by target, architecture and kernel release.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(c *cobra.Command, args []string) {
+			if err := indexRun(os.Stdout, args[0]); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+		},
+	}
+
+	return indexCmd
+}
+
+func indexRun(out io.Writer, dir string) error {
+	index, err := driverbuilder.BuildIndex(dir)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(index)
+}
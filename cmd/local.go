@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// NewLocalCmd creates the `driverkit local` command.
+func NewLocalCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	localCmd := &cobra.Command{
+		Use:   "local",
+		Short: "Build Falco kernel modules and eBPF probes on the host, with its own toolchain.",
+		Run: func(c *cobra.Command, args []string) {
+			logger.WithField("processor", c.Name()).Info("driver building, it will take a few seconds")
+			if !configOptions.DryRun {
+				if err := buildRun(driverbuilder.NewLocalBuildProcessor(viper.GetInt("timeout")), rootOpts); err != nil {
+					logger.WithError(err).Fatal("exiting")
+				}
+			}
+		},
+	}
+	// Add root flags
+	localCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return localCmd
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	"github.com/falcosecurity/driverkit/validate"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/term"
+)
+
+// stdinIsTerminal tells whether driverkit can prompt the user for the missing options.
+var stdinIsTerminal = func() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// prompter asks the user for option values.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func newPrompter(in io.Reader, out io.Writer) *prompter {
+	return &prompter{in: bufio.NewReader(in), out: out}
+}
+
+// ask prompts for a value until the answer, once normalized (if normalize is not nil), is valid
+// according to the given validator tag, offering the given choices, if any.
+// An empty answer is only accepted when optional.
+func (p *prompter) ask(name, tag string, choices []string, optional bool, normalize func(string) string) (string, error) {
+	question := name
+	if len(choices) > 0 {
+		question += " [" + strings.Join(choices, ",") + "]"
+	}
+	if optional {
+		question += " (optional)"
+	}
+	for {
+		fmt.Fprintf(p.out, "%s: ", question)
+		answer, err := p.in.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if err != nil && (err != io.EOF || len(answer) == 0) {
+			return "", fmt.Errorf("no %s provided: %w", name, err)
+		}
+		if len(answer) == 0 && optional {
+			return "", nil
+		}
+		if normalize != nil {
+			answer = normalize(answer)
+		}
+		vErr := validate.V.Var(answer, tag)
+		if vErr == nil {
+			return answer, nil
+		}
+		for _, e := range vErr.(validator.ValidationErrors) {
+			fmt.Fprintf(p.out, "invalid %s: %s\n", name, strings.TrimPrefix(e.Translate(validate.T), " "))
+		}
+	}
+}
+
+// promptMissingOptions prompts for the required build options missing from ro.
+func promptMissingOptions(in io.Reader, out io.Writer, ro *RootOptions) error {
+	p := newPrompter(in, out)
+	var err error
+
+	if len(ro.Target) == 0 {
+		targets := builder.BuilderByTarget.Targets()
+		sort.Strings(targets)
+		ro.Target, err = p.ask("target", "required,target", targets, false, func(target string) string {
+			// We just use ubuntu internally
+			if strings.HasPrefix(target, "ubuntu") {
+				return "ubuntu"
+			}
+			return target
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(ro.Architecture) == 0 {
+		archs := targetArchitectures(ro.Target)
+		normalize := func(arch string) string {
+			return kernelrelease.ParseArchitecture(arch).String()
+		}
+		if ro.Architecture, err = p.ask("architecture", "required,oneof="+strings.Join(archs, " "), archs, false, normalize); err != nil {
+			return err
+		}
+	}
+	if len(ro.KernelRelease) == 0 {
+		if ro.KernelRelease, err = p.ask("kernel release", "required,ascii", nil, false, nil); err != nil {
+			return err
+		}
+	}
+	if len(ro.Output.Module) == 0 && len(ro.Output.Probe) == 0 {
+		if ro.Output.Module, err = p.ask("output module path", "required,filepath,endswith=.ko", nil, true, nil); err != nil {
+			return err
+		}
+		// at least one of the two is required
+		optional := len(ro.Output.Module) > 0
+		if ro.Output.Probe, err = p.ask("output probe path", "required,filepath,endswith=.o", nil, optional, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targetArchitectures returns the architectures supported by the builder of target, sorted,
+// or all the supported ones when target has no builder.
+func targetArchitectures(target string) []string {
+	var archs []string
+	if b, ok := builder.BuilderByTarget[builder.Type(target)]; ok {
+		for _, arch := range b.SupportedArchitectures() {
+			archs = append(archs, arch.String())
+		}
+	} else {
+		archs = append(archs, kernelrelease.SupportedArchs.Strings()...)
+	}
+	sort.Strings(archs)
+	return archs
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestPromptMissingOptions(t *testing.T) {
+	ro := NewRootOptions()
+	ro.DriverVersion = "master"
+	input := strings.Join([]string{
+		"notadistro",     // invalid target, asked again
+		"ubuntu-generic", // target
+		"amd64",          // architecture
+		"5.15.0-52-generic",
+		"",              // no module
+		"/tmp/falco.ko", // invalid probe, asked again
+		"/tmp/falco.o",
+	}, "\n") + "\n"
+	var out bytes.Buffer
+
+	err := promptMissingOptions(strings.NewReader(input), &out, ro)
+	assert.NilError(t, err)
+	assert.Equal(t, ro.Target, "ubuntu")
+	assert.Equal(t, ro.Architecture, "amd64")
+	assert.Equal(t, ro.KernelRelease, "5.15.0-52-generic")
+	assert.Equal(t, ro.Output.Module, "")
+	assert.Equal(t, ro.Output.Probe, "/tmp/falco.o")
+	assert.Assert(t, strings.Count(out.String(), "invalid target:") == 1)
+	assert.Assert(t, strings.Count(out.String(), "invalid output probe path:") == 1)
+	assert.Assert(t, ro.Validate() == nil)
+}
+
+func TestPromptMissingOptionsEOF(t *testing.T) {
+	ro := NewRootOptions()
+	err := promptMissingOptions(strings.NewReader("vanilla\n"), &bytes.Buffer{}, ro)
+	assert.ErrorContains(t, err, "no architecture provided")
+}
+
+func TestPromptMissingOptionsTargetArchitectures(t *testing.T) {
+	ro := NewRootOptions()
+	ro.Target = "flatcar"
+	ro.KernelRelease = "3510.2.0"
+	ro.Output.Module = "/tmp/falco.ko"
+	var out bytes.Buffer
+
+	err := promptMissingOptions(strings.NewReader("s390x\nx86_64\n"), &out, ro)
+	assert.NilError(t, err)
+	// flatcar is not built for s390x, and the non-deb names are accepted
+	assert.Assert(t, strings.Contains(out.String(), "architecture [amd64,arm64]: "))
+	assert.Assert(t, strings.Count(out.String(), "invalid architecture:") == 1)
+	assert.Equal(t, ro.Architecture, "amd64")
+}
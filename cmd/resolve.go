@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/signals"
+	"github.com/falcosecurity/driverkit/validate"
+	"github.com/go-playground/validator/v10"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// resolveResult is the outcome of the resolution of the kernel headers urls of a build.
+type resolveResult struct {
+	Target        string   `json:"target"`
+	KernelRelease string   `json:"kernelrelease"`
+	KernelVersion string   `json:"kernelversion"`
+	Architecture  string   `json:"architecture"`
+	URLs          []string `json:"urls"`
+	// Mirror and Subdir are where the urls were found.
+	Mirror string `json:"mirror,omitempty"`
+	Subdir string `json:"subdir,omitempty"`
+	// Error is the reason the resolution failed, along with the candidate urls Tried, if any.
+	Error string             `json:"error,omitempty"`
+	Tried []builder.URLProbe `json:"tried,omitempty"`
+}
+
+// NewResolveCmd creates the `driverkit resolve` command.
+func NewResolveCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	resolveCmd := &cobra.Command{
+		Use:   "resolve",
+		Short: "Print the kernel headers urls the build would download, without building anything.",
+		Run: func(c *cobra.Command, args []string) {
+			if err := resolveRun(c.OutOrStdout(), rootOpts); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+		},
+	}
+
+	// Add resolve options flags
+	flags := resolveCmd.Flags()
+	addResolveFlags(flags)
+	resolveCmd.PersistentFlags().AddFlagSet(flags)
+	// Add root flags
+	resolveCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return resolveCmd
+}
+
+// resolveRun writes to out the kernel headers urls of the build of rootOpts,
+// or the reason they could not be resolved, returning it too.
+func resolveRun(out io.Writer, rootOpts *RootOptions) error {
+	if err := validate.V.Struct(resolveOptions); err != nil {
+		for _, e := range err.(validator.ValidationErrors) {
+			logger.WithError(fmt.Errorf(e.Translate(validate.T))).Error("error validating resolve options")
+		}
+		return fmt.Errorf("exiting for validation errors")
+	}
+
+	b := rootOpts.toBuild()
+	res := resolveResult{
+		Target:        b.TargetType.String(),
+		KernelRelease: b.KernelRelease,
+		KernelVersion: b.KernelVersion,
+		Architecture:  b.Architecture,
+		URLs:          []string{},
+	}
+	resolution, err := builder.ResolveURLsDetailed(signals.WithStandardSignals(context.Background()), b.TargetType, b.ToConfig(), b.KernelReleaseFromBuildConfig())
+	if err != nil {
+		res.Error = err.Error()
+		var resErr *builder.URLResolutionError
+		if errors.As(err, &resErr) {
+			res.Tried = resErr.Tried
+		}
+	}
+	for _, u := range resolution.URLs {
+		res.URLs = append(res.URLs, builder.RedactURL(u))
+	}
+	if len(resolution.Mirror) > 0 {
+		res.Mirror = builder.RedactURL(resolution.Mirror)
+		res.Subdir = resolution.Subdir
+	}
+
+	if resolveOptions.Output == "text" {
+		for _, u := range res.URLs {
+			if _, err := fmt.Fprintln(out, u); err != nil {
+				return err
+			}
+		}
+	} else if encErr := json.NewEncoder(out).Encode(res); encErr != nil {
+		return encErr
+	}
+	return err
+}
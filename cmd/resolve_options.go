@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	flag "github.com/spf13/pflag"
+)
+
+var resolveOptions = &ResolveOptions{}
+
+// ResolveOptions represent the flags of the resolve command.
+type ResolveOptions struct {
+	Output string `validate:"oneof=json text" name:"output format"`
+}
+
+func addResolveFlags(flags *flag.FlagSet) {
+	flags.StringVar(&resolveOptions.Output, "output", "json", "format of the resolved kernel headers urls, either json or text (one url per line)")
+}
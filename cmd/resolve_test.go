@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestResolve(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/kernel-devel.rpm" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer mirror.Close()
+
+	ro := NewRootOptions()
+	ro.Target = "centos"
+	ro.Architecture = "amd64"
+	ro.KernelRelease = "4.18.0-372.9.1.el8.x86_64"
+	ro.CacheDir = t.TempDir()
+	ro.KernelUrls = []string{mirror.URL + "/missing.rpm", mirror.URL + "/kernel-devel.rpm"}
+	defer func() { resolveOptions.Output = "json" }()
+
+	resolveOptions.Output = "json"
+	var out bytes.Buffer
+	assert.NilError(t, resolveRun(&out, ro))
+	var res resolveResult
+	assert.NilError(t, json.Unmarshal(out.Bytes(), &res))
+	assert.DeepEqual(t, res, resolveResult{
+		Target:        "centos",
+		KernelRelease: "4.18.0-372.9.1.el8.x86_64",
+		KernelVersion: "1",
+		Architecture:  "amd64",
+		URLs:          []string{mirror.URL + "/kernel-devel.rpm"},
+		Mirror:        mirror.URL,
+	})
+
+	resolveOptions.Output = "text"
+	out.Reset()
+	assert.NilError(t, resolveRun(&out, ro))
+	assert.Equal(t, out.String(), mirror.URL+"/kernel-devel.rpm\n")
+
+	// the failures are reported along with the candidates tried
+	resolveOptions.Output = "json"
+	ro.KernelUrls = []string{mirror.URL + "/missing.rpm"}
+	out.Reset()
+	err := resolveRun(&out, ro)
+	assert.ErrorContains(t, err, "kernel headers not found")
+	res = resolveResult{}
+	assert.NilError(t, json.Unmarshal(out.Bytes(), &res))
+	assert.Equal(t, res.Error, err.Error())
+	assert.Equal(t, len(res.URLs), 0)
+	assert.Equal(t, len(res.Tried), 1)
+	assert.Equal(t, res.Tried[0].StatusCode, http.StatusNotFound)
+
+	resolveOptions.Output = "yaml"
+	assert.ErrorContains(t, resolveRun(&out, ro), "validation errors")
+}
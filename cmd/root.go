@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
 	"github.com/falcosecurity/driverkit/pkg/version"
 	"github.com/spf13/cobra"
@@ -19,6 +21,24 @@ import (
 	"github.com/spf13/viper"
 )
 
+// unvalidatedCommands are the commands running disregarding the validity of the root flags.
+var unvalidatedCommands = map[string]bool{
+	"help":             true,
+	"__complete":       true,
+	"__completeNoDesc": true,
+	"completion":       true,
+	// they validate the root flags of each one of their kernels by themselves
+	"batch":         true,
+	"warm":          true,
+	"audit-mirrors": true,
+	"gen-matrix":    true,
+	"validate":      true,
+	// they do not build anything
+	"cleanup": true,
+	"index":   true,
+	"flavors": true,
+}
+
 func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c *cobra.Command, args []string) error {
 	return func(c *cobra.Command, args []string) error {
 		// Early exit if detect some error into config flags
@@ -27,19 +47,45 @@ func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c
 		}
 		// Merge environment variables or config file values into the RootOptions instance
 		skip := map[string]bool{ // do not merge these
-			"config":   true,
-			"timeout":  true,
-			"loglevel": true,
-			"dryrun":   true,
-			"proxy":    true,
+			"config":        true,
+			"timeout":       true,
+			"loglevel":      true,
+			"logformat":     true,
+			"dryrun":        true,
+			"proxy":         true,
+			"otel-endpoint": true,
+			"no-input":      true,
 		}
 		nested := map[string]string{ // handle nested options in config file
-			"output-module": "output.module",
-			"output-probe":  "output.probe",
+			"output-module":          "output.module",
+			"output-probe":           "output.probe",
+			"output-dockerfile":      "output.dockerfile",
+			"output-repro-bundle":    "output.reprobundle",
+			"output-manifest":        "output.manifest",
+			"output-rsync":           "output.rsync.destination",
+			"output-rsync-ssh":       "output.rsync.sshoptions",
+			"output-naming-strategy": "output.namingstrategy",
+			"output-name-template":   "output.nametemplate",
+			"output-loader":          "output.loader",
+			"attest":                 "attest.enabled",
+			"attest-key":             "attest.key",
+		}
+		slices := map[string]bool{ // handle slice options
+			"kernelurls":            true,
+			"headerurls":            true,
+			"requiredkernelconfigs": true,
+			"debianvendorflavors":   true,
+			"mirror":                true,
+			"extramirrors":          true,
+			"urlrewrite":            true,
+			"checksums":             true,
+			"kernelflavors":         true,
+			"kbuildflags":           true,
+			"localheaders":          true,
 		}
 		rootCommand.c.Flags().VisitAll(func(f *pflag.Flag) {
 			if name := f.Name; !skip[name] {
-				if name == "kernelurls" {
+				if slices[name] {
 					// Slice types need special treatment when used as flags. If we call 'Set(name, value)',
 					// rather than replace, it appends. Since viper will already have the cli options set
 					// if supplied, we only need this step if rootCommand doesn't already have them e.g.
@@ -71,13 +117,31 @@ func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c
 		// Avoid sensitive info into default values help line
 		rootCommand.StripSensitive()
 
-		// We just use ubuntu internally
-		if strings.HasPrefix(rootOpts.Target, "ubuntu") {
-			rootOpts.Target = "ubuntu"
+		// build --here detects the options not set from the running host
+		if c.Name() == "build" && buildOptions.Here {
+			if err := fillFromRunningHost(c, rootOpts); err != nil {
+				return err
+			}
+		}
+
+		if err := rootOpts.applyTargetAlias(); err != nil {
+			return err
 		}
 
+		rootOpts.normalizeTarget()
+		rootOpts.normalizeArchitecture()
+
 		// Do not block root or help command to exec disregarding the root flags validity
-		if c.Root() != c && c.Name() != "help" && c.Name() != "__complete" && c.Name() != "__completeNoDesc" && c.Name() != "completion" {
+		if c.Root() != c && !unvalidatedCommands[c.Name()] {
+			// The kernel release derived from the kernel config data, if any, is not to be prompted for
+			rootOpts.fillFromKernelConfig()
+			// Prompt for the missing required options when a user is there to answer
+			if !configOptions.NoInput && stdinIsTerminal() {
+				if err := promptMissingOptions(os.Stdin, os.Stderr, rootOpts); err != nil {
+					return err
+				}
+			}
+			rootOpts.applyOutputLoader()
 			if errs := rootOpts.Validate(); errs != nil {
 				for _, err := range errs {
 					logger.WithError(err).Error("error validating build options")
@@ -86,13 +150,24 @@ func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c
 			}
 			rootOpts.Log()
 		}
+
+		if endpoint := viper.GetString("otel-endpoint"); len(endpoint) > 0 {
+			shutdown, err := driverbuilder.SetupTracing(context.Background(), endpoint)
+			if err != nil {
+				return err
+			}
+			rootCommand.shutdownTracing = shutdown
+			// logger.Fatal exits without running the deferred flush of Execute
+			logger.RegisterExitHandler(rootCommand.flushTraces)
+		}
 		return nil
 	}
 }
 
 // RootCmd wraps the main cobra.Command.
 type RootCmd struct {
-	c *cobra.Command
+	c               *cobra.Command
+	shutdownTracing func(context.Context) error
 }
 
 // NewRootCmd instantiates the root command.
@@ -129,16 +204,29 @@ func NewRootCmd() *RootCmd {
 
 	flags.StringVarP(&configOptions.ConfigFile, "config", "c", configOptions.ConfigFile, "config file path (default $HOME/.driverkit.yaml if exists)")
 	flags.StringVarP(&configOptions.LogLevel, "loglevel", "l", configOptions.LogLevel, "log level")
+	flags.StringVar(&configOptions.LogFormat, "logformat", configOptions.LogFormat, "log format, either text or json (one object per line, e.g. to parse the debug logs of the kernel headers resolution)")
 	flags.IntVar(&configOptions.Timeout, "timeout", configOptions.Timeout, "timeout in seconds")
 	flags.BoolVar(&configOptions.DryRun, "dryrun", configOptions.DryRun, "do not actually perform the action")
-	flags.StringVar(&configOptions.ProxyURL, "proxy", configOptions.ProxyURL, "the proxy to use to download data")
+	flags.StringVar(&configOptions.ProxyURL, "proxy", configOptions.ProxyURL, "the proxy to use to download data, from the host and from the builds (HTTP_PROXY and HTTPS_PROXY are honored on the host otherwise)")
+	flags.StringVar(&configOptions.OTelEndpoint, "otel-endpoint", configOptions.OTelEndpoint, "OTLP/HTTP endpoint to export the traces of the builds to (e.g. http://localhost:4318), tracing is disabled when empty")
+	flags.BoolVar(&configOptions.NoInput, "no-input", configOptions.NoInput, "never prompt for the missing required options, even when stdin is a terminal")
 
 	flags.StringVar(&rootOpts.Output.Module, "output-module", rootOpts.Output.Module, "filepath where to save the resulting kernel module")
 	flags.StringVar(&rootOpts.Output.Probe, "output-probe", rootOpts.Output.Probe, "filepath where to save the resulting eBPF probe")
-	flags.StringVar(&rootOpts.Architecture, "architecture", runtime.GOARCH, "target architecture for the built driver, one of "+kernelrelease.SupportedArchs.String())
+	flags.StringVar(&rootOpts.Output.Dockerfile, "output-dockerfile", rootOpts.Output.Dockerfile, "filepath where to save a Dockerfile building the drivers, instead of building them (docker processor only)")
+	flags.StringVar(&rootOpts.Output.RepoBundleOnFailure, "output-repro-bundle", rootOpts.Output.RepoBundleOnFailure, "filepath where to save a tar.gz bundle to reproduce the build when it fails, with the effective config, the probed urls, the build script and the container log (docker processor only)")
+	flags.StringVar(&rootOpts.Output.Manifest, "output-manifest", rootOpts.Output.Manifest, "filepath where to save a JSON manifest describing how the artifacts were built (target, kernel release, architecture, flavor, driver version, resolved kernel headers urls and artifacts digests)")
+	flags.StringVar(&rootOpts.Output.Rsync.Destination, "output-rsync", rootOpts.Output.Rsync.Destination, "rsync destination (e.g. user@host:/srv/drivers/ or rsync://host/drivers/) where to transfer the resulting artifacts to, with the layout of the output naming strategy")
+	flags.StringVar(&rootOpts.Output.Rsync.SSHOptions, "output-rsync-ssh", rootOpts.Output.Rsync.SSHOptions, "options of the ssh transport of the rsync output (e.g. \"-p 2222 -i ~/.ssh/drivers\")")
+	flags.StringVar(&rootOpts.Output.NamingStrategy, "output-naming-strategy", rootOpts.Output.NamingStrategy, "scheme of the artifact names expected by the consuming driver loader, one of legacy (<driverversion>/<drivername>_<target>_<kernelrelease>_<kernelversion>.{ko,o}) or current (with the <arch> directory too), the default")
+	flags.StringVar(&rootOpts.Output.NameTemplate, "output-name-template", rootOpts.Output.NameTemplate, "Go template of the base name of the resulting kernel module and eBPF probe, without their extension, kept in their directory, whose fields are .Target, .KernelRelease, .KernelVersion, .Arch (e.g. x86_64), .ModuleDriverName and .DriverVersion")
+	flags.StringVar(&rootOpts.Output.Loader, "output-loader", rootOpts.Output.Loader, "driver loader consuming the artifacts, one of falco-modern or falco-legacy, setting the naming strategy it expects unless output-naming-strategy is set")
+	flags.BoolVar(&rootOpts.Attest.Enabled, "attest", rootOpts.Attest.Enabled, "write the signed in-toto attestation of the provenance of each artifact next to it, with the .att extension")
+	flags.StringVar(&rootOpts.Attest.Key, "attest-key", rootOpts.Attest.Key, "ECDSA private key signing the attestations, either PEM encoded or an encrypted cosign one whose password is read from COSIGN_PASSWORD")
+	flags.StringVar(&rootOpts.Architecture, "architecture", runtime.GOARCH, "target architecture for the built driver, one of "+kernelrelease.SupportedArchs.String()+" (x86_64 and aarch64 are accepted too)")
 	flags.StringVar(&rootOpts.DriverVersion, "driverversion", rootOpts.DriverVersion, "driver version as a git commit hash or as a git tag")
 	flags.StringVar(&rootOpts.KernelVersion, "kernelversion", rootOpts.KernelVersion, "kernel version to build the module for, it's the numeric value after the hash when you execute 'uname -v'")
-	flags.StringVar(&rootOpts.KernelRelease, "kernelrelease", rootOpts.KernelRelease, "kernel release to build the module for, it can be found by executing 'uname -v'")
+	flags.StringVar(&rootOpts.KernelRelease, "kernelrelease", rootOpts.KernelRelease, "kernel release to build the module for, it can be found by executing 'uname -v'; when missing, it is derived from the kernel config data, if possible")
 	flags.StringVarP(&rootOpts.Target, "target", "t", rootOpts.Target, "the system to target the build for, one of ["+strings.Join(targets, ",")+"]")
 	flags.StringVar(&rootOpts.KernelConfigData, "kernelconfigdata", rootOpts.KernelConfigData, "base64 encoded kernel config data: in some systems it can be found under the /boot directory, in other it is gzip compressed under /proc")
 	flags.StringVar(&rootOpts.ModuleDeviceName, "moduledevicename", rootOpts.ModuleDeviceName, "kernel module device name (the default is falco, so the device will be under /dev/falco*)")
@@ -148,6 +236,55 @@ func NewRootCmd() *RootCmd {
 	flags.StringVar(&rootOpts.GCCVersion, "gccversion", rootOpts.GCCVersion, "enforce a specific gcc version for the build")
 
 	flags.StringSliceVar(&rootOpts.KernelUrls, "kernelurls", nil, "list of kernel header urls (e.g. --kernelurls <URL1> --kernelurls <URL2> --kernelurls \"<URL3>,<URL4>\")")
+	flags.StringSliceVar(&rootOpts.HeaderURLs, "headerurls", nil, "urls of the kernel headers packages pinned for the build, used verbatim without resolving nor probing any (e.g. for reproducible builds), unlike the kernelurls ones, and not to be set along with them nor with localheaders")
+	flags.StringSliceVar(&rootOpts.RequiredKernelConfigs, "requiredkernelconfigs", nil, "list of kernel config options that must be enabled in the kernel config data for the build to proceed (e.g. --requiredkernelconfigs CONFIG_TRACEPOINTS)")
+	flags.BoolVar(&rootOpts.ListingFallback, "listingfallback", rootOpts.ListingFallback, "look for the ubuntu kernel headers into the HTML listings of the mirrors, when their names cannot be guessed (e.g. an unexpected kernel version suffix)")
+	flags.BoolVar(&rootOpts.PreferSecurityMirror, "prefersecuritymirror", rootOpts.PreferSecurityMirror, "look for ubuntu amd64 kernel headers into security.ubuntu.com before mirrors.edge.kernel.org, which can be stale for the most recent kernels")
+	flags.BoolVar(&rootOpts.ParallelArtifacts, "parallelartifacts", rootOpts.ParallelArtifacts, "compile the kernel module and the eBPF probe concurrently, when building both")
+	flags.StringVar(&rootOpts.ResolverEndpoint, "resolverendpoint", rootOpts.ResolverEndpoint, "URL of a service returning the kernel headers urls to use, instead of the target ones, for the POSTed build key")
+	flags.BoolVar(&rootOpts.ResolverStrict, "resolverstrict", rootOpts.ResolverStrict, "fail the build when the resolver endpoint errors, instead of falling back to the target kernel headers urls")
+	flags.StringVar(&rootOpts.VermagicSuffix, "vermagicsuffix", rootOpts.VermagicSuffix, "string recorded into the 'driverkit_build' modinfo tag of the kernel module, to tell apart the modules built with it, its vermagic being left as it is for the kernel to still load the module")
+	flags.StringVar(&rootOpts.BuildCommit, "buildcommit", rootOpts.BuildCommit, "git commit of the driver sources, stamped into the info of the kernel module (ubuntu only), defaults to the driver version when it is a commit hash")
+	flags.StringSliceVar(&rootOpts.ExtraKBuildFlags, "kbuildflags", nil, "additional VAR=value flags of the kbuild invocation building the kernel module, repeatable (e.g. --kbuildflags KCFLAGS=-g, ubuntu only)")
+	flags.BoolVar(&rootOpts.Streaming, "streaming", rootOpts.Streaming, "extract the kernel headers packages while downloading them and feed the build script to the builder without staging them on disk")
+	flags.BoolVar(&rootOpts.StrictContentCheck, "strictcontentcheck", rootOpts.StrictContentCheck, "discard the kernel headers urls serving HTML pages not labelled as such, sniffing their first bytes, as some misconfigured mirrors do for missing files (the ones labelled as such are always discarded)")
+	flags.Int64Var(&rootOpts.MinPackageSize, "minpackagesize", rootOpts.MinPackageSize, "minimum size, in bytes, of the kernel headers packages: the urls whose Content-Length is smaller get discarded, as the error pages some misconfigured mirrors answer with a 200, no minimum when zero")
+	flags.BoolVar(&rootOpts.VerifyToolchain, "verifytoolchain", rootOpts.VerifyToolchain, "check that the compilers needed by the build (i.e. gcc-<gccversion> and clang) are available in the builder image before compiling, failing early otherwise")
+	flags.DurationVar(&rootOpts.ConnectTimeout, "connecttimeout", rootOpts.ConnectTimeout, "maximum time to connect to the mirrors for any request resolving the kernel headers, listings included (e.g. 5s), unbounded when zero; the probes are bounded by http-timeout too, the shorter one winning")
+	flags.DurationVar(&rootOpts.ResponseHeaderTimeout, "responseheadertimeout", rootOpts.ResponseHeaderTimeout, "maximum time to wait for the mirrors to answer any request resolving the kernel headers, listings included, not bounding the reads of the answers (e.g. 30s), unbounded when zero; the probes are bounded by http-timeout too, the shorter one winning")
+	flags.StringVar(&rootOpts.CACertPath, "ca-cert", rootOpts.CACertPath, "PEM bundle of the CAs to trust, along with the system ones, while resolving and downloading the kernel headers from the host and while downloading anything from the builds (e.g. the one of a TLS-intercepting proxy)")
+	flags.IntVar(&rootOpts.HTTPRetries, "httpretries", rootOpts.HTTPRetries, "number of retries of the kernel headers probes failing with a network error or a 5xx status, never for a 404")
+	flags.IntVar(&rootOpts.ResolveParallelism, "resolveparallelism", rootOpts.ResolveParallelism, "maximum number of candidate kernel headers urls probed at the same time, the number of CPUs when zero")
+	flags.DurationVar(&rootOpts.HTTPRetryBaseDelay, "httpretrybasedelay", rootOpts.HTTPRetryBaseDelay, "delay before the first retry of a kernel headers probe, doubling at each retry, plus jitter")
+	flags.DurationVar(&rootOpts.HTTPTimeout, "http-timeout", rootOpts.HTTPTimeout, "maximum time of each request probing the kernel headers, from connecting to reading the answer, retries apart, whatever the one of the whole build (e.g. 10s), unbounded when zero; connecttimeout and responseheadertimeout bound the phases of all the requests, probes included, when shorter")
+	flags.StringVar(&rootOpts.MaxDownloadRate, "maxdownloadrate", rootOpts.MaxDownloadRate, "maximum rate, in bytes per second with an optional k, m or g suffix, for the downloads of the build (e.g. 5m), unlimited when empty")
+	flags.BoolVar(&rootOpts.SkipIfPublished, "skipifpublished", rootOpts.SkipIfPublished, "skip the build when its artifacts are already published into the publish registry, with their canonical tags")
+	flags.StringVar(&rootOpts.PublishRegistry, "publishregistry", rootOpts.PublishRegistry, "reference of the OCI repository the artifacts get published into (e.g. registry.example.com/falcosecurity/drivers, or http://localhost:5000/drivers for a plain http registry)")
+	flags.StringVar(&rootOpts.PackageCacheDir, "packagecachedir", rootOpts.PackageCacheDir, "directory of the kernel headers packages cached on the host (e.g. by the warm command), mounted into the docker builds to use them in place of downloading them")
+	flags.StringSliceVar(&rootOpts.LocalHeaders, "localheaders", nil, "paths of the kernel headers packages on the host to build against, in place of resolving and downloading any url (e.g. in air-gapped environments), mounted into the docker builds")
+	flags.StringVar(&rootOpts.CacheDir, "cachedir", rootOpts.CacheDir, "directory where the resolved kernel headers urls get cached, by target, kernel release, kernel version, architecture and options driving the resolution (e.g. the mirrors), to reuse them in the next runs")
+	flags.DurationVar(&rootOpts.CacheTTL, "cachettl", rootOpts.CacheTTL, "how long the cached kernel headers urls are reused")
+	flags.DurationVar(&rootOpts.UnresolvedCacheTTL, "unresolvedcachettl", rootOpts.UnresolvedCacheTTL, "how long the kernels whose headers are not on any mirror are cached as unresolvable, failing fast")
+	flags.BoolVar(&rootOpts.NoCache, "no-cache", false, "resolve the kernel headers urls in place of reusing the cached ones or failing fast on the kernels cached as unresolvable, still caching the new ones (i.e. refresh the cache)")
+	flags.BoolVar(&rootOpts.StrictBuilderImageVersion, "strictbuilderimageversion", rootOpts.StrictBuilderImageVersion, "fail the docker builds whose builder image is older than the one needed by the target, according to its "+builder.BuilderImageVersionLabel+" label, instead of warning about it")
+	flags.StringVar(&rootOpts.Sysroot, "sysroot", rootOpts.Sysroot, "absolute path of the sysroot inside the builder container to compile the eBPF probe against, passed to clang with --sysroot (e.g. to target a musl-based libc)")
+	flags.StringVar(&rootOpts.HeadersPatternOverride, "headerspattern", rootOpts.HeadersPatternOverride, "advanced: pattern of the directory of the extracted kernel headers to build against, in place of the one derived from the kernel release flavor, for the custom kernels whose headers get extracted elsewhere (e.g. linux-headers-*custom, ubuntu and debian only)")
+	flags.BoolVar(&rootOpts.FlavorFallbackGeneric, "flavorfallbackgeneric", rootOpts.FlavorFallbackGeneric, "build against the headers of the generic flavor when the ones of the requested flavor are missing, only safe when they share the same ABI (ubuntu only)")
+	flags.StringVar(&rootOpts.MirrorUsername, "mirrorusername", rootOpts.MirrorUsername, "username of the basic auth of the mirrors, sent along with the requests resolving and downloading the kernel headers from the hosts of the mirror, extramirrors, kernelurls and headerurls options only")
+	flags.StringVar(&rootOpts.MirrorPassword, "mirrorpassword", rootOpts.MirrorPassword, "password of the basic auth of the mirrors, better passed through the DRIVERKIT_MIRRORPASSWORD environment variable")
+	flags.StringVar(&rootOpts.MirrorToken, "mirrortoken", rootOpts.MirrorToken, "bearer token of the mirrors, sent in place of their basic auth to the same hosts, better passed through the DRIVERKIT_MIRRORTOKEN environment variable")
+	flags.BoolVar(&rootOpts.StrictKernelVersion, "strictkernelversion", rootOpts.StrictKernelVersion, "fail the build when the resolved kernel headers packages are for another kernel version than the requested one, instead of warning about it")
+	flags.BoolVar(&rootOpts.StrictStatus, "strictstatus", rootOpts.StrictStatus, "fail the build when any kernel headers url is answered with another status than 200 or 404 (e.g. 403 or 500), to catch proxy or ACL misconfigurations")
+	flags.StringVar(&rootOpts.TargetAliases, "targetaliases", rootOpts.TargetAliases, "yaml file mapping derivative target names onto the builder of an existing target, with optional mirror overrides")
+	flags.StringSliceVar(&rootOpts.Mirrors, "mirror", nil, "mirror where to look for the kernel headers in place of the default ones, repeatable, tried in the given order (e.g. --mirror https://apt.example.com/ubuntu/pool/main/l, ubuntu only)")
+	flags.StringSliceVar(&rootOpts.ExtraMirrors, "extramirrors", nil, "list of architecture=mirror mappings of the ubuntu mirrors where to look for the kernel headers after the default ones (e.g. --extramirrors arm64=https://mirror.example.com/ubuntu-ports/pool/main/l)")
+	flags.StringSliceVar(&rootOpts.URLRewrite, "urlrewrite", nil, "list of regex=>replacement rules rewriting the candidate kernel headers urls before probing them, applied in order (e.g. --urlrewrite '^https://mirrors.edge.kernel.org/=>https://cdn.example.com/kernel.org/')")
+	flags.StringSliceVar(&rootOpts.Checksums, "checksums", nil, "list of package=sha256 mappings of the known-good checksums of the kernel headers packages, by base name: the resolved packages are verified against them before building, and the build container checks its own downloads against them too, not streaming them")
+	flags.BoolVar(&rootOpts.SkipChecksums, "skipchecksums", false, "skip the verification of the kernel headers packages checksums")
+	flags.StringSliceVar(&rootOpts.KernelFlavors, "kernelflavors", nil, "list of candidate flavors of the kernel release, when unsure of it, the first one whose headers resolve is built (e.g. --kernelflavors generic,lowlatency, ubuntu only)")
+	flags.StringVar(&rootOpts.DebianVendorPool, "debianvendorpool", rootOpts.DebianVendorPool, "url of the pool, with the Debian layout, hosting the kernel packages of a Debian derivative (e.g. https://vendor.example.com/debian/pool/main/l/linux)")
+	flags.StringSliceVar(&rootOpts.DebianVendorFlavors, "debianvendorflavors", nil, "list of flavor=package-flavor mappings from the kernel releases flavors of a Debian derivative to the ones of its headers packages (e.g. --debianvendorflavors appliance=appliance-amd64)")
+	flags.StringVar(&rootOpts.ContainerWorkDir, "containerworkdir", rootOpts.ContainerWorkDir, "absolute path of the directory used inside the builder container to download and extract sources (default \"/tmp\")")
 
 	flags.StringVar(&rootOpts.Repo.Org, "repo-org", rootOpts.Repo.Org, "repository github organization")
 	flags.StringVar(&rootOpts.Repo.Name, "repo-name", rootOpts.Repo.Name, "repository github name")
@@ -167,14 +304,39 @@ func NewRootCmd() *RootCmd {
 	rootCmd.AddCommand(NewKubernetesCmd(rootOpts, flags))
 	rootCmd.AddCommand(NewKubernetesInClusterCmd(rootOpts, flags))
 	rootCmd.AddCommand(NewDockerCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewLocalCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewBuildCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewResolveCmd(rootOpts, flags))
 	rootCmd.AddCommand(NewImagesCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewFlavorsCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewBatchCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewWarmCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewAuditMirrorsCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewGenMatrixCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewValidateCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewCleanupCmd())
+	rootCmd.AddCommand(NewIndexCmd())
 	rootCmd.AddCommand(NewCompletionCmd())
+	rootCmd.SetGlobalNormalizationFunc(normalizeFlagName)
 
 	ret.StripSensitive()
 
 	return ret
 }
 
+// deprecatedFlagNames maps the deprecated spellings of the flags onto their current names.
+var deprecatedFlagNames = map[string]string{
+	"httptimeout": "http-timeout",
+}
+
+// normalizeFlagName accepts the deprecated flag names as aliases of the current ones.
+func normalizeFlagName(_ *pflag.FlagSet, name string) pflag.NormalizedName {
+	if current, ok := deprecatedFlagNames[name]; ok {
+		name = current
+	}
+	return pflag.NormalizedName(name)
+}
+
 // Sensitive is a list of sensitive environment variable to replace into the help outputs.
 var Sensitive = []string{
 	"HOME",
@@ -209,11 +371,24 @@ func (r *RootCmd) SetArgs(args []string) {
 	r.c.SetArgs(args)
 }
 
-// Execute proxies the cobra.Command execution.
+// Execute proxies the cobra.Command execution, flushing the traces of the builds, if any, once done.
 func (r *RootCmd) Execute() error {
+	defer r.flushTraces()
 	return r.c.Execute()
 }
 
+// flushTraces flushes and stops the export of the traces of the builds, if any.
+func (r *RootCmd) flushTraces() {
+	if r.shutdownTracing == nil {
+		return
+	}
+	shutdown := r.shutdownTracing
+	r.shutdownTracing = nil
+	if err := shutdown(context.Background()); err != nil {
+		logger.WithError(err).Warn("error flushing the traces")
+	}
+}
+
 // Start creates the root command and runs it.
 func Start() {
 	root := NewRootCmd()
@@ -240,6 +415,9 @@ func initConfig() {
 		}
 		// configOptions.configErrors should be true here
 	}
+	if configOptions.LogFormat == "json" {
+		logger.SetFormatter(&logger.JSONFormatter{})
+	}
 	if configOptions.ConfigFile != "" {
 		viper.SetConfigFile(configOptions.ConfigFile)
 	} else {
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/base64"
 	"fmt"
 	"github.com/creasty/defaults"
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
@@ -8,13 +9,49 @@ import (
 	"github.com/falcosecurity/driverkit/validate"
 	"github.com/go-playground/validator/v10"
 	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	"strings"
+	"time"
 )
 
 // OutputOptions wraps the two drivers that driverkit builds.
 type OutputOptions struct {
 	Module string `validate:"required_without=Probe,filepath,omitempty,endswith=.ko" name:"output module path"`
 	Probe  string `validate:"required_without=Module,filepath,omitempty,endswith=.o" name:"output probe path"`
+	// Dockerfile, when set, makes the docker processor write a Dockerfile building the drivers rather than building them.
+	Dockerfile string `validate:"omitempty,filepath" name:"output dockerfile path"`
+	// RepoBundleOnFailure, when set, is where a reproduction bundle of failed builds gets written.
+	RepoBundleOnFailure string `validate:"omitempty,filepath" name:"output reproduction bundle path"`
+	// Manifest, when set, is where the JSON manifest describing how the artifacts were built gets written.
+	Manifest string `validate:"omitempty,filepath" name:"output manifest path"`
+	// Rsync, when set, is where the built artifacts get transferred to.
+	Rsync RsyncOptions
+	// NamingStrategy is the scheme of the artifact names expected by the driver loaders consuming them.
+	NamingStrategy string `validate:"omitempty,oneof=legacy current" name:"output naming strategy"`
+	// NameTemplate, when set, is the template of the base name of the module and probe, without their extension.
+	NameTemplate string `validate:"omitempty,outputnametemplate" name:"output name template"`
+	// Loader, when set, is the driver loader consuming the artifacts, setting the naming strategy it expects when not set.
+	Loader string `validate:"omitempty,oneof=falco-modern falco-legacy" name:"output loader"`
+}
+
+// loaderNamingStrategies are the naming strategies of the artifacts expected by each driver loader.
+var loaderNamingStrategies = map[string]builder.NamingStrategy{
+	"falco-modern": builder.NamingStrategyCurrent,
+	"falco-legacy": builder.NamingStrategyLegacy,
+}
+
+// AttestOptions tell whether the SLSA provenance attestations of the built artifacts get written next to them,
+// and the key signing them.
+type AttestOptions struct {
+	Enabled bool   `name:"attest"`
+	Key     string `validate:"required_if=Enabled true,omitempty,file" name:"attest key"`
+}
+
+// RsyncOptions tell the rsync destination the built artifacts get transferred to, with the layout of the output
+// naming strategy, and the options of its ssh transport.
+type RsyncOptions struct {
+	Destination string `name:"output rsync destination"`
+	SSHOptions  string `name:"output rsync ssh options"`
 }
 
 type RepoOptions struct {
@@ -24,20 +61,72 @@ type RepoOptions struct {
 
 // RootOptions ...
 type RootOptions struct {
-	Architecture     string   `validate:"required,architecture" name:"architecture"`
-	DriverVersion    string   `default:"master" validate:"eq=master|sha1|semver" name:"driver version"`
-	KernelVersion    string   `default:"1" validate:"omitempty" name:"kernel version"`
-	ModuleDriverName string   `default:"falco" validate:"max=60" name:"kernel module driver name"`
-	ModuleDeviceName string   `default:"falco" validate:"excludes=/,max=255" name:"kernel module device name"`
-	KernelRelease    string   `validate:"required,ascii" name:"kernel release"`
-	Target           string   `validate:"required,target" name:"target"`
-	KernelConfigData string   `validate:"omitempty,base64" name:"kernel config data"` // fixme > tag "name" does not seem to work when used at struct level, but works when used at inner level
-	BuilderImage     string   `validate:"omitempty,imagename" name:"builder image"`
-	BuilderRepos     []string `default:"[\"docker.io/falcosecurity/driverkit\"]" validate:"omitempty" name:"docker repositories to look for builder images or absolute path pointing to a yaml file containing builder image index"`
-	GCCVersion       string   `validate:"omitempty,semvertolerant" name:"gcc version"`
-	KernelUrls       []string `name:"kernel header urls"`
-	Repo             RepoOptions
-	Output           OutputOptions
+	Architecture              string        `validate:"required,architecture" name:"architecture"`
+	DriverVersion             string        `default:"master" validate:"eq=master|sha1|semver" name:"driver version"`
+	KernelVersion             string        `default:"1" validate:"omitempty" name:"kernel version"`
+	ModuleDriverName          string        `default:"falco" validate:"max=60" name:"kernel module driver name"`
+	ModuleDeviceName          string        `default:"falco" validate:"excludes=/,max=255" name:"kernel module device name"`
+	KernelRelease             string        `validate:"required,ascii" name:"kernel release"`
+	Target                    string        `validate:"required,target" name:"target"`
+	KernelConfigData          string        `validate:"omitempty,base64" name:"kernel config data"` // fixme > tag "name" does not seem to work when used at struct level, but works when used at inner level
+	BuilderImage              string        `validate:"omitempty,imagename" name:"builder image"`
+	BuilderRepos              []string      `default:"[\"docker.io/falcosecurity/driverkit\"]" validate:"omitempty" name:"docker repositories to look for builder images or absolute path pointing to a yaml file containing builder image index"`
+	GCCVersion                string        `validate:"omitempty,semvertolerant" name:"gcc version"`
+	KernelUrls                []string      `name:"kernel header urls"`
+	HeaderURLs                []string      `validate:"omitempty,excluded_with=KernelUrls LocalHeaders,dive,url" name:"pinned kernel headers urls"`
+	ContainerWorkDir          string        `validate:"omitempty,startswith=/" name:"container work directory"`
+	RequiredKernelConfigs     []string      `validate:"omitempty" name:"required kernel config options"`
+	PreferSecurityMirror      bool          `name:"prefer security mirror"`
+	ParallelArtifacts         bool          `name:"parallel artifacts"`
+	ResolverEndpoint          string        `validate:"omitempty,url" name:"resolver endpoint"`
+	ResolverStrict            bool          `name:"resolver strict"`
+	VermagicSuffix            string        `validate:"omitempty,max=64,excludesall=/&'\\" name:"vermagic suffix"`
+	BuildCommit               string        `validate:"omitempty,sha1" name:"build commit"`
+	ExtraKBuildFlags          []string      `validate:"omitempty,dive,contains==,excludesall='" name:"extra kbuild flags"`
+	ListingFallback           bool          `name:"listing fallback"`
+	Streaming                 bool          `name:"streaming"`
+	StrictContentCheck        bool          `name:"strict content check"`
+	MinPackageSize            int64         `default:"65536" validate:"min=0" name:"min package size"`
+	DebianVendorPool          string        `validate:"omitempty,url" name:"debian vendor pool"`
+	DebianVendorFlavors       []string      `validate:"omitempty,dive,contains==" name:"debian vendor flavors"`
+	MaxDownloadRate           string        `validate:"omitempty,rate" name:"max download rate"`
+	SkipIfPublished           bool          `name:"skip if published"`
+	PublishRegistry           string        `validate:"required_with=SkipIfPublished" name:"publish registry"`
+	VerifyToolchain           bool          `name:"verify toolchain"`
+	ConnectTimeout            time.Duration `validate:"min=0" name:"connect timeout"`
+	ResponseHeaderTimeout     time.Duration `validate:"min=0" name:"response header timeout"`
+	CACertPath                string        `validate:"omitempty,file,cacert" name:"ca cert"`
+	HTTPRetries               int           `validate:"min=0" name:"http retries"`
+	HTTPRetryBaseDelay        time.Duration `default:"1s" validate:"min=0" name:"http retry base delay"`
+	HTTPTimeout               time.Duration `default:"10s" validate:"min=0" name:"http timeout"`
+	ResolveParallelism        int           `validate:"min=0" name:"resolve parallelism"`
+	PackageCacheDir           string        `name:"package cache directory"`
+	LocalHeaders              []string      `validate:"omitempty,dive,file" name:"local kernel headers"`
+	CacheDir                  string        `name:"cache directory"`
+	CacheTTL                  time.Duration `default:"24h" validate:"min=0" name:"cache ttl"`
+	UnresolvedCacheTTL        time.Duration `default:"1h" validate:"min=0" name:"unresolved cache ttl"`
+	NoCache                   bool          `name:"no cache"`
+	StrictBuilderImageVersion bool          `name:"strict builder image version"`
+	Sysroot                   string        `validate:"omitempty,startswith=/,excludesall='" name:"sysroot"`
+	HeadersPatternOverride    string        `validate:"omitempty,excludesall=\"$\x60\\/" name:"headers pattern override"`
+	FlavorFallbackGeneric     bool          `name:"flavor fallback generic"`
+	MirrorUsername            string        `validate:"required_with=MirrorPassword" name:"mirror username"`
+	MirrorPassword            string        `name:"mirror password"`
+	MirrorToken               string        `validate:"excluded_with=MirrorUsername" name:"mirror token"`
+	StrictKernelVersion       bool          `name:"strict kernel version"`
+	StrictStatus              bool          `name:"strict status"`
+	TargetAliases             string        `validate:"omitempty,file" name:"target aliases"`
+	Mirrors                   []string      `validate:"omitempty,dive,url" name:"mirrors"`
+	ExtraMirrors              []string      `validate:"omitempty,dive,contains==" name:"extra mirrors"`
+	URLRewrite                []string      `validate:"omitempty,dive,urlrewrite" name:"url rewrite"`
+	Checksums                 []string      `validate:"omitempty,dive,contains==" name:"checksums"`
+	SkipChecksums             bool          `name:"skip checksums"`
+	KernelFlavors             []string      `validate:"omitempty,dive,required,excludesall=/_" name:"kernel flavors"`
+	Repo                      RepoOptions
+	Output                    OutputOptions
+	Attest                    AttestOptions
+	// mirrors are the ones of the target alias, if any, overridden by Mirrors.
+	mirrors []string
 }
 
 func init() {
@@ -65,6 +154,10 @@ func (ro *RootOptions) Validate() []error {
 		return errArr
 	}
 
+	if err := checkTargetArchitecture(ro.Target, ro.Architecture); err != nil {
+		return []error{err}
+	}
+
 	// check that the kernel versions supports at least one of probe and module
 	kr := kernelrelease.FromString(ro.KernelRelease)
 	kr.Architecture = kernelrelease.Architecture(ro.Architecture)
@@ -75,6 +168,16 @@ func (ro *RootOptions) Validate() []error {
 	return nil
 }
 
+// checkTargetArchitecture fails when the builder of target does not support arch.
+// The unknown targets are left to the target validation.
+func checkTargetArchitecture(target, arch string) error {
+	b, ok := builder.BuilderByTarget[builder.Type(target)]
+	if !ok {
+		return nil
+	}
+	return builder.CheckArchitecture(b, kernelrelease.Architecture(arch))
+}
+
 // Log emits a log line containing the receiving RootOptions for debugging purposes.
 //
 // Call it only after validation.
@@ -87,6 +190,12 @@ func (ro *RootOptions) Log() {
 		fields["output-probe"] = ro.Output.Probe
 
 	}
+	if ro.Output.Dockerfile != "" {
+		fields["output-dockerfile"] = ro.Output.Dockerfile
+	}
+	if ro.Output.RepoBundleOnFailure != "" {
+		fields["output-repro-bundle"] = ro.Output.RepoBundleOnFailure
+	}
 	if ro.DriverVersion != "" {
 		fields["driverversion"] = ro.DriverVersion
 	}
@@ -103,36 +212,210 @@ func (ro *RootOptions) Log() {
 	if len(ro.KernelUrls) > 0 {
 		fields["kernelurls"] = ro.KernelUrls
 	}
+	if len(ro.HeaderURLs) > 0 {
+		fields["headerurls"] = ro.HeaderURLs
+	}
+	if len(ro.LocalHeaders) > 0 {
+		fields["localheaders"] = ro.LocalHeaders
+	}
 	fields["repo-org"] = ro.Repo.Org
 	fields["repo-name"] = ro.Repo.Name
 
 	logger.WithFields(fields).Debug("running with options")
 }
 
+// fillFromKernelConfig derives the kernel release and version from the kernel config data,
+// when the kernel release is not provided.
+func (ro *RootOptions) fillFromKernelConfig() {
+	if ro.KernelRelease != "" || ro.KernelConfigData == "" {
+		return
+	}
+	configDecoded, err := base64.StdEncoding.DecodeString(ro.KernelConfigData)
+	if err != nil {
+		// validation will report it
+		return
+	}
+	v, err := builder.KernelVersionFromConfig(string(configDecoded))
+	if err != nil {
+		logger.WithError(err).Debug("unable to derive the kernel release from the kernel config data")
+		return
+	}
+	ro.KernelRelease = v.KernelRelease
+	if v.KernelVersion != "" {
+		ro.KernelVersion = v.KernelVersion
+	}
+	logger.WithField("kernelrelease", ro.KernelRelease).
+		WithField("kernelversion", ro.KernelVersion).
+		Info("kernel release derived from the kernel config data")
+}
+
+// extraMirrors parses the given architecture=mirror mappings.
+func extraMirrors(mappings []string) map[string][]string {
+	if len(mappings) == 0 {
+		return nil
+	}
+	res := make(map[string][]string)
+	for _, m := range mappings {
+		arch, mirror, _ := strings.Cut(m, "=")
+		res[arch] = append(res[arch], mirror)
+	}
+	return res
+}
+
+// urlRewriteRules parses the given url rewrite rules, already validated.
+func urlRewriteRules(rules []string) []builder.URLRewriteRule {
+	var res []builder.URLRewriteRule
+	for _, r := range rules {
+		if rule, err := builder.ParseURLRewriteRule(r); err == nil {
+			res = append(res, rule)
+		}
+	}
+	return res
+}
+
+// applyTargetAlias replaces the target with the one it is an alias of into the target aliases file, if any,
+// along with its mirrors.
+func (ro *RootOptions) applyTargetAlias() error {
+	if len(ro.TargetAliases) == 0 {
+		return nil
+	}
+	aliases, err := builder.LoadTargetAliases(ro.TargetAliases)
+	if err != nil {
+		return err
+	}
+	alias, ok := aliases[ro.Target]
+	if !ok {
+		return nil
+	}
+	logger.WithField("alias", ro.Target).WithField("target", alias.Target).Debug("target alias found")
+	ro.Target = alias.Target.String()
+	ro.mirrors = alias.Mirrors
+	return nil
+}
+
+// normalizeTarget replaces the deprecated target names with the current ones.
+func (ro *RootOptions) normalizeTarget() {
+	ro.Target = builder.CurrentTarget(builder.Type(ro.Target)).String()
+	// We just use ubuntu internally
+	if strings.HasPrefix(ro.Target, "ubuntu") {
+		ro.Target = "ubuntu"
+	}
+}
+
+// normalizeArchitecture replaces the non-deb architecture names (x86_64, aarch64) with the canonical ones.
+func (ro *RootOptions) normalizeArchitecture() {
+	if len(ro.Architecture) > 0 {
+		ro.Architecture = kernelrelease.ParseArchitecture(ro.Architecture).String()
+	}
+}
+
+// applyOutputLoader sets the naming strategy expected by the output loader, if any, when not set.
+func (ro *RootOptions) applyOutputLoader() {
+	strategy, ok := loaderNamingStrategies[ro.Output.Loader]
+	if ok && ro.Output.NamingStrategy == "" {
+		ro.Output.NamingStrategy = string(strategy)
+	}
+}
+
+// keyValues parses the given key=value mappings, e.g. flavor=package-flavor or package=sha256.
+func keyValues(mappings []string) map[string]string {
+	if len(mappings) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(mappings))
+	for _, m := range mappings {
+		k, v, _ := strings.Cut(m, "=")
+		res[k] = v
+	}
+	return res
+}
+
 func (ro *RootOptions) toBuild() *builder.Build {
 	kernelConfigData := ro.KernelConfigData
 	if len(kernelConfigData) == 0 {
 		kernelConfigData = "bm8tZGF0YQ==" // no-data
 	}
+	mirrors := ro.Mirrors
+	if len(mirrors) == 0 {
+		mirrors = ro.mirrors
+	}
 
 	build := &builder.Build{
-		TargetType:       builder.Type(ro.Target),
-		DriverVersion:    ro.DriverVersion,
-		KernelVersion:    ro.KernelVersion,
-		KernelRelease:    ro.KernelRelease,
-		Architecture:     ro.Architecture,
-		KernelConfigData: kernelConfigData,
-		ModuleFilePath:   ro.Output.Module,
-		ProbeFilePath:    ro.Output.Probe,
-		ModuleDriverName: ro.ModuleDriverName,
-		ModuleDeviceName: ro.ModuleDeviceName,
-		GCCVersion:       ro.GCCVersion,
-		BuilderImage:     ro.BuilderImage,
-		BuilderRepos:     ro.BuilderRepos,
-		KernelUrls:       ro.KernelUrls,
-		RepoOrg:          ro.Repo.Org,
-		RepoName:         ro.Repo.Name,
-		Images:           make(builder.ImagesMap),
+		TargetType:                builder.Type(ro.Target),
+		DriverVersion:             ro.DriverVersion,
+		KernelVersion:             ro.KernelVersion,
+		KernelRelease:             ro.KernelRelease,
+		Architecture:              ro.Architecture,
+		KernelConfigData:          kernelConfigData,
+		ModuleFilePath:            ro.Output.Module,
+		ProbeFilePath:             ro.Output.Probe,
+		DockerfilePath:            ro.Output.Dockerfile,
+		RepoBundleOnFailure:       ro.Output.RepoBundleOnFailure,
+		ManifestPath:              ro.Output.Manifest,
+		ModuleDriverName:          ro.ModuleDriverName,
+		ModuleDeviceName:          ro.ModuleDeviceName,
+		GCCVersion:                ro.GCCVersion,
+		BuilderImage:              ro.BuilderImage,
+		BuilderRepos:              ro.BuilderRepos,
+		KernelUrls:                ro.KernelUrls,
+		HeaderURLs:                ro.HeaderURLs,
+		RepoOrg:                   ro.Repo.Org,
+		RepoName:                  ro.Repo.Name,
+		Images:                    make(builder.ImagesMap),
+		ContainerWorkDir:          ro.ContainerWorkDir,
+		RequiredKernelConfigs:     ro.RequiredKernelConfigs,
+		PreferSecurityMirror:      ro.PreferSecurityMirror,
+		ParallelArtifacts:         ro.ParallelArtifacts,
+		ResolverEndpoint:          ro.ResolverEndpoint,
+		ResolverStrict:            ro.ResolverStrict,
+		VermagicSuffix:            ro.VermagicSuffix,
+		BuildCommit:               ro.BuildCommit,
+		ExtraKBuildFlags:          ro.ExtraKBuildFlags,
+		ListingFallback:           ro.ListingFallback,
+		Streaming:                 ro.Streaming,
+		StrictContentCheck:        ro.StrictContentCheck,
+		MinPackageSize:            ro.MinPackageSize,
+		DebianVendorPool:          ro.DebianVendorPool,
+		DebianVendorFlavors:       keyValues(ro.DebianVendorFlavors),
+		MaxDownloadRate:           ro.MaxDownloadRate,
+		KernelFlavors:             ro.KernelFlavors,
+		ConnectTimeout:            ro.ConnectTimeout,
+		ResponseHeaderTimeout:     ro.ResponseHeaderTimeout,
+		ProxyURL:                  viper.GetString("proxy"),
+		CACertPath:                ro.CACertPath,
+		HTTPRetries:               ro.HTTPRetries,
+		HTTPRetryBaseDelay:        ro.HTTPRetryBaseDelay,
+		HTTPTimeout:               ro.HTTPTimeout,
+		ResolveParallelism:        ro.ResolveParallelism,
+		VerifyToolchain:           ro.VerifyToolchain,
+		SkipIfPublished:           ro.SkipIfPublished,
+		PublishRegistry:           ro.PublishRegistry,
+		RsyncDestination:          ro.Output.Rsync.Destination,
+		RsyncSSHOptions:           ro.Output.Rsync.SSHOptions,
+		NamingStrategy:            builder.NamingStrategy(ro.Output.NamingStrategy),
+		OutputNameTemplate:        ro.Output.NameTemplate,
+		PackageCacheDir:           ro.PackageCacheDir,
+		LocalHeaders:              ro.LocalHeaders,
+		CacheDir:                  ro.CacheDir,
+		CacheTTL:                  ro.CacheTTL,
+		UnresolvedCacheTTL:        ro.UnresolvedCacheTTL,
+		NoCache:                   ro.NoCache,
+		StrictBuilderImageVersion: ro.StrictBuilderImageVersion,
+		Sysroot:                   ro.Sysroot,
+		HeadersPatternOverride:    ro.HeadersPatternOverride,
+		FlavorFallbackGeneric:     ro.FlavorFallbackGeneric,
+		MirrorUsername:            ro.MirrorUsername,
+		MirrorPassword:            ro.MirrorPassword,
+		MirrorToken:               ro.MirrorToken,
+		StrictKernelVersion:       ro.StrictKernelVersion,
+		StrictStatus:              ro.StrictStatus,
+		Mirrors:                   mirrors,
+		ExtraMirrors:              extraMirrors(ro.ExtraMirrors),
+		Attest:                    ro.Attest.Enabled,
+		AttestKeyPath:             ro.Attest.Key,
+		URLRewrite:                urlRewriteRules(ro.URLRewrite),
+		Checksums:                 keyValues(ro.Checksums),
+		SkipChecksums:             ro.SkipChecksums,
 	}
 
 	// loop over BuilderRepos to constuct the list ImagesListers based on the value of the builderRepo, if it's a local path, add FileImagesLister, otherwise add RepoImagesLister
@@ -144,6 +427,11 @@ func (ro *RootOptions) toBuild() *builder.Build {
 		}
 	}
 
+	// already validated, it cannot fail
+	if err := build.ApplyOutputNameTemplate(); err != nil {
+		logger.WithError(err).Fatal("invalid output name template")
+	}
+
 	// attempt the build in case it comes from an invalid config
 	kr := build.KernelReleaseFromBuildConfig()
 	if len(build.ModuleFilePath) > 0 && !kr.SupportsModule() {
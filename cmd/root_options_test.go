@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/validate"
+	"gotest.tools/assert"
+)
+
+func TestApplyOutputLoader(t *testing.T) {
+	for loader, want := range map[string]string{
+		"":             "",
+		"falco-modern": "current",
+		"falco-legacy": "legacy",
+	} {
+		opts := &RootOptions{Output: OutputOptions{Loader: loader}}
+		opts.applyOutputLoader()
+		assert.Equal(t, want, opts.Output.NamingStrategy, "loader %q", loader)
+	}
+
+	// the naming strategy explicitly set wins over the one of the loader
+	opts := &RootOptions{Output: OutputOptions{Loader: "falco-legacy", NamingStrategy: "current"}}
+	opts.applyOutputLoader()
+	assert.Equal(t, "current", opts.Output.NamingStrategy)
+}
+
+func TestApplyTargetAlias(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aliases.yaml")
+	assert.NilError(t, os.WriteFile(path, []byte(`aliases:
+  acme-linux:
+    target: ubuntu
+    mirrors:
+      - https://mirror.acme.internal/ubuntu/pool/main/l
+`), 0644))
+
+	opts := &RootOptions{Target: "acme-linux", TargetAliases: path}
+	assert.NilError(t, opts.applyTargetAlias())
+	b := opts.toBuild()
+	assert.Equal(t, builder.TargetTypeUbuntu, b.TargetType)
+	assert.DeepEqual(t, []string{"https://mirror.acme.internal/ubuntu/pool/main/l"}, b.Mirrors)
+
+	// while the mirrors explicitly set win over the alias ones
+	opts = &RootOptions{Target: "acme-linux", TargetAliases: path, Mirrors: []string{"https://apt.example.com/ubuntu/pool/main/l"}}
+	assert.NilError(t, opts.applyTargetAlias())
+	assert.DeepEqual(t, []string{"https://apt.example.com/ubuntu/pool/main/l"}, opts.toBuild().Mirrors)
+
+	// the targets not aliased are left alone
+	opts = &RootOptions{Target: "centos", TargetAliases: path}
+	assert.NilError(t, opts.applyTargetAlias())
+	assert.Equal(t, "centos", opts.Target)
+}
+
+func TestHeadersPatternOverrideValidation(t *testing.T) {
+	for pattern, valid := range map[string]bool{
+		"":                         true,
+		"linux-headers-*custom":    true,
+		"linux-headers-$(id)":      false,
+		"linux-headers-`id`":       false,
+		`linux-headers-"*`:         false,
+		"usr/src/linux-headers-*":  false,
+		`linux-headers-\*-generic`: false,
+	} {
+		opts := &RootOptions{HeadersPatternOverride: pattern}
+		err := validate.V.StructPartial(opts, "HeadersPatternOverride")
+		assert.Equal(t, valid, err == nil, "pattern %q: %v", pattern, err)
+	}
+	assert.Equal(t, "linux-headers-*custom", (&RootOptions{HeadersPatternOverride: "linux-headers-*custom"}).toBuild().HeadersPatternOverride)
+}
+
+func TestBuildStampValidation(t *testing.T) {
+	for commit, valid := range map[string]bool{
+		"":        true,
+		"2e7ea6c": true,
+		"2e7ea6c5e7d9e3f0b8a1c4d2f6e8a0b1c3d5e7f9": true,
+		"2e7e":       false,
+		"master; id": false,
+	} {
+		err := validate.V.StructPartial(&RootOptions{BuildCommit: commit}, "BuildCommit")
+		assert.Equal(t, valid, err == nil, "commit %q: %v", commit, err)
+	}
+	for flag, valid := range map[string]bool{
+		"KCFLAGS=-g -O2": true,
+		"W=1":            true,
+		"-j4":            false,
+		"KCFLAGS='-g'":   false,
+	} {
+		err := validate.V.StructPartial(&RootOptions{ExtraKBuildFlags: []string{flag}}, "ExtraKBuildFlags")
+		assert.Equal(t, valid, err == nil, "flag %q: %v", flag, err)
+	}
+}
+
+func TestOutputNameTemplateValidation(t *testing.T) {
+	for tmpl, valid := range map[string]bool{
+		"": true,
+		"falco_{{ .Target }}_{{ .KernelRelease }}_{{ .Arch }}": true,
+		"falco_{{ .Target }":         false,
+		"falco_{{ .Unknown }}":       false,
+		"{{ .DriverVersion }}/falco": false,
+	} {
+		err := validate.V.StructPartial(&RootOptions{Output: OutputOptions{NameTemplate: tmpl}}, "Output.NameTemplate")
+		assert.Equal(t, valid, err == nil, "template %q: %v", tmpl, err)
+	}
+
+	opts := NewRootOptions()
+	opts.Target = "ubuntu"
+	opts.Architecture = "amd64"
+	opts.KernelRelease = "5.15.0-52-generic"
+	opts.Output.Module = "/tmp/out/module.ko"
+	opts.Output.NameTemplate = "falco_{{ .Target }}_{{ .KernelRelease }}_{{ .Arch }}"
+	assert.Equal(t, "/tmp/out/falco_ubuntu_5.15.0-52-generic_x86_64.ko", opts.toBuild().ModuleFilePath)
+}
+
+func TestNormalizeArchitecture(t *testing.T) {
+	for arch, want := range map[string]string{
+		"amd64":   "amd64",
+		"x86_64":  "amd64",
+		"arm64":   "arm64",
+		"aarch64": "arm64",
+		"s390x":   "s390x",
+		"":        "",
+	} {
+		opts := &RootOptions{Architecture: arch}
+		opts.normalizeArchitecture()
+		assert.Equal(t, want, opts.Architecture, "architecture %q", arch)
+		if len(want) > 0 {
+			assert.NilError(t, validate.V.StructPartial(opts, "Architecture"), "architecture %q", arch)
+		}
+	}
+}
+
+func TestHeaderURLsValidation(t *testing.T) {
+	opts := &RootOptions{HeaderURLs: []string{"https://mirror.example.com/linux-headers.deb"}}
+	assert.NilError(t, validate.V.StructPartial(opts, "HeaderURLs"))
+
+	// the pinned urls replace the resolution, they cannot be set along with other headers
+	opts.KernelUrls = []string{"https://mirror.example.com/linux-headers-all.deb"}
+	assert.Assert(t, validate.V.StructPartial(opts, "HeaderURLs") != nil)
+	opts.KernelUrls = nil
+	opts.LocalHeaders = []string{"linux-headers.deb"}
+	assert.Assert(t, validate.V.StructPartial(opts, "HeaderURLs") != nil)
+}
+
+func TestMirrorTokenValidation(t *testing.T) {
+	opts := &RootOptions{MirrorToken: "t0k3n"}
+	assert.NilError(t, validate.V.StructPartial(opts, "MirrorToken"))
+	assert.Equal(t, "t0k3n", opts.toBuild().MirrorToken)
+
+	// the token replaces the basic auth credentials, they cannot be set together
+	opts.MirrorUsername = "builder"
+	assert.Assert(t, validate.V.StructPartial(opts, "MirrorToken") != nil)
+}
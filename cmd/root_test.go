@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestExecuteFlushesTraces(t *testing.T) {
+	c := NewRootCmd()
+	c.SetOutput(bytes.NewBuffer(nil))
+	flushes := 0
+	c.shutdownTracing = func(context.Context) error {
+		flushes++
+		return nil
+	}
+
+	// the traces get flushed when the command fails too
+	c.SetArgs([]string{"nonexisting"})
+	assert.ErrorContains(t, c.Execute(), "invalid argument")
+	assert.Equal(t, 1, flushes)
+
+	// and only once
+	c.flushTraces()
+	assert.Equal(t, 1, flushes)
+}
+
+func TestDeprecatedFlagNames(t *testing.T) {
+	for _, name := range []string{"http-timeout", "httptimeout"} {
+		c := NewRootCmd()
+		docker, _, err := c.c.Find([]string{"docker"})
+		assert.NilError(t, err)
+		assert.NilError(t, docker.ParseFlags([]string{"--" + name, "3s"}))
+		assert.Equal(t, "3s", docker.Flags().Lookup("http-timeout").Value.String(), "flag %q", name)
+	}
+}
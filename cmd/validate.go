@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	"github.com/falcosecurity/driverkit/validate"
+	"github.com/go-playground/validator/v10"
+	"github.com/olekukonko/tablewriter"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// validateFile is a list of kernels, either under the 'kernels' key (as read by warm, audit-mirrors and gen-matrix)
+// or under the 'builds' one (as read by batch).
+type validateFile struct {
+	Kernels []yaml.Node `yaml:"kernels"`
+	Builds  []yaml.Node `yaml:"builds"`
+}
+
+// kernelCheck is the outcome of the validation of a kernel of the list.
+type kernelCheck struct {
+	Target        string
+	KernelRelease string
+	Architecture  string
+	Errors        []string
+}
+
+// NewValidateCmd creates the `driverkit validate` command.
+func NewValidateCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	validateCmd := &cobra.Command{
+		Use:   "validate <file>",
+		Short: "Check the targets, kernel releases and architectures of a list of kernels, without any network access nor build.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(c *cobra.Command, args []string) {
+			if err := validateRun(os.Stdout, args[0], rootOpts); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+		},
+	}
+
+	// Add root flags: they act as defaults for each kernel
+	validateCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return validateCmd
+}
+
+func validateRun(out io.Writer, path string, rootOpts *RootOptions) error {
+	checks, err := checkKernels(path, rootOpts)
+	if err != nil {
+		return err
+	}
+	if failed := writeKernelChecks(out, checks); failed > 0 {
+		return fmt.Errorf("%d of %d kernels are invalid", failed, len(checks))
+	}
+	return nil
+}
+
+// checkKernels validates each one of the kernels listed into the given file,
+// using the values of rootOpts as defaults for each one of them.
+func checkKernels(path string, rootOpts *RootOptions) ([]kernelCheck, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var vf validateFile
+	if err := yaml.Unmarshal(data, &vf); err != nil {
+		return nil, err
+	}
+	nodes := append(vf.Kernels, vf.Builds...)
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no kernels listed into %s under the 'kernels' or 'builds' key", path)
+	}
+
+	checks := make([]kernelCheck, 0, len(nodes))
+	for _, node := range nodes {
+		opts := *rootOpts
+		if err := node.Decode(&opts); err != nil {
+			checks = append(checks, kernelCheck{Errors: []string{err.Error()}})
+			continue
+		}
+		if opts.Target != rootOpts.Target {
+			// the mirrors of the alias of the default target do not apply to the others
+			opts.mirrors = nil
+		}
+		var errs []string
+		if err := opts.applyTargetAlias(); err != nil {
+			errs = append(errs, err.Error())
+		}
+		opts.normalizeTarget()
+		opts.normalizeArchitecture()
+		checks = append(checks, kernelCheck{
+			Target:        opts.Target,
+			KernelRelease: opts.KernelRelease,
+			Architecture:  opts.Architecture,
+			Errors:        append(errs, checkKernel(&opts)...),
+		})
+	}
+	return checks, nil
+}
+
+// checkKernel returns the problems of the options identifying the kernel of opts, if any.
+func checkKernel(opts *RootOptions) []string {
+	var errs []string
+	for _, f := range []struct{ name, value, tag string }{
+		{"target", opts.Target, "required,target"},
+		{"architecture", opts.Architecture, "required,architecture"},
+		{"kernel release", opts.KernelRelease, "required,ascii"},
+	} {
+		if err := validate.V.Var(f.value, f.tag); err != nil {
+			for _, e := range err.(validator.ValidationErrors) {
+				errs = append(errs, f.name+e.Translate(validate.T))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	if err := checkTargetArchitecture(opts.Target, opts.Architecture); err != nil {
+		return []string{err.Error()}
+	}
+
+	kr := kernelrelease.FromString(opts.KernelRelease)
+	if len(kr.Fullversion) == 0 {
+		return []string{fmt.Sprintf("malformed kernel release %s", opts.KernelRelease)}
+	}
+	kr.Architecture = kernelrelease.Architecture(opts.Architecture)
+	if !kr.SupportsModule() && !kr.SupportsProbe() {
+		return []string{fmt.Sprintf("both module and probe are not supported by kernel release %s on %s", opts.KernelRelease, opts.Architecture)}
+	}
+	return nil
+}
+
+// writeKernelChecks writes the outcome of the validation of each kernel, returning the number of invalid ones.
+func writeKernelChecks(out io.Writer, checks []kernelCheck) int {
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{"#", "Target", "Kernel release", "Architecture", "Result"})
+	table.SetAutoFormatHeaders(false)
+	table.SetAutoWrapText(false)
+	table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+	table.SetCenterSeparator("|")
+
+	failed := 0
+	for i, c := range checks {
+		result := "ok"
+		if len(c.Errors) > 0 {
+			result = "FAIL: " + strings.Join(c.Errors, "; ")
+			failed++
+		}
+		table.Append([]string{strconv.Itoa(i), c.Target, c.KernelRelease, c.Architecture, result})
+	}
+	table.Render()
+	return failed
+}
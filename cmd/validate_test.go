@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestValidate(t *testing.T) {
+	kernels := filepath.Join(t.TempDir(), "kernels.yaml")
+	assert.NilError(t, os.WriteFile(kernels, []byte(`kernels:
+  - target: ubuntu-generic
+    kernelrelease: 5.15.0-52-generic
+  - target: centoss
+    kernelrelease: 4.18.0-372.9.1.el8.x86_64
+  - target: centos
+    architecture: ppc64
+    kernelrelease: 4.18.0-372.9.1.el8.ppc64
+  - target: debian
+    kernelrelease: not-a-release
+  - target: centos
+    architecture: s390x
+    kernelrelease: 3.0.101-63-default
+builds:
+  - target: debian
+    architecture: arm64
+    kernelrelease: 6.1.0-18-arm64
+  - target: photon
+    architecture: arm64
+    kernelrelease: 5.10.152-2.ph4
+`), 0644))
+
+	ro := NewRootOptions()
+	ro.Architecture = "amd64"
+	var out bytes.Buffer
+	err := validateRun(&out, kernels, ro)
+	assert.Error(t, err, "5 of 7 kernels are invalid")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	// the header, its separator and a row per kernel
+	assert.Equal(t, len(lines), 9, out.String())
+	for i, want := range []string{
+		"| ok ",
+		"FAIL: target must be a valid target",
+		"FAIL: architecture must be a valid architecture",
+		"FAIL: malformed kernel release not-a-release",
+		"FAIL: both module and probe are not supported by kernel release 3.0.101-63-default on s390x",
+		"| ok ",
+		"FAIL: target photon does not support architecture arm64, supported ones: amd64",
+	} {
+		assert.Assert(t, strings.Contains(lines[i+2], want), "kernel #%d: %s", i, lines[i+2])
+	}
+	assert.Assert(t, strings.Contains(lines[2], "| ubuntu "), lines[2])
+
+	// a file not listing any kernel is an error too
+	empty := filepath.Join(t.TempDir(), "empty.yaml")
+	assert.NilError(t, os.WriteFile(empty, []byte("kernelrelease: 5.15.0-52-generic\n"), 0644))
+	assert.ErrorContains(t, validateRun(&out, empty, ro), "no kernels listed")
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/signals"
+	"github.com/falcosecurity/driverkit/validate"
+	"github.com/go-playground/validator/v10"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+type warmFile struct {
+	Kernels []yaml.Node `yaml:"kernels"`
+}
+
+// NewWarmCmd creates the `driverkit warm` command.
+func NewWarmCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	warmCmd := &cobra.Command{
+		Use:   "warm",
+		Short: "Pull the builder images and fetch the kernel headers of a list of kernels into the package cache, ahead of building them.",
+		Run: func(c *cobra.Command, args []string) {
+			logger.WithField("processor", "docker").Info("warming up, it will take a while")
+			if err := warmRun(rootOpts); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+		},
+	}
+
+	// Add warm options flags
+	flags := warmCmd.Flags()
+	addWarmFlags(flags)
+	warmCmd.PersistentFlags().AddFlagSet(flags)
+	// Add root flags: they act as defaults for each kernel
+	warmCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return warmCmd
+}
+
+func warmRun(rootOpts *RootOptions) error {
+	if err := validate.V.Struct(warmOptions); err != nil {
+		for _, e := range err.(validator.ValidationErrors) {
+			logger.WithError(fmt.Errorf(e.Translate(validate.T))).Error("error validating warm options")
+		}
+		return fmt.Errorf("exiting for validation errors")
+	}
+	if len(rootOpts.PackageCacheDir) == 0 {
+		return fmt.Errorf("a package cache directory is required to warm it up")
+	}
+
+	builds, err := loadKernelBuilds(warmOptions.Kernels, rootOpts)
+	if err != nil {
+		return err
+	}
+	if configOptions.DryRun {
+		return nil
+	}
+	if err := driverbuilder.Warm(signals.WithStandardSignals(context.Background()), builds, warmOptions.Parallelism); err != nil {
+		return err
+	}
+	logger.WithField("kernels", len(builds)).WithField("path", rootOpts.PackageCacheDir).Info("package cache warmed up")
+	return nil
+}
+
+// loadKernelBuilds reads the kernels listed into the given file,
+// using the values of rootOpts as defaults for each one of them.
+// Only the options identifying the kernels are required, as nothing gets built.
+func loadKernelBuilds(path string, rootOpts *RootOptions) ([]*builder.Build, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var wf warmFile
+	if err := yaml.Unmarshal(data, &wf); err != nil {
+		return nil, err
+	}
+
+	var builds []*builder.Build
+	for i, node := range wf.Kernels {
+		opts := *rootOpts
+		if err := node.Decode(&opts); err != nil {
+			return nil, fmt.Errorf("kernel #%d: %w", i, err)
+		}
+		if opts.Target != rootOpts.Target {
+			// the mirrors of the alias of the default target do not apply to the others
+			opts.mirrors = nil
+		}
+		if err := opts.applyTargetAlias(); err != nil {
+			return nil, fmt.Errorf("kernel #%d: %w", i, err)
+		}
+		opts.normalizeTarget()
+		opts.normalizeArchitecture()
+		valid := true
+		for _, f := range []struct{ name, value, tag string }{
+			{"target", opts.Target, "required,target"},
+			{"architecture", opts.Architecture, "required,architecture"},
+			{"kernel release", opts.KernelRelease, "required,ascii"},
+		} {
+			if err := validate.V.Var(f.value, f.tag); err != nil {
+				for _, e := range err.(validator.ValidationErrors) {
+					logger.WithError(fmt.Errorf("%s%s", f.name, e.Translate(validate.T))).WithField("kernel", i).Error("error validating kernel options")
+				}
+				valid = false
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("exiting for validation errors")
+		}
+		builds = append(builds, opts.toBuild())
+	}
+	return builds, nil
+}
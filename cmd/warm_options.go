@@ -0,0 +1,18 @@
+package cmd
+
+import (
+	flag "github.com/spf13/pflag"
+)
+
+var warmOptions = &WarmOptions{}
+
+// WarmOptions represent the flags of the warm command.
+type WarmOptions struct {
+	Kernels     string `validate:"required,file" name:"kernels file"`
+	Parallelism int    `validate:"min=1" name:"parallelism"`
+}
+
+func addWarmFlags(flags *flag.FlagSet) {
+	flags.StringVar(&warmOptions.Kernels, "kernels", "", "yaml file listing the kernels to warm up under the 'kernels' key, each one with the same format of the config file")
+	flags.IntVar(&warmOptions.Parallelism, "parallelism", 4, "maximum number of kernels whose headers are fetched at the same time")
+}
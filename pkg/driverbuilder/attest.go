@@ -0,0 +1,254 @@
+package driverbuilder
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"debug/elf"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/version"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v0.1"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v0.2"
+	// dsseInTotoPayloadType is the payload type of the DSSE envelopes of the in-toto statements.
+	dsseInTotoPayloadType = "application/vnd.in-toto+json"
+	driverkitBuildType    = "https://github.com/falcosecurity/driverkit/build@v1"
+	// attestationExt is appended to the paths of the artifacts to get the ones of their attestations.
+	attestationExt = ".att"
+	// cosignPasswordEnv is the environment variable holding the password of the encrypted cosign keys.
+	cosignPasswordEnv = "COSIGN_PASSWORD"
+)
+
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	PredicateType string          `json:"predicateType"`
+	Subject       []inTotoSubject `json:"subject"`
+	Predicate     slsaProvenance  `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// slsaProvenance is the SLSA provenance predicate of the artifacts,
+// linking them to the build parameters, the kernel headers packages and the builder image.
+type slsaProvenance struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType  string `json:"buildType"`
+	Invocation struct {
+		Parameters map[string]string `json:"parameters"`
+	} `json:"invocation"`
+	Materials []slsaMaterial `json:"materials"`
+}
+
+type slsaMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// dsseEnvelope is the signed envelope of an in-toto statement, as produced and verified by cosign.
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// dssePAE returns the pre-authentication encoding of the payload, i.e. what gets actually signed.
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// cosignEncryptedKey is the content of the encrypted private keys generated by cosign.
+type cosignEncryptedKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// loadAttestKey reads the ECDSA private key signing the attestations, either a PEM encoded one
+// or an encrypted cosign one, whose password is read from the COSIGN_PASSWORD environment variable.
+func loadAttestKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found into %s", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "ENCRYPTED COSIGN PRIVATE KEY", "ENCRYPTED SIGSTORE PRIVATE KEY":
+		var der []byte
+		if der, err = decryptCosignKey(block.Bytes, []byte(os.Getenv(cosignPasswordEnv))); err == nil {
+			key, err = x509.ParsePKCS8PrivateKey(der)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported %s key into %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the key into %s is not an ECDSA one", path)
+	}
+	return ecKey, nil
+}
+
+func decryptCosignKey(data, password []byte) ([]byte, error) {
+	var k cosignEncryptedKey
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, err
+	}
+	if k.KDF.Name != "scrypt" || k.Cipher.Name != "nacl/secretbox" || len(k.Cipher.Nonce) != 24 {
+		return nil, fmt.Errorf("unsupported cosign key encryption: %s, %s", k.KDF.Name, k.Cipher.Name)
+	}
+	secret, err := scrypt.Key(password, k.KDF.Salt, k.KDF.Params.N, k.KDF.Params.R, k.KDF.Params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	var secretKey [32]byte
+	copy(nonce[:], k.Cipher.Nonce)
+	copy(secretKey[:], secret)
+	der, ok := secretbox.Open(nil, k.Ciphertext, &nonce, &secretKey)
+	if !ok {
+		return nil, fmt.Errorf("unable to decrypt the cosign key, check %s", cosignPasswordEnv)
+	}
+	return der, nil
+}
+
+// newProvenance returns the provenance of the artifacts of b, built into the given builder image.
+func newProvenance(ctx context.Context, b *builder.Build, image, imageDigest string) (slsaProvenance, error) {
+	var p slsaProvenance
+	p.Builder.ID = "https://github.com/falcosecurity/driverkit@" + version.String()
+	p.BuildType = driverkitBuildType
+	p.Invocation.Parameters = map[string]string{
+		"target":        b.TargetType.String(),
+		"kernelrelease": b.KernelRelease,
+		"kernelversion": b.KernelVersion,
+		"architecture":  b.Architecture,
+		"driverversion": b.DriverVersion,
+	}
+	// the toolchain is the one recorded into the module itself
+	if len(b.ModuleFilePath) > 0 {
+		if f, err := elf.Open(b.ModuleFilePath); err == nil {
+			if note, err := builder.ParseModuleNote(f); err == nil {
+				p.Invocation.Parameters["gccversion"] = note.GCCVersion
+			}
+			f.Close()
+		}
+	}
+
+	for _, u := range b.ResolvedURLs {
+		sum, err := builder.PackageDigest(ctx, b, u)
+		if err != nil {
+			return p, fmt.Errorf("unable to digest the kernel headers package %s: %w", builder.RedactURL(u), err)
+		}
+		p.Materials = append(p.Materials, slsaMaterial{URI: builder.RedactURL(u), Digest: map[string]string{"sha256": sum}})
+	}
+	material := slsaMaterial{URI: "docker://" + image}
+	if algo, hex, ok := strings.Cut(imageDigest, ":"); ok {
+		material.Digest = map[string]string{algo: hex}
+	}
+	p.Materials = append(p.Materials, material)
+	return p, nil
+}
+
+// attestArtifacts writes, next to each artifact of b, the DSSE envelope of its signed in-toto statement,
+// when b asks for it.
+func attestArtifacts(ctx context.Context, b *builder.Build, image, imageDigest string) error {
+	if !b.Attest {
+		return nil
+	}
+	key, err := loadAttestKey(b.AttestKeyPath)
+	if err != nil {
+		return err
+	}
+	provenance, err := newProvenance(ctx, b, image, imageDigest)
+	if err != nil {
+		return err
+	}
+	for _, p := range []string{b.ModuleFilePath, b.ProbeFilePath} {
+		if len(p) == 0 {
+			continue
+		}
+		sum, err := fileSHA256(p)
+		if err != nil {
+			return err
+		}
+		envelope, err := signStatement(key, inTotoStatement{
+			Type:          inTotoStatementType,
+			PredicateType: slsaProvenanceType,
+			Subject:       []inTotoSubject{{Name: filepath.Base(p), Digest: map[string]string{"sha256": sum}}},
+			Predicate:     provenance,
+		})
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(p+attestationExt, envelope, 0644); err != nil {
+			return err
+		}
+		logger.WithField("path", p+attestationExt).Info("attestation available")
+	}
+	return nil
+}
+
+// signStatement returns the DSSE envelope of the given statement signed with key.
+func signStatement(key *ecdsa.PrivateKey, statement inTotoStatement) ([]byte, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(dssePAE(dsseInTotoPayloadType, payload))
+	sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(dsseEnvelope{
+		PayloadType: dsseInTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []dsseSignature{{Sig: base64.StdEncoding.EncodeToString(sig)}},
+	})
+}
@@ -0,0 +1,212 @@
+package driverbuilder
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// verifyEnvelope checks the signature of the envelope with pub, returning its statement.
+func verifyEnvelope(t *testing.T, pub *ecdsa.PublicKey, data []byte) inTotoStatement {
+	t.Helper()
+	var env dsseEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatalf("Invalid envelope: %s", err)
+	}
+	if env.PayloadType != dsseInTotoPayloadType || len(env.Signatures) != 1 {
+		t.Fatalf("Unexpected envelope: %+v", env)
+	}
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(env.Signatures[0].Sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(dssePAE(env.PayloadType, payload))
+	if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+		t.Fatalf("Invalid envelope signature")
+	}
+	var statement inTotoStatement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		t.Fatalf("Invalid statement: %s", err)
+	}
+	return statement
+}
+
+func writeTestKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "attest.key")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return key, path
+}
+
+func TestAttestArtifacts(t *testing.T) {
+	key, keyPath := writeTestKey(t)
+	out := t.TempDir()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(path.Base(r.URL.Path)))
+	}))
+	defer mirror.Close()
+	b := &builder.Build{
+		TargetType:     builder.TargetTypeUbuntu,
+		KernelRelease:  "5.15.0-52-generic",
+		KernelVersion:  "58",
+		Architecture:   "amd64",
+		DriverVersion:  "2.0.0+driver",
+		ModuleFilePath: filepath.Join(out, "falco.ko"),
+		ProbeFilePath:  filepath.Join(out, "falco.o"),
+		Attest:         true,
+		AttestKeyPath:  keyPath,
+		ResolvedURLs: []string{
+			mirror.URL + "/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+			mirror.URL + "/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+		},
+		// the digests of the verified packages are the known-good ones
+		Checksums: map[string]string{"linux-headers-5.15.0-52_5.15.0-52.58_all.deb": "ABCD0123"},
+	}
+	for _, p := range []string{b.ModuleFilePath, b.ProbeFilePath} {
+		if err := os.WriteFile(p, []byte(filepath.Base(p)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	image := "docker.io/falcosecurity/driverkit-builder-any-x86_64:latest"
+	if err := attestArtifacts(context.Background(), b, image, "sha256:0123abcd"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for _, p := range []string{b.ModuleFilePath, b.ProbeFilePath} {
+		data, err := os.ReadFile(p + attestationExt)
+		if err != nil {
+			t.Fatalf("Expected an attestation for %s: %s", p, err)
+		}
+		statement := verifyEnvelope(t, &key.PublicKey, data)
+		if statement.Type != inTotoStatementType || statement.PredicateType != slsaProvenanceType {
+			t.Errorf("Unexpected statement types: %s, %s", statement.Type, statement.PredicateType)
+		}
+		expectedSubject := []inTotoSubject{{
+			Name:   filepath.Base(p),
+			Digest: map[string]string{"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte(filepath.Base(p))))},
+		}}
+		if !reflect.DeepEqual(expectedSubject, statement.Subject) {
+			t.Errorf("Expected subject %v, got %v", expectedSubject, statement.Subject)
+		}
+		expectedMaterials := []slsaMaterial{
+			{URI: b.ResolvedURLs[0], Digest: map[string]string{"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte("linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb")))}},
+			{URI: b.ResolvedURLs[1], Digest: map[string]string{"sha256": "abcd0123"}},
+			{URI: "docker://" + image, Digest: map[string]string{"sha256": "0123abcd"}},
+		}
+		if !reflect.DeepEqual(expectedMaterials, statement.Predicate.Materials) {
+			t.Errorf("Expected materials %v, got %v", expectedMaterials, statement.Predicate.Materials)
+		}
+		if params := statement.Predicate.Invocation.Parameters; params["kernelrelease"] != b.KernelRelease || params["target"] != "ubuntu" {
+			t.Errorf("Unexpected invocation parameters: %v", params)
+		}
+	}
+
+	// a statement signed by another key does not verify
+	other, _ := writeTestKey(t)
+	data, _ := os.ReadFile(b.ModuleFilePath + attestationExt)
+	var env dsseEnvelope
+	json.Unmarshal(data, &env)
+	payload, _ := base64.StdEncoding.DecodeString(env.Payload)
+	sig, _ := base64.StdEncoding.DecodeString(env.Signatures[0].Sig)
+	digest := sha256.Sum256(dssePAE(env.PayloadType, payload))
+	if ecdsa.VerifyASN1(&other.PublicKey, digest[:], sig) {
+		t.Errorf("Expected the signature not to verify with another key")
+	}
+}
+
+func TestLoadAttestKeyEncryptedCosign(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// encrypt it as cosign does
+	var encrypted cosignEncryptedKey
+	encrypted.KDF.Name = "scrypt"
+	encrypted.KDF.Params.N, encrypted.KDF.Params.R, encrypted.KDF.Params.P = 1024, 8, 1
+	encrypted.KDF.Salt = []byte("0123456789abcdef0123456789abcdef")
+	encrypted.Cipher.Name = "nacl/secretbox"
+	encrypted.Cipher.Nonce = []byte("0123456789abcdef01234567")
+	secret, err := scrypt.Key([]byte("s3cr3t"), encrypted.KDF.Salt, 1024, 8, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nonce [24]byte
+	var secretKey [32]byte
+	copy(nonce[:], encrypted.Cipher.Nonce)
+	copy(secretKey[:], secret)
+	encrypted.Ciphertext = secretbox.Seal(nil, der, &nonce, &secretKey)
+	data, err := json.Marshal(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cosign.key")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED COSIGN PRIVATE KEY", Bytes: data}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(cosignPasswordEnv, "wrong")
+	if _, err := loadAttestKey(path); err == nil {
+		t.Errorf("Expected an error with the wrong password")
+	}
+	t.Setenv(cosignPasswordEnv, "s3cr3t")
+	loaded, err := loadAttestKey(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !loaded.Equal(key) {
+		t.Errorf("Expected the decrypted key to match the original one")
+	}
+}
+
+func TestPodImageDigest(t *testing.T) {
+	for id, expected := range map[string]string{
+		"docker-pullable://docker.io/falcosecurity/driverkit-builder@sha256:0123abcd": "sha256:0123abcd",
+		"docker.io/falcosecurity/driverkit-builder@sha256:0123abcd":                   "sha256:0123abcd",
+		"sha256:0123abcd": "sha256:0123abcd",
+		"":                "",
+	} {
+		p := &corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{ImageID: id}}}}
+		if got := podImageDigest(p); got != expected {
+			t.Errorf("Expected the digest of %q to be %q, got %q", id, expected, got)
+		}
+	}
+}
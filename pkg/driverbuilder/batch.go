@@ -0,0 +1,226 @@
+package driverbuilder
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+)
+
+// ContextBuildProcessor is a BuildProcessor whose builds can be cancelled through a context.
+type ContextBuildProcessor interface {
+	BuildProcessor
+	StartWithContext(ctx context.Context, b *builder.Build) error
+}
+
+// startWithContext starts the build with bp, giving up on it when ctx is done.
+// Builds of processors not implementing ContextBuildProcessor are left running in the background.
+func startWithContext(ctx context.Context, bp BuildProcessor, b *builder.Build) error {
+	if cbp, ok := bp.(ContextBuildProcessor); ok {
+		return cbp.StartWithContext(ctx, b)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- bp.Start(b)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// BatchJob is a build of a batch. Jobs with a higher priority are started first;
+// jobs with a priority lower or equal to zero are low-priority ones.
+type BatchJob struct {
+	Build    *builder.Build
+	Priority int
+}
+
+// BatchFailure is a batch job whose build failed.
+type BatchFailure struct {
+	BatchJob
+	Err error
+}
+
+// BatchReport is the outcome of a batch.
+type BatchReport struct {
+	Built  []BatchJob
+	Failed []BatchFailure
+	// Unmet contains the jobs that were not built in time, either because
+	// they were never started or because they got cancelled.
+	Unmet []BatchJob
+}
+
+// BatchBuildProcessor runs batches of builds with a BuildProcessor.
+type BatchBuildProcessor struct {
+	processor   BuildProcessor
+	parallelism int
+	deadline    time.Duration
+	grace       time.Duration
+}
+
+// NewBatchBuildProcessor constructs a BatchBuildProcessor running up to parallelism builds at once.
+//
+// When deadline is not zero, the batch must complete within it: during the last grace period before the
+// deadline low-priority jobs are not started anymore and the in-flight ones are cancelled,
+// to leave room to the prioritized ones; once the deadline is reached, every in-flight build is cancelled.
+func NewBatchBuildProcessor(processor BuildProcessor, parallelism int, deadline, grace time.Duration) *BatchBuildProcessor {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	return &BatchBuildProcessor{
+		processor:   processor,
+		parallelism: parallelism,
+		deadline:    deadline,
+		grace:       grace,
+	}
+}
+
+type batchRun struct {
+	BatchJob
+	cancel context.CancelFunc
+}
+
+// shareURLResolvers makes the builds without a URLResolver share one per transport settings,
+// reusing its connections and mirror timings while resolving their kernel headers.
+func shareURLResolvers(builds []*builder.Build) {
+	resolvers := make(map[builder.TransportSettings]*builder.URLResolver)
+	for _, b := range builds {
+		if b.URLResolver != nil {
+			continue
+		}
+		key := b.TransportSettings()
+		if resolvers[key] == nil {
+			resolver, err := builder.NewURLResolver(key)
+			if err != nil {
+				logger.WithError(err).Warn("unable to share the url resolution among the builds")
+				continue
+			}
+			resolvers[key] = resolver
+		}
+		b.URLResolver = resolvers[key]
+	}
+}
+
+// Run builds the given jobs, returning a report of the batch.
+// The builds of the jobs share their URLResolver.
+func (bp *BatchBuildProcessor) Run(ctx context.Context, jobs []BatchJob) *BatchReport {
+	builds := make([]*builder.Build, len(jobs))
+	for i, j := range jobs {
+		builds[i] = j.Build
+	}
+	shareURLResolvers(builds)
+
+	pending := make([]BatchJob, len(jobs))
+	copy(pending, jobs)
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].Priority > pending[j].Priority
+	})
+
+	var approaching <-chan time.Time
+	if bp.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, bp.deadline)
+		defer cancel()
+		if bp.grace > 0 && bp.grace < bp.deadline {
+			timer := time.NewTimer(bp.deadline - bp.grace)
+			defer timer.Stop()
+			approaching = timer.C
+		}
+	}
+
+	report := &BatchReport{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	inFlight := map[*batchRun]struct{}{}
+	slots := make(chan struct{}, bp.parallelism)
+	nearDeadline := false
+
+	run := func(r *batchRun, jobCtx context.Context) {
+		defer wg.Done()
+		defer func() { <-slots }()
+		err := startWithContext(jobCtx, bp.processor, r.Build)
+
+		mu.Lock()
+		defer mu.Unlock()
+		delete(inFlight, r)
+		switch {
+		case jobCtx.Err() != nil:
+			logger.WithField("kernelrelease", r.Build.KernelRelease).Warn("build cancelled before completion")
+			report.Unmet = append(report.Unmet, r.BatchJob)
+		case err != nil:
+			logger.WithError(err).WithField("kernelrelease", r.Build.KernelRelease).Error("build failed")
+			report.Failed = append(report.Failed, BatchFailure{BatchJob: r.BatchJob, Err: err})
+		default:
+			report.Built = append(report.Built, r.BatchJob)
+		}
+	}
+
+	cancelLowPriority := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		nearDeadline = true
+		for r := range inFlight {
+			if r.Priority <= 0 {
+				logger.WithField("kernelrelease", r.Build.KernelRelease).Info("deadline approaching, cancelling low-priority build")
+				r.cancel()
+			}
+		}
+	}
+
+dispatch:
+	for len(pending) > 0 {
+		select {
+		case slots <- struct{}{}:
+		case <-approaching:
+			approaching = nil
+			cancelLowPriority()
+			continue
+		case <-ctx.Done():
+			break dispatch
+		}
+
+		mu.Lock()
+		if ctx.Err() != nil || (nearDeadline && pending[0].Priority <= 0) {
+			mu.Unlock()
+			<-slots
+			break dispatch
+		}
+		jobCtx, cancel := context.WithCancel(ctx)
+		r := &batchRun{BatchJob: pending[0], cancel: cancel}
+		pending = pending[1:]
+		inFlight[r] = struct{}{}
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer cancel()
+			run(r, jobCtx)
+		}()
+	}
+
+	// Keep cancelling low-priority builds once the deadline approaches
+	// while waiting for the in-flight ones
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-approaching:
+		cancelLowPriority()
+		<-done
+	}
+
+	report.Unmet = append(report.Unmet, pending...)
+	for _, j := range pending {
+		logger.WithField("kernelrelease", j.Build.KernelRelease).Warn("build not started before the deadline")
+	}
+	return report
+}
@@ -0,0 +1,129 @@
+package driverbuilder
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// sleepBuildProcessor fakes builds lasting the duration set for their kernel release.
+type sleepBuildProcessor struct {
+	durations map[string]time.Duration
+
+	mu        sync.Mutex
+	cancelled []string
+}
+
+func (bp *sleepBuildProcessor) String() string {
+	return "sleep"
+}
+
+func (bp *sleepBuildProcessor) Start(b *builder.Build) error {
+	return bp.StartWithContext(context.Background(), b)
+}
+
+func (bp *sleepBuildProcessor) StartWithContext(ctx context.Context, b *builder.Build) error {
+	select {
+	case <-time.After(bp.durations[b.KernelRelease]):
+		return nil
+	case <-ctx.Done():
+		bp.mu.Lock()
+		bp.cancelled = append(bp.cancelled, b.KernelRelease)
+		bp.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func kernelReleases(jobs []BatchJob) []string {
+	res := []string{}
+	for _, j := range jobs {
+		res = append(res, j.Build.KernelRelease)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func assertKernelReleases(t *testing.T, what string, got []BatchJob, want ...string) {
+	t.Helper()
+	releases := kernelReleases(got)
+	if len(releases) != len(want) {
+		t.Fatalf("%s: got %v, want %v", what, releases, want)
+	}
+	for i := range want {
+		if releases[i] != want[i] {
+			t.Fatalf("%s: got %v, want %v", what, releases, want)
+		}
+	}
+}
+
+func TestBatchDeadline(t *testing.T) {
+	processor := &sleepBuildProcessor{
+		durations: map[string]time.Duration{
+			"5.15.0-1": time.Millisecond,
+			"5.15.0-2": time.Minute,
+			"5.15.0-3": time.Minute,
+			"5.15.0-4": time.Minute,
+		},
+	}
+	jobs := []BatchJob{
+		{Build: &builder.Build{KernelRelease: "5.15.0-4"}, Priority: 0},
+		{Build: &builder.Build{KernelRelease: "5.15.0-3"}, Priority: 1},
+		{Build: &builder.Build{KernelRelease: "5.15.0-2"}, Priority: 2},
+		{Build: &builder.Build{KernelRelease: "5.15.0-1"}, Priority: 3},
+	}
+
+	start := time.Now()
+	report := NewBatchBuildProcessor(processor, 1, 100*time.Millisecond, 0).Run(context.Background(), jobs)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("batch took %s, expected it to stop at the deadline", elapsed)
+	}
+
+	assertKernelReleases(t, "built", report.Built, "5.15.0-1")
+	assertKernelReleases(t, "unmet", report.Unmet, "5.15.0-2", "5.15.0-3", "5.15.0-4")
+	if len(report.Failed) != 0 {
+		t.Fatalf("expected no failed builds, got %v", report.Failed)
+	}
+	// Only the in-flight build got cancelled, the others never started
+	if len(processor.cancelled) != 1 || processor.cancelled[0] != "5.15.0-2" {
+		t.Fatalf("expected the in-flight build to be cancelled, got %v", processor.cancelled)
+	}
+}
+
+func TestBatchDeadlineApproachingCancelsLowPriority(t *testing.T) {
+	processor := &sleepBuildProcessor{
+		durations: map[string]time.Duration{
+			"5.15.0-1": 200 * time.Millisecond,
+			"5.15.0-2": time.Minute,
+		},
+	}
+	jobs := []BatchJob{
+		{Build: &builder.Build{KernelRelease: "5.15.0-1"}, Priority: 1},
+		{Build: &builder.Build{KernelRelease: "5.15.0-2"}, Priority: 0},
+	}
+
+	report := NewBatchBuildProcessor(processor, 2, 5*time.Second, 4950*time.Millisecond).Run(context.Background(), jobs)
+
+	assertKernelReleases(t, "built", report.Built, "5.15.0-1")
+	assertKernelReleases(t, "unmet", report.Unmet, "5.15.0-2")
+}
+
+func TestBatchSharesURLResolver(t *testing.T) {
+	jobs := []BatchJob{
+		{Build: &builder.Build{KernelRelease: "5.15.0-1"}},
+		{Build: &builder.Build{KernelRelease: "5.15.0-2"}},
+		{Build: &builder.Build{KernelRelease: "5.15.0-3", ConnectTimeout: time.Second}},
+	}
+
+	NewBatchBuildProcessor(&sleepBuildProcessor{}, 2, 0, 0).Run(context.Background(), jobs)
+
+	if jobs[0].Build.URLResolver == nil || jobs[0].Build.URLResolver != jobs[1].Build.URLResolver {
+		t.Fatalf("Expected the builds to share a resolver")
+	}
+	if jobs[2].Build.URLResolver == nil || jobs[2].Build.URLResolver == jobs[0].Build.URLResolver {
+		t.Fatalf("Expected the build with different timeouts to get its own resolver")
+	}
+}
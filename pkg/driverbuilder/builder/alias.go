@@ -0,0 +1,49 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TargetAlias maps a derivative distribution, e.g. one whose os-release ID is not "ubuntu" but using the Ubuntu pool,
+// onto the builder of an existing target.
+type TargetAlias struct {
+	Target Type `yaml:"target"`
+	// Mirrors, when set, are where the kernel headers get looked for, in place of the default mirrors of the target.
+	Mirrors []string `yaml:"mirrors"`
+}
+
+// TargetAliases maps the names of the derivative targets onto their aliases.
+type TargetAliases map[string]TargetAlias
+
+type targetAliasesFile struct {
+	Aliases TargetAliases `yaml:"aliases"`
+}
+
+// LoadTargetAliases reads the target aliases from the given mapping file.
+//
+// Example:
+//
+//	aliases:
+//	  acme-linux:
+//	    target: ubuntu
+//	    mirrors:
+//	      - https://mirror.acme.internal/ubuntu/pool/main/l
+func LoadTargetAliases(path string) (TargetAliases, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f targetAliasesFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	for name, alias := range f.Aliases {
+		if _, ok := BuilderByTarget[alias.Target]; !ok {
+			return nil, fmt.Errorf("target alias %s: no builder found for target: %s", name, alias.Target)
+		}
+	}
+	return f.Aliases, nil
+}
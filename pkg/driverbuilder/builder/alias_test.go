@@ -0,0 +1,71 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestTargetAliasResolvesWithMirrorOverride(t *testing.T) {
+	mirror := newUbuntuFixtureMirror(
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+	)
+	defer mirror.Close()
+
+	path := filepath.Join(t.TempDir(), "aliases.yaml")
+	if err := os.WriteFile(path, []byte(`aliases:
+  acme-linux:
+    target: ubuntu
+    mirrors:
+      - `+mirror.URL+`
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	aliases, err := LoadTargetAliases(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	alias, ok := aliases["acme-linux"]
+	if !ok || alias.Target != TargetTypeUbuntu {
+		t.Fatalf("Expected acme-linux to be an alias of ubuntu, got %v", aliases)
+	}
+
+	b, err := Factory(alias.Target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestConfig(alias.Target)
+	c.KernelRelease = "5.15.0-52-generic"
+	c.KernelVersion = "58"
+	c.Mirrors = alias.Mirrors
+	kr := kernelrelease.FromString(c.KernelRelease)
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+
+	urls, err := KernelURLs(context.Background(), b, c, kr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(urls) != ubuntuRequiredURLs {
+		t.Fatalf("Expected %d urls, got %v", ubuntuRequiredURLs, urls)
+	}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, mirror.URL+"/") {
+			t.Errorf("Expected %s to be served by the override mirror", u)
+		}
+	}
+}
+
+func TestLoadTargetAliasesUnknownTarget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aliases.yaml")
+	if err := os.WriteFile(path, []byte("aliases:\n  acme-linux:\n    target: acme\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTargetAliases(path); err == nil {
+		t.Errorf("Expected an error for the alias of an unknown target")
+	}
+}
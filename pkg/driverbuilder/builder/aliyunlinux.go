@@ -1,13 +1,14 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
-//go:embed templates/alinux.sh
-var alinuxTemplate string
+//go:embed templates/alinux.sh.gz
+var alinuxTemplate []byte
 
 // TargetTypeAlinux identifies the AliyunLinux 2 and 3 target.
 const TargetTypeAlinux Type = "alinux"
@@ -30,11 +31,15 @@ func (c *alinux) Name() string {
 	return TargetTypeAlinux.String()
 }
 
+func (c *alinux) SupportedArchitectures() []kernelrelease.Architecture {
+	return []kernelrelease.Architecture{kernelrelease.ArchitectureAmd64, kernelrelease.ArchitectureArm64}
+}
+
 func (c *alinux) TemplateScript() string {
-	return alinuxTemplate
+	return decompressTemplate(alinuxTemplate)
 }
 
-func (c *alinux) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (c *alinux) URLs(_ context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
 	return fetchAlinuxKernelURLS(kr), nil
 }
 
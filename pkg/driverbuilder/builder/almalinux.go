@@ -1,13 +1,14 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
-//go:embed templates/almalinux.sh
-var almaTemplate string
+//go:embed templates/almalinux.sh.gz
+var almaTemplate []byte
 
 // TargetTypeAlma identifies the AlmaLinux target.
 const TargetTypeAlma Type = "almalinux"
@@ -29,11 +30,15 @@ func (c *alma) Name() string {
 	return TargetTypeAlma.String()
 }
 
+func (c *alma) SupportedArchitectures() []kernelrelease.Architecture {
+	return DefaultArchitectures()
+}
+
 func (c *alma) TemplateScript() string {
-	return almaTemplate
+	return decompressTemplate(almaTemplate)
 }
 
-func (c *alma) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (c *alma) URLs(_ context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
 	return fetchAlmaKernelURLS(kr), nil
 }
 
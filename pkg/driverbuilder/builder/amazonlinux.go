@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
+	"context"
 	"database/sql"
 	_ "embed"
 	"fmt"
@@ -21,8 +22,8 @@ import (
 	logger "github.com/sirupsen/logrus"
 )
 
-//go:embed templates/amazonlinux.sh
-var amazonlinuxTemplate string
+//go:embed templates/amazonlinux.sh.gz
+var amazonlinuxTemplate []byte
 
 type amazonBuilder interface {
 	Builder
@@ -74,12 +75,16 @@ func (a *amazonlinux) Name() string {
 	return TargetTypeAmazonLinux.String()
 }
 
+func (a *amazonlinux) SupportedArchitectures() []kernelrelease.Architecture {
+	return []kernelrelease.Architecture{kernelrelease.ArchitectureAmd64}
+}
+
 func (a *amazonlinux) TemplateScript() string {
-	return amazonlinuxTemplate
+	return decompressTemplate(amazonlinuxTemplate)
 }
 
-func (a *amazonlinux) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchAmazonLinuxPackagesURLs(a, kr)
+func (a *amazonlinux) URLs(ctx context.Context, c Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAmazonLinuxPackagesURLs(ctx, c.Build.mirrorClient(), a, kr)
 }
 
 func (a *amazonlinux) TemplateData(c Config, kr kernelrelease.KernelRelease, urls []string) interface{} {
@@ -114,8 +119,12 @@ func (a *amazonlinux2022) Name() string {
 	return TargetTypeAmazonLinux2022.String()
 }
 
-func (a *amazonlinux2022) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchAmazonLinuxPackagesURLs(a, kr)
+func (a *amazonlinux2022) SupportedArchitectures() []kernelrelease.Architecture {
+	return []kernelrelease.Architecture{kernelrelease.ArchitectureAmd64, kernelrelease.ArchitectureArm64}
+}
+
+func (a *amazonlinux2022) URLs(ctx context.Context, c Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAmazonLinuxPackagesURLs(ctx, c.Build.mirrorClient(), a, kr)
 }
 
 func (a *amazonlinux2022) repos() []string {
@@ -137,8 +146,12 @@ func (a *amazonlinux2023) Name() string {
 	return TargetTypeAmazonLinux2023.String()
 }
 
-func (a *amazonlinux2023) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchAmazonLinuxPackagesURLs(a, kr)
+func (a *amazonlinux2023) SupportedArchitectures() []kernelrelease.Architecture {
+	return []kernelrelease.Architecture{kernelrelease.ArchitectureAmd64, kernelrelease.ArchitectureArm64}
+}
+
+func (a *amazonlinux2023) URLs(ctx context.Context, c Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAmazonLinuxPackagesURLs(ctx, c.Build.mirrorClient(), a, kr)
 }
 
 func (a *amazonlinux2023) repos() []string {
@@ -159,8 +172,12 @@ func (a *amazonlinux2) Name() string {
 	return TargetTypeAmazonLinux2.String()
 }
 
-func (a *amazonlinux2) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchAmazonLinuxPackagesURLs(a, kr)
+func (a *amazonlinux2) SupportedArchitectures() []kernelrelease.Architecture {
+	return []kernelrelease.Architecture{kernelrelease.ArchitectureAmd64, kernelrelease.ArchitectureArm64}
+}
+
+func (a *amazonlinux2) URLs(ctx context.Context, c Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAmazonLinuxPackagesURLs(ctx, c.Build.mirrorClient(), a, kr)
 }
 
 func (a *amazonlinux2) repos() []string {
@@ -211,7 +228,7 @@ func unzipFuncFromBuilder(a amazonBuilder) (unzipFunc, error) {
 	return nil, fmt.Errorf("unsupported extension: %s", a.ext())
 }
 
-func fetchAmazonLinuxPackagesURLs(a amazonBuilder, kv kernelrelease.KernelRelease) ([]string, error) {
+func fetchAmazonLinuxPackagesURLs(ctx context.Context, client *http.Client, a amazonBuilder, kv kernelrelease.KernelRelease) ([]string, error) {
 	urls := []string{}
 	visited := make(map[string]struct{})
 
@@ -222,7 +239,7 @@ func fetchAmazonLinuxPackagesURLs(a amazonBuilder, kv kernelrelease.KernelReleas
 		}
 
 		// Obtain the repo URL by getting mirror URL content
-		mirrorRes, err := http.Get(mirror)
+		mirrorRes, err := httpGet(ctx, client, mirror)
 		if err != nil {
 			return nil, err
 		}
@@ -243,7 +260,7 @@ func fetchAmazonLinuxPackagesURLs(a amazonBuilder, kv kernelrelease.KernelReleas
 			continue
 		}
 		// Download the repo database
-		repoRes, err := http.Get(repoDatabaseURL)
+		repoRes, err := httpGet(ctx, client, repoDatabaseURL)
 		logger.WithField("url", repoDatabaseURL).Debug("downloading...")
 		if err != nil {
 			return nil, err
@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"strings"
@@ -8,8 +9,8 @@ import (
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
-//go:embed templates/archlinux.sh
-var archlinuxTemplate string
+//go:embed templates/archlinux.sh.gz
+var archlinuxTemplate []byte
 
 // TargetTypeArchlinux identifies the Archlinux target.
 const TargetTypeArchlinux Type = "arch"
@@ -31,11 +32,15 @@ func (c *archlinux) Name() string {
 	return TargetTypeArchlinux.String()
 }
 
+func (c *archlinux) SupportedArchitectures() []kernelrelease.Architecture {
+	return []kernelrelease.Architecture{kernelrelease.ArchitectureAmd64, kernelrelease.ArchitectureArm64}
+}
+
 func (c *archlinux) TemplateScript() string {
-	return archlinuxTemplate
+	return decompressTemplate(archlinuxTemplate)
 }
 
-func (c *archlinux) URLs(cfg Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (c *archlinux) URLs(_ context.Context, cfg Config, kr kernelrelease.KernelRelease) ([]string, error) {
 
 	urls := []string{}
 	possibleCompressionSuffixes := []string{
@@ -0,0 +1,133 @@
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// MirrorsBuilder is an optional interface of the builders looking for the headers into several mirrors,
+// to resolve them against each one of them independently.
+type MirrorsBuilder interface {
+	// Mirrors returns the base urls of the mirrors the headers of kr are looked for into.
+	Mirrors(c Config, kr kernelrelease.KernelRelease) []string
+	// MirrorURLs returns the candidate urls of the headers of kr into the given mirror.
+	MirrorURLs(c Config, kr kernelrelease.KernelRelease, mirror string) ([]string, error)
+}
+
+// MirrorPackage is the state of a kernel headers package into a mirror.
+type MirrorPackage struct {
+	Mirror    string
+	URL       string
+	Available bool
+	SHA256    string
+}
+
+// MirrorAudit is the state of the kernel headers packages of a kernel across the mirrors:
+// for each package found into any of them, it holds one entry per mirror, in the order of Mirrors.
+type MirrorAudit struct {
+	Mirrors  []string
+	Packages map[string][]MirrorPackage
+}
+
+// PackageNames returns the names of the audited packages, sorted.
+func (a *MirrorAudit) PackageNames() []string {
+	names := make([]string, 0, len(a.Packages))
+	for name := range a.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Consistent tells whether all the mirrors having the given package serve the same content for it.
+func (a *MirrorAudit) Consistent(name string) bool {
+	sum := ""
+	for _, p := range a.Packages[name] {
+		if !p.Available {
+			continue
+		}
+		if len(sum) > 0 && p.SHA256 != sum {
+			return false
+		}
+		sum = p.SHA256
+	}
+	return true
+}
+
+// AuditMirrors resolves the headers of kr against each one of the given mirrors independently,
+// or against the default mirrors of b when none is given, and checksums the packages each one of them has.
+func AuditMirrors(ctx context.Context, b Builder, c Config, kr kernelrelease.KernelRelease, mirrors []string) (*MirrorAudit, error) {
+	mb, ok := b.(MirrorsBuilder)
+	if !ok {
+		return nil, fmt.Errorf("target %s does not support mirrors audits", b.Name())
+	}
+	if len(mirrors) == 0 {
+		mirrors = mb.Mirrors(c, kr)
+	}
+
+	// the packages found into each mirror, by name
+	found := make([]map[string]string, len(mirrors))
+	for i, mirror := range mirrors {
+		candidates, err := mb.MirrorURLs(c, kr, mirror)
+		if err != nil {
+			return nil, err
+		}
+		urls, err := getResolvingURLs(ctx, c.Build, candidates)
+		if err != nil && !errors.Is(err, HeadersNotFoundErr) {
+			return nil, err
+		}
+		found[i] = make(map[string]string)
+		for _, u := range urls {
+			found[i][packageCacheName(u)] = u
+		}
+	}
+
+	client := c.mirrorClient()
+	audit := &MirrorAudit{Mirrors: mirrors, Packages: make(map[string][]MirrorPackage)}
+	for _, packages := range found {
+		for name := range packages {
+			if _, ok := audit.Packages[name]; ok {
+				continue
+			}
+			row := make([]MirrorPackage, len(mirrors))
+			for i, mirror := range mirrors {
+				row[i] = MirrorPackage{Mirror: mirror}
+				u, ok := found[i][name]
+				if !ok {
+					continue
+				}
+				sum, err := packageChecksum(ctx, client, u)
+				if err != nil {
+					return nil, err
+				}
+				row[i] = MirrorPackage{Mirror: mirror, URL: u, Available: true, SHA256: sum}
+			}
+			audit.Packages[name] = row
+		}
+	}
+	return audit, nil
+}
+
+// packageChecksum downloads the package at u, returning its sha256 digest.
+func packageChecksum(ctx context.Context, client *http.Client, u string) (string, error) {
+	res, err := httpGet(ctx, client, u)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status downloading %s: %s", u, res.Status)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, res.Body); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
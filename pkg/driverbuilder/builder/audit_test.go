@@ -0,0 +1,72 @@
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestAuditMirrors(t *testing.T) {
+	packages := []string{
+		"/linux/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb",
+		"/linux/linux-headers-4.15.0-188_4.15.0-188.199_all.deb",
+	}
+	full := newUbuntuFixtureMirror(packages...)
+	defer full.Close()
+	empty := newUbuntuFixtureMirror()
+	defer empty.Close()
+
+	kr := kernelrelease.FromString("4.15.0-188-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	c := Config{Build: &Build{TargetType: TargetTypeUbuntu, KernelVersion: "199"}}
+
+	audit, err := AuditMirrors(context.Background(), &ubuntu{}, c, kr, []string{empty.URL, full.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the fixture mirror serves empty packages
+	emptySum := fmt.Sprintf("%x", sha256.Sum256(nil))
+	names := audit.PackageNames()
+	expected := []string{
+		"linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb",
+		"linux-headers-4.15.0-188_4.15.0-188.199_all.deb",
+	}
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Fatalf("expected packages %v, got %v", expected, names)
+	}
+	for i, name := range names {
+		row := audit.Packages[name]
+		if len(row) != 2 {
+			t.Fatalf("expected one entry per mirror for %s, got %v", name, row)
+		}
+		if row[0].Mirror != empty.URL || row[0].Available {
+			t.Errorf("expected %s to be missing from the first mirror, got %+v", name, row[0])
+		}
+		if row[1].Mirror != full.URL || !row[1].Available || row[1].SHA256 != emptySum || row[1].URL != full.URL+packages[i] {
+			t.Errorf("expected %s to be available on the second mirror, got %+v", name, row[1])
+		}
+		if !audit.Consistent(name) {
+			t.Errorf("expected %s to be consistent", name)
+		}
+	}
+}
+
+func TestMirrorAuditConsistent(t *testing.T) {
+	audit := &MirrorAudit{
+		Mirrors: []string{"a", "b", "c"},
+		Packages: map[string][]MirrorPackage{
+			"same":      {{Available: true, SHA256: "1"}, {}, {Available: true, SHA256: "1"}},
+			"different": {{Available: true, SHA256: "1"}, {Available: true, SHA256: "2"}, {}},
+		},
+	}
+	if !audit.Consistent("same") {
+		t.Error("expected the packages with the same checksum to be consistent")
+	}
+	if audit.Consistent("different") {
+		t.Error("expected the packages with different checksums not to be consistent")
+	}
+}
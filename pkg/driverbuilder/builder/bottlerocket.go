@@ -21,6 +21,10 @@ func (b *bottlerocket) Name() string {
 	return TargetTypeBottlerocket.String()
 }
 
+func (b *bottlerocket) SupportedArchitectures() []kernelrelease.Architecture {
+	return []kernelrelease.Architecture{kernelrelease.ArchitectureAmd64, kernelrelease.ArchitectureArm64}
+}
+
 func (b *bottlerocket) TemplateData(c Config, kr kernelrelease.KernelRelease, urls []string) interface{} {
 	return vanillaTemplateData{
 		commonTemplateData: c.toTemplateData(b, kr),
@@ -2,34 +2,223 @@ package builder
 
 import (
 	"fmt"
+	"net/http"
+	"time"
+
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	logger "github.com/sirupsen/logrus"
 )
 
 // Build contains the info about the on-going build.
 type Build struct {
-	TargetType       Type
-	KernelConfigData string
-	KernelRelease    string
-	KernelVersion    string
-	DriverVersion    string
-	Architecture     string
-	ModuleFilePath   string
-	ProbeFilePath    string
-	ModuleDriverName string
-	ModuleDeviceName string
-	BuilderImage     string
-	BuilderRepos     []string
-	ImagesListers    []ImagesLister
-	KernelUrls       []string
-	GCCVersion       string
-	RepoOrg          string
-	RepoName         string
-	Images           ImagesMap
+	TargetType            Type
+	KernelConfigData      string
+	KernelRelease         string
+	KernelVersion         string
+	DriverVersion         string
+	Architecture          string
+	ModuleFilePath        string
+	ProbeFilePath         string
+	DockerfilePath        string
+	ModuleDriverName      string
+	ModuleDeviceName      string
+	BuilderImage          string
+	BuilderRepos          []string
+	ImagesListers         []ImagesLister
+	KernelUrls            []string
+	GCCVersion            string
+	RepoOrg               string
+	RepoName              string
+	Images                ImagesMap
+	ContainerWorkDir      string
+	RequiredKernelConfigs []string
+	RepoBundleOnFailure   string
+	PreferSecurityMirror  bool
+	ParallelArtifacts     bool
+	ResolverEndpoint      string
+	ResolverStrict        bool
+	VermagicSuffix        string
+	Streaming             bool
+	StrictContentCheck    bool
+	DebianVendorPool      string
+	DebianVendorFlavors   map[string]string
+	MaxDownloadRate       string
+	// BuildCommit is the git commit of the driver sources, stamped into the info of the module;
+	// the driver version is used when it is a commit hash itself, if not set.
+	BuildCommit string
+	// ExtraKBuildFlags are the additional VAR=value flags of the kbuild invocation building the module.
+	ExtraKBuildFlags []string
+	// ListingFallback makes the ubuntu builds look for the headers packages into the listings of the mirrors,
+	// when their names cannot be guessed.
+	ListingFallback bool
+	// NamingStrategy is the scheme of the canonical artifact names, NamingStrategyCurrent when empty.
+	NamingStrategy NamingStrategy
+	// OutputNameTemplate, when set, is the template of the base name of the artifacts, without their extension,
+	// rendered against the template data of the build (e.g. falco_{{ .Target }}_{{ .KernelRelease }}_{{ .Arch }}):
+	// ApplyOutputNameTemplate renames the output module and probe after it, keeping their directories.
+	OutputNameTemplate string
+	// RsyncDestination, when set, is where the artifacts get transferred to, with the canonical layout.
+	RsyncDestination string
+	// RsyncSSHOptions are the options of the ssh transport used by rsync, if any.
+	RsyncSSHOptions string
+	// SkipIfPublished skips the build when its artifacts are already published into PublishRegistry.
+	SkipIfPublished bool
+	// PublishRegistry is the reference of the OCI repository the artifacts get published into
+	// (e.g. registry.example.com/falcosecurity/drivers).
+	PublishRegistry string
+	// VerifyToolchain makes the builds check the compilers they need are available before compiling.
+	VerifyToolchain bool
+	// ConnectTimeout, when set, bounds the connects to the mirrors of all the requests resolving the kernel headers,
+	// the probes bounded by HTTPTimeout too.
+	ConnectTimeout time.Duration
+	// ResponseHeaderTimeout, when set, bounds the waits for the mirrors to answer all the requests resolving the kernel headers,
+	// the probes bounded by HTTPTimeout too.
+	ResponseHeaderTimeout time.Duration
+	// ProxyURL, when set, is the proxy of the requests to the mirrors sent from the host,
+	// the one of the HTTP_PROXY and HTTPS_PROXY environment variables otherwise.
+	ProxyURL string
+	// CACertPath, when set, is a PEM bundle of the CAs to trust along with the system ones,
+	// e.g. the one of a TLS-intercepting proxy, by the requests sent from the host and by the downloads of the builds.
+	CACertPath string
+	// HTTPRetries is how many times the probes of the kernel headers failing with a network error or a 5xx status
+	// get retried, with exponential backoff from HTTPRetryBaseDelay plus jitter.
+	HTTPRetries        int
+	HTTPRetryBaseDelay time.Duration
+	// HTTPTimeout, when set, bounds each one of the requests probing the kernel headers urls from end to end, retries apart,
+	// whatever the deadline of the whole build; ConnectTimeout and ResponseHeaderTimeout still cut them short when shorter.
+	HTTPTimeout time.Duration
+	// ResolveParallelism bounds how many candidate kernel headers urls get probed at the same time,
+	// GOMAXPROCS when not set.
+	ResolveParallelism int
+	// batchSlots, when set, bounds the probes in place of ResolveParallelism,
+	// shared by all the kernels of a batch.
+	batchSlots chan struct{}
+	// PackageCacheDir, when set, is the directory of the kernel headers packages cached on the host,
+	// used by the docker builds in place of downloading them.
+	PackageCacheDir string
+	// LocalHeaders, when set, are the paths on the host of the kernel headers packages to build against,
+	// in place of resolving and downloading any url, e.g. in air-gapped environments.
+	// The docker builds mount them into the build container.
+	LocalHeaders []string
+	// HeaderURLs, when set, are the urls of the kernel headers packages pinned for the build, used verbatim
+	// in place of resolving any: they are neither probed nor cached, only checked to be as many as the target needs,
+	// e.g. to keep the builds reproducible whatever the mirrors and the resolution heuristics become.
+	HeaderURLs []string
+	// StrictBuilderImageVersion fails the docker builds whose builder image is older than the one needed by the target,
+	// instead of warning about it.
+	StrictBuilderImageVersion bool
+	// Sysroot, when set, is the path of the sysroot inside the builder container the eBPF probe gets compiled against,
+	// e.g. to target a musl-based libc.
+	Sysroot string
+	// HeadersPatternOverride, when set, is the pattern of the directory of the extracted kernel headers the builds look for,
+	// in place of the one derived from the flavor of the kernel release (ubuntu and debian only).
+	// It is an escape hatch for the custom kernels whose headers get extracted elsewhere.
+	HeadersPatternOverride string
+	// FlavorFallbackGeneric makes the ubuntu builds fall back to the headers of the generic flavor,
+	// when the ones of the requested flavor are missing. It is only safe when they share the same ABI.
+	FlavorFallbackGeneric bool
+	// MirrorUsername and MirrorPassword, when set, are the basic auth credentials of the mirrors,
+	// sent along with the requests resolving and downloading the kernel headers from the hosts of Mirrors,
+	// ExtraMirrors, KernelUrls and HeaderURLs only, never to the default mirrors nor to the targets of the redirects.
+	MirrorUsername string
+	MirrorPassword string
+	// MirrorToken, when set, is the bearer token of the mirrors, sent in place of the basic auth credentials,
+	// to the same hosts.
+	MirrorToken string
+	// StrictKernelVersion fails the builds whose resolved headers packages are for another kernel version
+	// than the requested one, instead of warning about it.
+	StrictKernelVersion bool
+	// StrictStatus fails the resolution of the kernel headers when any probe is answered
+	// with another status than 200 or 404, surfacing proxy or ACL misconfigurations.
+	StrictStatus bool
+	// MinPackageSize, when positive, is the minimum size of the kernel headers packages, in bytes:
+	// the candidate urls whose Content-Length is smaller get discarded while resolving them,
+	// e.g. the error pages some misconfigured mirrors answer with a 200.
+	MinPackageSize int64
+	// Mirrors, when set, are where the kernel headers get looked for, in the given order,
+	// in place of the default mirrors of the target (ubuntu only).
+	Mirrors []string
+	// ExtraMirrors are the mirrors where the kernel headers get looked for after the default ones of the target,
+	// by architecture (ubuntu only).
+	ExtraMirrors map[string][]string
+	// Attest makes the builds write the signed in-toto attestation of the provenance of each artifact next to it,
+	// signed with the ECDSA key at AttestKeyPath.
+	Attest        bool
+	AttestKeyPath string
+	// URLRewrite are the rules rewriting the candidate kernel headers urls before probing them,
+	// e.g. to route them through an internal CDN.
+	URLRewrite []URLRewriteRule
+	// CacheDir, when set, is where the resolved kernel headers urls get cached, to be reused by the next runs
+	// for CacheTTL (DefaultURLCacheTTL when not set). NoCache skips reading them, still caching the new ones.
+	// The kernels whose headers are not on any mirror get cached too, failing fast for UnresolvedCacheTTL
+	// (DefaultUnresolvedURLCacheTTL when not set).
+	CacheDir           string
+	CacheTTL           time.Duration
+	UnresolvedCacheTTL time.Duration
+	NoCache            bool
+	// Checksums, when set, are the known-good SHA256 digests of the kernel headers packages, by base name:
+	// the resolved packages are downloaded and verified against them before building, unless SkipChecksums is set,
+	// and the build scripts check the packages they download again against them.
+	Checksums     map[string]string
+	SkipChecksums bool
+	// URLResolver, when set, resolves the kernel headers urls in place of a client of the build alone,
+	// sharing its connection pool and mirror timings with the other builds using it.
+	URLResolver *URLResolver
+	// Transport, when set, sends all the requests resolving and downloading the kernel headers,
+	// in place of the one of the transport settings or of the URLResolver, e.g. to instrument them.
+	Transport http.RoundTripper
+	// Logger, when set, replaces the standard logger for the logs of the resolution of the kernel headers,
+	// e.g. to collect the ones of each build of a batch apart.
+	Logger *logger.Logger
+	// KernelFlavors, when set, are the candidate flavors of the kernel release, tried in order.
+	KernelFlavors []string
+	// URLProbes records the candidate URLs probed while resolving the kernel headers.
+	URLProbes []URLProbe
+	// ResolvedURLs records the urls of the kernel headers packages the build downloads, once resolved.
+	ResolvedURLs []string
+	// ManifestPath, when set, is where the JSON manifest of the build gets written once it completed.
+	ManifestPath string
+	// Manifest records how the build was rendered, when ManifestPath is set.
+	Manifest *Manifest
+	// ProgressFn, when set, is called as the build goes through its phases,
+	// e.g. to surface them into a TUI without parsing the logs.
+	// ResolveURLsBatch calls it concurrently, for all the kernels of the batch.
+	ProgressFn func(Event)
+}
+
+// URLProbe is the outcome of probing a candidate URL.
+type URLProbe struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// log returns the entry logging the resolution of the kernel headers of b, identifying the build.
+func (b *Build) log() *logger.Entry {
+	if b == nil {
+		return logger.NewEntry(logger.StandardLogger())
+	}
+	l := b.Logger
+	if l == nil {
+		l = logger.StandardLogger()
+	}
+	return l.WithFields(logger.Fields{
+		"target":        b.TargetType,
+		"kernelrelease": b.KernelRelease,
+		"architecture":  b.Architecture,
+	})
+}
+
+func (b *Build) recordURLProbe(p URLProbe) {
+	if b == nil {
+		return
+	}
+	b.URLProbes = append(b.URLProbes, p)
 }
 
 func (b *Build) KernelReleaseFromBuildConfig() kernelrelease.KernelRelease {
 	kv := kernelrelease.FromString(b.KernelRelease)
-	kv.Architecture = kernelrelease.Architecture(b.Architecture)
+	kv.Architecture = kernelrelease.ParseArchitecture(b.Architecture)
 	return kv
 }
 
@@ -42,6 +231,17 @@ func (b *Build) ToConfig() Config {
 		DriverName:      b.ModuleDriverName,
 		DeviceName:      b.ModuleDeviceName,
 		DownloadBaseURL: b.toGithubRepoArchive(),
+		PackageCacheDir: b.PackageCacheDir,
+		MirrorAuth:      b.hasMirrorAuth(),
 		Build:           b,
 	}
 }
+
+// BuilderArchitecture returns the architecture of the builder image building the drivers of b:
+// amd64 for the cross-compiled architectures, the one of b otherwise.
+func (b *Build) BuilderArchitecture() string {
+	if _, ok := crossCompiledArchs[kernelrelease.ParseArchitecture(b.Architecture)]; ok {
+		return kernelrelease.ArchitectureAmd64
+	}
+	return b.Architecture
+}
@@ -2,12 +2,18 @@ package builder
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"path"
+	"reflect"
+	"regexp"
 	"strings"
 	"text/template"
 
@@ -23,22 +29,79 @@ const DriverDirectory = "/tmp/driver"
 // ModuleFileName is the standard file name for the kernel module.
 const ModuleFileName = "module.ko"
 
+// DefaultContainerWorkDir is the default directory used inside the builder container to download and extract sources.
+const DefaultContainerWorkDir = "/tmp"
+
 // ProbeFileName is the standard file name for the eBPF probe.
 const ProbeFileName = "probe.o"
 
 // ModuleFullPath is the standard path for the kernel module. Builders must place the compiled module at this location.
-var ModuleFullPath = path.Join(DriverDirectory, ModuleFileName)
+var ModuleFullPath = ModulePath(DriverDirectory)
 
 // ProbeFullPath is the standard path for the eBPF probe. Builders must place the compiled probe at this location.
-var ProbeFullPath = path.Join(DriverDirectory, "bpf", ProbeFileName)
+var ProbeFullPath = ProbePath(DriverDirectory)
+
+// ModulePath returns the path of the kernel module built into the given driver directory.
+func ModulePath(driverDir string) string {
+	return path.Join(driverDir, ModuleFileName)
+}
+
+// ProbePath returns the path of the eBPF probe built into the given driver directory.
+func ProbePath(driverDir string) string {
+	return path.Join(driverDir, "bpf", ProbeFileName)
+}
 
 var HeadersNotFoundErr = errors.New("kernel headers not found")
 
+// URLResolutionError tells the candidate urls of the kernel headers of a target that were tried, with the outcome of their probes,
+// when none of them resolved. It matches HeadersNotFoundErr.
+type URLResolutionError struct {
+	Target Type
+	Tried  []URLProbe
+	// Resolved and Missing, when set, are the urls resolved when only some of the needed packages did,
+	// and the packages still missing, e.g. the _all.deb one of the ubuntu headers.
+	Resolved []string
+	Missing  []string
+}
+
+func (e *URLResolutionError) Error() string {
+	msg := HeadersNotFoundErr.Error()
+	if len(e.Target) > 0 {
+		msg += " for target " + e.Target.String()
+	}
+	if len(e.Resolved) > 0 {
+		msg += fmt.Sprintf("; resolved %s only, missing the %s package", strings.Join(redactURLs(e.Resolved), ", "), strings.Join(e.Missing, " and "))
+	}
+	if len(e.Tried) == 0 {
+		return msg
+	}
+	tried := make([]string, len(e.Tried))
+	for i, p := range e.Tried {
+		outcome := p.Error
+		if len(outcome) == 0 {
+			outcome = fmt.Sprint(p.StatusCode)
+		}
+		tried[i] = fmt.Sprintf("%s (%s)", p.URL, outcome)
+	}
+	return msg + "; tried: " + strings.Join(tried, ", ")
+}
+
+func (e *URLResolutionError) Unwrap() error {
+	return HeadersNotFoundErr
+}
+
 // Config contains all the configurations needed to build the kernel module or the eBPF probe.
 type Config struct {
 	DriverName      string
 	DeviceName      string
 	DownloadBaseURL string
+	// PackageCacheDir is the package cache the script can use, if mounted into the build container.
+	PackageCacheDir string
+	// DriverBuildDir is where the script builds the drivers, DriverDirectory when not set.
+	DriverBuildDir string
+	// MirrorAuth tells whether the script can authenticate to the mirrors
+	// with the credentials copied into the build container.
+	MirrorAuth bool
 	*Build
 }
 
@@ -50,14 +113,70 @@ type commonTemplateData struct {
 	BuildModule       bool
 	BuildProbe        bool
 	GCCVersion        string
+	ContainerWorkDir  string
+	Parallel          bool
+	VermagicSuffix    string
+	Streaming         bool
+	MaxDownloadRate   string
+	VerifyToolchain   bool
+	Sysroot           string
+	MirrorAuth        bool
+	CACert            bool
+	DriverVersion     string
+	BuildCommit       string
+	ExtraKBuildFlags  []string
+	// CrossCompile is the prefix of the cross toolchain building the drivers, for the cross-compiled architectures.
+	CrossCompile string
+	// Target, KernelRelease, KernelVersion and Arch identify the build, e.g. for the output name template,
+	// Arch being the non-deb name of the architecture (e.g. x86_64), as in the canonical artifact names.
+	Target        string
+	KernelRelease string
+	KernelVersion string
+	Arch          string
 }
 
 // Builder represents a builder capable of generating a script for a driverkit target.
 type Builder interface {
 	Name() string
 	TemplateScript() string
-	URLs(c Config, kr kernelrelease.KernelRelease) ([]string, error)
+	// URLs returns the urls of the kernel headers packages of kr; the requests it sends are cancelled along with ctx.
+	URLs(ctx context.Context, c Config, kr kernelrelease.KernelRelease) ([]string, error)
 	TemplateData(c Config, kr kernelrelease.KernelRelease, urls []string) interface{} // error return type is managed
+	// SupportedArchitectures returns the architectures whose kernel headers the builder resolves,
+	// DefaultArchitectures for the ones supporting all of them.
+	SupportedArchitectures() []kernelrelease.Architecture
+}
+
+// DefaultArchitectures returns all the architectures supported by driverkit but the cross-compiled ones, sorted:
+// the builders opt in to these, as their scripts need to pick the cross toolchain.
+func DefaultArchitectures() []kernelrelease.Architecture {
+	var res []kernelrelease.Architecture
+	for _, a := range kernelrelease.SupportedArchs.Strings() {
+		if _, ok := crossCompiledArchs[kernelrelease.Architecture(a)]; !ok {
+			res = append(res, kernelrelease.Architecture(a))
+		}
+	}
+	return res
+}
+
+// crossCompiledArchs are the architectures without builder images of their own, by the prefix of their cross toolchain:
+// their drivers get cross-compiled into the amd64 builder images, the host programs of their kernel headers running through qemu.
+var crossCompiledArchs = map[kernelrelease.Architecture]string{
+	kernelrelease.ArchitectureRiscv64: "riscv64-linux-gnu-",
+}
+
+// CheckArchitecture fails when b does not support the given architecture,
+// not to fail later, in the middle of the resolution of the kernel headers.
+func CheckArchitecture(b Builder, arch kernelrelease.Architecture) error {
+	archs := b.SupportedArchitectures()
+	names := make([]string, len(archs))
+	for i, a := range archs {
+		if a == arch {
+			return nil
+		}
+		names[i] = a.String()
+	}
+	return fmt.Errorf("target %s does not support architecture %s, supported ones: %s", b.Name(), arch, strings.Join(names, ", "))
 }
 
 // MinimumURLsBuilder is an optional interface
@@ -66,41 +185,426 @@ type MinimumURLsBuilder interface {
 	MinimumURLs() int
 }
 
-func Script(b Builder, c Config, kr kernelrelease.KernelRelease) (string, error) {
-	t := template.New(b.Name())
-	parsed, err := t.Parse(b.TemplateScript())
-	if err != nil {
+// KernelMinimumURLsBuilder is an optional interface of the builders needing a number of headers urls
+// depending on the build or the kernel release, e.g. an extra package for some flavors.
+// It takes precedence over MinimumURLsBuilder.
+type KernelMinimumURLsBuilder interface {
+	KernelMinimumURLs(c Config, kr kernelrelease.KernelRelease) int
+}
+
+// Flavor is a flavor of the kernels of a target, as known by the resolution of their headers.
+type Flavor struct {
+	Name string
+	// Example is a kernel release of the flavor, whose headers resolution is covered by the tests.
+	Example string
+	// Description tells where the headers of the flavor get looked for.
+	Description string
+}
+
+// FlavorsBuilder is an optional interface of the builders resolving the headers of the kernels
+// differently depending on their flavor, telling the flavors they know about.
+type FlavorsBuilder interface {
+	Flavors() []Flavor
+}
+
+// minimumURLs returns the number of headers urls b needs to build kr, at least one.
+func minimumURLs(b Builder, c Config, kr kernelrelease.KernelRelease) int {
+	minimum := 1
+	if bb, ok := b.(KernelMinimumURLsBuilder); ok {
+		minimum = bb.KernelMinimumURLs(c, kr)
+	} else if bb, ok := b.(MinimumURLsBuilder); ok {
+		minimum = bb.MinimumURLs()
+	}
+	if minimum < 1 {
+		// no build goes without headers
+		return 1
+	}
+	return minimum
+}
+
+// checkMinimumURLs fails when the resolved urls are less than the ones b needs to build kr,
+// not to start builds bound to fail once into the container.
+func checkMinimumURLs(b Builder, c Config, kr kernelrelease.KernelRelease, urls []string) error {
+	expected := minimumURLs(b, c, kr)
+	if len(urls) < expected {
+		return fmt.Errorf("not enough headers packages found for target %s; expected %d, found %d", b.Name(), expected, len(urls))
+	}
+	return nil
+}
+
+func Script(ctx context.Context, b Builder, c Config, kr kernelrelease.KernelRelease) (string, error) {
+	if err := c.checkKernelConfig(); err != nil {
 		return "", err
 	}
 
-	minimumURLs := 1
-	if bb, ok := b.(MinimumURLsBuilder); ok {
-		minimumURLs = bb.MinimumURLs()
+	urls, err := KernelURLs(ctx, b, c, kr)
+	if err != nil {
+		return "", err
 	}
 
-	var urls []string
-	if c.KernelUrls == nil {
-		urls, err = b.URLs(c, kr)
-		if err != nil {
+	if rb, ok := b.(releaseInfoBuilder); ok {
+		if err := rb.fetchReleaseInfo(ctx, c, kr); err != nil {
 			return "", err
 		}
-		// Only if returned urls array is not empty
-		// Otherwise, it is up to the builder to return an error
-		if len(urls) > 0 {
-			// Check (and filter) existing kernels before continuing
-			urls, err = getResolvingURLs(urls)
+	}
+
+	script, err := renderScript(b, c, kr, c.cachedURLs(urls))
+	if err != nil {
+		return "", err
+	}
+	c.Build.recordManifest(b, c, kr, urls)
+	return script, nil
+}
+
+// releaseInfoBuilder is implemented by the builders whose template data needs the metadata of the release,
+// besides its kernel headers urls, e.g. the gcc version of flatcar.
+type releaseInfoBuilder interface {
+	// fetchReleaseInfo fetches the metadata of the release, if not already known; cancelling ctx aborts the requests.
+	fetchReleaseInfo(ctx context.Context, c Config, kr kernelrelease.KernelRelease) error
+}
+
+// ResolveURLs resolves the urls of the kernel headers packages the build for the given target and kernel release would use,
+// without building anything, e.g. to check which kernels of a batch are buildable beforehand.
+func ResolveURLs(ctx context.Context, target Type, c Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	b, err := BuilderForTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	return KernelURLs(ctx, b, c, kr)
+}
+
+// Resolution is the outcome of a successful resolution of the kernel headers urls, along with where they were found.
+type Resolution struct {
+	URLs []string
+	// Mirror is the base url of the mirror hosting the packages: one of the mirrors of the builders looking for them
+	// into several ones, otherwise the scheme and host of their urls.
+	Mirror string
+	// Subdir is the directory of the packages into the mirror, e.g. linux-aws-5.15 into the ubuntu pools.
+	Subdir string
+}
+
+// ResolveURLsDetailed is ResolveURLs, also telling which mirror and subdir the urls were found into,
+// e.g. to learn which mirrors are worth trying first.
+func ResolveURLsDetailed(ctx context.Context, target Type, c Config, kr kernelrelease.KernelRelease) (Resolution, error) {
+	b, err := BuilderForTarget(target)
+	if err != nil {
+		return Resolution{}, err
+	}
+	urls, err := KernelURLs(ctx, b, c, kr)
+	if err != nil {
+		return Resolution{}, err
+	}
+	var mirrors []string
+	if mb, ok := b.(MirrorsBuilder); ok {
+		mirrors = rewriteURLs(c.URLRewrite, mb.Mirrors(c, kr))
+	}
+	res := Resolution{URLs: urls}
+	res.Mirror, res.Subdir = urlsLocation(urls, mirrors)
+	return res, nil
+}
+
+// urlsLocation returns the mirror and the subdir of the first one of urls: the longest one of mirrors it belongs to, if any,
+// otherwise its scheme and host.
+func urlsLocation(urls, mirrors []string) (string, string) {
+	if len(urls) == 0 || !strings.Contains(urls[0], "/") {
+		return "", ""
+	}
+	// not path.Dir, cleaning the double slash of the scheme away
+	dir := urls[0][:strings.LastIndex(urls[0], "/")]
+	mirror := ""
+	for _, m := range mirrors {
+		m = strings.TrimSuffix(m, "/")
+		if len(m) > len(mirror) && (dir == m || strings.HasPrefix(dir, m+"/")) {
+			mirror = m
 		}
-	} else {
-		urls, err = getResolvingURLs(c.KernelUrls)
 	}
+	if len(mirror) == 0 {
+		u, err := url.Parse(urls[0])
+		if err != nil {
+			return "", ""
+		}
+		mirror = (&url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host}).String()
+	}
+	return mirror, strings.TrimPrefix(strings.TrimPrefix(dir, mirror), "/")
+}
+
+// RenderScript returns the build script the build for the given target and kernel release would run, without building anything,
+// e.g. to run its commands by hand. The package cache is not used, the script downloading the kernel headers by itself.
+func RenderScript(ctx context.Context, target Type, c Config, kr kernelrelease.KernelRelease) (string, error) {
+	b, err := BuilderForTarget(target)
 	if err != nil {
 		return "", err
 	}
+	c.PackageCacheDir = ""
+	return Script(ctx, b, c, kr)
+}
+
+// KernelURLs resolves the urls of the kernel headers packages needed by the build of b,
+// reusing the ones resolved by the previous runs, if cached. Cancelling ctx aborts the requests in flight.
+// The local kernel headers packages of the build, if any, are used in place of resolving anything,
+// as the pinned kernel headers urls are otherwise.
+func KernelURLs(ctx context.Context, b Builder, c Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	if err := CheckArchitecture(b, kr.Architecture); err != nil {
+		return nil, err
+	}
+	c.Build.Progress(PhaseResolution, "resolving URLs")
+	var urls []string
+	var cached bool
+	var err error
+	if len(c.LocalHeaders) > 0 {
+		if urls, err = c.Build.localHeadersURLs(); err != nil {
+			return nil, err
+		}
+	} else if len(c.HeaderURLs) > 0 {
+		urls = c.HeaderURLs
+	} else if urls, cached, err = c.Build.loadURLCache(kr); err != nil {
+		return nil, err
+	} else if !cached {
+		if urls, err = resolveKernelURLs(ctx, b, c, kr); err != nil {
+			c.Build.storeUnresolvedURLCache(kr, err)
+			return nil, err
+		}
+	}
+
+	if err := checkMinimumURLs(b, c, kr, urls); err != nil {
+		return nil, err
+	}
+	if err := checkPackagesKernelVersion(b, c, urls); err != nil {
+		return nil, err
+	}
+	if c.checksChecksums() {
+		if err := c.Build.verifyURLChecksums(ctx, urls, c.Checksums); err != nil {
+			return nil, err
+		}
+	}
+	if !cached && len(c.LocalHeaders) == 0 && len(c.HeaderURLs) == 0 {
+		c.Build.storeURLCache(kr, urls)
+	}
+	c.Build.ResolvedURLs = urls
+	c.Build.Progress(PhaseResolution, "resolved %d headers packages", len(urls))
+	return urls, nil
+}
 
-	if len(urls) < minimumURLs {
-		return "", fmt.Errorf("not enough headers packages found; expected %d, found %d", minimumURLs, len(urls))
+// resolveKernelURLs resolves the urls of the kernel headers packages, from the ones of the build if any,
+// otherwise from the resolver endpoint or the builder.
+func resolveKernelURLs(ctx context.Context, b Builder, c Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	var urls []string
+	var err error
+	switch {
+	case c.KernelUrls != nil:
+		urls, err = getResolvingURLs(ctx, c.Build, c.KernelUrls)
+	case len(c.ResolverEndpoint) > 0:
+		urls, err = resolverEndpointURLs(ctx, c.Build)
+		if err != nil && !c.ResolverStrict {
+			logger.WithError(err).
+				WithField("endpoint", c.ResolverEndpoint).
+				Warn("resolver endpoint failed, falling back to the built-in resolution")
+			urls, err = builderURLs(ctx, b, c, kr)
+		}
+	default:
+		urls, err = builderURLs(ctx, b, c, kr)
+	}
+	if c.StrictStatus {
+		if statusErr := checkProbesStatus(c.URLProbes); statusErr != nil {
+			return nil, statusErr
+		}
 	}
+	return urls, err
+}
+
+// checkProbesStatus fails on the first probe answered with another status than 200 or 404,
+// e.g. a 403 of a proxy or a 500 of a broken mirror.
+func checkProbesStatus(probes []URLProbe) error {
+	for _, p := range probes {
+		if p.StatusCode != 0 && p.StatusCode != http.StatusOK && p.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("unexpected status %d probing %s", p.StatusCode, p.URL)
+		}
+	}
+	return nil
+}
+
+// PackageKernelVersionBuilder is an optional interface of the builders
+// whose headers packages embed the kernel version into their names.
+type PackageKernelVersionBuilder interface {
+	// PackageKernelVersion returns the kernel version embedded into the name of the package at u, if any.
+	PackageKernelVersion(u string) (string, bool)
+}
+
+// checkPackagesKernelVersion warns about the resolved packages whose kernel version differs from the requested one,
+// e.g. when given by the resolver endpoint or the kernel urls; with StrictKernelVersion, it fails instead.
+func checkPackagesKernelVersion(b Builder, c Config, urls []string) error {
+	bb, ok := b.(PackageKernelVersionBuilder)
+	if !ok || len(c.KernelVersion) == 0 {
+		return nil
+	}
+	for _, u := range urls {
+		kv, ok := bb.PackageKernelVersion(u)
+		if !ok || kv == c.KernelVersion {
+			continue
+		}
+		if c.StrictKernelVersion {
+			return fmt.Errorf("kernel headers package %s is for kernel version %s, not %s", RedactURL(u), kv, c.KernelVersion)
+		}
+		logger.WithField("url", RedactURL(u)).
+			WithField("kernelversion", c.KernelVersion).
+			WithField("package_kernelversion", kv).
+			Warn("kernel headers package resolved for another kernel version")
+	}
+	return nil
+}
+
+// artifactsTemplates contains the snippets the builder templates wrap the module and probe builds into,
+// to run them concurrently when the template data asks for it. Each build logs into its own file,
+// printed once both are over, not to interleave their outputs.
+const artifactsTemplates = `
+{{ define "artifact_begin" }}
+{{ template "stage" "compile" }}{{ template "toolchain_check" . }}{{ if .Parallel }}
+({{ end }}{{ end }}
 
+{{ define "toolchain_check" }}{{ if .VerifyToolchain }}
+# Verify the toolchain before compiling anything
+if [ -z "${toolchain_verified:-}" ]; then
+{{- if .BuildModule }}
+  if ! command -v /usr/bin/{{ .CrossCompile }}gcc-{{ .GCCVersion }} > /dev/null; then
+    echo "{{ .CrossCompile }}gcc-{{ .GCCVersion }} is not available in the builder image, upgrade it or choose another one with --builderimage" >&2
+    exit 1
+  fi
+  /usr/bin/{{ .CrossCompile }}gcc-{{ .GCCVersion }} --version | head -n 1
+{{- end }}
+{{- if .BuildProbe }}
+  if ! command -v clang > /dev/null || ! command -v llc > /dev/null; then
+    echo "clang and llc are not available in the builder image, upgrade it or choose another one with --builderimage" >&2
+    exit 1
+  fi
+  clang --version | head -n 1
+{{- end }}
+  toolchain_verified=1
+fi{{ end }}{{ end }}
+
+{{ define "module_end" }}{{ if .Parallel }}) > {{ .ContainerWorkDir }}/module-build.log 2>&1 &
+module_pid=$!
+{{ end }}{{ end }}
+
+{{ define "probe_end" }}{{ if .Parallel }}) > {{ .ContainerWorkDir }}/probe-build.log 2>&1 &
+probe_pid=$!
+{{ end }}{{ end }}
+
+{{ define "artifacts_wait" }}{{ if .Parallel }}
+# Wait for the module and the probe, built concurrently
+status=0
+wait $module_pid || status=$?
+cat {{ .ContainerWorkDir }}/module-build.log
+wait $probe_pid || status=$?
+cat {{ .ContainerWorkDir }}/probe-build.log
+if [ $status -ne 0 ]; then
+  exit $status
+fi
+{{ end }}{{ end }}
+`
+
+// The template scripts are embedded gzip compressed, the templates/*.sh files being their sources.
+// Run go generate after editing any of them.
+//go:generate sh -c "gzip -9 -n -k -f templates/*.sh"
+
+// decompressTemplate returns the template script embedded gzip compressed into data.
+// It panics on malformed data, which can only be a broken build.
+func decompressTemplate(data []byte) string {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		panic(fmt.Errorf("malformed embedded template: %w", err))
+	}
+	script, err := io.ReadAll(r)
+	if err != nil {
+		panic(fmt.Errorf("malformed embedded template: %w", err))
+	}
+	return string(script)
+}
+
+// downloadFlagsTemplate renders the additional flags
+// the builder templates pass to curl when downloading anything.
+const downloadFlagsTemplate = `{{ define "download_flags" }}{{ if .MaxDownloadRate }} --limit-rate {{ .MaxDownloadRate }}{{ end }}{{ if .CACert }} --cacert ` + ContainerCABundlePath + `{{ end }}{{ end }}`
+
+// ContainerCACertPath is where the PEM bundle of the CAs to trust, if any, gets copied into the build container.
+const ContainerCACertPath = "/driverkit/ca.pem"
+
+// ContainerCABundlePath is where the build scripts gather the CAs to trust along with the system ones,
+// as curl trusts the ones of its --cacert in place of the system ones.
+const ContainerCABundlePath = "/driverkit/ca-bundle.pem"
+
+// caBundleTemplate renders the commands gathering the CAs to trust into ContainerCABundlePath, when there are any.
+const caBundleTemplate = `{{ define "ca_bundle" }}{{ if .CACert }}
+# Trust the given CAs along with the system ones
+cat /etc/ssl/certs/ca-certificates.crt /etc/pki/tls/certs/ca-bundle.crt 2> /dev/null > ` + ContainerCABundlePath + ` || true
+cat ` + ContainerCACertPath + ` >> ` + ContainerCABundlePath + `{{ end }}{{ end }}`
+
+// MirrorAuthConfigPath is where the curl config holding the mirror credentials gets copied into the build container.
+const MirrorAuthConfigPath = "/driverkit/mirror-auth.conf"
+
+// kernelDownloadFlagsTemplate renders the curl flags of the kernel headers downloads,
+// which also authenticate to the mirrors when there are credentials for them.
+const kernelDownloadFlagsTemplate = `{{ define "kernel_download_flags" }}{{ template "download_flags" . }}{{ if .MirrorAuth }} --config ` + MirrorAuthConfigPath + `{{ end }}{{ end }}`
+
+// MirrorAuthConfig returns the curl config, to be copied to MirrorAuthConfigPath, holding the mirror credentials of b.
+func MirrorAuthConfig(b *Build) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	if len(b.MirrorToken) > 0 {
+		return fmt.Sprintf("header = \"Authorization: Bearer %s\"\n", escaper.Replace(b.MirrorToken))
+	}
+	return fmt.Sprintf("user = \"%s:%s\"\n", escaper.Replace(b.MirrorUsername), escaper.Replace(b.MirrorPassword))
+}
+
+// StageMarker prefixes the lines the build scripts print when entering a new stage of the build
+// (i.e. "download" or "compile"), for processors to keep track of them.
+const StageMarker = "driverkit-stage: "
+
+// stageTemplate renders the command printing the stage marker of the stage passed as data.
+const stageTemplate = `{{ define "stage" }}echo "` + StageMarker + `{{ . }}"{{ end }}`
+
+// ModuleBuildTag is the modinfo tag of the kernel module recording its vermagic suffix, if any,
+// as shown by modinfo: the vermagic itself is left as it is, for the kernel to still load the module.
+const ModuleBuildTag = "driverkit_build"
+
+// moduleMakeFlagsTemplate renders the additional variables
+// the builder templates pass to the make invocation building the kernel module.
+const moduleMakeFlagsTemplate = `{{ define "module_make_flags" }}{{ if .VermagicSuffix }} DRIVERKIT_BUILD='{{ .VermagicSuffix }}'{{ end }}{{ end }}`
+
+// probeMakeFlagsTemplate renders the additional variables
+// the builder templates pass to the make invocation building the eBPF probe.
+const probeMakeFlagsTemplate = `{{ define "probe_make_flags" }}{{ if .Sysroot }} CLANG='clang --sysroot={{ .Sysroot }}'{{ end }}{{ end }}`
+
+// moduleNoteTemplate renders the commands embedding the build metadata into the kernel module,
+// as an ELF note written by the moduleNote function.
+const moduleNoteTemplate = `{{ define "module_note" }}# Embed the build metadata into the kernel module
+echo '{{ moduleNote }}' | base64 -d > {{ .ContainerWorkDir }}/driverkit.note
+{{ .CrossCompile }}objcopy --add-section ` + ModuleNoteSection + `={{ .ContainerWorkDir }}/driverkit.note --set-section-flags ` + ModuleNoteSection + `=noload,readonly {{ .ModuleFullPath }}{{ end }}`
+
+// builderURLs returns the resolving urls generated by the builder.
+func builderURLs(ctx context.Context, b Builder, c Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	urls, err := b.URLs(ctx, c, kr)
+	if err != nil {
+		return nil, err
+	}
+	// Only if returned urls array is not empty
+	// Otherwise, it is up to the builder to return an error
+	if len(urls) > 0 {
+		// Check (and filter) existing kernels before continuing
+		urls, err = getResolvingURLs(ctx, c.Build, urls)
+	}
+	return urls, err
+}
+
+// renderScript executes the builder template against its template data for the given (already resolved) urls.
+func renderScript(b Builder, c Config, kr kernelrelease.KernelRelease, urls []string) (string, error) {
+	parsed, err := parseScriptTemplate(b, c, urls)
+	if err != nil {
+		return "", err
+	}
+
+	if c.MirrorAuth && !c.Build.onMirrorHosts(urls) {
+		// curl would send the credentials to any host of the urls
+		logger.Warn("some kernel headers urls are not on the mirrors, the build script downloads them without the mirror credentials")
+		c.MirrorAuth = false
+	}
 	td := b.TemplateData(c, kr, urls)
 	if tdErr, ok := td.(error); ok {
 		return "", tdErr
@@ -114,6 +618,55 @@ func Script(b Builder, c Config, kr kernelrelease.KernelRelease) (string, error)
 	return buf.String(), nil
 }
 
+// parseScriptTemplate parses the script template of b, along with the shared ones it uses.
+func parseScriptTemplate(b Builder, c Config, urls []string) (*template.Template, error) {
+	t := template.New(b.Name()).Funcs(scriptFuncs).Funcs(template.FuncMap{
+		// evaluated lazily, once the template data settled the gcc version
+		"moduleNote": func() string { return newModuleNote(c, urls).base64() },
+		"checksum":   c.scriptChecksum,
+	})
+	parsed, err := t.Parse(b.TemplateScript())
+	if err != nil {
+		return nil, err
+	}
+	for _, shared := range []string{
+		artifactsTemplates,
+		moduleMakeFlagsTemplate,
+		probeMakeFlagsTemplate,
+		stageTemplate,
+		downloadFlagsTemplate,
+		caBundleTemplate,
+		kernelDownloadFlagsTemplate,
+		moduleNoteTemplate,
+	} {
+		if parsed, err = parsed.Parse(shared); err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
+
+// scriptFuncs are the helpers available to the script templates of all the builders.
+// As in sprig, the piped value is the last argument, e.g. {{ .KernelLocalVersion | trimPrefix "-" | default "none" }}.
+var scriptFuncs = template.FuncMap{
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	// default returns given, unless empty
+	"default": func(def, given interface{}) interface{} {
+		if v := reflect.ValueOf(given); !v.IsValid() || v.IsZero() || (v.Kind() == reflect.Slice && v.Len() == 0) {
+			return def
+		}
+		return given
+	},
+	"splitList": func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":      func(sep string, elems []string) string { return strings.Join(elems, sep) },
+}
+
+// MinBuilderImageVersionRequestor is an optional interface
+// to specify the minimum version of the builder images the template of a builder relies on.
+type MinBuilderImageVersionRequestor interface {
+	MinBuilderImageVersion() semver.Version
+}
+
 type GCCVersionRequestor interface {
 	// GCCVersion returns the GCC version to be used.
 	// If the returned value is empty, the default algorithm will be enforced.
@@ -215,6 +768,13 @@ func (b *Build) setGCCVersion(builder Builder, kr kernelrelease.KernelRelease) {
 		Debug("foundGCC=", b.GCCVersion)
 }
 
+// ResolveBuilderImage returns the builder image of the build of b with the given builder,
+// picking the gcc version it needs when not set yet.
+func (b *Build) ResolveBuilderImage(builder Builder, kr kernelrelease.KernelRelease) string {
+	b.setGCCVersion(builder, kr)
+	return b.GetBuilderImage()
+}
+
 func (b *Build) GetBuilderImage() string {
 	imageTag := "latest"
 	if len(b.BuilderImage) > 0 {
@@ -251,15 +811,55 @@ func Factory(target Type) (Builder, error) {
 
 func (c Config) toTemplateData(b Builder, kr kernelrelease.KernelRelease) commonTemplateData {
 	c.setGCCVersion(b, kr)
+	workDir := DefaultContainerWorkDir
+	if len(c.ContainerWorkDir) > 0 {
+		workDir = strings.TrimSuffix(c.ContainerWorkDir, "/")
+	}
+	driverDir := DriverDirectory
+	if len(c.DriverBuildDir) > 0 {
+		driverDir = c.DriverBuildDir
+	}
 	return commonTemplateData{
-		DriverBuildDir:    DriverDirectory,
+		DriverBuildDir:    driverDir,
 		ModuleDownloadURL: fmt.Sprintf("%s/%s.tar.gz", c.DownloadBaseURL, c.DriverVersion),
 		ModuleDriverName:  c.DriverName,
-		ModuleFullPath:    ModuleFullPath,
+		ModuleFullPath:    ModulePath(driverDir),
 		BuildModule:       len(c.ModuleFilePath) > 0,
 		BuildProbe:        len(c.ProbeFilePath) > 0,
 		GCCVersion:        c.GCCVersion,
+		ContainerWorkDir:  workDir,
+		// there is nothing to parallelize with a single artifact
+		Parallel:       c.ParallelArtifacts && len(c.ModuleFilePath) > 0 && len(c.ProbeFilePath) > 0,
+		VermagicSuffix: c.VermagicSuffix,
+		// the packages get checked once downloaded, before being extracted
+		Streaming:        c.Streaming && !c.checksChecksums(),
+		MaxDownloadRate:  c.MaxDownloadRate,
+		VerifyToolchain:  c.VerifyToolchain,
+		Sysroot:          c.Sysroot,
+		MirrorAuth:       c.MirrorAuth,
+		CACert:           len(c.CACertPath) > 0,
+		DriverVersion:    c.DriverVersion,
+		BuildCommit:      c.buildCommit(),
+		ExtraKBuildFlags: c.ExtraKBuildFlags,
+		CrossCompile:     crossCompiledArchs[kr.Architecture],
+		Target:           c.TargetType.String(),
+		KernelRelease:    c.KernelRelease,
+		KernelVersion:    c.KernelVersion,
+		Arch:             kernelrelease.SupportedArchs[kr.Architecture],
+	}
+}
+
+var commitHashRegex = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// buildCommit returns the git commit of the driver sources, if known.
+func (c Config) buildCommit() string {
+	if len(c.BuildCommit) > 0 {
+		return c.BuildCommit
 	}
+	if commitHashRegex.MatchString(c.DriverVersion) {
+		return c.DriverVersion
+	}
+	return ""
 }
 
 func resolveURLReference(u string) string {
@@ -267,33 +867,170 @@ func resolveURLReference(u string) string {
 	if err != nil {
 		log.Fatal(err)
 	}
-	base, err := url.Parse(uu.Host)
+	base := &url.URL{Scheme: uu.Scheme, Host: uu.Host}
+	return base.ResolveReference(uu).String()
+}
+
+// checkPackageHeaders returns an error when res, answering to HEAD, cannot be the one of a package:
+// an HTML page, as returned with a 200 by some misconfigured mirrors for missing files,
+// or a content shorter than minSize, if positive, when its length is known.
+func checkPackageHeaders(res *http.Response, minSize int64) error {
+	if isHTMLContentType(res.Header.Get("Content-Type")) {
+		return fmt.Errorf("unexpected content type: %s", res.Header.Get("Content-Type"))
+	}
+	if minSize > 0 && res.ContentLength >= 0 && res.ContentLength < minSize {
+		return fmt.Errorf("unexpected content length: %d bytes, expected at least %d", res.ContentLength, minSize)
+	}
+	return nil
+}
+
+// checkPackageContent returns an error when the content at u is an HTML page rather than a package,
+// even though it is not served as such, sniffing its first bytes.
+func checkPackageContent(ctx context.Context, client *http.Client, u string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	return base.ResolveReference(uu).String()
+	// http.DetectContentType considers at most the first 512 bytes
+	req.Header.Set("Range", "bytes=0-511")
+	getRes, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer getRes.Body.Close()
+	head, err := io.ReadAll(io.LimitReader(getRes.Body, 512))
+	if err != nil {
+		return err
+	}
+	if contentType := http.DetectContentType(head); isHTMLContentType(contentType) {
+		return fmt.Errorf("unexpected content: %s", contentType)
+	}
+	return nil
 }
 
-func getResolvingURLs(urls []string) ([]string, error) {
-	var results []string
-	for _, u := range urls {
-		// in case url has some relative paths
-		// (kernel-crawler does not resolve them for us,
-		// neither it is expected, because they are effectively valid urls),
-		// resolve the absolute one.
-		// HEAD would fail otherwise.
-		u = resolveURLReference(u)
-		res, err := http.Head(u)
-		if err != nil {
-			continue
+func isHTMLContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/html"
+}
+
+// getResolvingURLs returns the urls answering to HEAD requests, in the order of the given ones
+// whatever the order the probes complete in, recording the outcome of each probe into b, if any.
+func getResolvingURLs(ctx context.Context, b *Build, urls []string) ([]string, error) {
+	return getResolvingURLsOfSize(ctx, b, urls, b.minPackageSize())
+}
+
+// getResolvingMetadataURLs is getResolvingURLs for the urls of the files other than the kernel headers packages,
+// e.g. the lists of packages of a release, that are not expected to be as big as the packages are.
+func getResolvingMetadataURLs(ctx context.Context, b *Build, urls []string) ([]string, error) {
+	return getResolvingURLsOfSize(ctx, b, urls, 0)
+}
+
+func getResolvingURLsOfSize(ctx context.Context, b *Build, urls []string, minSize int64) ([]string, error) {
+	results, tried := resolveURLsOfSize(ctx, b, urls, minSize, nil)
+	if len(results) == 0 {
+		err := &URLResolutionError{Tried: tried}
+		if b != nil {
+			err.Target = b.TargetType
 		}
-		if res.StatusCode == http.StatusOK {
-			results = append(results, u)
-			logger.WithField("url", u).Debug("kernel header url found")
+		return nil, err
+	}
+	return results, nil
+}
+
+// urlProbeOutcome is the outcome of the probe of a candidate url.
+type urlProbeOutcome struct {
+	url   string
+	probe URLProbe
+}
+
+// resolveURLs probes the given urls concurrently, up to the resolve parallelism of b,
+// returning the ones answering to HEAD requests in the given order, along with the outcome of the probes,
+// recorded into b too, if any.
+// When done, if any, tells that the resolving urls among the ones probed so far, in order, are enough,
+// the remaining probes are cancelled, as all of them are when ctx is.
+func resolveURLs(ctx context.Context, b *Build, urls []string, done func(resolved []string) bool) ([]string, []URLProbe) {
+	return resolveURLsOfSize(ctx, b, urls, b.minPackageSize(), done)
+}
+
+// resolveURLsOfSize is resolveURLs discarding the answers whose Content-Length is smaller than minSize, if positive.
+func resolveURLsOfSize(ctx context.Context, b *Build, urls []string, minSize int64, done func(resolved []string) bool) ([]string, []URLProbe) {
+	if b != nil {
+		urls = rewriteURLs(b.URLRewrite, urls)
+	}
+	client := b.mirrorClient()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outcomes := make([]chan urlProbeOutcome, len(urls))
+	for i := range outcomes {
+		outcomes[i] = make(chan urlProbeOutcome, 1)
+	}
+	slots := b.resolveSlots()
+	go func() {
+		for i, u := range urls {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				// the probes never launched fail with the context, not to leave the consumer waiting for them
+				for j := i; j < len(urls); j++ {
+					outcomes[j] <- urlProbeOutcome{url: urls[j], probe: URLProbe{URL: RedactURL(urls[j]), Error: ctx.Err().Error()}}
+				}
+				return
+			}
+			go func(i int, u string) {
+				defer func() { <-slots }()
+				outcomes[i] <- probeURL(ctx, b, client, u, minSize)
+			}(i, u)
+		}
+	}()
+
+	var results []string
+	var tried []URLProbe
+	for i := range urls {
+		o := <-outcomes[i]
+		b.recordURLProbe(o.probe)
+		tried = append(tried, o.probe)
+		b.log().WithField("url", o.probe.URL).WithField("status", o.probe.StatusCode).WithField("error", o.probe.Error).Debug("kernel header url tried")
+		if o.probe.StatusCode == http.StatusOK && len(o.probe.Error) == 0 {
+			results = append(results, o.url)
+			b.log().WithField("url", o.probe.URL).Debug("kernel header url found")
+			if done != nil && done(results) {
+				break
+			}
 		}
 	}
-	if len(results) == 0 {
-		return nil, HeadersNotFoundErr
+	return results, tried
+}
+
+// probeURL sends a HEAD request to u, discarding the answers that cannot be the ones of a package
+// of at least minSize bytes, and checking the content of the package too when b asks for it.
+func probeURL(ctx context.Context, b *Build, client *http.Client, u string, minSize int64) urlProbeOutcome {
+	// in case url has some relative paths
+	// (kernel-crawler does not resolve them for us,
+	// neither it is expected, because they are effectively valid urls),
+	// resolve the absolute one.
+	// HEAD would fail otherwise.
+	u = resolveURLReference(u)
+	o := urlProbeOutcome{url: u, probe: URLProbe{URL: RedactURL(u)}}
+	res, err := b.headWithRetries(ctx, client, u)
+	if err != nil {
+		o.probe.Error = err.Error()
+		return o
 	}
-	return results, nil
+	res.Body.Close()
+	o.probe.StatusCode = res.StatusCode
+	if res.StatusCode != http.StatusOK {
+		return o
+	}
+	err = checkPackageHeaders(res, minSize)
+	if err == nil && b != nil && b.StrictContentCheck {
+		reqCtx, cancel := b.requestContext(ctx)
+		defer cancel()
+		err = checkPackageContent(reqCtx, client, u)
+	}
+	if err != nil {
+		o.probe.Error = err.Error()
+		b.log().WithError(err).WithField("url", RedactURL(u)).Debug("kernel header url discarded")
+	}
+	return o
 }
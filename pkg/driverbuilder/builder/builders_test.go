@@ -1,9 +1,23 @@
 package builder
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/blang/semver"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder/buildertest"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
@@ -91,3 +105,810 @@ func TestDefaultGCC(t *testing.T) {
 		}
 	}
 }
+
+// testImagesLister is a static ImagesLister providing an "any" builder image for each major gcc.
+type testImagesLister struct{}
+
+func (l *testImagesLister) LoadImages() []Image {
+	var res []Image
+	for _, gcc := range []string{"4.8.0", "4.9.0", "5.0.0", "6.0.0", "8.0.0", "9.0.0", "10.0.0", "11.0.0", "12.0.0"} {
+		res = append(res, Image{
+			Target:     Type("any"),
+			GCCVersion: mustParseTolerant(gcc),
+			Name:       "docker.io/falcosecurity/driverkit-builder-any-x86_64_gcc" + gcc,
+		})
+	}
+	return res
+}
+
+// newTestConfig returns a Config suitable to render templates without any network access.
+func newTestConfig(target Type) Config {
+	b := &Build{
+		TargetType:       target,
+		DriverVersion:    "master",
+		ModuleFilePath:   "/tmp/falco.ko",
+		ProbeFilePath:    "/tmp/falco.o",
+		ModuleDriverName: "falco",
+		ModuleDeviceName: "falco",
+		RepoOrg:          "falcosecurity",
+		RepoName:         "libs",
+		ImagesListers:    []ImagesLister{&testImagesLister{}},
+		Images:           make(ImagesMap),
+	}
+	return b.ToConfig()
+}
+
+// templateTest checks the build scripts of some targets, rendered before and after an option is set.
+type templateTest struct {
+	// targets are the ones whose scripts get rendered, all of them but flatcar when not set.
+	targets []Type
+	// kernelRelease is the one the scripts get rendered for, 5.15.0-1004-intel-iotg when not set.
+	kernelRelease string
+	// set sets the option under test.
+	set func(c *Config)
+	// unexpected are the snippets none of the scripts has before set.
+	unexpected []string
+	// expected are the snippets all of the scripts have after set.
+	expected []string
+	// check, when set, checks the script of target rendered after set further.
+	check func(t *testing.T, target Type, script string)
+}
+
+// templateTargets returns all the targets but the skipped ones and flatcar, that needs to fetch the infos of its own releases.
+func templateTargets(skip ...Type) []Type {
+	skipped := map[Type]bool{TargetTypeFlatcar: true}
+	for _, target := range skip {
+		skipped[target] = true
+	}
+	var targets []Type
+	for target := range BuilderByTarget {
+		if !skipped[target] {
+			targets = append(targets, target)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+	return targets
+}
+
+func runTemplateTest(t *testing.T, test templateTest) {
+	t.Helper()
+	targets := test.targets
+	if len(targets) == 0 {
+		targets = templateTargets()
+	}
+	release := test.kernelRelease
+	if len(release) == 0 {
+		release = "5.15.0-1004-intel-iotg"
+	}
+	kr := kernelrelease.FromString(release)
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	urls := []string{"https://example.com/kernel-headers"}
+
+	for _, target := range targets {
+		b := BuilderByTarget[target]
+		c := newTestConfig(target)
+		c.KernelRelease = kr.String()
+		c.KernelVersion = "6"
+		c.KernelConfigData = "bm8tZGF0YQ=="
+
+		script, err := renderScript(b, c, kr, urls)
+		if err != nil {
+			t.Fatalf("Unexpected error rendering %s template: %s", target, err)
+		}
+		for _, snippet := range test.unexpected {
+			if strings.Contains(script, snippet) {
+				t.Errorf("Rendered %s template has %q before the option is set", target, snippet)
+			}
+		}
+
+		test.set(&c)
+		script, err = renderScript(b, c, kr, urls)
+		if err != nil {
+			t.Fatalf("Unexpected error rendering %s template: %s", target, err)
+		}
+		for _, snippet := range test.expected {
+			if !strings.Contains(script, snippet) {
+				t.Errorf("Rendered %s template does not have %q:\n%s", target, snippet, script)
+			}
+		}
+		if test.check != nil {
+			test.check(t, target, script)
+		}
+	}
+}
+
+func TestTemplateVermagicSuffix(t *testing.T) {
+	runTemplateTest(t, templateTest{
+		set:        func(c *Config) { c.VermagicSuffix = "-myfork1" },
+		unexpected: []string{"DRIVERKIT_BUILD"},
+		expected:   []string{" DRIVERKIT_BUILD='-myfork1'\n"},
+	})
+}
+
+func TestTemplateBothArtifacts(t *testing.T) {
+	// the parallel builds tell apart the module and the probe blocks of the scripts
+	runTemplateTest(t, templateTest{
+		kernelRelease: "5.15.0-52-generic",
+		set:           func(c *Config) { c.ParallelArtifacts = true },
+		check: func(t *testing.T, target Type, script string) {
+			for _, cmd := range []string{"module_pid=$!\n", "probe_pid=$!\n"} {
+				if strings.Count(script, cmd) != 1 {
+					t.Errorf("Rendered %s template with both outputs does not build each artifact once (%s):\n%s", target, strings.TrimSpace(cmd), script)
+				}
+			}
+		},
+	})
+	runTemplateTest(t, templateTest{
+		kernelRelease: "5.15.0-52-generic",
+		set:           func(c *Config) { c.ProbeFilePath = "" },
+		expected:      []string{"mv falco.ko " + ModuleFullPath},
+		check: func(t *testing.T, target Type, script string) {
+			if strings.Contains(script, "# Build the eBPF probe") {
+				t.Errorf("Rendered %s template with the module output only builds the probe:\n%s", target, script)
+			}
+		},
+	})
+}
+
+func TestGetResolvingURLsStrictContentCheck(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/error.deb":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte("<html><body>404 Not Found</body></html>"))
+		case "/mislabelled.deb":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write([]byte("<!DOCTYPE html><html><body>404 Not Found</body></html>"))
+		case "/real.deb":
+			w.Header().Set("Content-Type", "application/vnd.debian.binary-package")
+			_, _ = w.Write([]byte("!<arch>\ndebian-binary   1654011374  0     0     100644  4         `\n2.0\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer mirror.Close()
+
+	urls := []string{mirror.URL + "/error.deb", mirror.URL + "/mislabelled.deb", mirror.URL + "/real.deb"}
+	for _, test := range []struct {
+		strict   bool
+		expected []string
+	}{
+		// the pages served as HTML are discarded anyway
+		{strict: false, expected: []string{mirror.URL + "/mislabelled.deb", mirror.URL + "/real.deb"}},
+		{strict: true, expected: []string{mirror.URL + "/real.deb"}},
+	} {
+		got, err := getResolvingURLs(context.Background(), &Build{StrictContentCheck: test.strict}, urls)
+		if err != nil {
+			t.Fatalf("Unexpected error with strict content check: %t: %s", test.strict, err)
+		}
+		if strings.Join(got, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("With strict content check: %t expected urls %v, got %v", test.strict, test.expected, got)
+		}
+	}
+}
+
+func TestGetResolvingURLsPackageHeaders(t *testing.T) {
+	mirror := buildertest.NewMirror().
+		Handle("/not-found.deb", buildertest.Response{
+			Header: http.Header{"Content-Type": {"text/html"}},
+			Body:   "<html><body><h1>Not Found</h1></body></html>",
+		}).
+		Handle("/truncated.deb", buildertest.Response{Body: "!<arch>\n"}).
+		Handle("/real.deb", buildertest.Response{Body: "!<arch>\ndebian-binary   1654011374  0     0     100644  4         `\n2.0\n"})
+	urls := []string{"https://mirror.example.com/not-found.deb", "https://mirror.example.com/truncated.deb", "https://mirror.example.com/real.deb"}
+
+	for _, test := range []struct {
+		minPackageSize int64
+		expected       []string
+	}{
+		{minPackageSize: 0, expected: urls[1:]},
+		{minPackageSize: 32, expected: urls[2:]},
+	} {
+		b := &Build{MinPackageSize: test.minPackageSize, URLResolver: NewURLResolverWithClient(mirror.Client())}
+		got, err := getResolvingURLs(context.Background(), b, urls)
+		buildertest.AssertURLs(t, got, err, test.expected)
+		for _, p := range b.URLProbes[:len(urls)-len(test.expected)] {
+			if p.StatusCode != http.StatusOK || !strings.HasPrefix(p.Error, "unexpected content") {
+				t.Errorf("Expected %s to be discarded for its content, got %+v", p.URL, p)
+			}
+		}
+	}
+	// the answers to HEAD are enough to discard them
+	if requests := mirror.Requests(); len(requests) != 2*len(urls) {
+		t.Errorf("Expected a single HEAD request per url and run, got %v", requests)
+	}
+}
+
+func TestTemplateMaxDownloadRate(t *testing.T) {
+	runTemplateTest(t, templateTest{
+		set:        func(c *Config) { c.MaxDownloadRate = "5m" },
+		unexpected: []string{"--limit-rate", "throttle"},
+		check: func(t *testing.T, target Type, script string) {
+			limited := strings.Count(script, "curl --silent --limit-rate 5m ") + strings.Count(script, "yum install -y --setopt=throttle=5m ")
+			if limited == 0 || limited != strings.Count(script, "curl ")+strings.Count(script, "yum install") {
+				t.Errorf("Rendered %s template does not limit every download:\n%s", target, script)
+			}
+		},
+	})
+}
+
+func TestTemplateCACert(t *testing.T) {
+	runTemplateTest(t, templateTest{
+		set:        func(c *Config) { c.CACertPath = "/etc/driverkit/ca.pem" },
+		unexpected: []string{ContainerCABundlePath},
+		expected:   []string{"cat " + ContainerCACertPath + " >> " + ContainerCABundlePath + "\n"},
+		check: func(t *testing.T, target Type, script string) {
+			trusting := strings.Count(script, " --cacert "+ContainerCABundlePath) + strings.Count(script, " --setopt=sslcacert="+ContainerCABundlePath)
+			if trusting != strings.Count(script, "curl ")+strings.Count(script, "yum install") {
+				t.Errorf("Rendered %s template does not trust the CAs for every download:\n%s", target, script)
+			}
+		},
+	})
+}
+
+func TestTemplateVerifyToolchain(t *testing.T) {
+	runTemplateTest(t, templateTest{
+		set: func(c *Config) {
+			c.VerifyToolchain = true
+			c.ParallelArtifacts = true
+		},
+		unexpected: []string{"toolchain_verified"},
+		expected:   []string{"if ! command -v /usr/bin/gcc-", "if ! command -v clang > /dev/null"},
+		check: func(t *testing.T, target Type, script string) {
+			check := strings.Index(script, "if ! command -v /usr/bin/gcc-")
+			// the verification must happen before compiling, outside of the concurrent builds
+			if compile := strings.Index(script, "CC=/usr/bin/gcc-"); compile >= 0 && compile < check {
+				t.Errorf("Rendered %s template compiles before verifying the toolchain:\n%s", target, script)
+			}
+			if subshell := strings.Index(script, "\n(\n"); subshell < check {
+				t.Errorf("Rendered %s template verifies the toolchain into a concurrent build:\n%s", target, script)
+			}
+		},
+	})
+}
+
+func TestTemplateSysroot(t *testing.T) {
+	runTemplateTest(t, templateTest{
+		set:        func(c *Config) { c.Sysroot = "/opt/musl" },
+		unexpected: []string{"--sysroot"},
+		check: func(t *testing.T, target Type, script string) {
+			// the sysroot is passed to the probe build only
+			probe := script[strings.Index(script, "/bpf\n"):]
+			if !strings.Contains(strings.SplitN(probe, "\n", 3)[1], "CLANG='clang --sysroot=/opt/musl'") {
+				t.Errorf("Rendered %s template does not build the probe against the sysroot:\n%s", target, script)
+			}
+			if strings.Count(script, "--sysroot") != 1 {
+				t.Errorf("Rendered %s template uses the sysroot outside of the probe build:\n%s", target, script)
+			}
+		},
+	})
+}
+
+func TestTemplateHeadersPatternOverride(t *testing.T) {
+	for target, release := range map[Type]string{
+		TargetTypeUbuntu: "5.15.0-1004-intel-iotg",
+		TargetTypeDebian: "5.10.0-21-amd64",
+	} {
+		runTemplateTest(t, templateTest{
+			targets:       []Type{target},
+			kernelRelease: release,
+			set:           func(c *Config) { c.HeadersPatternOverride = "linux-headers-*custom" },
+			unexpected:    []string{`-name "linux-headers-*custom"`},
+			expected:      []string{`find . -type d -name "linux-headers-*custom"`},
+		})
+	}
+}
+
+func TestTemplateBuildStamp(t *testing.T) {
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+
+	for _, test := range []struct {
+		driverVersion string
+		buildCommit   string
+		expected      string
+	}{
+		{"6.0.1+driver", "", `printf 'driverversion=6.0.1+driver\0' >> /tmp/driverkit.modinfo`},
+		{"6.0.1+driver", "2e7ea6c", `printf 'driverversion=6.0.1+driver\0buildcommit=2e7ea6c\0' >> /tmp/driverkit.modinfo`},
+		// a driver version being a commit is the build commit itself
+		{"2e7ea6c5e7d9", "", `printf 'driverversion=2e7ea6c5e7d9\0buildcommit=2e7ea6c5e7d9\0' >> /tmp/driverkit.modinfo`},
+		{"master", "", `printf 'driverversion=master\0' >> /tmp/driverkit.modinfo`},
+	} {
+		c := newTestConfig(TargetTypeUbuntu)
+		c.DriverVersion = test.driverVersion
+		c.BuildCommit = test.buildCommit
+		c.ExtraKBuildFlags = []string{"KCFLAGS=-g -O2", "W=1"}
+
+		td := c.toTemplateData(BuilderByTarget[TargetTypeUbuntu], kr)
+		if td.DriverVersion != test.driverVersion || !reflect.DeepEqual(td.ExtraKBuildFlags, c.ExtraKBuildFlags) {
+			t.Errorf("Unexpected template data: %+v", td)
+		}
+
+		runTemplateTest(t, templateTest{
+			targets:       []Type{TargetTypeUbuntu},
+			kernelRelease: kr.String(),
+			set: func(c *Config) {
+				c.DriverVersion = test.driverVersion
+				c.BuildCommit = test.buildCommit
+				c.ExtraKBuildFlags = []string{"KCFLAGS=-g -O2", "W=1"}
+			},
+			unexpected: []string{"KCFLAGS"},
+			expected:   []string{test.expected, "KERNELDIR=$sourcedir 'KCFLAGS=-g -O2' 'W=1'\n"},
+		})
+	}
+}
+
+func TestKernelURLsStrictStatus(t *testing.T) {
+	// a mirror whose proxy forbids one of the candidates
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/forbidden.deb":
+			w.WriteHeader(http.StatusForbidden)
+		case "/missing.deb":
+			http.NotFound(w, r)
+		}
+	}))
+	defer mirror.Close()
+
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	newConfig := func(strict bool) Config {
+		c := newTestConfig(TargetTypeUbuntu)
+		c.KernelUrls = []string{mirror.URL + "/all.deb", mirror.URL + "/forbidden.deb", mirror.URL + "/missing.deb", mirror.URL + "/amd64.deb"}
+		c.StrictStatus = strict
+		return c
+	}
+
+	if _, err := KernelURLs(context.Background(), BuilderByTarget[TargetTypeUbuntu], newConfig(false), kr); err != nil {
+		t.Fatalf("Unexpected error out of strict mode: %s", err)
+	}
+	_, err := KernelURLs(context.Background(), BuilderByTarget[TargetTypeUbuntu], newConfig(true), kr)
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), mirror.URL+"/forbidden.deb") {
+		t.Errorf("Expected the forbidden url to trip the strict mode, got %v", err)
+	}
+}
+
+func TestResolveURLs(t *testing.T) {
+	packages := []string{
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+	}
+	mirror := newUbuntuFixtureMirror(packages...)
+	defer mirror.Close()
+
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelRelease = "5.15.0-52-generic"
+	c.KernelVersion = "58"
+	c.Mirrors = []string{mirror.URL}
+
+	urls, err := ResolveURLs(context.Background(), TargetTypeUbuntu, c, kr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	sort.Strings(urls)
+	if len(urls) != len(packages) || urls[0] != mirror.URL+packages[1] || urls[1] != mirror.URL+packages[0] {
+		t.Errorf("Unexpected urls: %v", urls)
+	}
+
+	// the builders needing more packages than the resolved ones are not buildable
+	c.KernelUrls = []string{mirror.URL + packages[1]}
+	if _, err := ResolveURLs(context.Background(), TargetTypeUbuntu, c, kr); err == nil || !strings.Contains(err.Error(), "expected 2, found 1") {
+		t.Errorf("Expected not enough packages to be found, got %v", err)
+	}
+
+	if _, err := ResolveURLs(context.Background(), "unknown", c, kr); err == nil {
+		t.Errorf("Expected an error for an unknown target")
+	}
+}
+
+func TestResolveURLsDetailed(t *testing.T) {
+	packages := []string{
+		"/linux-aws-5.15/linux-headers-5.15.0-1019-aws_5.15.0-1019.23~20.04.1_amd64.deb",
+		"/linux-aws-5.15/linux-aws-headers-5.15.0-1019_5.15.0-1019.23~20.04.1_all.deb",
+	}
+	empty := newUbuntuFixtureMirror()
+	defer empty.Close()
+	mirror := newUbuntuFixtureMirror(packages...)
+	defer mirror.Close()
+
+	kr := kernelrelease.FromString("5.15.0-1019-aws")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelRelease = "5.15.0-1019-aws"
+	c.KernelVersion = "23~20.04.1"
+	c.Mirrors = []string{empty.URL, mirror.URL}
+
+	// the mirror that resolved the packages is told apart from the ones tried before it
+	res, err := ResolveURLsDetailed(context.Background(), TargetTypeUbuntu, c, kr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(res.URLs) != len(packages) || res.Mirror != mirror.URL || res.Subdir != "linux-aws-5.15" {
+		t.Errorf("Unexpected resolution: %+v", res)
+	}
+
+	// the urls of the build are located by their host
+	c.KernelUrls = []string{mirror.URL + packages[0], mirror.URL + packages[1]}
+	res, err = ResolveURLsDetailed(context.Background(), TargetTypeUbuntu, c, kr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if res.Mirror != mirror.URL || res.Subdir != "linux-aws-5.15" {
+		t.Errorf("Unexpected resolution: %+v", res)
+	}
+
+	if _, err := ResolveURLsDetailed(context.Background(), "unknown", c, kr); err == nil {
+		t.Errorf("Expected an error for an unknown target")
+	}
+}
+
+func TestResolveURLsBatch(t *testing.T) {
+	packages := []string{
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+	}
+	mirror := newUbuntuFixtureMirror(packages...)
+	defer mirror.Close()
+
+	c := newTestConfig(TargetTypeUbuntu)
+	c.Architecture = kernelrelease.ArchitectureAmd64
+	c.KernelVersion = "58"
+	c.Mirrors = []string{mirror.URL}
+	c.ResolveParallelism = 2
+	kernels := []BatchKernel{
+		{KernelRelease: kernelrelease.FromString("5.15.0-52-generic")},
+		{KernelRelease: kernelrelease.FromString("5.15.0-53-generic")},
+		{KernelRelease: kernelrelease.FromString("5.15.0-52-generic"), KernelVersion: "58"},
+		// each kernel has its own version
+		{KernelRelease: kernelrelease.FromString("5.15.0-52-generic"), KernelVersion: "59"},
+	}
+
+	results := ResolveURLsBatch(context.Background(), TargetTypeUbuntu, c, kernels)
+	if len(results) != len(kernels) {
+		t.Fatalf("Expected one result for each kernel, got %d", len(results))
+	}
+	for i, r := range results {
+		kr := kernels[i].KernelRelease
+		if r.KernelRelease.Fullversion+r.KernelRelease.FullExtraversion != kr.Fullversion+kr.FullExtraversion {
+			t.Errorf("Expected the results in the order of the kernels, got %v at %d", r.KernelRelease, i)
+		}
+		if r.KernelRelease.Architecture != kernelrelease.ArchitectureAmd64 {
+			t.Errorf("Expected the kernels to get the architecture of the build, got %q", r.KernelRelease.Architecture)
+		}
+		if len(r.Probes) == 0 || r.Duration <= 0 {
+			t.Errorf("Expected the probes and the timing of %v", r.KernelRelease)
+		}
+	}
+	for _, i := range []int{0, 2} {
+		if results[i].Err != nil || len(results[i].URLs) != len(packages) {
+			t.Errorf("Expected the headers of the kernel #%d to resolve, got %v, %v", i, results[i].URLs, results[i].Err)
+		}
+	}
+	for _, i := range []int{1, 3} {
+		if !errors.Is(results[i].Err, HeadersNotFoundErr) || results[i].URLs != nil {
+			t.Errorf("Expected the headers of the kernel #%d not to be found, got %v, %v", i, results[i].URLs, results[i].Err)
+		}
+	}
+	if results[0].KernelVersion != "58" || results[3].KernelVersion != "59" {
+		t.Errorf("Expected the kernels to get their own version, or the one of the build, got %q and %q", results[0].KernelVersion, results[3].KernelVersion)
+	}
+	if c.URLProbes != nil || c.URLResolver != nil {
+		t.Errorf("Expected the build to be left alone")
+	}
+
+	for _, r := range ResolveURLsBatch(context.Background(), "unknown", c, kernels) {
+		if r.Err == nil {
+			t.Errorf("Expected an error for an unknown target")
+		}
+	}
+}
+
+func TestResolveURLsBatchParallelism(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		http.NotFound(w, r)
+	}))
+	defer mirror.Close()
+
+	c := newTestConfig(TargetTypeUbuntu)
+	c.Architecture = kernelrelease.ArchitectureAmd64
+	c.KernelVersion = "58"
+	c.Mirrors = []string{mirror.URL}
+	c.ResolveParallelism = 3
+	var kernels []BatchKernel
+	for i := 100; i < 110; i++ {
+		kernels = append(kernels, BatchKernel{KernelRelease: kernelrelease.FromString(fmt.Sprintf("5.15.0-%d-generic", i))})
+	}
+
+	// the kernels of the batch share the probes, not running up to the square of the parallelism
+	ResolveURLsBatch(context.Background(), TargetTypeUbuntu, c, kernels)
+	if maxInFlight == 0 || maxInFlight > c.ResolveParallelism {
+		t.Errorf("Expected up to %d requests at once, got %d", c.ResolveParallelism, maxInFlight)
+	}
+
+	// nor hanging once cancelled, with probes left to launch
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	done := make(chan []BatchResult)
+	go func() { done <- ResolveURLsBatch(ctx, TargetTypeUbuntu, c, kernels) }()
+	select {
+	case results := <-done:
+		if results[len(results)-1].Err == nil {
+			t.Errorf("Expected the last kernel to fail")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the cancelled batch to return")
+	}
+}
+
+// underResolvingBuilder needs more headers packages than the ones its mirror serves.
+type underResolvingBuilder struct {
+	acmeBuilder
+	mirror string
+}
+
+func (u *underResolvingBuilder) URLs(_ context.Context, _ Config, _ kernelrelease.KernelRelease) ([]string, error) {
+	return []string{u.mirror + "/kernel-devel.rpm", u.mirror + "/kernel-core.rpm"}, nil
+}
+
+func (u *underResolvingBuilder) MinimumURLs() int {
+	return 2
+}
+
+func TestKernelURLsMinimum(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/kernel-devel.rpm" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer mirror.Close()
+
+	kr := kernelrelease.FromString("5.14.0-70.el9")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	b := &underResolvingBuilder{mirror: mirror.URL}
+	_, err := KernelURLs(context.Background(), b, newTestConfig("acme"), kr)
+	if err == nil || err.Error() != "not enough headers packages found for target acme; expected 2, found 1" {
+		t.Fatalf("Expected the partially resolved urls to be refused, got %v", err)
+	}
+	if _, err := Script(context.Background(), b, newTestConfig("acme"), kr); err == nil {
+		t.Errorf("Expected no script to be rendered for partially resolved urls")
+	}
+}
+
+// flavorMinimumBuilder needs an extra headers package for the kbuild flavored kernels.
+type flavorMinimumBuilder struct {
+	underResolvingBuilder
+}
+
+func (f *flavorMinimumBuilder) KernelMinimumURLs(_ Config, kr kernelrelease.KernelRelease) int {
+	if strings.HasSuffix(kr.FullExtraversion, "-kbuild") {
+		return 3
+	}
+	if strings.HasSuffix(kr.FullExtraversion, "-bogus") {
+		return 0
+	}
+	return 1
+}
+
+func TestKernelURLsKernelMinimum(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer mirror.Close()
+	b := &flavorMinimumBuilder{underResolvingBuilder{mirror: mirror.URL}}
+
+	// the minimum of the kernel release takes precedence over the fixed one
+	kr := kernelrelease.FromString("5.14.0-70.el9")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	if urls, err := KernelURLs(context.Background(), b, newTestConfig("acme"), kr); err != nil || len(urls) != 2 {
+		t.Errorf("Expected the urls to be enough, got %v (%v)", urls, err)
+	}
+
+	kr = kernelrelease.FromString("5.14.0-70.el9-kbuild")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	_, err := KernelURLs(context.Background(), b, newTestConfig("acme"), kr)
+	if err == nil || err.Error() != "not enough headers packages found for target acme; expected 3, found 2" {
+		t.Errorf("Expected the urls to be refused for the kbuild flavor, got %v", err)
+	}
+
+	// a minimum below one still needs a headers package
+	kr = kernelrelease.FromString("5.14.0-70.el9-bogus")
+	err = checkMinimumURLs(b, newTestConfig("acme"), kr, nil)
+	if err == nil || err.Error() != "not enough headers packages found for target acme; expected 1, found 0" {
+		t.Errorf("Expected no urls to be refused, got %v", err)
+	}
+}
+
+func TestKernelURLsPinned(t *testing.T) {
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	mirror := buildertest.NewMirror()
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelVersion = "58"
+	c.URLResolver = NewURLResolverWithClient(mirror.Client())
+	c.CacheDir = t.TempDir()
+	c.HeaderURLs = []string{
+		"https://pinned.example.com/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+		"https://pinned.example.com/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+	}
+
+	// the pinned urls are used verbatim, neither probed nor cached
+	urls, err := KernelURLs(context.Background(), BuilderByTarget[TargetTypeUbuntu], c, kr)
+	buildertest.AssertURLs(t, urls, err, c.HeaderURLs)
+	if requests := mirror.Requests(); len(requests) != 0 {
+		t.Errorf("Expected no requests, got %v", requests)
+	}
+	if entries, err := os.ReadDir(c.CacheDir); err != nil || len(entries) != 0 {
+		t.Errorf("Expected nothing cached, got %v (%v)", entries, err)
+	}
+	script, err := Script(context.Background(), BuilderByTarget[TargetTypeUbuntu], c, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(script, c.HeaderURLs[0]) || !strings.Contains(script, c.HeaderURLs[1]) {
+		t.Errorf("Expected the script to download the pinned urls, got:\n%s", script)
+	}
+
+	c.HeaderURLs = c.HeaderURLs[:1]
+	_, err = KernelURLs(context.Background(), BuilderByTarget[TargetTypeUbuntu], c, kr)
+	if err == nil || err.Error() != "not enough headers packages found for target ubuntu; expected 2, found 1" {
+		t.Errorf("Expected too few pinned urls to be refused, got %v", err)
+	}
+}
+
+func TestRenderScript(t *testing.T) {
+	packages := []string{
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+	}
+	mirror := newUbuntuFixtureMirror(packages...)
+	defer mirror.Close()
+
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelVersion = "58"
+	c.KernelUrls = []string{mirror.URL + packages[0], mirror.URL + packages[1]}
+	// the cached packages are not mounted out of driverkit
+	c.Build.PackageCacheDir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(c.Build.PackageCacheDir, path.Base(packages[0])), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	script, err := RenderScript(context.Background(), TargetTypeUbuntu, c, kr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, p := range packages {
+		if !strings.Contains(script, "-SL "+mirror.URL+p) {
+			t.Errorf("Expected the script to download %s, got:\n%s", mirror.URL+p, script)
+		}
+	}
+	if !strings.HasPrefix(script, "#!/bin/bash") {
+		t.Errorf("Expected a bash script, got:\n%s", script)
+	}
+
+	if _, err := RenderScript(context.Background(), "unknown", c, kr); err == nil {
+		t.Errorf("Expected an error for an unknown target")
+	}
+}
+
+// embeddedTemplates are the compressed templates embedded into the binary, by source file.
+var embeddedTemplates = map[string][]byte{
+	"alinux.sh":      alinuxTemplate,
+	"almalinux.sh":   almaTemplate,
+	"amazonlinux.sh": amazonlinuxTemplate,
+	"archlinux.sh":   archlinuxTemplate,
+	"centos.sh":      centosTemplate,
+	"debian.sh":      debianTemplate,
+	"fedora.sh":      fedoraTemplate,
+	"flatcar.sh":     flatcarTemplate,
+	"opensuse.sh":    opensuseTemplate,
+	"oracle.sh":      oracleTemplate,
+	"photonos.sh":    photonTemplate,
+	"redhat.sh":      redhatTemplate,
+	"rocky.sh":       rockyTemplate,
+	"ubuntu.sh":      ubuntuTemplate,
+	"vanilla.sh":     vanillaTemplate,
+}
+
+func TestEmbeddedTemplates(t *testing.T) {
+	sources, err := filepath.Glob("templates/*.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != len(embeddedTemplates) {
+		t.Fatalf("Expected %d template sources, got %v", len(embeddedTemplates), sources)
+	}
+	for _, src := range sources {
+		data, ok := embeddedTemplates[filepath.Base(src)]
+		if !ok {
+			t.Errorf("Template %s is not embedded", src)
+			continue
+		}
+		expected, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decompressTemplate(data) != string(expected) {
+			t.Errorf("Embedded template %s is stale, run go generate", src)
+		}
+	}
+	for target, b := range BuilderByTarget {
+		if len(b.TemplateScript()) == 0 {
+			t.Errorf("Empty template script for target %s", target)
+		}
+	}
+}
+
+func BenchmarkDecompressTemplates(b *testing.B) {
+	var raw, compressed int
+	for _, data := range embeddedTemplates {
+		raw += len(decompressTemplate(data))
+		compressed += len(data)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, data := range embeddedTemplates {
+			decompressTemplate(data)
+		}
+	}
+	// reported after the loop, ResetTimer dropping the metrics reported so far
+	b.ReportMetric(float64(raw), "raw-bytes")
+	b.ReportMetric(float64(compressed), "embedded-bytes")
+	b.ReportMetric(100*(1-float64(compressed)/float64(raw)), "%saved")
+}
+
+// helpersBuilder renders a script using the helpers of the templates.
+type helpersBuilder struct {
+	acmeBuilder
+	script string
+}
+
+func (h *helpersBuilder) TemplateScript() string { return h.script }
+
+func (h *helpersBuilder) TemplateData(_ Config, _ kernelrelease.KernelRelease, _ []string) interface{} {
+	return struct {
+		LocalVersion string
+		Flavors      []string
+		Empty        string
+		None         []string
+	}{"-52-intel-iotg", []string{"generic", "lowlatency"}, "", nil}
+}
+
+func TestScriptFuncs(t *testing.T) {
+	kr := kernelrelease.FromString("5.15.0-52-intel-iotg")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	for script, want := range map[string]string{
+		`{{ .LocalVersion | trimPrefix "-" }}`:                          "52-intel-iotg",
+		`{{ .LocalVersion | trimPrefix "+" }}`:                          "-52-intel-iotg",
+		`{{ .Empty | default "generic" }}`:                              "generic",
+		`{{ .LocalVersion | default "generic" }}`:                       "-52-intel-iotg",
+		`{{ .None | default "none" }}`:                                  "none",
+		`{{ index (splitList "-" .LocalVersion) 2 }}`:                   "intel",
+		`{{ len (splitList "," .Empty) }}`:                              "1",
+		`{{ .Flavors | join "," }}`:                                     "generic,lowlatency",
+		`{{ splitList "-" (trimPrefix "-" .LocalVersion) | join "_" }}`: "52_intel_iotg",
+	} {
+		b := &helpersBuilder{script: script}
+		got, err := renderScript(b, newTestConfig("acme"), kr, nil)
+		if err != nil {
+			t.Errorf("Unexpected error rendering %s: %s", script, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Rendering %s: got %q, want %q", script, got, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Package buildertest provides the fixtures of the tests resolving the kernel headers urls,
+// serving canned responses in place of the real mirrors.
+package buildertest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Response is the canned response of a path of a Mirror.
+type Response struct {
+	// StatusCode defaults to 200.
+	StatusCode int
+	Header     http.Header
+	Body       string
+	// Delay, when set, is waited for before answering, unless the request is cancelled meanwhile.
+	Delay time.Duration
+}
+
+// Mirror is an http.RoundTripper answering the requests by their path only, whatever the host,
+// with the canned responses registered for it, or 404 otherwise.
+// It records the urls requested too.
+type Mirror struct {
+	mu        sync.Mutex
+	responses map[string]Response
+	requests  []string
+}
+
+// NewMirror constructs a Mirror without any canned response, answering 404 to any request.
+func NewMirror() *Mirror {
+	return &Mirror{responses: make(map[string]Response)}
+}
+
+// Handle registers the response of the given path.
+func (m *Mirror) Handle(path string, res Response) *Mirror {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.responses[path] = res
+	return m
+}
+
+// Packages registers an empty 200 response for each one of the given paths.
+func (m *Mirror) Packages(paths ...string) *Mirror {
+	for _, p := range paths {
+		m.Handle(p, Response{})
+	}
+	return m
+}
+
+// RoundTrip implements http.RoundTripper.
+func (m *Mirror) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.requests = append(m.requests, req.URL.String())
+	res, ok := m.responses[req.URL.Path]
+	m.mu.Unlock()
+
+	if !ok {
+		res = Response{StatusCode: http.StatusNotFound, Body: "404 page not found\n"}
+	}
+	if res.Delay > 0 {
+		select {
+		case <-time.After(res.Delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if res.StatusCode == 0 {
+		res.StatusCode = http.StatusOK
+	}
+	header := res.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	body := res.Body
+	if req.Method == http.MethodHead {
+		body = ""
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode)),
+		StatusCode:    res.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(res.Body)),
+		Request:       req,
+	}, nil
+}
+
+// Client returns a client sending its requests to m.
+func (m *Mirror) Client() *http.Client {
+	return &http.Client{Transport: m}
+}
+
+// Requests returns the urls requested so far, in order.
+func (m *Mirror) Requests() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.requests...)
+}
+
+// AssertURLs fails t when err is not nil or when the resolved urls are not the expected ones, in order.
+func AssertURLs(t testing.TB, urls []string, err error, expected []string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Unexpected error resolving the urls: %s", err)
+	}
+	if len(urls) != len(expected) {
+		t.Fatalf("Slice sizes don't match! Got: '%v' / Want: '%v'", urls, expected)
+	}
+	for i, u := range urls {
+		if u != expected[i] {
+			t.Fatalf("Slice values don't match! Got: '%v' / Want: '%v'", urls, expected)
+		}
+	}
+}
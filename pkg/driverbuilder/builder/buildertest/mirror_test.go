@@ -0,0 +1,63 @@
+package buildertest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMirror(t *testing.T) {
+	m := NewMirror().
+		Packages("/pool/linux-headers_all.deb").
+		Handle("/index", Response{StatusCode: http.StatusAccepted, Header: http.Header{"X-Test": {"yes"}}, Body: "content"})
+	client := m.Client()
+
+	// the path is matched whatever the host
+	for _, u := range []string{"https://a.example.com/pool/linux-headers_all.deb", "http://b.example.com/pool/linux-headers_all.deb"} {
+		res, err := client.Head(u)
+		if err != nil || res.StatusCode != http.StatusOK {
+			t.Fatalf("Expected %s to be found, got %v (%v)", u, res, err)
+		}
+	}
+	if res, err := client.Head("https://a.example.com/pool/missing.deb"); err != nil || res.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected a missing package not to be found, got %v (%v)", res, err)
+	}
+
+	res, err := client.Get("https://a.example.com/index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted || res.Header.Get("X-Test") != "yes" || string(body) != "content" {
+		t.Errorf("Unexpected response: %d %v %q", res.StatusCode, res.Header, body)
+	}
+	res, err = client.Head("https://a.example.com/index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(res.Body); len(body) != 0 || res.ContentLength != int64(len("content")) {
+		t.Errorf("Expected a HEAD response without body, got %q (%d)", body, res.ContentLength)
+	}
+
+	expected := []string{
+		"https://a.example.com/pool/linux-headers_all.deb",
+		"http://b.example.com/pool/linux-headers_all.deb",
+		"https://a.example.com/pool/missing.deb",
+		"https://a.example.com/index",
+		"https://a.example.com/index",
+	}
+	if got := m.Requests(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected the requests %v, got %v", expected, got)
+	}
+
+	// cancelled requests are not answered
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodHead, "https://a.example.com/pool/linux-headers_all.deb", nil)
+	if _, err := client.Do(req); err == nil {
+		t.Errorf("Expected a cancelled request to fail")
+	}
+}
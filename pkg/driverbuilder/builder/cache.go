@@ -0,0 +1,101 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// ContainerPackageCacheDir is where the package cache gets mounted into the build containers.
+const ContainerPackageCacheDir = "/driverkit/package-cache"
+
+// packageCacheName returns the name of the cached copy of the package at u, i.e. its base name.
+func packageCacheName(u string) string {
+	if parsed, err := url.Parse(u); err == nil {
+		return path.Base(parsed.Path)
+	}
+	return path.Base(u)
+}
+
+// cachedURLs replaces the urls of the packages found into the package cache of c, if any,
+// with the ones of their copies mounted into the build container.
+func (c Config) cachedURLs(urls []string) []string {
+	if len(c.PackageCacheDir) == 0 {
+		return urls
+	}
+	res := make([]string, len(urls))
+	for i, u := range urls {
+		res[i] = u
+		name := packageCacheName(u)
+		if _, err := os.Stat(filepath.Join(c.PackageCacheDir, name)); err == nil {
+			res[i] = "file://" + path.Join(ContainerPackageCacheDir, name)
+			logger.WithField("url", RedactURL(u)).Debug("kernel header package found into the cache")
+		}
+	}
+	return res
+}
+
+// FetchPackages downloads the packages at the given urls into the package cache of b,
+// skipping the ones already there, and returns their paths.
+func FetchPackages(ctx context.Context, b *Build, urls []string) ([]string, error) {
+	if len(b.PackageCacheDir) == 0 {
+		return nil, fmt.Errorf("no package cache configured")
+	}
+	if err := os.MkdirAll(b.PackageCacheDir, 0755); err != nil {
+		return nil, err
+	}
+	client := b.mirrorClient()
+	var paths []string
+	for i, u := range urls {
+		p := filepath.Join(b.PackageCacheDir, packageCacheName(u))
+		if _, err := os.Stat(p); err == nil {
+			logger.WithField("path", p).Debug("kernel header package already cached")
+			paths = append(paths, p)
+			continue
+		}
+		b.Progress(PhaseDownload, "downloading headers (%d/%d)", i+1, len(urls))
+		if err := fetchPackage(ctx, client, u, p); err != nil {
+			return paths, err
+		}
+		logger.WithField("url", RedactURL(u)).WithField("path", p).Info("kernel header package cached")
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// fetchPackage downloads the package at u to p, through a temporary file
+// not to leave partial packages into the cache.
+func fetchPackage(ctx context.Context, client *http.Client, u, p string) error {
+	res, err := httpGet(ctx, client, u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status downloading %s: %s", u, res.Status)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := io.Copy(tmp, res.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
@@ -1,14 +1,15 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"github.com/blang/semver"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
-//go:embed templates/centos.sh
-var centosTemplate string
+//go:embed templates/centos.sh.gz
+var centosTemplate []byte
 
 // TargetTypeCentos identifies the Centos target.
 const TargetTypeCentos Type = "centos"
@@ -30,11 +31,15 @@ func (c *centos) Name() string {
 	return TargetTypeCentos.String()
 }
 
+func (c *centos) SupportedArchitectures() []kernelrelease.Architecture {
+	return DefaultArchitectures()
+}
+
 func (c *centos) TemplateScript() string {
-	return centosTemplate
+	return decompressTemplate(centosTemplate)
 }
 
-func (c *centos) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (c *centos) URLs(_ context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
 	vaultReleases := []string{
 		"6.0/os",
 		"6.0/updates",
@@ -0,0 +1,93 @@
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// verifyURLChecksums downloads the packages at the given urls, or reads the local ones or their copies from the package cache, if any,
+// failing on the first one whose SHA256 digest is not the expected one for its base name.
+// The packages missing from expected fail too, not being known-good.
+func (b *Build) verifyURLChecksums(ctx context.Context, urls []string, expected map[string]string) error {
+	client := b.mirrorClient()
+	for i, u := range urls {
+		name := packageCacheName(u)
+		want, ok := expected[name]
+		if !ok {
+			return fmt.Errorf("no checksum known for %s", name)
+		}
+		b.Progress(PhaseDownload, "downloading headers (%d/%d)", i+1, len(urls))
+		got, err := b.packageSHA256(ctx, client, u)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(got, want) {
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", RedactURL(u), want, got)
+		}
+		logger.WithField("url", RedactURL(u)).Debug("kernel header package checksum verified")
+	}
+	return nil
+}
+
+// checksChecksums tells whether the kernel headers packages of c get verified against known-good checksums.
+func (c Config) checksChecksums() bool {
+	return len(c.Checksums) > 0 && !c.SkipChecksums
+}
+
+// scriptChecksum returns the known-good SHA256 digest the build script checks the package at u against, if any,
+// once downloaded into the build container: the one verified by verifyURLChecksums is a download of its own.
+func (c Config) scriptChecksum(u string) string {
+	if !c.checksChecksums() {
+		return ""
+	}
+	return c.Checksums[packageCacheName(u)]
+}
+
+// PackageDigest returns the hex SHA256 digest of the kernel headers package at u of the build of b:
+// the known-good one when the packages get verified, otherwise the one of the local or cached copy of the package, if any,
+// otherwise the one of its download.
+func PackageDigest(ctx context.Context, b *Build, u string) (string, error) {
+	if sum, ok := b.Checksums[packageCacheName(u)]; ok && !b.SkipChecksums {
+		return strings.ToLower(sum), nil
+	}
+	return b.packageSHA256(ctx, b.mirrorClient(), u)
+}
+
+// packageSHA256 returns the hex SHA256 digest of the package at u.
+func (b *Build) packageSHA256(ctx context.Context, client *http.Client, u string) (string, error) {
+	var r io.Reader
+	if p, ok := b.localHeaderPath(u); ok {
+		f, err := os.Open(p)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	} else if f, err := os.Open(filepath.Join(b.PackageCacheDir, packageCacheName(u))); len(b.PackageCacheDir) > 0 && err == nil {
+		defer f.Close()
+		r = f
+	} else {
+		res, err := httpGet(ctx, client, u)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("unexpected status downloading %s: %s", RedactURL(u), res.Status)
+		}
+		r = res.Body
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
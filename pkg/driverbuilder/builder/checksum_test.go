@@ -0,0 +1,104 @@
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestKernelURLsChecksums(t *testing.T) {
+	packages := map[string]string{
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb":           "all",
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb": "amd64",
+	}
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if content, ok := packages[r.URL.Path]; ok {
+			w.Write([]byte(content))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer mirror.Close()
+
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelRelease = "5.15.0-52-generic"
+	c.KernelVersion = "58"
+	c.Checksums = make(map[string]string)
+	for p, content := range packages {
+		c.KernelUrls = append(c.KernelUrls, mirror.URL+p)
+		c.Checksums[filepath.Base(p)] = fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+	}
+	b := BuilderByTarget[TargetTypeUbuntu]
+
+	if urls, err := KernelURLs(context.Background(), b, c, kr); err != nil || len(urls) != len(packages) {
+		t.Fatalf("Expected the checksums to verify, got %v (%v)", err, urls)
+	}
+
+	// a corrupted package fails the build
+	packages["/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb"] = "corrupted"
+	if _, err := KernelURLs(context.Background(), b, c, kr); err == nil || !strings.Contains(err.Error(), "checksum mismatch for") {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+
+	// unless the checksums are skipped
+	c.SkipChecksums = true
+	if _, err := KernelURLs(context.Background(), b, c, kr); err != nil {
+		t.Errorf("Expected the checksums to be skipped, got %v", err)
+	}
+	c.SkipChecksums = false
+
+	// the copies into the package cache are verified in place of the downloaded ones
+	c.Build.PackageCacheDir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(c.Build.PackageCacheDir, "linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb"), []byte("amd64"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := KernelURLs(context.Background(), b, c, kr); err != nil {
+		t.Errorf("Expected the cached package checksum to verify, got %v", err)
+	}
+
+	// and the packages missing from the known-good list fail
+	delete(c.Checksums, "linux-headers-5.15.0-52_5.15.0-52.58_all.deb")
+	if _, err := KernelURLs(context.Background(), b, c, kr); err == nil || !strings.Contains(err.Error(), "no checksum known for linux-headers-5.15.0-52_5.15.0-52.58_all.deb") {
+		t.Errorf("Expected a missing checksum error, got %v", err)
+	}
+}
+
+func TestTemplateChecksums(t *testing.T) {
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte("amd64")))
+	checksums := map[string]string{packageCacheName("https://example.com/kernel-headers"): sum}
+
+	// redhat downloads through yum
+	targets := templateTargets(TargetTypeRedhat)
+	runTemplateTest(t, templateTest{
+		targets: targets,
+		set: func(c *Config) {
+			c.Streaming = true
+			c.Checksums = checksums
+		},
+		unexpected: []string{"sha256sum"},
+		// the build container checks its own download, not to build against a different package
+		expected: []string{"echo '" + sum + "  ", "' | sha256sum --check --quiet -\n"},
+	})
+	runTemplateTest(t, templateTest{
+		targets: targets,
+		set: func(c *Config) {
+			c.Checksums = checksums
+			c.SkipChecksums = true
+		},
+		check: func(t *testing.T, target Type, script string) {
+			if strings.Contains(script, "sha256sum") {
+				t.Errorf("Rendered %s template checks the package checksum though skipped", target)
+			}
+		},
+	})
+}
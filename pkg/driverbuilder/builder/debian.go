@@ -1,17 +1,19 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	"io/ioutil"
 	"net/http"
+	"path"
 	"regexp"
 	"strings"
 )
 
-//go:embed templates/debian.sh
-var debianTemplate string
+//go:embed templates/debian.sh.gz
+var debianTemplate []byte
 
 // TargetTypeDebian identifies the Debian target.
 const TargetTypeDebian Type = "debian"
@@ -22,6 +24,21 @@ const TargetTypeDebian Type = "debian"
 // kbuild package
 const debianRequiredURLs = 3
 
+// debianPools are the pools hosting the Debian kernel headers packages, in the order they are looked into.
+var debianPools = []string{
+	"https://deb.debian.org/debian/pool/main/l/linux/",
+	"https://security.debian.org/debian-security/pool/updates/main/l/linux/",
+	"http://security-cdn.debian.org/pool/main/l/linux/",
+	"http://security-cdn.debian.org/pool/updates/main/l/linux/",
+	"https://mirrors.edge.kernel.org/debian/pool/main/l/linux/",
+}
+
+// debianKbuildPool is where the kbuild package is looked for, when not shipped along with the headers.
+var debianKbuildPool = "http://mirrors.kernel.org/debian/pool/main/l/linux/"
+
+// debianToolsKbuildPool is the debianKbuildPool of the 3.x kernels, shipping the kbuild package with the linux-tools sources.
+var debianToolsKbuildPool = "http://mirrors.kernel.org/debian/pool/main/l/linux-tools/"
+
 func init() {
 	BuilderByTarget[TargetTypeDebian] = &debian{}
 }
@@ -41,18 +58,26 @@ func (v *debian) Name() string {
 	return TargetTypeDebian.String()
 }
 
+func (v *debian) SupportedArchitectures() []kernelrelease.Architecture {
+	return DefaultArchitectures()
+}
+
 func (v *debian) TemplateScript() string {
-	return debianTemplate
+	return decompressTemplate(debianTemplate)
 }
 
-func (v *debian) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchDebianKernelURLs(kr)
+func (v *debian) URLs(ctx context.Context, c Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchDebianKernelURLs(ctx, c.Build.mirrorClient(), c.DebianVendorPool, c.DebianVendorFlavors, kr)
 }
 
 func (v *debian) TemplateData(c Config, kr kernelrelease.KernelRelease, urls []string) interface{} {
 	var KernelHeadersPattern string
-	if strings.HasSuffix(kr.Extraversion, "pve") {
+	if len(c.HeadersPatternOverride) > 0 {
+		KernelHeadersPattern = c.HeadersPatternOverride
+	} else if strings.HasSuffix(kr.Extraversion, "pve") {
 		KernelHeadersPattern = "linux-headers-*pve"
+	} else if flavor, _, ok := debianVendorFlavor(c.DebianVendorFlavors, kr); ok {
+		KernelHeadersPattern = "linux-headers-*" + flavor
 	} else {
 		KernelHeadersPattern = "linux-headers-*" + kr.Architecture.String()
 	}
@@ -69,13 +94,27 @@ func (v *debian) MinimumURLs() int {
 	return debianRequiredURLs
 }
 
-func fetchDebianKernelURLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	kbuildURL, err := debianKbuildURLFromRelease(kr)
+// debianVendorFlavor returns the vendor flavor of the kernel release, if any, with the flavor of its headers packages.
+func debianVendorFlavor(flavors map[string]string, kr kernelrelease.KernelRelease) (string, string, bool) {
+	for flavor, packageFlavor := range flavors {
+		if strings.HasSuffix(kr.FullExtraversion, "-"+flavor) {
+			return flavor, packageFlavor, true
+		}
+	}
+	return "", "", false
+}
+
+// fetchDebianKernelURLs returns the kernel headers urls for the given kernel release,
+// looking into vendorPool (a pool with the Debian layout hosting the kernels of a Debian derivative) first, if any.
+// The vendor flavors map the flavors of the kernel releases of the derivative to the ones of their headers packages.
+func fetchDebianKernelURLs(ctx context.Context, client *http.Client, vendorPool string, vendorFlavors map[string]string, kr kernelrelease.KernelRelease) ([]string, error) {
+	urls, pool, err := debianHeadersURLFromRelease(ctx, client, vendorPool, vendorFlavors, kr)
 	if err != nil {
 		return nil, err
 	}
 
-	urls, err := debianHeadersURLFromRelease(kr)
+	// the kbuild package comes from the same source package as the headers
+	kbuildURL, err := debianKbuildURLFromRelease(ctx, client, pool, debianPackageVersion(urls[0]), kr)
 	if err != nil {
 		return nil, err
 	}
@@ -84,25 +123,25 @@ func fetchDebianKernelURLs(kr kernelrelease.KernelRelease) ([]string, error) {
 	return urls, nil
 }
 
-func debianHeadersURLFromRelease(kr kernelrelease.KernelRelease) ([]string, error) {
-	baseURLS := []string{
-		"http://security-cdn.debian.org/pool/main/l/linux/",
-		"http://security-cdn.debian.org/pool/updates/main/l/linux/",
-		"https://mirrors.edge.kernel.org/debian/pool/main/l/linux/",
+// debianHeadersURLFromRelease returns the kernel headers urls for the given kernel release, with the pool hosting them.
+func debianHeadersURLFromRelease(ctx context.Context, client *http.Client, vendorPool string, vendorFlavors map[string]string, kr kernelrelease.KernelRelease) ([]string, string, error) {
+	baseURLS := debianPools
+	if len(vendorPool) > 0 {
+		baseURLS = append([]string{debianPoolURL(vendorPool)}, baseURLS...)
 	}
 
 	for _, u := range baseURLS {
-		urls, err := fetchDebianHeadersURLFromRelease(u, kr)
+		urls, err := fetchDebianHeadersURLFromRelease(ctx, client, u, vendorFlavors, kr)
 
 		if err == nil {
-			return urls, err
+			return urls, u, err
 		}
 	}
 
-	return nil, HeadersNotFoundErr
+	return nil, "", HeadersNotFoundErr
 }
 
-func fetchDebianHeadersURLFromRelease(baseURL string, kr kernelrelease.KernelRelease) ([]string, error) {
+func fetchDebianHeadersURLFromRelease(ctx context.Context, client *http.Client, baseURL string, vendorFlavors map[string]string, kr kernelrelease.KernelRelease) ([]string, error) {
 	extraVersionPartial := strings.TrimSuffix(kr.FullExtraversion, "-"+kr.Architecture.String())
 	matchExtraGroup := kr.Architecture.String()
 	rmatch := `href="(linux-headers-%d\.%d\.%d%s-(%s)_.*(%s|all)\.deb)"`
@@ -117,10 +156,14 @@ func fetchDebianHeadersURLFromRelease(baseURL string, kr kernelrelease.KernelRel
 	if strings.Contains(kr.FullExtraversion, "-cloud") {
 		extraVersionPartial = strings.TrimSuffix(extraVersionPartial, "-cloud")
 		matchExtraGroup = "cloud-" + matchExtraGroup
+	} else if flavor, packageFlavor, ok := debianVendorFlavor(vendorFlavors, kr); ok {
+		// match for vendor kernel versions like 5.10.0-21-appliance
+		extraVersionPartial = strings.TrimSuffix(extraVersionPartial, "-"+flavor)
+		matchExtraGroup = regexp.QuoteMeta(packageFlavor)
 	}
 
 	// download index
-	resp, err := http.Get(baseURL)
+	resp, err := httpGet(ctx, client, baseURL)
 	if err != nil {
 		return nil, err
 	}
@@ -167,16 +210,43 @@ func fetchDebianHeadersURLFromRelease(baseURL string, kr kernelrelease.KernelRel
 	return foundURLs, nil
 }
 
-func debianKbuildURLFromRelease(kr kernelrelease.KernelRelease) (string, error) {
-	rmatch := `href="(linux-kbuild-%d\.%d.*%s\.deb)"`
+// debianPoolURL returns the url of the pool directory hosting the linux packages, with a trailing slash.
+func debianPoolURL(pool string) string {
+	return strings.TrimSuffix(pool, "/") + "/"
+}
 
-	kbuildPattern := regexp.MustCompile(fmt.Sprintf(rmatch, kr.Major, kr.Minor, kr.Architecture.String()))
-	baseURL := "http://mirrors.kernel.org/debian/pool/main/l/linux/"
+// debianPackageVersion returns the version of the Debian package at u, e.g. 6.1.76-1 for linux-headers-6.1.0-18-amd64_6.1.76-1_amd64.deb.
+func debianPackageVersion(u string) string {
+	if fields := strings.Split(path.Base(u), "_"); len(fields) == 3 {
+		return fields[1]
+	}
+	return ""
+}
+
+// debianKbuildURLFromRelease returns the url of the kbuild package of the given version,
+// looking into the pool hosting the headers first, as vendors may not ship it, relying on the Debian one.
+func debianKbuildURLFromRelease(ctx context.Context, client *http.Client, headersPool, version string, kr kernelrelease.KernelRelease) (string, error) {
+	baseURL := debianKbuildPool
 	if kr.Major == 3 {
-		baseURL = "http://mirrors.kernel.org/debian/pool/main/l/linux-tools/"
+		baseURL = debianToolsKbuildPool
+	}
+	if len(headersPool) > 0 && headersPool != baseURL {
+		if u, err := fetchDebianKbuildURLFromRelease(ctx, client, headersPool, version, kr); err == nil {
+			return u, nil
+		}
 	}
+	return fetchDebianKbuildURLFromRelease(ctx, client, baseURL, version, kr)
+}
+
+// fetchDebianKbuildURLFromRelease looks for the kbuild package of the given version into baseURL,
+// falling back to any of the kernel release series when missing.
+func fetchDebianKbuildURLFromRelease(ctx context.Context, client *http.Client, baseURL, version string, kr kernelrelease.KernelRelease) (string, error) {
+	rmatch := `href="(linux-kbuild-%d\.%d.*%s\.deb)"`
+	rmatchVersion := `href="(linux-kbuild-%d\.%d_%s_%s\.deb)"`
 
-	resp, err := http.Get(baseURL)
+	kbuildPattern := regexp.MustCompile(fmt.Sprintf(rmatch, kr.Major, kr.Minor, kr.Architecture.String()))
+
+	resp, err := httpGet(ctx, client, baseURL)
 	if err != nil {
 		return "", err
 	}
@@ -185,7 +255,16 @@ func debianKbuildURLFromRelease(kr kernelrelease.KernelRelease) (string, error)
 	if err != nil {
 		return "", err
 	}
-	match := kbuildPattern.FindStringSubmatch(string(body))
+	bodyStr := string(body)
+
+	var match []string
+	if len(version) > 0 {
+		versionPattern := regexp.MustCompile(fmt.Sprintf(rmatchVersion, kr.Major, kr.Minor, regexp.QuoteMeta(version), kr.Architecture.String()))
+		match = versionPattern.FindStringSubmatch(bodyStr)
+	}
+	if len(match) != 2 {
+		match = kbuildPattern.FindStringSubmatch(bodyStr)
+	}
 
 	if len(match) != 2 {
 		return "", fmt.Errorf("kbuild not found")
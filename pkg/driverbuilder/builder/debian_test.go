@@ -0,0 +1,174 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// newDebianFixturePool serves a pool with the Debian layout, listing the given packages.
+func newDebianFixturePool(packages ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/debian/pool/main/l/linux/" {
+			for _, p := range packages {
+				fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", p, p)
+			}
+			return
+		}
+		for _, p := range packages {
+			if r.URL.Path == "/debian/pool/main/l/linux/"+p {
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+func TestFetchDebianKernelURLsVendorPool(t *testing.T) {
+	pool := newDebianFixturePool(
+		"linux-headers-5.10.0-21-appliance_5.10.162-1+vendor1_amd64.deb",
+		"linux-headers-5.10.0-21-common_5.10.162-1+vendor1_all.deb",
+		"linux-headers-5.10.0-21-amd64_5.10.162-1+vendor1_amd64.deb",
+		"linux-kbuild-5.10_5.10.162-1+vendor1_amd64.deb",
+	)
+	defer pool.Close()
+	vendorPool := pool.URL + "/debian/pool/main/l/linux"
+
+	kr := kernelrelease.FromString("5.10.0-21-appliance")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	flavors := map[string]string{"appliance": "appliance"}
+
+	urls, err := fetchDebianKernelURLs(context.Background(), http.DefaultClient, vendorPool, flavors, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		vendorPool + "/linux-headers-5.10.0-21-appliance_5.10.162-1+vendor1_amd64.deb",
+		vendorPool + "/linux-headers-5.10.0-21-common_5.10.162-1+vendor1_all.deb",
+		vendorPool + "/linux-kbuild-5.10_5.10.162-1+vendor1_amd64.deb",
+	}
+	if strings.Join(urls, " ") != strings.Join(expected, " ") {
+		t.Fatalf("Expected urls %v, got %v", expected, urls)
+	}
+
+	c := newTestConfig(TargetTypeDebian)
+	c.DebianVendorFlavors = flavors
+	td := (&debian{}).TemplateData(c, kr, urls).(debianTemplateData)
+	if td.KernelHeadersPattern != "linux-headers-*appliance" {
+		t.Errorf("Expected the vendor flavor headers pattern, got %s", td.KernelHeadersPattern)
+	}
+}
+
+func TestFetchDebianKernelURLsBookworm(t *testing.T) {
+	mirror := newDebianFixturePool(
+		"linux-headers-6.1.0-17-amd64_6.1.69-1_amd64.deb",
+		"linux-headers-6.1.0-17-common_6.1.69-1_all.deb",
+		"linux-headers-6.1.0-18-amd64_6.1.76-1_amd64.deb",
+		"linux-headers-6.1.0-18-arm64_6.1.76-1_arm64.deb",
+		"linux-headers-6.1.0-18-cloud-amd64_6.1.76-1_amd64.deb",
+		"linux-headers-6.1.0-18-common_6.1.76-1_all.deb",
+		"linux-headers-6.1.0-18-common-rt_6.1.76-1_all.deb",
+		"linux-kbuild-6.1_6.1.69-1_amd64.deb",
+		"linux-kbuild-6.1_6.1.76-1_amd64.deb",
+		"linux-kbuild-6.1_6.1.76-1_arm64.deb",
+	)
+	defer mirror.Close()
+	// security updates do not ship the kbuild package of the previous point releases
+	security := newDebianFixturePool(
+		"linux-headers-6.1.0-20-amd64_6.1.85-1_amd64.deb",
+		"linux-headers-6.1.0-20-common_6.1.85-1_all.deb",
+	)
+	defer security.Close()
+	kbuild := newDebianFixturePool(
+		"linux-kbuild-6.1_6.1.76-1_amd64.deb",
+		"linux-kbuild-6.1_6.1.85-1_amd64.deb",
+	)
+	defer kbuild.Close()
+
+	mainPool := mirror.URL + "/debian/pool/main/l/linux/"
+	securityPool := security.URL + "/debian/pool/main/l/linux/"
+	kbuildPool := kbuild.URL + "/debian/pool/main/l/linux/"
+	defaultPools, defaultKbuildPool := debianPools, debianKbuildPool
+	debianPools, debianKbuildPool = []string{mainPool, securityPool}, kbuildPool
+	defer func() { debianPools, debianKbuildPool = defaultPools, defaultKbuildPool }()
+
+	tests := map[string]struct {
+		kernelrelease string
+		arch          string
+		expected      []string
+	}{
+		"amd64": {
+			kernelrelease: "6.1.0-18-amd64",
+			arch:          kernelrelease.ArchitectureAmd64,
+			expected: []string{
+				mainPool + "linux-headers-6.1.0-18-amd64_6.1.76-1_amd64.deb",
+				mainPool + "linux-headers-6.1.0-18-common_6.1.76-1_all.deb",
+				mainPool + "linux-kbuild-6.1_6.1.76-1_amd64.deb",
+			},
+		},
+		"previous abi": {
+			kernelrelease: "6.1.0-17-amd64",
+			arch:          kernelrelease.ArchitectureAmd64,
+			expected: []string{
+				mainPool + "linux-headers-6.1.0-17-amd64_6.1.69-1_amd64.deb",
+				mainPool + "linux-headers-6.1.0-17-common_6.1.69-1_all.deb",
+				mainPool + "linux-kbuild-6.1_6.1.69-1_amd64.deb",
+			},
+		},
+		"cloud": {
+			kernelrelease: "6.1.0-18-cloud-amd64",
+			arch:          kernelrelease.ArchitectureAmd64,
+			expected: []string{
+				mainPool + "linux-headers-6.1.0-18-cloud-amd64_6.1.76-1_amd64.deb",
+				mainPool + "linux-headers-6.1.0-18-common_6.1.76-1_all.deb",
+				mainPool + "linux-kbuild-6.1_6.1.76-1_amd64.deb",
+			},
+		},
+		"arm64": {
+			kernelrelease: "6.1.0-18-arm64",
+			arch:          kernelrelease.ArchitectureArm64,
+			expected: []string{
+				mainPool + "linux-headers-6.1.0-18-arm64_6.1.76-1_arm64.deb",
+				mainPool + "linux-headers-6.1.0-18-common_6.1.76-1_all.deb",
+				mainPool + "linux-kbuild-6.1_6.1.76-1_arm64.deb",
+			},
+		},
+		"security update": {
+			kernelrelease: "6.1.0-20-amd64",
+			arch:          kernelrelease.ArchitectureAmd64,
+			expected: []string{
+				securityPool + "linux-headers-6.1.0-20-amd64_6.1.85-1_amd64.deb",
+				securityPool + "linux-headers-6.1.0-20-common_6.1.85-1_all.deb",
+				kbuildPool + "linux-kbuild-6.1_6.1.85-1_amd64.deb",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			kr := kernelrelease.FromString(tt.kernelrelease)
+			kr.Architecture = kernelrelease.Architecture(tt.arch)
+			urls, err := fetchDebianKernelURLs(context.Background(), http.DefaultClient, "", nil, kr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(urls, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("Expected urls %v, got %v", tt.expected, urls)
+			}
+			if len(urls) < (&debian{}).MinimumURLs() {
+				t.Errorf("Expected at least %d urls, got %d", (&debian{}).MinimumURLs(), len(urls))
+			}
+		})
+	}
+
+	kr := kernelrelease.FromString("6.1.0-99-amd64")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	if _, err := fetchDebianKernelURLs(context.Background(), http.DefaultClient, "", nil, kr); !errors.Is(err, HeadersNotFoundErr) {
+		t.Errorf("Expected the headers not to be found, got %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+package builder
+
+import (
+	logger "github.com/sirupsen/logrus"
+)
+
+// DeprecatedTargets maps the deprecated target names onto the current ones,
+// so that the configurations written for the renamed or split targets keep working.
+// Renaming a target means registering its old name here.
+var DeprecatedTargets = map[Type]Type{}
+
+// CurrentTarget returns the current name of the given target, warning about it when deprecated.
+func CurrentTarget(target Type) Type {
+	current, ok := DeprecatedTargets[target]
+	if !ok {
+		return target
+	}
+	logger.WithField("target", target).WithField("current", current).Warn("deprecated target, use the current one")
+	return current
+}
+
+// BuilderForTarget returns the builder of the given target, resolving the deprecated target names.
+func BuilderForTarget(target Type) (Builder, error) {
+	return Factory(CurrentTarget(target))
+}
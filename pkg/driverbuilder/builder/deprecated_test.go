@@ -0,0 +1,37 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestBuilderForDeprecatedTarget(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	DeprecatedTargets["centos-legacy"] = TargetTypeCentos
+	defer delete(DeprecatedTargets, "centos-legacy")
+
+	b, err := BuilderForTarget("centos-legacy")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if b != BuilderByTarget[TargetTypeCentos] {
+		t.Errorf("Expected the centos builder, got %T", b)
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Level != logrus.WarnLevel || entry.Data["target"] != Type("centos-legacy") || entry.Data["current"] != TargetTypeCentos {
+		t.Errorf("Expected a deprecation warning, got %v", entry)
+	}
+
+	// current targets resolve silently
+	hook.Reset()
+	if b, err := BuilderForTarget(TargetTypeRocky); err != nil || b != BuilderByTarget[TargetTypeRocky] || len(hook.AllEntries()) != 0 {
+		t.Errorf("Expected the rocky builder without warnings, got %T, %v (%v)", b, err, hook.AllEntries())
+	}
+
+	if _, err := BuilderForTarget("unknown"); err == nil {
+		t.Errorf("Expected an error for an unknown target")
+	}
+}
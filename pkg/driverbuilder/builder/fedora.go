@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"strings"
@@ -8,8 +9,8 @@ import (
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
-//go:embed templates/fedora.sh
-var fedoraTemplate string
+//go:embed templates/fedora.sh.gz
+var fedoraTemplate []byte
 
 // TargetTypeFedora identifies the Fedora target.
 const TargetTypeFedora Type = "fedora"
@@ -31,11 +32,15 @@ func (c *fedora) Name() string {
 	return TargetTypeFedora.String()
 }
 
+func (c *fedora) SupportedArchitectures() []kernelrelease.Architecture {
+	return DefaultArchitectures()
+}
+
 func (c *fedora) TemplateScript() string {
-	return fedoraTemplate
+	return decompressTemplate(fedoraTemplate)
 }
 
-func (c *fedora) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (c *fedora) URLs(_ context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
 
 	// fedora FullExtraversion looks like "-200.fc36.x86_64"
 	// need to get the "fc36" out of the middle
@@ -1,17 +1,18 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"github.com/blang/semver"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	logger "github.com/sirupsen/logrus"
 	"io/ioutil"
-	"net/http"
 	"strings"
 )
 
-//go:embed templates/flatcar.sh
-var flatcarTemplate string
+//go:embed templates/flatcar.sh.gz
+var flatcarTemplate []byte
 
 // TargetTypeFlatcar identifies the Flatcar target.
 const TargetTypeFlatcar Type = "flatcar"
@@ -34,37 +35,57 @@ func (f *flatcar) Name() string {
 	return TargetTypeFlatcar.String()
 }
 
+func (f *flatcar) SupportedArchitectures() []kernelrelease.Architecture {
+	return []kernelrelease.Architecture{kernelrelease.ArchitectureAmd64, kernelrelease.ArchitectureArm64}
+}
+
 func (f *flatcar) TemplateScript() string {
-	return flatcarTemplate
+	return decompressTemplate(flatcarTemplate)
 }
 
-func (f *flatcar) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
-	if err := f.fillFlatcarInfos(kr); err != nil {
+func (f *flatcar) URLs(ctx context.Context, c Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	if err := f.fillFlatcarInfos(ctx, c.Build, kr); err != nil {
 		return nil, err
 	}
 	return fetchFlatcarKernelURLS(f.info.KernelVersion), nil
 }
 
 func (f *flatcar) TemplateData(c Config, kr kernelrelease.KernelRelease, urls []string) interface{} {
-	// This happens when `kernelurls` option is passed,
-	// therefore URLs() method is not called.
-	if f.info == nil {
-		if err := f.fillFlatcarInfos(kr); err != nil {
-			return err
-		}
-	}
-
 	return flatcarTemplateData{
 		commonTemplateData: c.toTemplateData(f, kr),
 		KernelDownloadURL:  urls[0],
 	}
 }
 
-func (f *flatcar) GCCVersion(_ kernelrelease.KernelRelease) semver.Version {
+// fetchReleaseInfo fills the metadata of the release when URLs() was not called for it,
+// e.g. with the `kernelurls` option or cached urls, the template data needing its gcc version.
+// Nothing is fetched when the gcc version is set, nor with local headers, the build being offline.
+func (f *flatcar) fetchReleaseInfo(ctx context.Context, c Config, kr kernelrelease.KernelRelease) error {
+	if f.hasInfo(kr) || len(c.GCCVersion) > 0 {
+		return nil
+	}
+	if len(c.LocalHeaders) > 0 {
+		logger.WithField("release", kr.Fullversion).
+			Warn("unknown gcc version of the flatcar release with local headers, set gccversion to pick it")
+		return nil
+	}
+	return f.fillFlatcarInfos(ctx, c.Build, kr)
+}
+
+func (f *flatcar) GCCVersion(kr kernelrelease.KernelRelease) semver.Version {
+	if !f.hasInfo(kr) {
+		// falling back to the default algorithm
+		return semver.Version{}
+	}
 	return f.info.GCCVersion
 }
 
-func (f *flatcar) fillFlatcarInfos(kr kernelrelease.KernelRelease) error {
+// hasInfo tells whether the metadata of the given release were already fetched.
+func (f *flatcar) hasInfo(kr kernelrelease.KernelRelease) bool {
+	return f.info != nil && f.info.Version == kr.Fullversion
+}
+
+func (f *flatcar) fillFlatcarInfos(ctx context.Context, b *Build, kr kernelrelease.KernelRelease) error {
 	if kr.Extraversion != "" {
 		return fmt.Errorf("unexpected extraversion: %s", kr.Extraversion)
 	}
@@ -75,7 +96,7 @@ func (f *flatcar) fillFlatcarInfos(kr kernelrelease.KernelRelease) error {
 	}
 
 	var err error
-	f.info, err = fetchFlatcarMetadata(kr)
+	f.info, err = fetchFlatcarMetadata(ctx, b, kr)
 	return err
 }
 
@@ -84,16 +105,16 @@ func fetchFlatcarKernelURLS(kernelVersion string) []string {
 	return []string{fetchVanillaKernelURLFromKernelVersion(kv)}
 }
 
-func fetchFlatcarMetadata(kr kernelrelease.KernelRelease) (*flatcarReleaseInfo, error) {
-	flatcarInfo := flatcarReleaseInfo{}
+func fetchFlatcarMetadata(ctx context.Context, b *Build, kr kernelrelease.KernelRelease) (*flatcarReleaseInfo, error) {
 	flatcarVersion := kr.Fullversion
-	packageIndexUrl, err := getResolvingURLs(fetchFlatcarPackageListURL(kr.Architecture, flatcarVersion))
+	flatcarInfo := flatcarReleaseInfo{Version: flatcarVersion}
+	packageIndexUrl, err := getResolvingMetadataURLs(ctx, b, fetchFlatcarPackageListURL(kr.Architecture, flatcarVersion))
 	if err != nil {
 		return nil, err
 	}
 	// first part of the URL is the channel
 	flatcarInfo.Channel = strings.Split(packageIndexUrl[0], ".")[0][len("https://"):]
-	resp, err := http.Get(packageIndexUrl[0])
+	resp, err := httpGet(ctx, b.mirrorClient(), packageIndexUrl[0])
 	if err != nil {
 		return nil, err
 	}
@@ -146,6 +167,7 @@ func fetchFlatcarPackageListURL(architecture kernelrelease.Architecture, flatcar
 }
 
 type flatcarReleaseInfo struct {
+	Version       string
 	Channel       string
 	GCCVersion    semver.Version
 	KernelVersion string
@@ -0,0 +1,89 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder/buildertest"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestFlatcarURLs(t *testing.T) {
+	packages := "sys-devel/gcc-12.2.1-r1::portage-stable\nsys-kernel/coreos-kernel-5.15.86::coreos-overlay\n"
+	mirror := buildertest.NewMirror().
+		Handle("/amd64-usr/3510.2.0/flatcar_production_image_packages.txt", buildertest.Response{Body: packages})
+
+	kr := kernelrelease.FromString("3510.2.0")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	c := newTestConfig(TargetTypeFlatcar)
+	c.URLResolver = NewURLResolverWithClient(mirror.Client())
+	// the default of the CLI, the list of packages of the release being way smaller
+	c.MinPackageSize = 65536
+
+	f := &flatcar{}
+	urls, err := f.URLs(context.Background(), c, kr)
+	buildertest.AssertURLs(t, urls, err, []string{"https://cdn.kernel.org/pub/linux/kernel/v5.x/linux-5.15.86.tar.xz"})
+	if f.info.Channel != "stable" {
+		t.Errorf("Expected the stable channel, got %s", f.info.Channel)
+	}
+	if f.info.GCCVersion.String() != "12.2.1" {
+		t.Errorf("Expected gcc 12.2.1, got %s", f.info.GCCVersion)
+	}
+}
+
+func TestFlatcarScriptFetchesReleaseInfo(t *testing.T) {
+	packages := "sys-devel/gcc-12.2.1-r1::portage-stable\nsys-kernel/coreos-kernel-5.15.86::coreos-overlay\n"
+	mirror := buildertest.NewMirror().
+		Handle("/amd64-usr/3510.2.0/flatcar_production_image_packages.txt", buildertest.Response{Body: packages})
+
+	kr := kernelrelease.FromString("3510.2.0")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	c := newTestConfig(TargetTypeFlatcar)
+	c.URLResolver = NewURLResolverWithClient(mirror.Client())
+	c.MinPackageSize = 65536
+	// URLs() is not called, the metadata being fetched along with the script
+	c.HeaderURLs = []string{"https://cdn.kernel.org/pub/linux/kernel/v5.x/linux-5.15.86.tar.xz"}
+
+	f := &flatcar{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Script(ctx, f, c, kr); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("Expected the fetch of the metadata to be cancelled, got %v", err)
+	}
+
+	if _, err := Script(context.Background(), f, c, kr); err != nil {
+		t.Fatal(err)
+	}
+	if f.GCCVersion(kr).String() != "12.2.1" {
+		t.Errorf("Expected gcc 12.2.1, got %s", f.GCCVersion(kr))
+	}
+	// the metadata of another release are not reused
+	other := kernelrelease.FromString("3510.3.0")
+	if v := f.GCCVersion(other); v.String() != "0.0.0" {
+		t.Errorf("Expected no gcc version for another release, got %s", v)
+	}
+}
+
+func TestFlatcarScriptLocalHeaders(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "linux-5.15.86.tar.xz")
+	if err := os.WriteFile(p, []byte("linux"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mirror := buildertest.NewMirror()
+
+	kr := kernelrelease.FromString("3510.2.0")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	c := newTestConfig(TargetTypeFlatcar)
+	c.URLResolver = NewURLResolverWithClient(mirror.Client())
+	c.LocalHeaders = []string{p}
+
+	if _, err := Script(context.Background(), &flatcar{}, c, kr); err != nil {
+		t.Fatal(err)
+	}
+	if requests := mirror.Requests(); len(requests) != 0 {
+		t.Errorf("Expected no requests, got %v", requests)
+	}
+}
@@ -15,6 +15,45 @@ import (
 	"strings"
 )
 
+// BuilderImageVersionLabel is the label of the builder images holding their version.
+const BuilderImageVersionLabel = "org.falcosecurity/driverkit-builder-version"
+
+// BuilderImageTooOldErr is returned when a builder image is older than the one the builder needs.
+type BuilderImageTooOldErr struct {
+	Image    string
+	Version  string
+	Required semver.Version
+}
+
+func (e *BuilderImageTooOldErr) Error() string {
+	if len(e.Version) == 0 {
+		return fmt.Sprintf("builder image %s is not versioned, while at least %s is required", e.Image, e.Required)
+	}
+	return fmt.Sprintf("builder image %s has version %s, while at least %s is required", e.Image, e.Version, e.Required)
+}
+
+// CheckBuilderImageVersion compares the version found into the labels of the given builder image
+// against the minimum one required by b, if any.
+func CheckBuilderImageVersion(b Builder, image string, labels map[string]string) error {
+	bb, ok := b.(MinBuilderImageVersionRequestor)
+	if !ok {
+		return nil
+	}
+	required := bb.MinBuilderImageVersion()
+	version := labels[BuilderImageVersionLabel]
+	if len(version) == 0 {
+		return &BuilderImageTooOldErr{Image: image, Required: required}
+	}
+	v, err := semver.ParseTolerant(version)
+	if err != nil {
+		return fmt.Errorf("invalid version of builder image %s: %w", image, err)
+	}
+	if v.LT(required) {
+		return &BuilderImageTooOldErr{Image: image, Version: version, Required: required}
+	}
+	return nil
+}
+
 type YAMLImage struct {
 	Target      string   `yaml:"target"`
 	GCCVersions []string `yaml:"gcc_versions"` // we expect images to internally link eg: gcc5 to gcc5.0.0
@@ -109,7 +148,7 @@ func (f *FileImagesLister) LoadImages() []Image {
 func NewRepoImagesLister(repo string, build *Build) *RepoImagesLister {
 	if len(repoRegs) == 0 {
 		// Create the proper regexes to load "any" and target-specific images for requested arch
-		arch := kernelrelease.Architecture(build.Architecture).ToNonDeb()
+		arch := kernelrelease.Architecture(build.BuilderArchitecture()).ToNonDeb()
 		targetFmt := fmt.Sprintf("driverkit-builder-(?P<target>%s)-%s(?P<gccVers>(_gcc[0-9]+.[0-9]+.[0-9]+)+)$", build.TargetType.String(), arch)
 		repoRegs = append(repoRegs, regexp.MustCompile(targetFmt))
 		genericFmt := fmt.Sprintf("driverkit-builder-any-%s(?P<gccVers>(_gcc[0-9]+.[0-9]+.[0-9]+)+)$", arch)
@@ -0,0 +1,112 @@
+package builder
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// parseKernelConfig returns the options set in the given kernel config, mapped to their values.
+// Options explicitly marked as "is not set" are omitted.
+func parseKernelConfig(config string) map[string]string {
+	options := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(config))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "CONFIG_") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		options[kv[0]] = strings.Trim(kv[1], "\"")
+	}
+	return options
+}
+
+// missingKernelConfigs returns the required options that are not enabled (either built-in or as module) in config.
+func missingKernelConfigs(config map[string]string, required []string) []string {
+	var missing []string
+	for _, r := range required {
+		name := r
+		if !strings.HasPrefix(name, "CONFIG_") {
+			name = "CONFIG_" + name
+		}
+		if val, ok := config[name]; !ok || val == "n" {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// checkKernelConfig verifies that the kernel config data of the build has all the required options.
+// When no kernel config data is provided it only emits a warning, since there is nothing to check against.
+func (b *Build) checkKernelConfig() error {
+	if len(b.RequiredKernelConfigs) == 0 {
+		return nil
+	}
+
+	configDecoded, err := base64.StdEncoding.DecodeString(b.KernelConfigData)
+	if err != nil {
+		return err
+	}
+
+	config := parseKernelConfig(string(configDecoded))
+	if len(config) == 0 {
+		logger.WithField("required", b.RequiredKernelConfigs).
+			Warning("no kernel config data provided, skipping required kernel config options check")
+		return nil
+	}
+
+	if missing := missingKernelConfigs(config, b.RequiredKernelConfigs); len(missing) > 0 {
+		return fmt.Errorf("kernel config is missing required options: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+var (
+	// Example: "# Linux/x86 5.15.0-1004-intel-iotg Kernel Configuration"
+	kernelConfigHeaderPattern = regexp.MustCompile(`^#\s*Linux/\S+ (\S+) Kernel Configuration`)
+	// Example: "Ubuntu 5.15.0-1004.6-intel-iotg 5.15.27", where "6" is the kernel version (the upload number)
+	ubuntuVersionSignaturePattern = regexp.MustCompile(`^Ubuntu (\d+\.\d+\.\d+)-(\d+)\.([^-\s]+)-(\S+) \S+$`)
+)
+
+// KernelConfigVersion contains the build-relevant version fields derived from a kernel config.
+type KernelConfigVersion struct {
+	KernelRelease string
+	KernelVersion string
+}
+
+// KernelVersionFromConfig derives the kernel release and, when available, the kernel version
+// from the given kernel config. It is meant for systems whose uname can not be trusted.
+//
+// The kernel release and version come from the Ubuntu CONFIG_VERSION_SIGNATURE plus
+// CONFIG_LOCALVERSION, the signature being the one stamped at build time; other kernels
+// fall back to the release found in the header of the config, without a kernel version.
+func KernelVersionFromConfig(config string) (KernelConfigVersion, error) {
+	var v KernelConfigVersion
+	options := parseKernelConfig(config)
+	if m := ubuntuVersionSignaturePattern.FindStringSubmatch(options["CONFIG_VERSION_SIGNATURE"]); m != nil {
+		v.KernelRelease = fmt.Sprintf("%s-%s-%s%s", m[1], m[2], m[4], options["CONFIG_LOCALVERSION"])
+		v.KernelVersion = m[3]
+		return v, nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(config))
+	for scanner.Scan() {
+		if m := kernelConfigHeaderPattern.FindStringSubmatch(strings.TrimSpace(scanner.Text())); m != nil {
+			v.KernelRelease = m[1]
+			break
+		}
+	}
+
+	if len(v.KernelRelease) == 0 {
+		return v, fmt.Errorf("kernel release not found in kernel config")
+	}
+	return v, nil
+}
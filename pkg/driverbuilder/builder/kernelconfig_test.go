@@ -0,0 +1,121 @@
+package builder
+
+import (
+	"encoding/base64"
+	"os"
+	"testing"
+)
+
+const testKernelConfig = `
+#
+# Automatically generated file; DO NOT EDIT.
+# Linux/x86 5.15.0 Kernel Configuration
+#
+CONFIG_LOCALVERSION=""
+CONFIG_HAVE_SYSCALL_TRACEPOINTS=y
+CONFIG_MODULES=y
+CONFIG_KPROBES=m
+# CONFIG_TRACEPOINTS is not set
+CONFIG_BPF_SYSCALL=n
+`
+
+func TestCheckKernelConfig(t *testing.T) {
+	tests := map[string]struct {
+		config      string
+		required    []string
+		expectedErr string
+	}{
+		"no required options": {
+			config:   testKernelConfig,
+			required: nil,
+		},
+		"all required options enabled": {
+			config:   testKernelConfig,
+			required: []string{"CONFIG_MODULES", "CONFIG_HAVE_SYSCALL_TRACEPOINTS", "KPROBES"},
+		},
+		"required option not set": {
+			config:      testKernelConfig,
+			required:    []string{"CONFIG_MODULES", "CONFIG_TRACEPOINTS"},
+			expectedErr: "kernel config is missing required options: CONFIG_TRACEPOINTS",
+		},
+		"required options disabled or absent": {
+			config:      testKernelConfig,
+			required:    []string{"CONFIG_BPF_SYSCALL", "CONFIG_BPF_JIT"},
+			expectedErr: "kernel config is missing required options: CONFIG_BPF_SYSCALL, CONFIG_BPF_JIT",
+		},
+		"no kernel config data": {
+			config:   "no-data",
+			required: []string{"CONFIG_TRACEPOINTS"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := &Build{
+				KernelConfigData:      base64.StdEncoding.EncodeToString([]byte(test.config)),
+				RequiredKernelConfigs: test.required,
+			}
+			err := b.checkKernelConfig()
+			if test.expectedErr == "" && err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr) {
+				t.Fatalf("Got error '%v' / Want: '%s'", err, test.expectedErr)
+			}
+		})
+	}
+}
+
+func TestKernelVersionFromConfig(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/config-5.15.0-1004-intel-iotg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		config      string
+		expected    KernelConfigVersion
+		expectedErr string
+	}{
+		"ubuntu kernel config": {
+			config:   string(fixture),
+			expected: KernelConfigVersion{KernelRelease: "5.15.0-1004-intel-iotg", KernelVersion: "6"},
+		},
+		"ubuntu version signature only": {
+			config: `CONFIG_LOCALVERSION="-custom"
+CONFIG_VERSION_SIGNATURE="Ubuntu 5.15.0-24.24~20.04.3-lowlatency 5.15.30"
+`,
+			expected: KernelConfigVersion{KernelRelease: "5.15.0-24-lowlatency-custom", KernelVersion: "24~20.04.3"},
+		},
+		"version signature over a stale header": {
+			config: `# Linux/x86 5.15.0-1003-intel-iotg Kernel Configuration
+CONFIG_LOCALVERSION=""
+CONFIG_VERSION_SIGNATURE="Ubuntu 5.15.0-1004.6-intel-iotg 5.15.27"
+`,
+			expected: KernelConfigVersion{KernelRelease: "5.15.0-1004-intel-iotg", KernelVersion: "6"},
+		},
+		"header only": {
+			config:   testKernelConfig,
+			expected: KernelConfigVersion{KernelRelease: "5.15.0"},
+		},
+		"no version info": {
+			config:      "CONFIG_MODULES=y\n",
+			expectedErr: "kernel release not found in kernel config",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := KernelVersionFromConfig(test.config)
+			if test.expectedErr == "" && err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr) {
+				t.Fatalf("Got error '%v' / Want: '%s'", err, test.expectedErr)
+			}
+			if got != test.expected {
+				t.Fatalf("Got: '%+v' / Want: '%+v'", got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ContainerLocalHeadersDir is where the local kernel headers packages get mounted into the build containers.
+const ContainerLocalHeadersDir = "/driverkit/local-headers"
+
+// localHeadersURLs returns the urls of the copies of the local kernel headers packages of b
+// mounted into the build container, failing when any of them is missing on the host.
+func (b *Build) localHeadersURLs() ([]string, error) {
+	names := make(map[string]string, len(b.LocalHeaders))
+	urls := make([]string, 0, len(b.LocalHeaders))
+	for _, p := range b.LocalHeaders {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("local kernel headers package not available: %w", err)
+		}
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("local kernel headers package %s is not a regular file", p)
+		}
+		name := filepath.Base(p)
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("local kernel headers packages %s and %s have the same name", other, p)
+		}
+		names[name] = p
+		urls = append(urls, "file://"+path.Join(ContainerLocalHeadersDir, name))
+	}
+	return urls, nil
+}
+
+// localHeaderPath returns the path on the host of the local kernel headers package mounted at u, if any.
+func (b *Build) localHeaderPath(u string) (string, bool) {
+	if b == nil || !strings.HasPrefix(u, "file://"+ContainerLocalHeadersDir+"/") {
+		return "", false
+	}
+	name := path.Base(u)
+	for _, p := range b.LocalHeaders {
+		if filepath.Base(p) == name {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// LocalHeadersBinds returns the binds mounting the given local kernel headers packages,
+// read-only, where the build script expects them.
+func LocalHeadersBinds(paths []string) ([]string, error) {
+	binds := make([]string, 0, len(paths))
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		binds = append(binds, abs+":"+path.Join(ContainerLocalHeadersDir, filepath.Base(p))+":ro")
+	}
+	return binds, nil
+}
@@ -0,0 +1,90 @@
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder/buildertest"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestLocalHeaders(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+		"linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+	}
+	var paths []string
+	checksums := make(map[string]string)
+	for _, name := range names {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+		checksums[name] = fmt.Sprintf("%x", sha256.Sum256([]byte(name)))
+	}
+
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	mirror := buildertest.NewMirror()
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelVersion = "58"
+	c.URLResolver = NewURLResolverWithClient(mirror.Client())
+	c.LocalHeaders = paths
+	c.Checksums = checksums
+
+	// nothing gets resolved nor downloaded, the checksums being verified on the host
+	urls, err := KernelURLs(context.Background(), BuilderByTarget[TargetTypeUbuntu], c, kr)
+	buildertest.AssertURLs(t, urls, err, []string{
+		"file:///driverkit/local-headers/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+		"file:///driverkit/local-headers/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+	})
+	if requests := mirror.Requests(); len(requests) != 0 {
+		t.Errorf("Expected no requests, got %v", requests)
+	}
+	script, err := Script(context.Background(), BuilderByTarget[TargetTypeUbuntu], c, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(script, urls[0]) || !strings.Contains(script, urls[1]) {
+		t.Errorf("Expected the script to extract the local headers, got:\n%s", script)
+	}
+
+	c.Checksums = map[string]string{names[0]: checksums[names[1]], names[1]: checksums[names[1]]}
+	if _, err := KernelURLs(context.Background(), BuilderByTarget[TargetTypeUbuntu], c, kr); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+	c.Checksums = nil
+
+	c.LocalHeaders = append(paths, filepath.Join(dir, "missing.deb"))
+	if _, err := KernelURLs(context.Background(), BuilderByTarget[TargetTypeUbuntu], c, kr); err == nil {
+		t.Errorf("Expected a missing local headers package to fail")
+	}
+	other := filepath.Join(t.TempDir(), names[0])
+	if err := os.WriteFile(other, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.LocalHeaders = append(paths, other)
+	if _, err := KernelURLs(context.Background(), BuilderByTarget[TargetTypeUbuntu], c, kr); err == nil || !strings.Contains(err.Error(), "same name") {
+		t.Errorf("Expected local headers packages with the same name to fail, got %v", err)
+	}
+
+	binds, err := LocalHeadersBinds(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		paths[0] + ":/driverkit/local-headers/" + names[0] + ":ro",
+		paths[1] + ":/driverkit/local-headers/" + names[1] + ":ro",
+	}
+	if !reflect.DeepEqual(binds, expected) {
+		t.Errorf("Expected the binds %v, got %v", expected, binds)
+	}
+}
@@ -0,0 +1,52 @@
+package builder
+
+import (
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// Manifest describes how the artifacts of a build were built, for the audit of their provenance.
+type Manifest struct {
+	Target        string `json:"target"`
+	KernelRelease string `json:"kernelrelease"`
+	KernelVersion string `json:"kernelversion"`
+	Architecture  string `json:"architecture"`
+	// Flavor is the one of the kernel headers the build used, for the targets whose kernels come in flavors.
+	Flavor             string   `json:"flavor,omitempty"`
+	DriverVersion      string   `json:"driverversion"`
+	GCCVersion         string   `json:"gccversion,omitempty"`
+	KernelDownloadURLs []string `json:"kerneldownloadurls"`
+	// Artifacts are filled once the build completed.
+	Artifacts []ManifestArtifact `json:"artifacts,omitempty"`
+}
+
+// ManifestArtifact is an artifact of the build, by base name, with its digest.
+type ManifestArtifact struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// headersFlavorBuilder is an optional interface of the builders whose kernels come in flavors,
+// telling the flavor of the headers at the urls resolved for kr.
+type headersFlavorBuilder interface {
+	headersFlavor(c Config, kr kernelrelease.KernelRelease, urls []string) string
+}
+
+// recordManifest records into b, if asked to, the manifest of its build with the given builder from the given urls.
+func (b *Build) recordManifest(builder Builder, c Config, kr kernelrelease.KernelRelease, urls []string) {
+	if b == nil || len(b.ManifestPath) == 0 {
+		return
+	}
+	m := &Manifest{
+		Target:             b.TargetType.String(),
+		KernelRelease:      b.KernelRelease,
+		KernelVersion:      b.KernelVersion,
+		Architecture:       kr.Architecture.String(),
+		DriverVersion:      b.DriverVersion,
+		GCCVersion:         b.GCCVersion,
+		KernelDownloadURLs: redactURLs(urls),
+	}
+	if fb, ok := builder.(headersFlavorBuilder); ok {
+		m.Flavor = fb.headersFlavor(c, kr, urls)
+	}
+	b.Manifest = m
+}
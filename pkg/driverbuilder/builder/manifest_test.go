@@ -0,0 +1,52 @@
+package builder
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestScriptRecordsManifest(t *testing.T) {
+	packages := []string{
+		"/linux-lowlatency/linux-headers-5.15.0-52-lowlatency_5.15.0-52.58_amd64.deb",
+		"/linux-lowlatency/linux-lowlatency-headers-5.15.0-52_5.15.0-52.58_all.deb",
+	}
+	mirror := newUbuntuFixtureMirror(packages...)
+	defer mirror.Close()
+
+	kr := kernelrelease.FromString("5.15.0-52-lowlatency")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelRelease = "5.15.0-52-lowlatency"
+	c.KernelVersion = "58"
+	c.Architecture = kernelrelease.ArchitectureAmd64
+	c.KernelUrls = []string{mirror.URL + packages[0], mirror.URL + packages[1]}
+
+	// nothing gets recorded unless asked to
+	if _, err := Script(context.Background(), &ubuntu{}, c, kr); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if c.Manifest != nil {
+		t.Fatalf("Expected no manifest to be recorded, got %+v", c.Manifest)
+	}
+
+	c.ManifestPath = "/tmp/falco.json"
+	if _, err := Script(context.Background(), &ubuntu{}, c, kr); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := &Manifest{
+		Target:             "ubuntu",
+		KernelRelease:      "5.15.0-52-lowlatency",
+		KernelVersion:      "58",
+		Architecture:       "amd64",
+		Flavor:             "lowlatency",
+		DriverVersion:      "master",
+		GCCVersion:         c.GCCVersion,
+		KernelDownloadURLs: c.KernelUrls,
+	}
+	if !reflect.DeepEqual(expected, c.Manifest) {
+		t.Errorf("Expected manifest %+v, got %+v", expected, c.Manifest)
+	}
+}
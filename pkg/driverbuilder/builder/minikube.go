@@ -22,6 +22,10 @@ func (m *minikube) Name() string {
 	return TargetTypeMinikube.String()
 }
 
+func (m *minikube) SupportedArchitectures() []kernelrelease.Architecture {
+	return []kernelrelease.Architecture{kernelrelease.ArchitectureAmd64, kernelrelease.ArchitectureArm64}
+}
+
 func (m *minikube) TemplateData(c Config, kr kernelrelease.KernelRelease, urls []string) interface{} {
 	return vanillaTemplateData{
 		commonTemplateData: c.toTemplateData(m, kr),
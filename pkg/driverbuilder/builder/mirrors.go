@@ -0,0 +1,84 @@
+package builder
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// mirrorLatencies collects the latencies of the probes sent to each mirror during the session.
+var mirrorLatencies = newLatencyTracker()
+
+type latencySample struct {
+	total time.Duration
+	count int
+}
+
+// latencyTracker keeps the probe timings of the mirrors, keyed by scheme and host.
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples map[string]latencySample
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{samples: make(map[string]latencySample)}
+}
+
+func mirrorKey(u string) string {
+	uu, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return uu.Scheme + "://" + uu.Host
+}
+
+// observe records the time d taken by a probe of the URL u.
+func (lt *latencyTracker) observe(u string, d time.Duration) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	key := mirrorKey(u)
+	s := lt.samples[key]
+	s.total += d
+	s.count++
+	lt.samples[key] = s
+}
+
+// average returns the mean probe time of the mirror serving u, if any probe was recorded.
+func (lt *latencyTracker) average(u string) (time.Duration, bool) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	s, ok := lt.samples[mirrorKey(u)]
+	if !ok || s.count == 0 {
+		return 0, false
+	}
+	return s.total / time.Duration(s.count), true
+}
+
+// sortMirrors returns the given base URLs ordered by the measured latency of their mirrors, fastest first.
+// Mirrors without timing data keep their relative order after the measured ones,
+// so that the default order applies until some data is collected.
+func (lt *latencyTracker) sortMirrors(baseURLs []string) []string {
+	type mirror struct {
+		url      string
+		latency  time.Duration
+		measured bool
+	}
+	mirrors := make([]mirror, len(baseURLs))
+	for i, u := range baseURLs {
+		latency, measured := lt.average(u)
+		mirrors[i] = mirror{u, latency, measured}
+	}
+	sort.SliceStable(mirrors, func(i, j int) bool {
+		if mirrors[i].measured != mirrors[j].measured {
+			return mirrors[i].measured
+		}
+		return mirrors[i].latency < mirrors[j].latency
+	})
+
+	sorted := make([]string, len(mirrors))
+	for i, m := range mirrors {
+		sorted[i] = m.url
+	}
+	return sorted
+}
@@ -0,0 +1,46 @@
+package builder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSortMirrorsByLatency(t *testing.T) {
+	defaultTracker := mirrorLatencies
+	mirrorLatencies = newLatencyTracker()
+	defer func() { mirrorLatencies = defaultTracker }()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		http.NotFound(w, r)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer fast.Close()
+
+	baseURLs := []string{slow.URL + "/ubuntu/pool/main/l", fast.URL + "/ubuntu/pool/main/l"}
+
+	// Default order on the first build
+	got := mirrorLatencies.sortMirrors(baseURLs)
+	if got[0] != baseURLs[0] || got[1] != baseURLs[1] {
+		t.Fatalf("Expected the default mirrors order without timing data, got: '%v'", got)
+	}
+
+	// Probing the first mirror only does not reorder anything
+	getResolvingURLs(context.Background(), nil, []string{baseURLs[0] + "/linux/linux-headers.deb"})
+	got = mirrorLatencies.sortMirrors(baseURLs)
+	if got[0] != baseURLs[0] || got[1] != baseURLs[1] {
+		t.Fatalf("Expected the default mirrors order with partial timing data, got: '%v'", got)
+	}
+
+	getResolvingURLs(context.Background(), nil, []string{baseURLs[1] + "/linux/linux-headers.deb"})
+	got = mirrorLatencies.sortMirrors(baseURLs)
+	if got[0] != baseURLs[1] || got[1] != baseURLs[0] {
+		t.Fatalf("Expected the faster mirror to be tried first, got: '%v'", got)
+	}
+}
@@ -0,0 +1,101 @@
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// NamingStrategy is the scheme of the artifact names expected by the driver loaders consuming them.
+type NamingStrategy string
+
+const (
+	// NamingStrategyLegacy is the scheme of the driver loaders predating the multi-arch support,
+	// not expecting the architecture directory.
+	//
+	// Example: master/falco_ubuntu_5.15.0-52-generic_58.ko
+	NamingStrategyLegacy NamingStrategy = "legacy"
+	// NamingStrategyCurrent is the scheme of the current driver loaders, and the default one.
+	//
+	// Example: master/x86_64/falco_ubuntu_5.15.0-52-generic_58.ko
+	NamingStrategyCurrent NamingStrategy = "current"
+)
+
+func (s NamingStrategy) String() string {
+	return string(s)
+}
+
+// ParseOutputNameTemplate parses the template of the base name of the artifacts,
+// rendering it against the template data of a sample build too, not to fail once the build started.
+// Example: falco_{{ .Target }}_{{ .KernelRelease }}_{{ .Arch }}
+func ParseOutputNameTemplate(text string) (*template.Template, error) {
+	t, err := template.New("output-name").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := &Build{
+		TargetType:       TargetTypeUbuntu,
+		KernelRelease:    "5.15.0-52-generic",
+		KernelVersion:    "58",
+		Architecture:     kernelrelease.ArchitectureAmd64,
+		DriverVersion:    "master",
+		ModuleDriverName: "falco",
+	}
+	if _, err := renderOutputName(t, sample.outputNameData()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ApplyOutputNameTemplate renames the output module and probe of b after its output name template, if any,
+// keeping their directories and extensions.
+func (b *Build) ApplyOutputNameTemplate() error {
+	if len(b.OutputNameTemplate) == 0 {
+		return nil
+	}
+	t, err := ParseOutputNameTemplate(b.OutputNameTemplate)
+	if err != nil {
+		return err
+	}
+	name, err := renderOutputName(t, b.outputNameData())
+	if err != nil {
+		return err
+	}
+	for _, p := range []*string{&b.ModuleFilePath, &b.ProbeFilePath} {
+		if len(*p) > 0 {
+			*p = filepath.Join(filepath.Dir(*p), name+filepath.Ext(*p))
+		}
+	}
+	return nil
+}
+
+// outputNameData returns the template data the output name template of b gets rendered against:
+// only the fields identifying the build are set, not the ones depending on its resolution (e.g. GCCVersion).
+func (b *Build) outputNameData() commonTemplateData {
+	return commonTemplateData{
+		ModuleDriverName: b.ModuleDriverName,
+		DriverVersion:    b.DriverVersion,
+		BuildCommit:      Config{Build: b}.buildCommit(),
+		VermagicSuffix:   b.VermagicSuffix,
+		Target:           b.TargetType.String(),
+		KernelRelease:    b.KernelRelease,
+		KernelVersion:    b.KernelVersion,
+		Arch:             kernelrelease.SupportedArchs[kernelrelease.ParseArchitecture(b.Architecture)],
+	}
+}
+
+// renderOutputName renders t against data, failing when the result is not a base name.
+func renderOutputName(t *template.Template, data commonTemplateData) (string, error) {
+	var buf strings.Builder
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	name := buf.String()
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+		return "", fmt.Errorf("invalid output name %q, expected the base name of a file", name)
+	}
+	return name, nil
+}
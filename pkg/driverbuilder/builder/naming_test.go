@@ -0,0 +1,49 @@
+package builder
+
+import (
+	"testing"
+)
+
+func TestApplyOutputNameTemplate(t *testing.T) {
+	b := &Build{
+		TargetType:         TargetTypeUbuntu,
+		KernelRelease:      "5.15.0-52-generic",
+		KernelVersion:      "58",
+		Architecture:       "arm64",
+		DriverVersion:      "5.0.1+driver",
+		ModuleDriverName:   "falco",
+		ModuleFilePath:     "/tmp/out/module.ko",
+		ProbeFilePath:      "probe.o",
+		OutputNameTemplate: "{{ .ModuleDriverName }}_{{ .Target }}_{{ .KernelRelease }}_{{ .Arch }}_{{ .DriverVersion }}",
+	}
+	if err := b.ApplyOutputNameTemplate(); err != nil {
+		t.Fatal(err)
+	}
+	if b.ModuleFilePath != "/tmp/out/falco_ubuntu_5.15.0-52-generic_aarch64_5.0.1+driver.ko" {
+		t.Errorf("Unexpected module path: %s", b.ModuleFilePath)
+	}
+	if b.ProbeFilePath != "falco_ubuntu_5.15.0-52-generic_aarch64_5.0.1+driver.o" {
+		t.Errorf("Unexpected probe path: %s", b.ProbeFilePath)
+	}
+
+	// the default keeps the output paths as they are
+	b = &Build{ModuleFilePath: "/tmp/out/module.ko"}
+	if err := b.ApplyOutputNameTemplate(); err != nil || b.ModuleFilePath != "/tmp/out/module.ko" {
+		t.Errorf("Expected the module path to be kept, got %s (%v)", b.ModuleFilePath, err)
+	}
+}
+
+func TestParseOutputNameTemplate(t *testing.T) {
+	for text, valid := range map[string]bool{
+		"falco_{{ .Target }}_{{ .KernelRelease }}_{{ .Arch }}": true,
+		"{{ .KernelRelease }}-{{ .KernelVersion }}":            true,
+		"falco_{{ .Target ":                  false,
+		"falco_{{ .Flavor }}":                false,
+		"{{ .Target }}/{{ .KernelRelease }}": false,
+		"":                                   false,
+	} {
+		if _, err := ParseOutputNameTemplate(text); (err == nil) != valid {
+			t.Errorf("Template %q: expected valid %t, got %v", text, valid, err)
+		}
+	}
+}
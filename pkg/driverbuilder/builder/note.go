@@ -0,0 +1,113 @@
+package builder
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/version"
+)
+
+// ModuleNoteSection is the ELF section of the kernel modules holding the metadata of their build.
+const ModuleNoteSection = ".note.driverkit"
+
+const (
+	moduleNoteName = "driverkit"
+	moduleNoteType = 1
+)
+
+// ModuleNote is the metadata of the build of a kernel module, embedded into it as an ELF note
+// whose description lists "key=value" lines.
+type ModuleNote struct {
+	DriverkitVersion string
+	GCCVersion       string
+	KernelPackages   []string
+}
+
+// newModuleNote returns the note of the module built by c from the given kernel urls.
+func newModuleNote(c Config, urls []string) ModuleNote {
+	n := ModuleNote{
+		DriverkitVersion: version.String(),
+		GCCVersion:       c.GCCVersion,
+	}
+	for _, u := range urls {
+		n.KernelPackages = append(n.KernelPackages, path.Base(u))
+	}
+	return n
+}
+
+// marshal encodes the note with the layout of the ELF notes (little endian, 4 bytes aligned).
+func (n ModuleNote) marshal() []byte {
+	var desc bytes.Buffer
+	fmt.Fprintf(&desc, "driverkit=%s\n", n.DriverkitVersion)
+	fmt.Fprintf(&desc, "gcc=%s\n", n.GCCVersion)
+	for _, p := range n.KernelPackages {
+		fmt.Fprintf(&desc, "package=%s\n", p)
+	}
+
+	name := moduleNoteName + "\x00"
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(len(name)))
+	binary.Write(&buf, binary.LittleEndian, uint32(desc.Len()))
+	binary.Write(&buf, binary.LittleEndian, uint32(moduleNoteType))
+	buf.WriteString(name)
+	buf.Write(make([]byte, notePadding(len(name))))
+	buf.Write(desc.Bytes())
+	buf.Write(make([]byte, notePadding(desc.Len())))
+	return buf.Bytes()
+}
+
+// base64 returns the encoded note, for the templates to write it.
+func (n ModuleNote) base64() string {
+	return base64.StdEncoding.EncodeToString(n.marshal())
+}
+
+func notePadding(size int) int {
+	return (4 - size%4) % 4
+}
+
+// ParseModuleNote reads the build metadata from the note section of the given kernel module.
+func ParseModuleNote(f *elf.File) (*ModuleNote, error) {
+	section := f.Section(ModuleNoteSection)
+	if section == nil {
+		return nil, fmt.Errorf("no %s section", ModuleNoteSection)
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 {
+		return nil, fmt.Errorf("truncated %s section", ModuleNoteSection)
+	}
+	nameSize := int(f.ByteOrder.Uint32(data[0:4]))
+	descSize := int(f.ByteOrder.Uint32(data[4:8]))
+	noteType := f.ByteOrder.Uint32(data[8:12])
+	descStart := 12 + nameSize + notePadding(nameSize)
+	if descStart+descSize > len(data) {
+		return nil, fmt.Errorf("truncated %s section", ModuleNoteSection)
+	}
+	if name := strings.TrimRight(string(data[12:12+nameSize]), "\x00"); name != moduleNoteName || noteType != moduleNoteType {
+		return nil, fmt.Errorf("unexpected note %s (type %d)", name, noteType)
+	}
+
+	n := &ModuleNote{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data[descStart:descStart+descSize])), "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed note line: %q", line)
+		}
+		switch key {
+		case "driverkit":
+			n.DriverkitVersion = value
+		case "gcc":
+			n.GCCVersion = value
+		case "package":
+			n.KernelPackages = append(n.KernelPackages, value)
+		}
+	}
+	return n, nil
+}
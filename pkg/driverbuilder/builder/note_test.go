@@ -0,0 +1,98 @@
+package builder
+
+import (
+	"debug/elf"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestModuleNote(t *testing.T) {
+	if _, err := exec.LookPath("objcopy"); err != nil {
+		t.Skip("objcopy not available")
+	}
+
+	kr := kernelrelease.FromString("5.15.0-1004-intel-iotg")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	urls := []string{
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb",
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb",
+	}
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelRelease = kr.String()
+	c.KernelVersion = "6"
+
+	script, err := renderScript(&ubuntu{}, c, kr, urls)
+	if err != nil {
+		t.Fatalf("Unexpected error rendering the template: %s", err)
+	}
+	start := strings.Index(script, "# Embed the build metadata into the kernel module")
+	if start < 0 {
+		t.Fatalf("Rendered template does not embed the build metadata:\n%s", script)
+	}
+	end := strings.Index(script[start:], "\nstrip ")
+	snippet := script[start : start+end]
+
+	// run the snippet against a fixture ELF standing for the kernel module
+	dir := t.TempDir()
+	module := filepath.Join(dir, "falco.ko")
+	copyFixtureELF(t, module)
+	snippet = strings.ReplaceAll(snippet, DefaultContainerWorkDir+"/driverkit.note", filepath.Join(dir, "driverkit.note"))
+	snippet = strings.ReplaceAll(snippet, ModuleFullPath, module)
+	if out, err := exec.Command("/bin/bash", "-euo", "pipefail", "-c", snippet).CombinedOutput(); err != nil {
+		t.Fatalf("Unexpected error embedding the note: %s: %s", err, out)
+	}
+
+	f, err := elf.Open(module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if s := f.Section(ModuleNoteSection); s == nil || s.Type != elf.SHT_NOTE {
+		t.Fatalf("Expected a %s note section, got: %v", ModuleNoteSection, s)
+	}
+	note, err := ParseModuleNote(f)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing the note: %s", err)
+	}
+	if note.GCCVersion != c.GCCVersion || len(note.GCCVersion) == 0 {
+		t.Errorf("Unexpected gcc version: %s", note.GCCVersion)
+	}
+	if len(note.DriverkitVersion) == 0 {
+		t.Error("Expected the driverkit version")
+	}
+	want := []string{
+		"linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb",
+		"linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb",
+	}
+	if strings.Join(note.KernelPackages, ",") != strings.Join(want, ",") {
+		t.Errorf("Unexpected kernel packages! Got: '%v' / Want: '%v'", note.KernelPackages, want)
+	}
+}
+
+// copyFixtureELF copies the running test binary, an ELF file, to dst.
+func copyFixtureELF(t *testing.T, dst string) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"strings"
@@ -8,8 +9,8 @@ import (
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
-//go:embed templates/opensuse.sh
-var opensuseTemplate string
+//go:embed templates/opensuse.sh.gz
+var opensuseTemplate []byte
 
 // TargetTypeOpenSUSE identifies the OpenSUSE target.
 const TargetTypeOpenSUSE Type = "opensuse"
@@ -67,11 +68,15 @@ func (o *opensuse) Name() string {
 	return TargetTypeOpenSUSE.String()
 }
 
+func (o *opensuse) SupportedArchitectures() []kernelrelease.Architecture {
+	return DefaultArchitectures()
+}
+
 func (o *opensuse) TemplateScript() string {
-	return opensuseTemplate
+	return decompressTemplate(opensuseTemplate)
 }
 
-func (o *opensuse) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (o *opensuse) URLs(ctx context.Context, c Config, kr kernelrelease.KernelRelease) ([]string, error) {
 
 	// SUSE requires 2 urls: a kernel-default-devel*{arch}.rpm and a kernel-devel*noarch.rpm
 	kernelDefaultDevelPattern := fmt.Sprintf("kernel-default-devel-%s%s.rpm", kr.Fullversion, kr.FullExtraversion)
@@ -85,7 +90,7 @@ func (o *opensuse) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, err
 	possibleURLs := buildURLs(kr, kernelDefaultDevelPattern, kernelDevelNoArchPattern)
 
 	// trim the list to only resolving URLs
-	urls, err := getResolvingURLs(possibleURLs)
+	urls, err := getResolvingURLs(ctx, c.Build, possibleURLs)
 	if err != nil {
 		return nil, err
 	}
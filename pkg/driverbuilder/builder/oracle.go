@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"strings"
@@ -8,8 +9,8 @@ import (
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
-//go:embed templates/oracle.sh
-var oracleTemplate string
+//go:embed templates/oracle.sh.gz
+var oracleTemplate []byte
 
 // TargetTypeoracle identifies the oracle target ("ol" is the ID from /etc/os-release that Oracle uses)
 const TargetTypeoracle Type = "ol"
@@ -31,11 +32,15 @@ func (c *oracle) Name() string {
 	return TargetTypeoracle.String()
 }
 
+func (c *oracle) SupportedArchitectures() []kernelrelease.Architecture {
+	return []kernelrelease.Architecture{kernelrelease.ArchitectureAmd64, kernelrelease.ArchitectureArm64}
+}
+
 func (c *oracle) TemplateScript() string {
-	return oracleTemplate
+	return decompressTemplate(oracleTemplate)
 }
 
-func (c *oracle) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (c *oracle) URLs(_ context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
 
 	// oracle FullExtraversion looks like "-2047.510.5.5.el7uek.x86_64"
 	// need to get the "el7uek" out of the middle
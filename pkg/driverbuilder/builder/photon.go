@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
@@ -9,8 +10,8 @@ import (
 // TargetTypePhoton identifies the Photon target.
 const TargetTypePhoton Type = "photon"
 
-//go:embed templates/photonos.sh
-var photonTemplate string
+//go:embed templates/photonos.sh.gz
+var photonTemplate []byte
 
 func init() {
 	BuilderByTarget[TargetTypePhoton] = &photon{}
@@ -29,11 +30,15 @@ func (p *photon) Name() string {
 	return TargetTypePhoton.String()
 }
 
+func (p *photon) SupportedArchitectures() []kernelrelease.Architecture {
+	return []kernelrelease.Architecture{kernelrelease.ArchitectureAmd64}
+}
+
 func (p *photon) TemplateScript() string {
-	return photonTemplate
+	return decompressTemplate(photonTemplate)
 }
 
-func (p *photon) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (p *photon) URLs(_ context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
 	return fetchPhotonKernelURLS(kr), nil
 }
 
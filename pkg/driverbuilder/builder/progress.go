@@ -0,0 +1,32 @@
+package builder
+
+import "fmt"
+
+// Phase is a phase of a build, as reported to its ProgressFn.
+type Phase string
+
+const (
+	// PhaseResolution is the resolution of the kernel headers urls.
+	PhaseResolution Phase = "resolution"
+	// PhaseDownload is the download of the kernel headers packages.
+	PhaseDownload Phase = "download"
+	// PhaseCompile is the compilation of the drivers.
+	PhaseCompile Phase = "compile"
+	// PhaseDone is the end of a successful build.
+	PhaseDone Phase = "done"
+)
+
+// Event is the progress of a build, reported to its ProgressFn.
+type Event struct {
+	Phase Phase
+	// Message describes the progress in a human-readable way, e.g. "downloading headers (2/2)".
+	Message string
+}
+
+// Progress reports the progress of b to its ProgressFn, if any.
+func (b *Build) Progress(phase Phase, format string, args ...interface{}) {
+	if b == nil || b.ProgressFn == nil {
+		return
+	}
+	b.ProgressFn(Event{Phase: phase, Message: fmt.Sprintf(format, args...)})
+}
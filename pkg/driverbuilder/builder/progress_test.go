@@ -0,0 +1,46 @@
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestKernelURLsProgress(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(filepath.Base(r.URL.Path)))
+	}))
+	defer mirror.Close()
+
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelRelease = "5.15.0-52-generic"
+	c.KernelVersion = "58"
+	c.Checksums = make(map[string]string)
+	for _, name := range []string{"linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb", "linux-headers-5.15.0-52_5.15.0-52.58_all.deb"} {
+		c.KernelUrls = append(c.KernelUrls, mirror.URL+"/linux/"+name)
+		c.Checksums[name] = fmt.Sprintf("%x", sha256.Sum256([]byte(name)))
+	}
+	var events []Event
+	c.ProgressFn = func(e Event) { events = append(events, e) }
+
+	if _, err := KernelURLs(context.Background(), BuilderByTarget[TargetTypeUbuntu], c, kr); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Event{
+		{PhaseResolution, "resolving URLs"},
+		{PhaseDownload, "downloading headers (1/2)"},
+		{PhaseDownload, "downloading headers (2/2)"},
+		{PhaseResolution, "resolved 2 headers packages"},
+	}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Errorf("Expected the events %v, got %v", expected, events)
+	}
+}
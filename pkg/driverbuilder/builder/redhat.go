@@ -1,12 +1,13 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
-//go:embed templates/redhat.sh
-var redhatTemplate string
+//go:embed templates/redhat.sh.gz
+var redhatTemplate []byte
 
 // TargetTypeRedhat identifies the redhat target.
 const TargetTypeRedhat Type = "redhat"
@@ -28,11 +29,15 @@ func (v *redhat) Name() string {
 	return TargetTypeRedhat.String()
 }
 
+func (v *redhat) SupportedArchitectures() []kernelrelease.Architecture {
+	return DefaultArchitectures()
+}
+
 func (v *redhat) TemplateScript() string {
-	return redhatTemplate
+	return decompressTemplate(redhatTemplate)
 }
 
-func (v *redhat) URLs(_ Config, _ kernelrelease.KernelRelease) ([]string, error) {
+func (v *redhat) URLs(_ context.Context, _ Config, _ kernelrelease.KernelRelease) ([]string, error) {
 	return nil, nil
 }
 
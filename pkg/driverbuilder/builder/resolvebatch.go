@@ -0,0 +1,96 @@
+package builder
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// BatchKernel is one of the kernels of a batch.
+type BatchKernel struct {
+	KernelRelease kernelrelease.KernelRelease
+	// KernelVersion is the version of the kernel release, e.g. the "58" of Ubuntu's 5.15.0-52-generic,
+	// the one of the build when not set.
+	KernelVersion string
+}
+
+// BatchResult is the outcome of the resolution of the kernel headers urls of one of the kernels of a batch.
+type BatchResult struct {
+	KernelRelease kernelrelease.KernelRelease
+	KernelVersion string
+	URLs          []string
+	Err           error
+	// Probes are the candidate urls probed while resolving the headers of the kernel.
+	Probes   []URLProbe
+	Duration time.Duration
+}
+
+// ResolveURLsBatch resolves the urls of the kernel headers packages of each one of the given kernels,
+// as ResolveURLs would for a build of c for each one of them, e.g. to check the buildability of a whole series of kernels.
+// Up to the resolve parallelism of the build kernels are resolved at once, all of them sending their requests
+// through the same URLResolver: the one of the build if any, otherwise a new one.
+// The resolve parallelism bounds the candidate urls probed at the same time by the whole batch too.
+// The kernel releases without an architecture get the one of the build.
+// The results are in the order of kernels, one for each one of them.
+func ResolveURLsBatch(ctx context.Context, target Type, c Config, kernels []BatchKernel) []BatchResult {
+	results := make([]BatchResult, len(kernels))
+	for i, k := range kernels {
+		if len(k.KernelRelease.Architecture) == 0 {
+			k.KernelRelease.Architecture = kernelrelease.ParseArchitecture(c.Build.Architecture)
+		}
+		if len(k.KernelVersion) == 0 {
+			k.KernelVersion = c.Build.KernelVersion
+		}
+		results[i].KernelRelease = k.KernelRelease
+		results[i].KernelVersion = k.KernelVersion
+	}
+
+	b, err := BuilderForTarget(target)
+	if err == nil && c.URLResolver == nil {
+		var resolver *URLResolver
+		if resolver, err = NewURLResolver(c.TransportSettings()); err == nil {
+			build := *c.Build
+			build.URLResolver = resolver
+			c.Build = &build
+		}
+	}
+	if err != nil {
+		for i := range results {
+			results[i].Err = err
+		}
+		return results
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, c.resolveParallelism())
+	probeSlots := make(chan struct{}, c.resolveParallelism())
+	for i := range results {
+		wg.Add(1)
+		go func(r *BatchResult) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			// each kernel records its own probes, logging with its own kernel release
+			build := *c.Build
+			build.KernelRelease = r.KernelRelease.Fullversion + r.KernelRelease.FullExtraversion
+			build.KernelVersion = r.KernelVersion
+			build.Architecture = r.KernelRelease.Architecture.String()
+			build.URLProbes = nil
+			build.batchSlots = probeSlots
+			kc := c
+			kc.Build = &build
+
+			start := time.Now()
+			if r.Err = ctx.Err(); r.Err == nil {
+				r.URLs, r.Err = KernelURLs(ctx, b, kc, r.KernelRelease)
+			}
+			r.Duration = time.Since(start)
+			r.Probes = build.URLProbes
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,59 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// resolverRequest is the build key POSTed to the resolver endpoint.
+type resolverRequest struct {
+	Target        string `json:"target"`
+	KernelRelease string `json:"kernelrelease"`
+	KernelVersion string `json:"kernelversion"`
+	Architecture  string `json:"architecture"`
+}
+
+// resolverResponse is the answer of the resolver endpoint, listing the kernel headers urls for the build key.
+type resolverResponse struct {
+	URLs []string `json:"urls"`
+}
+
+// resolverEndpointURLs asks the resolver endpoint of the build for the kernel headers urls,
+// returning the resolving ones.
+func resolverEndpointURLs(ctx context.Context, b *Build) ([]string, error) {
+	body, err := json.Marshal(resolverRequest{
+		Target:        b.TargetType.String(),
+		KernelRelease: b.KernelRelease,
+		KernelVersion: b.KernelVersion,
+		Architecture:  b.Architecture,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.ResolverEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := b.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("resolver endpoint answered with status %d", res.StatusCode)
+	}
+
+	var resolved resolverResponse
+	if err := json.NewDecoder(res.Body).Decode(&resolved); err != nil {
+		return nil, fmt.Errorf("invalid resolver endpoint response: %w", err)
+	}
+	if len(resolved.URLs) == 0 {
+		return nil, fmt.Errorf("resolver endpoint returned no urls")
+	}
+	return getResolvingURLs(ctx, b, resolved.URLs)
+}
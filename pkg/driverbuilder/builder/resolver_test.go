@@ -0,0 +1,88 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestResolverEndpoint(t *testing.T) {
+	mirror := newUbuntuFixtureMirror(
+		"/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb",
+		"/pool/main/l/linux/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb",
+	)
+	defer mirror.Close()
+
+	var got resolverRequest
+	resolver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("expected a POST request, got %s", r.Method)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		_ = json.NewEncoder(w).Encode(resolverResponse{URLs: []string{
+			mirror.URL + "/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb",
+			mirror.URL + "/pool/main/l/linux/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb",
+			mirror.URL + "/pool/main/l/linux/missing.deb",
+		}})
+	}))
+	defer resolver.Close()
+
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelRelease = "5.15.0-1004-intel-iotg"
+	c.KernelVersion = "6"
+	c.Architecture = kernelrelease.ArchitectureAmd64
+	c.ResolverEndpoint = resolver.URL
+	kr := c.KernelReleaseFromBuildConfig()
+
+	script, err := Script(context.Background(), &ubuntu{}, c, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRequest := resolverRequest{
+		Target:        "ubuntu",
+		KernelRelease: "5.15.0-1004-intel-iotg",
+		KernelVersion: "6",
+		Architecture:  kernelrelease.ArchitectureAmd64,
+	}
+	if got != expectedRequest {
+		t.Fatalf("expected the resolver to receive %v, got %v", expectedRequest, got)
+	}
+	for _, e := range []string{
+		"curl --silent -o kernel.deb -SL " + mirror.URL + "/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb\n",
+		"curl --silent -o kernel.deb -SL " + mirror.URL + "/pool/main/l/linux/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb\n",
+	} {
+		if !strings.Contains(script, e) {
+			t.Errorf("expected the script to use the resolver urls with %q, got:\n%s", e, script)
+		}
+	}
+	if strings.Contains(script, "missing.deb") {
+		t.Errorf("expected the script not to use the non-resolving urls, got:\n%s", script)
+	}
+}
+
+func TestResolverEndpointStrict(t *testing.T) {
+	resolver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer resolver.Close()
+
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelRelease = "5.15.0-1004-intel-iotg"
+	c.KernelVersion = "6"
+	c.Architecture = kernelrelease.ArchitectureAmd64
+	c.ResolverEndpoint = resolver.URL
+	c.ResolverStrict = true
+
+	_, err := Script(context.Background(), &ubuntu{}, c, c.KernelReleaseFromBuildConfig())
+	if err == nil || !strings.Contains(err.Error(), "status 503") {
+		t.Fatalf("expected the resolver endpoint error, got: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// urlRewriteSeparator separates the pattern of a rewrite rule from its replacement.
+const urlRewriteSeparator = "=>"
+
+// URLRewriteRule rewrites the candidate kernel headers urls matching Pattern,
+// expanding Replacement as regexp.Regexp.ReplaceAllString does (e.g. $1 is the first submatch).
+type URLRewriteRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// ParseURLRewriteRule parses a "pattern=>replacement" rewrite rule.
+// Example: ^https://mirrors.edge.kernel.org/=>https://cdn.example.com/kernel.org/
+func ParseURLRewriteRule(rule string) (URLRewriteRule, error) {
+	i := strings.LastIndex(rule, urlRewriteSeparator)
+	if i <= 0 {
+		return URLRewriteRule{}, fmt.Errorf("invalid url rewrite rule %q, expected pattern%sreplacement", rule, urlRewriteSeparator)
+	}
+	pattern, err := regexp.Compile(rule[:i])
+	if err != nil {
+		return URLRewriteRule{}, err
+	}
+	return URLRewriteRule{Pattern: pattern, Replacement: rule[i+len(urlRewriteSeparator):]}, nil
+}
+
+// rewriteURLs applies the given rules, in order, to each one of urls.
+func rewriteURLs(rules []URLRewriteRule, urls []string) []string {
+	if len(rules) == 0 {
+		return urls
+	}
+	res := make([]string, len(urls))
+	for i, u := range urls {
+		res[i] = u
+		for _, r := range rules {
+			res[i] = r.Pattern.ReplaceAllString(res[i], r.Replacement)
+		}
+		if res[i] != u {
+			logger.WithField("url", RedactURL(u)).WithField("rewritten", RedactURL(res[i])).Debug("kernel header url rewritten")
+		}
+	}
+	return res
+}
@@ -1,13 +1,14 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
-//go:embed templates/rocky.sh
-var rockyTemplate string
+//go:embed templates/rocky.sh.gz
+var rockyTemplate []byte
 
 // TargetTypeRocky identifies the Rocky target.
 const TargetTypeRocky Type = "rocky"
@@ -29,11 +30,15 @@ func (c *rocky) Name() string {
 	return TargetTypeRocky.String()
 }
 
+func (c *rocky) SupportedArchitectures() []kernelrelease.Architecture {
+	return DefaultArchitectures()
+}
+
 func (c *rocky) TemplateScript() string {
-	return rockyTemplate
+	return decompressTemplate(rockyTemplate)
 }
 
-func (c *rocky) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (c *rocky) URLs(_ context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
 	return fetchRockyKernelURLS(kr), nil
 }
 
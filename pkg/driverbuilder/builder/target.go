@@ -1,8 +1,16 @@
 package builder
 
+import (
+	"fmt"
+	"sort"
+)
+
 // BuilderByTarget maps targets to their builder.
 var BuilderByTarget = Targets{}
 
+// OverrideBuilders lets RegisterBuilder replace the builders of the targets already registered, built-in ones included.
+var OverrideBuilders bool
+
 // Type is a type representing targets.
 type Type string
 
@@ -21,3 +29,27 @@ func (t Targets) Targets() []string {
 	}
 	return res
 }
+
+// RegisterBuilder registers the builder of a custom target, e.g. a proprietary distribution,
+// failing when the target is already registered, unless OverrideBuilders is set.
+// It is meant to be called at init, before any build starts.
+func RegisterBuilder(t Type, b Builder) error {
+	if len(t) == 0 || b == nil {
+		return fmt.Errorf("a target and its builder are required")
+	}
+	if _, ok := BuilderByTarget[t]; ok && !OverrideBuilders {
+		return fmt.Errorf("target already registered: %s", t)
+	}
+	BuilderByTarget[t] = b
+	return nil
+}
+
+// Builders returns the registered targets, sorted.
+func Builders() []Type {
+	res := make([]Type, 0, len(BuilderByTarget))
+	for t := range BuilderByTarget {
+		res = append(res, t)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
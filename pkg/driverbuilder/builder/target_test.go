@@ -0,0 +1,114 @@
+package builder
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+type acmeBuilder struct{}
+
+func (a *acmeBuilder) Name() string { return "acme" }
+
+func (a *acmeBuilder) TemplateScript() string { return "echo acme" }
+
+func (a *acmeBuilder) URLs(_ context.Context, _ Config, _ kernelrelease.KernelRelease) ([]string, error) {
+	return []string{"https://acme.example.com/kernel-headers.rpm"}, nil
+}
+
+func (a *acmeBuilder) TemplateData(_ Config, _ kernelrelease.KernelRelease, _ []string) interface{} {
+	return nil
+}
+
+func (a *acmeBuilder) SupportedArchitectures() []kernelrelease.Architecture {
+	return []kernelrelease.Architecture{kernelrelease.ArchitectureAmd64}
+}
+
+func TestRegisterBuilder(t *testing.T) {
+	const acme Type = "acme"
+	defer delete(BuilderByTarget, acme)
+
+	if err := RegisterBuilder(acme, &acmeBuilder{}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if b, err := BuilderForTarget(acme); err != nil || b.Name() != "acme" {
+		t.Errorf("Expected the acme builder, got %v (%v)", b, err)
+	}
+	targets := Builders()
+	if !sort.SliceIsSorted(targets, func(i, j int) bool { return targets[i] < targets[j] }) {
+		t.Errorf("Expected the targets to be sorted, got %v", targets)
+	}
+	var found bool
+	for _, target := range targets {
+		found = found || target == acme
+	}
+	if !found {
+		t.Errorf("Expected acme among the targets, got %v", targets)
+	}
+
+	// the registered targets are not overwritten by accident
+	ubuntu := BuilderByTarget[TargetTypeUbuntu]
+	if err := RegisterBuilder(TargetTypeUbuntu, &acmeBuilder{}); err == nil {
+		t.Errorf("Expected an error overwriting a built-in target")
+	}
+	if err := RegisterBuilder(acme, &acmeBuilder{}); err == nil {
+		t.Errorf("Expected an error registering a target twice")
+	}
+
+	// unless explicitly allowed
+	OverrideBuilders = true
+	defer func() {
+		OverrideBuilders = false
+		BuilderByTarget[TargetTypeUbuntu] = ubuntu
+	}()
+	if err := RegisterBuilder(TargetTypeUbuntu, &acmeBuilder{}); err != nil || BuilderByTarget[TargetTypeUbuntu].Name() != "acme" {
+		t.Errorf("Expected the ubuntu builder to be overridden, got %v", err)
+	}
+}
+
+func TestSupportedArchitectures(t *testing.T) {
+	supported := map[kernelrelease.Architecture]bool{}
+	for _, a := range DefaultArchitectures() {
+		supported[a] = true
+	}
+	if len(supported)+len(crossCompiledArchs) != len(kernelrelease.SupportedArchs) {
+		t.Fatalf("Expected the default architectures to be all the natively built ones, got %v", DefaultArchitectures())
+	}
+	for a := range crossCompiledArchs {
+		supported[a] = true
+	}
+	for target, b := range BuilderByTarget {
+		archs := b.SupportedArchitectures()
+		if len(archs) == 0 {
+			t.Errorf("Expected target %s to support some architecture", target)
+		}
+		for _, a := range archs {
+			if !supported[a] {
+				t.Errorf("Target %s supports the unknown architecture %s", target, a)
+			}
+		}
+	}
+
+	if err := CheckArchitecture(BuilderByTarget[TargetTypeUbuntu], kernelrelease.ArchitectureS390x); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if err := CheckArchitecture(BuilderByTarget[TargetTypeUbuntu], kernelrelease.ArchitectureRiscv64); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if err := CheckArchitecture(BuilderByTarget[TargetTypeDebian], kernelrelease.ArchitectureRiscv64); err == nil {
+		t.Errorf("Expected the cross-compiled riscv64 to be supported by the ubuntu target only")
+	}
+	err := CheckArchitecture(BuilderByTarget[TargetTypePhoton], kernelrelease.ArchitectureArm64)
+	if err == nil || err.Error() != "target photon does not support architecture arm64, supported ones: amd64" {
+		t.Errorf("Expected photon not to support arm64, got %v", err)
+	}
+
+	// rejected before resolving anything
+	kr := kernelrelease.FromString("5.10.0")
+	kr.Architecture = kernelrelease.ArchitectureArm64
+	if _, err := KernelURLs(context.Background(), &acmeBuilder{}, newTestConfig("acme"), kr); err == nil {
+		t.Errorf("Expected an unsupported architecture to be rejected")
+	}
+}
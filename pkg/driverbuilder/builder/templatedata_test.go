@@ -0,0 +1,185 @@
+package builder
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// AssertTemplateDataComplete fails t when the script template of the given target
+// references fields missing from the data returned by the TemplateData of its builder.
+func AssertTemplateDataComplete(t *testing.T, target Type) {
+	t.Helper()
+	b, ok := BuilderByTarget[target]
+	if !ok {
+		t.Fatalf("Unknown target %s", target)
+	}
+	c := newTestConfig(target)
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	urls := []string{"https://example.com/kernel-headers"}
+
+	tmpl, err := parseScriptTemplate(b, c, urls)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing the %s template: %s", target, err)
+	}
+	td := b.TemplateData(c, kr, urls)
+	if tdErr, ok := td.(error); ok {
+		t.Fatalf("Unexpected error getting the %s template data: %s", target, tdErr)
+	}
+
+	w := &templateDataWalker{tmpl: tmpl, root: reflect.TypeOf(td), missing: map[string]bool{}, visited: map[string]bool{}}
+	w.walkTemplate(tmpl.Name(), w.root)
+	if len(w.missing) > 0 {
+		var missing []string
+		for field := range w.missing {
+			missing = append(missing, field)
+		}
+		sort.Strings(missing)
+		t.Errorf("The %s template references fields missing from its template data (%T): %s", target, td, strings.Join(missing, ", "))
+	}
+}
+
+// templateDataWalker follows the dot through the nodes of a template,
+// recording the referenced fields the data types do not have.
+type templateDataWalker struct {
+	tmpl    *template.Template
+	root    reflect.Type
+	missing map[string]bool
+	visited map[string]bool
+}
+
+// walkTemplate walks the named template, executed with dot of the given type.
+func (w *templateDataWalker) walkTemplate(name string, dot reflect.Type) {
+	key := name + "/" + typeName(dot)
+	if w.visited[key] {
+		return
+	}
+	w.visited[key] = true
+	t := w.tmpl.Lookup(name)
+	if t == nil || t.Tree == nil {
+		w.missing["template "+name] = true
+		return
+	}
+	w.walk(t.Tree.Root, dot)
+}
+
+func (w *templateDataWalker) walk(node parse.Node, dot reflect.Type) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			w.walk(child, dot)
+		}
+	case *parse.ActionNode:
+		w.pipe(n.Pipe, dot)
+	case *parse.IfNode:
+		w.pipe(n.Pipe, dot)
+		w.walk(n.List, dot)
+		w.walk(n.ElseList, dot)
+	case *parse.WithNode:
+		w.walk(n.List, w.pipe(n.Pipe, dot))
+		w.walk(n.ElseList, dot)
+	case *parse.RangeNode:
+		var elem reflect.Type
+		if t := w.pipe(n.Pipe, dot); t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		w.walk(n.List, elem)
+		w.walk(n.ElseList, dot)
+	case *parse.TemplateNode:
+		w.walkTemplate(n.Name, w.pipe(n.Pipe, dot))
+	}
+}
+
+// pipe checks the fields referenced by p, returning the type of its value when known.
+func (w *templateDataWalker) pipe(p *parse.PipeNode, dot reflect.Type) reflect.Type {
+	if p == nil {
+		return dot
+	}
+	var res reflect.Type
+	for _, cmd := range p.Cmds {
+		res = nil
+		for _, arg := range cmd.Args {
+			t := w.arg(arg, dot)
+			if len(cmd.Args) == 1 {
+				res = t
+			}
+		}
+	}
+	return res
+}
+
+func (w *templateDataWalker) arg(node parse.Node, dot reflect.Type) reflect.Type {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.StringNode:
+		return reflect.TypeOf("")
+	case *parse.FieldNode:
+		return w.field(dot, n.Ident)
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" {
+			return w.field(w.root, n.Ident[1:])
+		}
+	case *parse.ChainNode:
+		return w.field(w.arg(n.Node, dot), n.Field)
+	case *parse.PipeNode:
+		return w.pipe(n, dot)
+	}
+	return nil
+}
+
+// field resolves the chain of fields from t, recording the missing ones;
+// nil is returned when the type of the chain is unknown.
+func (w *templateDataWalker) field(t reflect.Type, chain []string) reflect.Type {
+	for i, name := range chain {
+		if t == nil {
+			return nil
+		}
+		if m, ok := t.MethodByName(name); ok {
+			t = m.Type.Out(0)
+			continue
+		}
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			// maps and interfaces are resolved at execution only
+			return nil
+		}
+		f, ok := t.FieldByName(name)
+		if !ok {
+			w.missing["."+strings.Join(chain[:i+1], ".")] = true
+			return nil
+		}
+		t = f.Type
+	}
+	return t
+}
+
+func typeName(t reflect.Type) string {
+	if t == nil {
+		return "?"
+	}
+	return t.String()
+}
+
+func TestAssertTemplateDataComplete(t *testing.T) {
+	AssertTemplateDataComplete(t, TargetTypeUbuntu)
+
+	// a template referencing a field its data lacks is caught
+	tmpl := template.Must(template.New("broken").Parse(`{{ .KernelDownloadURLS }}{{ template "nested" . }}{{ define "nested" }}{{ .KernelMissing }}{{ end }}`))
+	w := &templateDataWalker{tmpl: tmpl, root: reflect.TypeOf(ubuntuTemplateData{}), missing: map[string]bool{}, visited: map[string]bool{}}
+	w.walkTemplate(tmpl.Name(), w.root)
+	if !reflect.DeepEqual(w.missing, map[string]bool{".KernelMissing": true}) {
+		t.Errorf("Expected .KernelMissing to be missing, got %v", w.missing)
+	}
+}
@@ -1,39 +1,49 @@
 #!/bin/bash
-set -xeuo pipefail
+set -xeuo pipefail{{ template "ca_bundle" . }}
 
 rm -Rf {{ .DriverBuildDir }}
 mkdir {{ .DriverBuildDir }}
-rm -Rf /tmp/module-download
-mkdir -p /tmp/module-download
+rm -Rf {{ .ContainerWorkDir }}/module-download
+mkdir -p {{ .ContainerWorkDir }}/module-download
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
-mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+curl --silent{{ template "download_flags" $ }} -SL {{ .ModuleDownloadURL }} | tar -xzf - -C {{ .ContainerWorkDir }}/module-download
+mv {{ .ContainerWorkDir }}/module-download/*/driver/* {{ .DriverBuildDir }}
 
 cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
 bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 
 # Fetch the kernel
-mkdir /tmp/kernel-download
-cd /tmp/kernel-download
-curl --silent -o kernel-devel.rpm -SL {{ .KernelDownloadURL }}
+{{ template "stage" "download" }}
+mkdir {{ .ContainerWorkDir }}/kernel-download
+cd {{ .ContainerWorkDir }}/kernel-download
+{{- if .Streaming }}
+curl --silent{{ template "kernel_download_flags" $ }} -SL {{ .KernelDownloadURL }} | rpm2cpio - | cpio --extract --make-directories
+{{- else }}
+curl --silent{{ template "kernel_download_flags" $ }} -o kernel-devel.rpm -SL {{ .KernelDownloadURL }}
+{{- with checksum .KernelDownloadURL }}
+echo '{{ . }}  kernel-devel.rpm' | sha256sum --check --quiet -
+{{- end }}
 rpm2cpio kernel-devel.rpm | cpio --extract --make-directories
-rm -Rf /tmp/kernel
-mkdir -p /tmp/kernel
-mv usr/src/kernels/*/* /tmp/kernel
+{{- end }}
+rm -Rf {{ .ContainerWorkDir }}/kernel
+mkdir -p {{ .ContainerWorkDir }}/kernel
+mv usr/src/kernels/*/* {{ .ContainerWorkDir }}/kernel
 
-{{ if .BuildModule }}
+{{ if .BuildModule }}{{ template "artifact_begin" . }}
 # Build the module
 cd {{ .DriverBuildDir }}
-make CC=/usr/bin/gcc-{{ .GCCVersion }} KERNELDIR=/tmp/kernel
+make CC=/usr/bin/gcc-{{ .GCCVersion }} KERNELDIR={{ .ContainerWorkDir }}/kernel{{ template "module_make_flags" . }}
 mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
+{{ template "module_note" . }}
 strip -g {{ .ModuleFullPath }}
 # Print results
 modinfo {{ .ModuleFullPath }}
-{{ end }}
+{{ template "module_end" . }}{{ end }}
 
-{{ if .BuildProbe }}
+{{ if .BuildProbe }}{{ template "artifact_begin" . }}
 # Build the eBPF probe
 cd {{ .DriverBuildDir }}/bpf
-make KERNELDIR=/tmp/kernel
+make KERNELDIR={{ .ContainerWorkDir }}/kernel{{ template "probe_make_flags" . }}
 ls -l probe.o
-{{ end }}
\ No newline at end of file
+{{ template "probe_end" . }}{{ end }}
+{{- template "artifacts_wait" . }}
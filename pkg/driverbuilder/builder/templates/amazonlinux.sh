@@ -1,42 +1,52 @@
 #!/bin/bash
-set -xeuo pipefail
+set -xeuo pipefail{{ template "ca_bundle" . }}
 
 rm -Rf {{ .DriverBuildDir }}
 mkdir {{ .DriverBuildDir }}
-rm -Rf /tmp/module-download
-mkdir -p /tmp/module-download
+rm -Rf {{ .ContainerWorkDir }}/module-download
+mkdir -p {{ .ContainerWorkDir }}/module-download
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
-mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+curl --silent{{ template "download_flags" $ }} -SL {{ .ModuleDownloadURL }} | tar -xzf - -C {{ .ContainerWorkDir }}/module-download
+mv {{ .ContainerWorkDir }}/module-download/*/driver/* {{ .DriverBuildDir }}
 
 cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
 bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 
 # Fetch the kernel
-mkdir /tmp/kernel-download
-cd /tmp/kernel-download
+{{ template "stage" "download" }}
+mkdir {{ .ContainerWorkDir }}/kernel-download
+cd {{ .ContainerWorkDir }}/kernel-download
 {{ range $url := .KernelDownloadURLs }}
-curl --silent -o kernel.rpm -SL {{ $url }}
+{{- if $.Streaming }}
+curl --silent{{ template "kernel_download_flags" $ }} -SL {{ $url }} | rpm2cpio - | cpio --extract --make-directories
+{{- else }}
+curl --silent{{ template "kernel_download_flags" $ }} -o kernel.rpm -SL {{ $url }}
+{{- with checksum $url }}
+echo '{{ . }}  kernel.rpm' | sha256sum --check --quiet -
+{{- end }}
 rpm2cpio kernel.rpm | cpio --extract --make-directories
 rm -rf kernel.rpm
+{{- end }}
 {{ end }}
-rm -Rf /tmp/kernel
-mkdir -p /tmp/kernel
-mv usr/src/kernels/*/* /tmp/kernel
+rm -Rf {{ .ContainerWorkDir }}/kernel
+mkdir -p {{ .ContainerWorkDir }}/kernel
+mv usr/src/kernels/*/* {{ .ContainerWorkDir }}/kernel
 
-{{ if .BuildModule }}
+{{ if .BuildModule }}{{ template "artifact_begin" . }}
 # Build the kernel module
 cd {{ .DriverBuildDir }}
 
-make KERNELDIR=/tmp/kernel CC=/usr/bin/gcc-{{ .GCCVersion }} LD=/usr/bin/ld.bfd CROSS_COMPILE=""
+make KERNELDIR={{ .ContainerWorkDir }}/kernel CC=/usr/bin/gcc-{{ .GCCVersion }} LD=/usr/bin/ld.bfd CROSS_COMPILE=""{{ template "module_make_flags" . }}
 mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
+{{ template "module_note" . }}
 # Print results
 modinfo {{ .ModuleFullPath }}
-{{ end }}
+{{ template "module_end" . }}{{ end }}
 
-{{ if .BuildProbe }}
+{{ if .BuildProbe }}{{ template "artifact_begin" . }}
 # Build the eBPF probe
 cd {{ .DriverBuildDir }}/bpf
-make KERNELDIR=/tmp/kernel
+make KERNELDIR={{ .ContainerWorkDir }}/kernel{{ template "probe_make_flags" . }}
 ls -l probe.o
-{{ end }}
\ No newline at end of file
+{{ template "probe_end" . }}{{ end }}
+{{- template "artifacts_wait" . }}
@@ -1,27 +1,35 @@
 #!/bin/bash
-set -xeuo pipefail
+set -xeuo pipefail{{ template "ca_bundle" . }}
 
 rm -Rf {{ .DriverBuildDir }}
 mkdir {{ .DriverBuildDir }}
-rm -Rf /tmp/module-download
-mkdir -p /tmp/module-download
+rm -Rf {{ .ContainerWorkDir }}/module-download
+mkdir -p {{ .ContainerWorkDir }}/module-download
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
-mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+curl --silent{{ template "download_flags" $ }} -SL {{ .ModuleDownloadURL }} | tar -xzf - -C {{ .ContainerWorkDir }}/module-download
+mv {{ .ContainerWorkDir }}/module-download/*/driver/* {{ .DriverBuildDir }}
 
 cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
 bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 
 # Fetch the kernel
-mkdir /tmp/kernel-download
-cd /tmp/kernel-download
+{{ template "stage" "download" }}
+mkdir {{ .ContainerWorkDir }}/kernel-download
+cd {{ .ContainerWorkDir }}/kernel-download
 {{ range $url := .KernelDownloadURLS }}
-curl --silent -o kernel.deb -SL {{ $url }}
+{{- if $.Streaming }}
+curl --silent{{ template "kernel_download_flags" $ }} -SL {{ $url }} | bsdtar -xOf - 'data.tar.*' | bsdtar -xvf -
+{{- else }}
+curl --silent{{ template "kernel_download_flags" $ }} -o kernel.deb -SL {{ $url }}
+{{- with checksum $url }}
+echo '{{ . }}  kernel.deb' | sha256sum --check --quiet -
+{{- end }}
 ar x kernel.deb
 tar -xvf data.tar.xz
+{{- end }}
 {{ end }}
 
-cd /tmp/kernel-download/
+cd {{ .ContainerWorkDir }}/kernel-download/
 
 cp -r usr/* /usr
 cp -r lib/* /lib
@@ -29,19 +37,21 @@ cp -r lib/* /lib
 cd /usr/src
 sourcedir=$(find . -type d -name "{{ .KernelHeadersPattern }}" | head -n 1 | xargs readlink -f)
 
-{{ if .BuildModule }}
+{{ if .BuildModule }}{{ template "artifact_begin" . }}
 # Build the module
 cd {{ .DriverBuildDir }}
-make CC=/usr/bin/gcc-{{ .GCCVersion }} KERNELDIR=$sourcedir
+make CC=/usr/bin/gcc-{{ .GCCVersion }} KERNELDIR=$sourcedir{{ template "module_make_flags" . }}
 mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
+{{ template "module_note" . }}
 strip -g {{ .ModuleFullPath }}
 # Print results
 modinfo {{ .ModuleFullPath }}
-{{ end }}
+{{ template "module_end" . }}{{ end }}
 
-{{ if .BuildProbe }}
+{{ if .BuildProbe }}{{ template "artifact_begin" . }}
 # Build the eBPF probe
 cd {{ .DriverBuildDir }}/bpf
-make KERNELDIR=$sourcedir
+make KERNELDIR=$sourcedir{{ template "probe_make_flags" . }}
 ls -l probe.o
-{{ end }}
\ No newline at end of file
+{{ template "probe_end" . }}{{ end }}
+{{- template "artifacts_wait" . }}
@@ -1,46 +1,56 @@
 #!/bin/bash
-set -xeuo pipefail
+set -xeuo pipefail{{ template "ca_bundle" . }}
 
 rm -Rf {{ .DriverBuildDir }}
 mkdir {{ .DriverBuildDir }}
-rm -Rf /tmp/module-download
-mkdir -p /tmp/module-download
+rm -Rf {{ .ContainerWorkDir }}/module-download
+mkdir -p {{ .ContainerWorkDir }}/module-download
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
-mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+curl --silent{{ template "download_flags" $ }} -SL {{ .ModuleDownloadURL }} | tar -xzf - -C {{ .ContainerWorkDir }}/module-download
+mv {{ .ContainerWorkDir }}/module-download/*/driver/* {{ .DriverBuildDir }}
 
 cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
 bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 
 # Fetch the kernel
-mkdir /tmp/kernel-download
-cd /tmp/kernel-download
-curl --silent -SL {{ .KernelDownloadURL }} | tar -Jxf - -C /tmp/kernel-download
-rm -Rf /tmp/kernel
-mkdir -p /tmp/kernel
-mv /tmp/kernel-download/*/* /tmp/kernel
+{{ template "stage" "download" }}
+mkdir {{ .ContainerWorkDir }}/kernel-download
+cd {{ .ContainerWorkDir }}/kernel-download
+{{- with checksum .KernelDownloadURL }}
+curl --silent{{ template "kernel_download_flags" $ }} -o kernel.tar.xz -SL {{ $.KernelDownloadURL }}
+echo '{{ . }}  kernel.tar.xz' | sha256sum --check --quiet -
+tar -Jxf kernel.tar.xz -C {{ $.ContainerWorkDir }}/kernel-download
+rm -f kernel.tar.xz
+{{- else }}
+curl --silent{{ template "kernel_download_flags" $ }} -SL {{ .KernelDownloadURL }} | tar -Jxf - -C {{ .ContainerWorkDir }}/kernel-download
+{{- end }}
+rm -Rf {{ .ContainerWorkDir }}/kernel
+mkdir -p {{ .ContainerWorkDir }}/kernel
+mv {{ .ContainerWorkDir }}/kernel-download/*/* {{ .ContainerWorkDir }}/kernel
 
 # Prepare the kernel
-cd /tmp/kernel
+cd {{ .ContainerWorkDir }}/kernel
 cp /driverkit/kernel.config /tmp/kernel.config
 
 sed -i -e 's|^\(EXTRAVERSION =\).*|\1 -flatcar|' Makefile
 make KCONFIG_CONFIG=/tmp/kernel.config oldconfig
 make KCONFIG_CONFIG=/tmp/kernel.config modules_prepare
 
-{{ if .BuildModule }}
+{{ if .BuildModule }}{{ template "artifact_begin" . }}
 # Build the module
 cd {{ .DriverBuildDir }}
-make CC=/usr/bin/gcc-{{ .GCCVersion }} KERNELDIR=/tmp/kernel
+make CC=/usr/bin/gcc-{{ .GCCVersion }} KERNELDIR={{ .ContainerWorkDir }}/kernel{{ template "module_make_flags" . }}
 mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
+{{ template "module_note" . }}
 strip -g {{ .ModuleFullPath }}
 # Print results
 modinfo {{ .ModuleFullPath }}
-{{ end }}
+{{ template "module_end" . }}{{ end }}
 
-{{ if .BuildProbe }}
+{{ if .BuildProbe }}{{ template "artifact_begin" . }}
 # Build the eBPF probe
 cd {{ .DriverBuildDir }}/bpf
-make KERNELDIR=/tmp/kernel
+make KERNELDIR={{ .ContainerWorkDir }}/kernel{{ template "probe_make_flags" . }}
 ls -l probe.o
-{{ end }}
\ No newline at end of file
+{{ template "probe_end" . }}{{ end }}
+{{- template "artifacts_wait" . }}
@@ -1,43 +1,62 @@
 #!/bin/bash
-set -xeuo pipefail
+set -xeuo pipefail{{ template "ca_bundle" . }}
 
 rm -Rf {{ .DriverBuildDir }}
 mkdir {{ .DriverBuildDir }}
-rm -Rf /tmp/module-download
-mkdir -p /tmp/module-download
+rm -Rf {{ .ContainerWorkDir }}/module-download
+mkdir -p {{ .ContainerWorkDir }}/module-download
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
-mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+curl --silent{{ template "download_flags" $ }} -SL {{ .ModuleDownloadURL }} | tar -xzf - -C {{ .ContainerWorkDir }}/module-download
+mv {{ .ContainerWorkDir }}/module-download/*/driver/* {{ .DriverBuildDir }}
 
 cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
 bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 
 # Fetch the kernel
-mkdir /tmp/kernel-download
-cd /tmp/kernel-download
+{{ template "stage" "download" }}
+mkdir {{ .ContainerWorkDir }}/kernel-download
+cd {{ .ContainerWorkDir }}/kernel-download
 {{range $url := .KernelDownloadURLS}}
-curl --silent -o kernel.deb -SL {{ $url }}
+{{- if $.Streaming }}
+curl --silent{{ template "kernel_download_flags" $ }} -SL {{ $url }} | bsdtar -xOf - 'data.tar.*' | bsdtar -xf -
+{{- else }}
+curl --silent{{ template "kernel_download_flags" $ }} -o kernel.deb -SL {{ $url }}
+{{- with checksum $url }}
+echo '{{ . }}  kernel.deb' | sha256sum --check --quiet -
+{{- end }}
 ar x kernel.deb
 tar -xf data.tar.*
+{{- end }}
 {{end}}
 
-cd /tmp/kernel-download/usr/src/
+cd {{ .ContainerWorkDir }}/kernel-download/usr/src/
 ls -altr
 sourcedir=$(find . -type d -name "{{ .KernelHeadersPattern }}" | head -n 1 | xargs readlink -f)
+{{- if .UTSRelease }}
 
-{{ if .BuildModule }}
+# The headers are the ones of the fallback flavor: build for the requested kernel release anyway
+sed -i 's/"{{ .HeadersRelease }}"/"{{ .UTSRelease }}"/' $sourcedir/include/generated/utsrelease.h
+{{- end }}
+
+{{ if .BuildModule }}{{ template "artifact_begin" . }}
 # Build the module
 cd {{ .DriverBuildDir }}
-make CC=/usr/bin/gcc-{{ .GCCVersion }} KERNELDIR=$sourcedir
+make CC=/usr/bin/{{ .CrossCompile }}gcc-{{ .GCCVersion }} ARCH={{ .KernelArch }}{{ if .CrossCompile }} CROSS_COMPILE={{ .CrossCompile }}{{ end }} KERNELDIR=$sourcedir{{ template "module_make_flags" . }}{{ range .ExtraKBuildFlags }} '{{ . }}'{{ end }}
 mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
-strip -g {{ .ModuleFullPath }}
+# Stamp the driver version and the source revision into the module info
+{{ .CrossCompile }}objcopy --dump-section .modinfo={{ .ContainerWorkDir }}/driverkit.modinfo {{ .ModuleFullPath }}
+printf 'driverversion={{ .DriverVersion }}\0{{ if .BuildCommit }}buildcommit={{ .BuildCommit }}\0{{ end }}' >> {{ .ContainerWorkDir }}/driverkit.modinfo
+{{ .CrossCompile }}objcopy --update-section .modinfo={{ .ContainerWorkDir }}/driverkit.modinfo {{ .ModuleFullPath }}
+{{ template "module_note" . }}
+{{ .CrossCompile }}strip -g {{ .ModuleFullPath }}
 # Print results
 modinfo {{ .ModuleFullPath }}
-{{ end }}
+{{ template "module_end" . }}{{ end }}
 
-{{ if .BuildProbe }}
+{{ if .BuildProbe }}{{ template "artifact_begin" . }}
 # Build the eBPF probe
 cd {{ .DriverBuildDir }}/bpf
-make KERNELDIR=$sourcedir
+make ARCH={{ .KernelArch }}{{ if .CrossCompile }} CROSS_COMPILE={{ .CrossCompile }}{{ end }} KERNELDIR=$sourcedir{{ template "probe_make_flags" . }}
 ls -l probe.o
-{{ end }}
+{{ template "probe_end" . }}{{ end }}
+{{- template "artifacts_wait" . }}
@@ -0,0 +1,373 @@
+package builder
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// dialContext opens the connections of the resolver transport.
+var dialContext = (&net.Dialer{KeepAlive: 30 * time.Second}).DialContext
+
+// TransportSettings are the settings of the clients resolving the kernel headers,
+// the builds having the same ones can share their clients.
+type TransportSettings struct {
+	// ConnectTimeout and ResponseHeaderTimeout, when set, bound the connects and the waits for the response headers.
+	ConnectTimeout        time.Duration
+	ResponseHeaderTimeout time.Duration
+	// ProxyURL, when set, replaces the proxy of the HTTP_PROXY and HTTPS_PROXY environment variables.
+	ProxyURL string
+	// CACertPath, when set, is a PEM bundle of the CAs to trust along with the system ones.
+	CACertPath string
+}
+
+// TransportSettings returns the settings of the clients resolving the kernel headers of b.
+func (b *Build) TransportSettings() TransportSettings {
+	if b == nil {
+		return TransportSettings{}
+	}
+	return TransportSettings{
+		ConnectTimeout:        b.ConnectTimeout,
+		ResponseHeaderTimeout: b.ResponseHeaderTimeout,
+		ProxyURL:              b.ProxyURL,
+		CACertPath:            b.CACertPath,
+	}
+}
+
+// httpClient returns the client resolving the kernel headers of b, sending its requests with its Transport if any,
+// otherwise the one of its URLResolver if any, otherwise the one of its transport settings.
+func (b *Build) httpClient() *http.Client {
+	if b != nil && b.Transport != nil {
+		return &http.Client{Transport: b.Transport}
+	}
+	if b != nil && b.URLResolver != nil {
+		return b.URLResolver.client
+	}
+	s := b.TransportSettings()
+	if s == (TransportSettings{}) {
+		return http.DefaultClient
+	}
+	v, _ := settingsClients.LoadOrStore(s, &settingsClient{})
+	sc := v.(*settingsClient)
+	sc.once.Do(func() {
+		var err error
+		if sc.client, err = NewHTTPClient(s); err != nil {
+			// the requests fail with the configuration error, rather than going out without the proxy or the CAs asked for
+			sc.client = &http.Client{Transport: brokenTransport{err: err}}
+		}
+	})
+	return sc.client
+}
+
+// HTTPClient returns the client of the requests of b sent from the host other than the kernel headers ones,
+// e.g. the ones fetching the driver sources metadata, configured as the resolving ones are.
+func (b *Build) HTTPClient() *http.Client {
+	return b.httpClient()
+}
+
+// settingsClients are the clients of the builds without a Transport or a URLResolver, by transport settings,
+// built once for all the builds having the same ones.
+var settingsClients sync.Map
+
+type settingsClient struct {
+	once   sync.Once
+	client *http.Client
+}
+
+// brokenTransport fails any request with the error configuring the transport settings.
+type brokenTransport struct {
+	err error
+}
+
+func (t brokenTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("unable to configure the http client: %w", t.err)
+}
+
+// NewHTTPClient returns a client with its own connection pool, configured by the given settings.
+// The reads of the bodies are not bounded, not to kill slow but progressing downloads.
+func NewHTTPClient(s TransportSettings) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if s.ConnectTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.ConnectTimeout)
+			defer cancel()
+		}
+		return dialContext(ctx, network, addr)
+	}
+	transport.ResponseHeaderTimeout = s.ResponseHeaderTimeout
+	if len(s.ProxyURL) > 0 {
+		proxy, err := url.Parse(s.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if len(s.CACertPath) > 0 {
+		pool, err := certPool(s.CACertPath)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// CACert returns the PEM bundle of the CAs to trust of b, to be copied to ContainerCACertPath.
+func CACert(b *Build) (string, error) {
+	data, err := os.ReadFile(b.CACertPath)
+	return string(data), err
+}
+
+// certPool returns the system cert pool, along with the CAs of the PEM bundle at p.
+func certPool(p string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no CA certificates found into %s", p)
+	}
+	return pool, nil
+}
+
+// URLResolver resolves the kernel headers urls of many builds, e.g. the ones of a batch,
+// sharing its connection pool and the timings of the mirrors among them.
+type URLResolver struct {
+	client    *http.Client
+	latencies *latencyTracker
+}
+
+// NewURLResolver constructs a URLResolver whose client is configured by the given settings.
+func NewURLResolver(s TransportSettings) (*URLResolver, error) {
+	client, err := NewHTTPClient(s)
+	if err != nil {
+		return nil, err
+	}
+	return &URLResolver{
+		client:    client,
+		latencies: newLatencyTracker(),
+	}, nil
+}
+
+// NewURLResolverWithClient constructs a URLResolver sending its requests with the given client,
+// e.g. one whose transport serves canned responses.
+func NewURLResolverWithClient(client *http.Client) *URLResolver {
+	return &URLResolver{
+		client:    client,
+		latencies: newLatencyTracker(),
+	}
+}
+
+// mirrorLatencies returns the timings of the mirrors probed for b, the ones of its URLResolver if any.
+func (b *Build) mirrorLatencies() *latencyTracker {
+	if b != nil && b.URLResolver != nil {
+		return b.URLResolver.latencies
+	}
+	return mirrorLatencies
+}
+
+// hasMirrorAuth tells whether b has credentials for the mirrors.
+func (b *Build) hasMirrorAuth() bool {
+	return b != nil && (len(b.MirrorUsername) > 0 || len(b.MirrorToken) > 0)
+}
+
+// mirrorHosts returns the hosts the mirror credentials of b get sent to:
+// the ones of its mirrors and extra mirrors, and of the kernel headers urls it was given.
+func (b *Build) mirrorHosts() map[string]bool {
+	hosts := make(map[string]bool)
+	add := func(urls []string) {
+		for _, u := range urls {
+			if uu, err := url.Parse(u); err == nil && len(uu.Host) > 0 {
+				hosts[uu.Host] = true
+			}
+		}
+	}
+	add(b.Mirrors)
+	for _, mirrors := range b.ExtraMirrors {
+		add(mirrors)
+	}
+	add(b.KernelUrls)
+	add(b.HeaderURLs)
+	return hosts
+}
+
+// onMirrorHosts tells whether all the given urls are on the mirror hosts of b.
+func (b *Build) onMirrorHosts(urls []string) bool {
+	hosts := b.mirrorHosts()
+	for _, u := range urls {
+		uu, err := url.Parse(u)
+		if err != nil || !hosts[uu.Host] {
+			return false
+		}
+	}
+	return true
+}
+
+// mirrorClient returns the client of the requests to the mirrors,
+// sending the mirror credentials of b, if any, along with the ones to the mirror hosts only.
+func (b *Build) mirrorClient() *http.Client {
+	client := b.httpClient()
+	if !b.hasMirrorAuth() {
+		return client
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &http.Client{Transport: &mirrorAuthTransport{
+		hosts:    b.mirrorHosts(),
+		username: b.MirrorUsername,
+		password: b.MirrorPassword,
+		token:    b.MirrorToken,
+		base:     base,
+	}}
+}
+
+// headWithRetries sends a HEAD request to u, retrying up to HTTPRetries times the ones failing
+// with a network error or a 5xx status, with exponential backoff from HTTPRetryBaseDelay plus jitter.
+// The 404s are never retried, being the legitimate answers of the mirrors not having u.
+func (b *Build) headWithRetries(ctx context.Context, client *http.Client, u string) (*http.Response, error) {
+	var retries int
+	var delay time.Duration
+	if b != nil {
+		retries, delay = b.HTTPRetries, b.HTTPRetryBaseDelay
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		reqCtx, cancel := b.requestContext(ctx)
+		res, err := client.Do(req.WithContext(reqCtx))
+		// the answers to HEAD requests have no body to read
+		cancel()
+		if ctx.Err() != nil {
+			// cancelled, the timing tells nothing about the mirror
+			return nil, ctx.Err()
+		}
+		b.mirrorLatencies().observe(u, time.Since(start))
+		if attempt >= retries || (err == nil && res.StatusCode < http.StatusInternalServerError) {
+			return res, err
+		}
+		l := logger.WithField("url", RedactURL(u)).WithField("attempt", attempt+1)
+		if err != nil {
+			l = l.WithError(err)
+		} else {
+			l = l.WithField("status", res.StatusCode)
+			res.Body.Close()
+		}
+		backoff := delay << attempt
+		if backoff > 0 {
+			backoff += time.Duration(rand.Int63n(int64(backoff)/2 + 1))
+		}
+		l.WithField("backoff", backoff).Debug("kernel header url probe failed, retrying")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// requestContext returns the context of a single request probing the kernel headers,
+// bounded by the HTTPTimeout of b, if any, besides being cancelled along with ctx.
+func (b *Build) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b == nil || b.HTTPTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, b.HTTPTimeout)
+}
+
+// httpGet sends a GET request to u with client, cancelled along with ctx.
+func httpGet(ctx context.Context, client *http.Client, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// resolveParallelism returns how many kernel headers urls b probes at the same time.
+func (b *Build) resolveParallelism() int {
+	if b == nil || b.ResolveParallelism <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return b.ResolveParallelism
+}
+
+// resolveSlots returns the semaphore bounding the probes of b: the one shared by the kernels of its batch, if any,
+// otherwise a new one of its resolve parallelism.
+func (b *Build) resolveSlots() chan struct{} {
+	if b != nil && b.batchSlots != nil {
+		return b.batchSlots
+	}
+	return make(chan struct{}, b.resolveParallelism())
+}
+
+// minPackageSize returns the minimum size of the kernel headers packages probed by b, if any.
+func (b *Build) minPackageSize() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.MinPackageSize
+}
+
+// mirrorAuthTransport sets the given credentials on the requests it sends to the given hosts,
+// rather than embedding them into the URLs, which would leak them into the logs:
+// the bearer token if any, otherwise the basic auth ones.
+// The requests to the other hosts, e.g. the default mirrors or the targets of the redirects, are sent as they are.
+type mirrorAuthTransport struct {
+	hosts    map[string]bool
+	username string
+	password string
+	token    string
+	base     http.RoundTripper
+}
+
+func (t *mirrorAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.hosts[req.URL.Host] {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if len(t.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	} else {
+		req.SetBasicAuth(t.username, t.password)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// RedactURL hides the password of the given URL, if any.
+func RedactURL(u string) string {
+	uu, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return uu.Redacted()
+}
+
+// redactURLs hides the passwords of the given URLs, if any.
+func redactURLs(urls []string) []string {
+	res := make([]string, len(urls))
+	for i, u := range urls {
+		res[i] = RedactURL(u)
+	}
+	return res
+}
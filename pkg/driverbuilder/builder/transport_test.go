@@ -0,0 +1,609 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder/buildertest"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	logger "github.com/sirupsen/logrus"
+)
+
+func TestHTTPClientConnectTimeout(t *testing.T) {
+	// a mock never completing the connects, until given up
+	defaultDialContext := dialContext
+	dialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	defer func() { dialContext = defaultDialContext }()
+
+	b := &Build{ConnectTimeout: 100 * time.Millisecond}
+	start := time.Now()
+	_, err := b.httpClient().Head("http://mirror.example.com/linux-headers.deb")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the connect to time out, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected the connect to fail at the connect timeout, took: %s", elapsed)
+	}
+}
+
+func TestHTTPClientResponseHeaderTimeout(t *testing.T) {
+	// a mirror answering right away, then slowly sending the body
+	slowBody := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("!<arch>\n"))
+	}))
+	defer slowBody.Close()
+	// a mirror slowly answering
+	slowHeaders := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer slowHeaders.Close()
+
+	b := &Build{
+		ConnectTimeout:        time.Second,
+		ResponseHeaderTimeout: 100 * time.Millisecond,
+		StrictContentCheck:    true,
+	}
+	// the content check reads the body, slower than the response header timeout
+	urls, err := getResolvingURLs(context.Background(), b, []string{slowBody.URL + "/linux-headers.deb"})
+	if err != nil || len(urls) != 1 {
+		t.Fatalf("Expected the slow body to be allowed, got: %v, %v", urls, err)
+	}
+
+	if _, err := b.httpClient().Head(slowHeaders.URL + "/linux-headers.deb"); err == nil {
+		t.Fatal("Expected the slow answer to time out")
+	}
+}
+
+func TestKernelURLsCancellation(t *testing.T) {
+	// a mirror never answering, until the request is given up
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hung.Close()
+	defaultPools := debianPools
+	debianPools = []string{hung.URL + "/debian/pool/main/l/linux/"}
+	defer func() { debianPools = defaultPools }()
+
+	kr := kernelrelease.FromString("6.1.0-18-amd64")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	for name, resolve := range map[string]func(ctx context.Context) error{
+		"probes": func(ctx context.Context) error {
+			_, err := getResolvingURLs(ctx, &Build{}, []string{hung.URL + "/linux-headers.deb"})
+			return err
+		},
+		"probes beyond the parallelism": func(ctx context.Context) error {
+			var urls []string
+			for i := 0; i < 8; i++ {
+				urls = append(urls, fmt.Sprintf("%s/linux-headers-%d.deb", hung.URL, i))
+			}
+			_, err := getResolvingURLs(ctx, &Build{ResolveParallelism: 2}, urls)
+			return err
+		},
+		"pool listing": func(ctx context.Context) error {
+			_, err := KernelURLs(ctx, BuilderByTarget[TargetTypeDebian], newTestConfig(TargetTypeDebian), kr)
+			return err
+		},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		errs := make(chan error, 1)
+		go func() { errs <- resolve(ctx) }()
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Errorf("%s: expected the resolution to fail once cancelled", name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: expected the resolution to be cancelled along with its context", name)
+		}
+		cancel()
+	}
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	// a mirror stalling on the first candidate only
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.deb" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}))
+	defer mirror.Close()
+
+	b := &Build{HTTPTimeout: 100 * time.Millisecond, ResolveParallelism: 1}
+	start := time.Now()
+	urls, err := getResolvingURLs(context.Background(), b, []string{mirror.URL + "/slow.deb", mirror.URL + "/fast.deb"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the slow request to be aborted, took: %s", elapsed)
+	}
+	// the resolution moved on to the next candidate
+	if !reflect.DeepEqual(urls, []string{mirror.URL + "/fast.deb"}) {
+		t.Errorf("Expected only the fast candidate to resolve, got %v", urls)
+	}
+	if len(b.URLProbes) != 2 || !strings.Contains(b.URLProbes[0].Error, context.DeadlineExceeded.Error()) {
+		t.Errorf("Expected the slow probe to time out, got %v", b.URLProbes)
+	}
+}
+
+func TestMirrorCredentials(t *testing.T) {
+	var mu sync.Mutex
+	var auths []string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		mu.Lock()
+		defer mu.Unlock()
+		if !ok {
+			auths = append(auths, "")
+		} else {
+			auths = append(auths, username+":"+password)
+		}
+	}))
+	defer mirror.Close()
+
+	var logs bytes.Buffer
+	defer logger.SetOutput(logger.StandardLogger().Out)
+	defer logger.SetLevel(logger.GetLevel())
+	logger.SetOutput(&logs)
+	logger.SetLevel(logger.DebugLevel)
+
+	b := &Build{Mirrors: []string{mirror.URL}, MirrorUsername: "builder", MirrorPassword: "s3cr3t"}
+	// credentials embedded into the urls are redacted as well
+	embedded := strings.Replace(mirror.URL, "http://", "http://someone:emb3dd3d@", 1)
+	urls, err := getResolvingURLs(context.Background(), b, []string{mirror.URL + "/linux-headers.deb", embedded + "/linux-headers-all.deb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 2 {
+		t.Fatalf("expected both urls to resolve, got %v", urls)
+	}
+
+	// the credentials of the build are sent to the mirror
+	for _, auth := range auths {
+		if auth != "builder:s3cr3t" {
+			t.Errorf("expected the mirror credentials to be sent, got %q", auth)
+		}
+	}
+	// and never logged or recorded
+	for _, secret := range []string{"s3cr3t", "emb3dd3d"} {
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("password %s found into the logs:\n%s", secret, logs.String())
+		}
+		for _, p := range b.URLProbes {
+			if strings.Contains(p.URL, secret) {
+				t.Errorf("password %s recorded into the url probes: %s", secret, p.URL)
+			}
+		}
+	}
+	if !strings.Contains(logs.String(), "someone:xxxxx@") {
+		t.Errorf("expected the redacted url to be logged:\n%s", logs.String())
+	}
+
+	// the downloads of the build script authenticate with the credentials copied into the container
+	c := newTestConfig(TargetTypeUbuntu)
+	c.Mirrors = []string{mirror.URL}
+	c.MirrorUsername = "builder"
+	c.MirrorPassword = `s3"cr3t`
+	c = c.Build.ToConfig()
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	script, err := renderScript(&ubuntu{}, c, kr, urls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(script, "s3") || !strings.Contains(script, "--config "+MirrorAuthConfigPath+" -o kernel.deb") {
+		t.Errorf("expected the kernel downloads to use the mirror credentials config only:\n%s", script)
+	}
+	if conf := MirrorAuthConfig(c.Build); conf != "user = \"builder:s3\\\"cr3t\"\n" {
+		t.Errorf("unexpected mirror credentials config: %q", conf)
+	}
+}
+
+func TestMirrorCredentialsScope(t *testing.T) {
+	var mu sync.Mutex
+	leaked := map[string]string{}
+	// a public mirror, also the target of the redirects of the private one
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); len(auth) > 0 {
+			mu.Lock()
+			leaked[r.URL.Path] = auth
+			mu.Unlock()
+		}
+	}))
+	defer public.Close()
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.Header.Get("Authorization")) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/moved.deb" {
+			http.Redirect(w, r, public.URL+"/moved.deb", http.StatusFound)
+		}
+	}))
+	defer private.Close()
+
+	urls := []string{private.URL + "/linux-headers.deb", private.URL + "/moved.deb", public.URL + "/linux-headers-all.deb"}
+	for name, b := range map[string]*Build{
+		"basic auth": {Mirrors: []string{private.URL}, MirrorUsername: "builder", MirrorPassword: "s3cr3t"},
+		"token":      {Mirrors: []string{private.URL}, MirrorToken: "t0k3n"},
+	} {
+		got, err := getResolvingURLs(context.Background(), b, urls)
+		buildertest.AssertURLs(t, got, err, urls)
+		// the credentials are sent to the mirror hosts only, not to the other ones nor to the targets of the redirects
+		if len(leaked) > 0 {
+			t.Errorf("%s: mirror credentials sent to other hosts: %v", name, leaked)
+		}
+	}
+
+	b := &Build{Mirrors: []string{private.URL}, MirrorUsername: "builder", MirrorPassword: "s3cr3t"}
+
+	// the build script does not send them to other hosts either
+	c := newTestConfig(TargetTypeUbuntu)
+	c.Mirrors = b.Mirrors
+	c.MirrorUsername = b.MirrorUsername
+	c.MirrorPassword = b.MirrorPassword
+	c = c.Build.ToConfig()
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	script, err := renderScript(&ubuntu{}, c, kr, []string{private.URL + "/linux-headers.deb", public.URL + "/linux-headers-all.deb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(script, MirrorAuthConfigPath) {
+		t.Errorf("Expected the kernel downloads not to use the mirror credentials:\n%s", script)
+	}
+}
+
+func TestMirrorToken(t *testing.T) {
+	packages := []string{
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+	}
+	var mu sync.Mutex
+	var auths []string
+	// a private pool, e.g. the ubuntu pro one, serving the packages to the authenticated requests only
+	esm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths = append(auths, r.Header.Get("Authorization"))
+		mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		for _, p := range packages {
+			if r.URL.Path == p {
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer esm.Close()
+
+	var logs bytes.Buffer
+	defer logger.SetOutput(logger.StandardLogger().Out)
+	defer logger.SetLevel(logger.GetLevel())
+	logger.SetOutput(&logs)
+	logger.SetLevel(logger.TraceLevel)
+
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelRelease = "5.15.0-52-generic"
+	c.KernelVersion = "58"
+	c.Architecture = kernelrelease.ArchitectureAmd64
+	c.Mirrors = []string{esm.URL}
+	c.MirrorToken = "t0k3n"
+	c = c.Build.ToConfig()
+	kr := c.KernelReleaseFromBuildConfig()
+	urls, err := ResolveURLs(context.Background(), TargetTypeUbuntu, c, kr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(urls) != len(packages) {
+		t.Fatalf("Expected the packages of the private pool to resolve, got %v", urls)
+	}
+
+	// the token is sent to the mirror
+	mu.Lock()
+	for _, auth := range auths {
+		if auth != "Bearer t0k3n" {
+			t.Errorf("Expected the mirror token to be sent, got %q", auth)
+		}
+	}
+	mu.Unlock()
+	// and never logged or recorded
+	if strings.Contains(logs.String(), "t0k3n") {
+		t.Errorf("Token found into the logs:\n%s", logs.String())
+	}
+	for _, p := range c.URLProbes {
+		if strings.Contains(p.URL, "t0k3n") {
+			t.Errorf("Token recorded into the url probes: %s", p.URL)
+		}
+	}
+
+	// the downloads of the build script authenticate with the token copied into the container
+	script, err := renderScript(&ubuntu{}, c, kr, urls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(script, "t0k3n") || !strings.Contains(script, "--config "+MirrorAuthConfigPath) {
+		t.Errorf("Expected the kernel downloads to use the mirror credentials config only:\n%s", script)
+	}
+	if conf := MirrorAuthConfig(c.Build); conf != "header = \"Authorization: Bearer t0k3n\"\n" {
+		t.Errorf("Unexpected mirror credentials config: %q", conf)
+	}
+}
+
+func TestURLResolverSharedAcrossBuilds(t *testing.T) {
+	var mu sync.Mutex
+	conns := 0
+	mirror := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	mirror.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	mirror.Start()
+	defer mirror.Close()
+
+	resolver, err := NewURLResolver(TransportSettings{ConnectTimeout: time.Second, ResponseHeaderTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	first := &Build{URLResolver: resolver}
+	second := &Build{URLResolver: resolver}
+	for _, b := range []*Build{first, second} {
+		if _, err := getResolvingURLs(context.Background(), b, []string{mirror.URL + "/linux-headers.deb"}); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+
+	if first.httpClient() != second.httpClient() {
+		t.Errorf("Expected the builds to share the client of their resolver")
+	}
+	if _, ok := resolver.latencies.average(mirror.URL); !ok {
+		t.Errorf("Expected the resolver to record the mirror timings")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 1 {
+		t.Errorf("Expected the builds to reuse a single connection, %d were opened", conns)
+	}
+}
+
+func TestURLRewriteBeforeProbing(t *testing.T) {
+	// the internal CDN serving the ubuntu pool under its own path
+	cdn := newUbuntuFixtureMirror(
+		"/internal/ubuntu/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+		"/internal/ubuntu/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+	)
+	defer cdn.Close()
+
+	rule, err := ParseURLRewriteRule(`^https://mirror\.example\.com/ubuntu/pool/main/l/=>` + cdn.URL + `/internal/ubuntu/`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelRelease = "5.15.0-52-generic"
+	c.KernelVersion = "58"
+	c.Mirrors = []string{"https://mirror.example.com/ubuntu/pool/main/l"}
+	c.URLRewrite = []URLRewriteRule{rule}
+	kr := kernelrelease.FromString(c.KernelRelease)
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+
+	urls, err := KernelURLs(context.Background(), BuilderByTarget[TargetTypeUbuntu], c, kr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, cdn.URL+"/internal/ubuntu/linux/") {
+			t.Errorf("Expected %s to be rewritten to the CDN", u)
+		}
+	}
+	for _, p := range c.URLProbes {
+		if strings.HasPrefix(p.URL, "https://mirror.example.com/") {
+			t.Errorf("Expected no candidate to be probed before its rewrite, got %s", p.URL)
+		}
+	}
+}
+
+func TestParseURLRewriteRule(t *testing.T) {
+	rule, err := ParseURLRewriteRule(`^https://(\w+)\.example\.com/=>https://cdn.example.com/$1/`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if got := rewriteURLs([]URLRewriteRule{rule}, []string{"https://ports.example.com/linux.deb"}); got[0] != "https://cdn.example.com/ports/linux.deb" {
+		t.Errorf("Unexpected rewrite: %s", got[0])
+	}
+	for _, invalid := range []string{"no separator", "=>https://cdn.example.com/", "(=>x"} {
+		if _, err := ParseURLRewriteRule(invalid); err == nil {
+			t.Errorf("Expected an error parsing %q", invalid)
+		}
+	}
+}
+
+func TestURLResolutionRetries(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		n := requests[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/missing.deb":
+			http.NotFound(w, r)
+		case n <= 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	// without retries the transient 503s fail the resolution
+	if _, err := getResolvingURLs(context.Background(), &Build{}, []string{srv.URL + "/flaky.deb"}); !errors.Is(err, HeadersNotFoundErr) {
+		t.Fatalf("Expected the headers not to be found, got %v", err)
+	}
+
+	b := &Build{HTTPRetries: 2, HTTPRetryBaseDelay: time.Millisecond}
+	urls, err := getResolvingURLs(context.Background(), b, []string{srv.URL + "/retried.deb", srv.URL + "/missing.deb"})
+	if err != nil || len(urls) != 1 || urls[0] != srv.URL+"/retried.deb" {
+		t.Fatalf("Expected the url to resolve after the retries, got %v (%v)", urls, err)
+	}
+	if requests["/retried.deb"] != 3 {
+		t.Errorf("Expected 3 attempts, got %d", requests["/retried.deb"])
+	}
+	// 404s are not retried
+	if requests["/missing.deb"] != 1 {
+		t.Errorf("Expected a single attempt for a 404, got %d", requests["/missing.deb"])
+	}
+	if p := b.URLProbes[0]; p.StatusCode != http.StatusOK {
+		t.Errorf("Expected the probe to record the final status, got %v", p)
+	}
+}
+
+func TestHTTPClientProxy(t *testing.T) {
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.String())
+		mu.Unlock()
+	}))
+	defer proxy.Close()
+
+	b := &Build{ProxyURL: proxy.URL}
+	u := "http://mirror.example.com/linux-headers.deb"
+	urls, err := getResolvingURLs(context.Background(), b, []string{u})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(urls) != 1 || urls[0] != u {
+		t.Errorf("Expected %s to resolve through the proxy, got %v", u, urls)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(proxied) != 1 || proxied[0] != u {
+		t.Errorf("Expected the request to route through the proxy, got %v", proxied)
+	}
+}
+
+func TestHTTPClientCACert(t *testing.T) {
+	mirror := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer mirror.Close()
+	u := mirror.URL + "/linux-headers.deb"
+
+	// the mirror CA is not trusted by default
+	if _, err := getResolvingURLs(context.Background(), &Build{}, []string{u}); err == nil {
+		t.Fatalf("Expected the mirror certificate not to be trusted")
+	}
+
+	caCertPath := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: mirror.Certificate().Raw})
+	if err := os.WriteFile(caCertPath, cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getResolvingURLs(context.Background(), &Build{CACertPath: caCertPath}, []string{u}); err != nil {
+		t.Errorf("Expected the mirror certificate to be trusted, got %s", err)
+	}
+
+	// a bundle without certificates is refused
+	if err := os.WriteFile(caCertPath, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHTTPClient(TransportSettings{CACertPath: caCertPath}); err == nil {
+		t.Errorf("Expected an error for a bundle without certificates")
+	}
+	// and fails the requests of the builds, rather than sending them without it
+	badCACertPath := filepath.Join(t.TempDir(), "bad.pem")
+	if err := os.WriteFile(badCACertPath, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	if _, err := getResolvingURLs(context.Background(), &Build{CACertPath: badCACertPath}, []string{plain.URL + "/linux-headers.deb"}); err == nil || !strings.Contains(err.Error(), "unable to configure the http client") {
+		t.Errorf("Expected the configuration error, got %v", err)
+	}
+}
+
+// recordingTransport records the urls of the requests it sends through base.
+type recordingTransport struct {
+	mu   sync.Mutex
+	urls []string
+	base http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.urls = append(t.urls, req.URL.String())
+	t.mu.Unlock()
+	return t.base.RoundTrip(req)
+}
+
+func TestBuildTransport(t *testing.T) {
+	packages := []string{
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+	}
+	transport := &recordingTransport{base: buildertest.NewMirror().Packages(packages...).Client().Transport}
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	b := &Build{
+		Mirrors:        []string{ubuntuFixtureBaseURL},
+		MirrorUsername: "user",
+		MirrorPassword: "secret",
+		Transport:      transport,
+		// the transport wins over the client of the resolver, which would fail every request
+		URLResolver: NewURLResolverWithClient(&http.Client{Transport: failingTransport{}}),
+	}
+
+	urls, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "58")
+	buildertest.AssertURLs(t, urls, err, ubuntuFixtureURLs(packages[1], packages[0]))
+
+	if len(b.URLProbes) == 0 {
+		t.Fatalf("Expected the candidates to be probed")
+	}
+	sent := map[string]bool{}
+	for _, u := range transport.urls {
+		sent[u] = true
+	}
+	for _, p := range b.URLProbes {
+		if !sent[p.URL] {
+			t.Errorf("Expected the transport to send the request probing %s", p.URL)
+		}
+	}
+}
+
+// failingTransport fails every request.
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("unexpected request")
+}
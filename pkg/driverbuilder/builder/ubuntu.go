@@ -1,20 +1,45 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
 	"regexp"
 	"strings"
 
+	"github.com/blang/semver"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
-//go:embed templates/ubuntu.sh
-var ubuntuTemplate string
+//go:embed templates/ubuntu.sh.gz
+var ubuntuTemplate []byte
 
 // TargetTypeUbuntu identifies the Ubuntu target.
 const TargetTypeUbuntu Type = "ubuntu"
 
+const (
+	ubuntuEdgeMirror     = "https://mirrors.edge.kernel.org/ubuntu/pool/main/l"
+	ubuntuSecurityMirror = "http://security.ubuntu.com/ubuntu/pool/main/l"
+	// arm64 and others are hosted on ports.ubuntu.com
+	ubuntuPortsMirror = "http://ports.ubuntu.com/ubuntu-ports/pool/main/l"
+)
+
+// ubuntuMirrorsByArch routes each architecture to the mirrors hosting its packages,
+// in the order they must be tried by default; the architectures not listed here are looked for into ubuntuPortsMirror.
+// The extra mirrors of the builds are tried after them.
+var ubuntuMirrorsByArch = map[string][]string{
+	kernelrelease.ArchitectureAmd64: {ubuntuEdgeMirror, ubuntuSecurityMirror},
+	// ports do not resolve for amd64
+	kernelrelease.ArchitectureArm64:   {ubuntuPortsMirror},
+	kernelrelease.ArchitectureRiscv64: {ubuntuPortsMirror},
+	kernelrelease.ArchitectureS390x:   {ubuntuPortsMirror},
+}
+
 // We expect both a common "_all" package,
 // and an arch dependent package.
 const ubuntuRequiredURLs = 2
@@ -24,6 +49,11 @@ type ubuntuTemplateData struct {
 	KernelDownloadURLS   []string
 	KernelLocalVersion   string
 	KernelHeadersPattern string
+	// HeadersRelease is the kernel release of the headers, rewritten to UTSRelease if set.
+	HeadersRelease string
+	UTSRelease     string
+	// KernelArch is the ARCH of the kernel build system for the architecture of the headers.
+	KernelArch string
 }
 
 func init() {
@@ -37,31 +67,104 @@ func (v *ubuntu) Name() string {
 	return TargetTypeUbuntu.String()
 }
 
+// SupportedArchitectures returns the default architectures, along with riscv64, cross-compiled by the ubuntu script.
+func (v *ubuntu) SupportedArchitectures() []kernelrelease.Architecture {
+	return append(DefaultArchitectures(), kernelrelease.ArchitectureRiscv64)
+}
+
 func (v *ubuntu) TemplateScript() string {
-	return ubuntuTemplate
+	return decompressTemplate(ubuntuTemplate)
+}
+
+func (v *ubuntu) URLs(ctx context.Context, c Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	if err := ValidateUbuntuExtraversion(kr.Extraversion); err != nil {
+		return nil, err
+	}
+	if flavors := ubuntuCandidateFlavors(c, kr); len(flavors) > 0 {
+		return ubuntuHeadersURLFromFlavors(flavors, kr, func(flavored kernelrelease.KernelRelease) ([]string, error) {
+			return ubuntuHeadersURLFromRelease(ctx, c.Build, flavored, c.Build.KernelVersion)
+		})
+	}
+	return ubuntuHeadersURLFromRelease(ctx, c.Build, kr, c.Build.KernelVersion)
+}
+
+func (v *ubuntu) Mirrors(c Config, kr kernelrelease.KernelRelease) []string {
+	return ubuntuBaseURLs(c.Build, kr)
 }
 
-func (v *ubuntu) URLs(c Config, kr kernelrelease.KernelRelease) ([]string, error) {
-	return ubuntuHeadersURLFromRelease(kr, c.Build.KernelVersion)
+func (v *ubuntu) MirrorURLs(c Config, kr kernelrelease.KernelRelease, mirror string) ([]string, error) {
+	return fetchUbuntuKernelURL(mirror, kr, c.Build.KernelVersion)
 }
 
 func (v *ubuntu) MinimumURLs() int {
 	return ubuntuRequiredURLs
 }
 
+// Flavors returns the flavor families whose pool subdirs and package names fetchUbuntuKernelURL is tested against.
+// The other flavors are parsed all the same, and looked for as the vendor ones (ex: aws).
+func (v *ubuntu) Flavors() []Flavor {
+	return ubuntuFlavors
+}
+
+var ubuntuFlavors = []Flavor{
+	{"generic", "5.15.0-52-generic", "linux subdir"},
+	{"lowlatency", "5.15.0-52-lowlatency", "linux and linux-lowlatency subdirs"},
+	{"lowlatency-hwe", "5.15.0-24-lowlatency-hwe-5.15", "linux-lowlatency-hwe-X.Y subdir"},
+	{"hwe", "4.18.0-24-hwe", "linux-hwe subdir, shipping the generic headers"},
+	{"hwe-edge", "5.3.0-19-hwe-edge", "linux-hwe-edge subdir, shipping the generic headers"},
+	{"generic-hwe", "6.2.0-26-generic-hwe-22.04", "linux-hwe-<release> subdir"},
+	{"aws", "5.15.0-1019-aws", "linux-aws, linux-aws-X.Y, linux-aws-edge and linux-aws-fips subdirs"},
+	{"aws-fips", "5.15.0-1009-aws-fips", "linux-aws-fips subdir"},
+	{"azure", "5.4.0-1022-azure", "linux-azure, linux-azure-X.Y, linux-azure-edge and linux-azure-fips subdirs"},
+	{"gcp", "5.15.0-1021-gcp", "linux-gcp, linux-gcp-X.Y, linux-gcp-edge and linux-gcp-fips subdirs"},
+	{"kvm", "5.19.0-1006-kvm", "linux-kvm, linux-kvm-X.Y, linux-kvm-edge and linux-kvm-fips subdirs"},
+	{"intel-iotg", "5.15.0-1004-intel-iotg", "linux-intel-iotg subdirs, headers extracted as linux-headers*intel*"},
+	{"realtime", "5.15.0-1004-realtime", "linux-realtime and linux-realtime-X.Y subdirs"},
+	{"intel-iot-realtime", "5.15.0-1043-intel-iot-realtime", "linux-intel-iot-realtime and linux-intel-iot-realtime-X.Y subdirs"},
+	{"lts-utopic", "3.16.0-38-lts-utopic", "linux-lts-utopic and linux-lts-utopic-X.Y subdirs"},
+}
+
+// PackageKernelVersion returns the kernel version of the given headers package,
+// the one following the ABI number into its version.
+// Example: linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb -> 58
+func (v *ubuntu) PackageKernelVersion(u string) (string, bool) {
+	name := strings.TrimSuffix(path.Base(u), ".deb")
+	fields := strings.Split(name, "_")
+	if len(fields) != 3 {
+		return "", false
+	}
+	_, revision, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return "", false
+	}
+	_, kv, ok := strings.Cut(revision, ".")
+	return kv, ok && len(kv) > 0
+}
+
+// MinBuilderImageVersion requires the builder images able to extract
+// the zstd compressed headers packages of the recent releases.
+func (v *ubuntu) MinBuilderImageVersion() semver.Version {
+	return semver.MustParse("1.0.0")
+}
+
 func (v *ubuntu) TemplateData(c Config, kr kernelrelease.KernelRelease, urls []string) interface{} {
-	// parse the flavor out of the kernelrelease extraversion
-	_, flavor := parseUbuntuExtraVersion(kr.Extraversion)
+	// when given candidate flavors, build the one the urls were resolved for
+	requested := kr
+	if flavored, ok := ubuntuResolvedFlavorRelease(ubuntuCandidateFlavors(c, kr), kr, urls); ok {
+		kr = flavored
+	}
 
-	// handle hwe kernels, which resolve to "generic" urls under /linux-hwe
-	// Example: http://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-hwe/linux-headers-4.18.0-24-generic_4.18.0-24.25~18.04.1_amd64.deb
-	headersPattern := ""
-	if flavor == "hwe" {
-		headersPattern = "linux-headers*generic"
-	} else {
-		// some flavors (ex: lowlatency-hwe) only contain the first part of the flavor in the directory extracted from the .deb
-		// splitting a flavor without a "-" should just return the original flavor back	
-		headersPattern = fmt.Sprintf("linux-headers*%s*", strings.Split(flavor, "-")[0])
+	// when falling back to the generic headers, build for the requested kernel release anyway
+	utsRelease := ""
+	if c.FlavorFallbackGeneric && len(c.KernelFlavors) == 0 && kr.FullExtraversion != requested.FullExtraversion {
+		utsRelease = requested.Fullversion + requested.FullExtraversion
+	}
+
+	// parse the flavor out of the kernelrelease extraversion
+	_, flavor, _ := parseUbuntuExtraVersion(kr.Extraversion)
+	headersPattern := ubuntuHeadersPattern(flavor)
+	if len(c.HeadersPatternOverride) > 0 {
+		headersPattern = c.HeadersPatternOverride
 	}
 
 	return ubuntuTemplateData{
@@ -69,59 +172,293 @@ func (v *ubuntu) TemplateData(c Config, kr kernelrelease.KernelRelease, urls []s
 		KernelDownloadURLS:   urls,
 		KernelLocalVersion:   kr.FullExtraversion,
 		KernelHeadersPattern: headersPattern,
+		HeadersRelease:       kr.Fullversion + kr.FullExtraversion,
+		UTSRelease:           utsRelease,
+		KernelArch:           kr.Architecture.ToKernel(),
 	}
 }
 
-func ubuntuHeadersURLFromRelease(kr kernelrelease.KernelRelease, kv string) ([]string, error) {
-	// decide which mirrors to use based on the architecture passed in
-	baseURLs := []string{}
-	if kr.Architecture.String() == kernelrelease.ArchitectureAmd64 {
-		baseURLs = []string{
-			"https://mirrors.edge.kernel.org/ubuntu/pool/main/l",
-			"http://security.ubuntu.com/ubuntu/pool/main/l",
+// headersFlavor returns the flavor of the headers at urls, the one of kr unless resolved for another candidate flavor.
+func (v *ubuntu) headersFlavor(c Config, kr kernelrelease.KernelRelease, urls []string) string {
+	if flavored, ok := ubuntuResolvedFlavorRelease(ubuntuCandidateFlavors(c, kr), kr, urls); ok {
+		kr = flavored
+	}
+	_, flavor, _ := parseUbuntuExtraVersion(kr.Extraversion)
+	return flavor
+}
+
+// ubuntuHeadersPattern returns the pattern of the directory of the headers of the given flavor, once extracted from the packages.
+func ubuntuHeadersPattern(flavor string) string {
+	// the hwe kernels ship the headers of their base flavor, generic when there is none (e.g. hwe-edge),
+	// whatever their -hwe or -hwe-X.Y suffix
+	// Example: http://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-hwe/linux-headers-4.18.0-24-generic_4.18.0-24.25~18.04.1_amd64.deb
+	if base, _, ok := strings.Cut("-"+flavor, "-hwe"); ok {
+		base = strings.TrimPrefix(base, "-")
+		if len(base) == 0 {
+			base = "generic"
+		}
+		return "linux-headers*" + base
+	}
+	// the realtime kernels of the vendor flavors (ex: intel-iot-realtime) are told apart by their realtime suffix
+	if ubuntuRealtimeFlavor(flavor) {
+		return "linux-headers*realtime*"
+	}
+	// some flavors (ex: intel-iotg) only contain the first part of the flavor in the directory extracted from the .deb
+	// splitting a flavor without a "-" should just return the original flavor back
+	return fmt.Sprintf("linux-headers*%s*", strings.Split(flavor, "-")[0])
+}
+
+// ubuntuFallbackFlavor is the flavor whose headers are tried, when asked to,
+// once the ones of the requested flavor are missing.
+const ubuntuFallbackFlavor = "generic"
+
+// ubuntuCandidateFlavors returns the flavors of kr to try in order, if any other than its own one:
+// the configured ones, followed by the fallback flavor when asked to.
+func ubuntuCandidateFlavors(c Config, kr kernelrelease.KernelRelease) []string {
+	flavors := c.KernelFlavors
+	if !c.FlavorFallbackGeneric {
+		return flavors
+	}
+	if len(flavors) == 0 {
+		if _, flavor, err := parseUbuntuExtraVersion(kr.Extraversion); err == nil {
+			flavors = []string{flavor}
 		}
-	} else {
-		baseURLs = []string{
-			// arm64 and others are hosted on ports.ubuntu.com
-			// but they will resolve for amd64 without this if logic
-			"http://ports.ubuntu.com/ubuntu-ports/pool/main/l",
+	}
+	for _, flavor := range flavors {
+		if flavor == ubuntuFallbackFlavor {
+			return flavors
+		}
+	}
+	return append(append([]string{}, flavors...), ubuntuFallbackFlavor)
+}
+
+// ubuntuBaseURLs returns the mirrors where to look for the headers of kr, in the order they must be tried.
+func ubuntuBaseURLs(b *Build, kr kernelrelease.KernelRelease) []string {
+	// the mirrors of the build, if any, replace the default ones, tried in the given order
+	if b != nil && len(b.Mirrors) > 0 {
+		return b.Mirrors
+	}
+
+	// decide which mirrors to use based on the architecture passed in
+	baseURLs, ok := ubuntuMirrorsByArch[kr.Architecture.String()]
+	if !ok {
+		baseURLs = []string{ubuntuPortsMirror}
+	}
+	// then the extra ones of the build, if any
+	if b != nil && len(b.ExtraMirrors[kr.Architecture.String()]) > 0 {
+		baseURLs = append(append([]string{}, baseURLs...), b.ExtraMirrors[kr.Architecture.String()]...)
+	}
+
+	// try the mirrors that answered faster so far first
+	baseURLs = b.mirrorLatencies().sortMirrors(baseURLs)
+
+	// unless the security mirror was explicitly preferred,
+	// since the edge one can be stale for the most recent kernels
+	if b == nil || !b.PreferSecurityMirror || kr.Architecture.String() != kernelrelease.ArchitectureAmd64 {
+		return baseURLs
+	}
+	reordered := []string{ubuntuSecurityMirror}
+	for _, u := range baseURLs {
+		if u != ubuntuSecurityMirror {
+			reordered = append(reordered, u)
 		}
 	}
+	return reordered
+}
 
-	for _, url := range baseURLs {
+func ubuntuHeadersURLFromRelease(ctx context.Context, b *Build, kr kernelrelease.KernelRelease, kv string) ([]string, error) {
+	var tried []URLProbe
+	var resolved []string
+	for _, url := range ubuntuBaseURLs(b, kr) {
 		// get all possible URLs
 		possibleURLs, err := fetchUbuntuKernelURL(url, kr, kv)
 		if err != nil {
 			return nil, err
 		}
-		// try resolving the URLs
-		urls, err := getResolvingURLs(possibleURLs)
-		// there should be 2 urls returned - the _all.deb package and the _{arch}.deb package
+		b.log().WithField("mirror", RedactURL(url)).WithField("candidates", len(possibleURLs)).Debug("probing the ubuntu kernel headers candidates")
+		// try resolving the URLs, until the pair is found
+		urls, probes := resolveURLs(ctx, b, possibleURLs, ubuntuPairResolved)
+		tried = append(tried, probes...)
+		resolved = append(resolved, urls...)
+		// there should be 2 urls returned - the _{arch}.deb package and the _all.deb package
+		if pair := ubuntuHeadersPair(urls); pair != nil {
+			b.log().WithField("urls", redactURLs(pair)).Info("ubuntu kernel headers pair resolved")
+			return pair, nil
+		}
+		// then look for them into the listings of the mirror, when asked to
+		if b != nil && b.ListingFallback {
+			if urls, err := fetchUbuntuKernelURLByListing(ctx, b, url, kr, kv); err == nil {
+				b.log().WithField("urls", redactURLs(urls)).Info("ubuntu kernel headers pair resolved from the listing")
+				return urls, nil
+			}
+		}
+	}
+
+	// packages weren't found, return error out, telling which one is missing when the other one was
+	err := &URLResolutionError{Target: TargetTypeUbuntu, Tried: tried}
+	err.Resolved, err.Missing = ubuntuPartialPair(resolved, kr.Architecture)
+	return nil, err
+}
+
+// ubuntuPartialPair returns, when the pair of headers packages did not resolve, the resolving urls of the most specific subdir
+// hosting any of them, along with the package of the pair it lacks, either the _all.deb or the _{arch}.deb one.
+func ubuntuPartialPair(urls []string, arch kernelrelease.Architecture) ([]string, []string) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	var found []string
+	hasAll, hasArch := false, false
+	for _, u := range urls {
+		if path.Dir(u) != path.Dir(urls[0]) {
+			continue
+		}
+		found = append(found, u)
+		if strings.HasSuffix(u, "_all.deb") {
+			hasAll = true
+		} else {
+			hasArch = true
+		}
+	}
+	var missing []string
+	if !hasArch {
+		missing = append(missing, fmt.Sprintf("_%s.deb", arch))
+	}
+	if !hasAll {
+		missing = append(missing, "_all.deb")
+	}
+	return found, missing
+}
+
+// ubuntuPairResolved tells whether the given urls hold the pair of the _{arch}.deb package and the _all.deb one.
+func ubuntuPairResolved(urls []string) bool {
+	return ubuntuHeadersPair(urls) != nil
+}
+
+// ubuntuHeadersPair returns the pair of headers packages among the given resolving urls, in the order of the candidates,
+// if any: the first _{arch}.deb package and the first _all.deb one of the first subdir hosting both of them.
+// As the candidates list the most specific subdirs first, the pair does not depend on the order the probes complete in,
+// nor on the packages published by the less specific subdirs.
+func ubuntuHeadersPair(urls []string) []string {
+	arch, all := map[string]string{}, map[string]string{}
+	for _, u := range urls {
+		dir := path.Dir(u)
+		pkgs := arch
+		if strings.HasSuffix(u, "_all.deb") {
+			pkgs = all
+		}
+		if _, ok := pkgs[dir]; !ok {
+			pkgs[dir] = u
+		}
+		if len(arch[dir]) > 0 && len(all[dir]) > 0 {
+			return []string{arch[dir], all[dir]}
+		}
+	}
+	return nil
+}
+
+// ubuntuHeadersURLFromFlavors returns the headers urls, as resolved by resolve,
+// of the first of the given candidate flavors of kr whose headers fully resolve.
+// When none does, the returned URLResolutionError merges the urls tried for all of them;
+// any other error, e.g. a cancellation, is returned as soon as it occurs.
+func ubuntuHeadersURLFromFlavors(flavors []string, kr kernelrelease.KernelRelease, resolve func(kernelrelease.KernelRelease) ([]string, error)) ([]string, error) {
+	notFound := &URLResolutionError{Target: TargetTypeUbuntu}
+	for _, flavor := range flavors {
+		urls, err := resolve(ubuntuFlavoredRelease(kr, flavor))
 		if err == nil && len(urls) == ubuntuRequiredURLs {
-			return urls, err
+			return urls, nil
+		}
+		if err != nil && !errors.Is(err, HeadersNotFoundErr) {
+			return nil, err
+		}
+		var resErr *URLResolutionError
+		if errors.As(err, &resErr) {
+			notFound.Tried = append(notFound.Tried, resErr.Tried...)
+			notFound.Resolved = append(notFound.Resolved, resErr.Resolved...)
+		missing:
+			for _, m := range resErr.Missing {
+				for _, known := range notFound.Missing {
+					if m == known {
+						continue missing
+					}
+				}
+				notFound.Missing = append(notFound.Missing, m)
+			}
 		}
 	}
+	return nil, notFound
+}
 
-	// packages weren't found, return error out
-	return nil, fmt.Errorf("kernel headers not found")
+// ubuntuFlavoredRelease returns kr with its flavor, if any, replaced by the given one.
+// Example: "5.15.0-52-generic" with the "lowlatency" flavor -> "5.15.0-52-lowlatency"
+func ubuntuFlavoredRelease(kr kernelrelease.KernelRelease, flavor string) kernelrelease.KernelRelease {
+	firstExtra, _, _ := parseUbuntuExtraVersion(kr.Extraversion)
+	kr.Extraversion = fmt.Sprintf("%s-%s", firstExtra, flavor)
+	kr.FullExtraversion = "-" + kr.Extraversion
+	return kr
 }
 
-func fetchUbuntuKernelURL(baseURL string, kr kernelrelease.KernelRelease, kernelVersion string) ([]string, error) {
-	// parse the extra number and flavor for the kernelrelease extraversion
-	firstExtra, ubuntuFlavor := parseUbuntuExtraVersion(kr.Extraversion)
+// ubuntuResolvedFlavorRelease returns kr with the first of the given candidate flavors
+// whose arch dependent headers package is among urls, if any.
+func ubuntuResolvedFlavorRelease(flavors []string, kr kernelrelease.KernelRelease, urls []string) (kernelrelease.KernelRelease, bool) {
+	for _, flavor := range flavors {
+		flavored := ubuntuFlavoredRelease(kr, flavor)
+		packagePrefix := fmt.Sprintf("linux-headers-%s%s_", flavored.Fullversion, flavored.FullExtraversion)
+		for _, u := range urls {
+			// hwe kernels ship the generic headers, under their own pool subdir
+			if strings.HasPrefix(path.Base(u), packagePrefix) ||
+				(ubuntuHWEFlavors[flavor] && strings.Contains(u, fmt.Sprintf("/linux-%s/", flavor))) {
+				return flavored, true
+			}
+		}
+	}
+	return kr, false
+}
 
+// ubuntuPoolSubDirs returns the pool subdirs where to look for the headers of kr, whose flavor is the given one,
+// from the most specific one, followed by its variant subdirs, if any.
+func ubuntuPoolSubDirs(kr kernelrelease.KernelRelease, flavor string) ([]string, []string) {
 	// piece together possible subdirs on Ubuntu base URLs for a given flavor
-	// these include the base (such as 'linux-azure') and the base + version/patch ('linux-azure-5.15')
+	// these include the base (such as 'linux-azure') and the base + version/patch ('linux-azure-5.15'),
+	// listed from the most specific one, so that its packages are preferred over the ones of the others
 	// examples:
-	// 		https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux
-	// 		https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-aws
 	// 		https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-azure-5.15
+	// 		https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-aws
+	// 		https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux
 	possibleSubDirs := []string{
-		"linux",                               // default subdir, where generic etc. are stored
-		fmt.Sprintf("linux-%s", ubuntuFlavor), // ex: linux-aws
-		fmt.Sprintf("linux-%s-%d.%d", ubuntuFlavor, kr.Major, kr.Minor), // ex: linux-azure-5.15
+		fmt.Sprintf("linux-%s-%d.%d", flavor, kr.Major, kr.Minor), // ex: linux-azure-5.15
+		fmt.Sprintf("linux-%s", flavor),                           // ex: linux-aws
+		"linux",                                                   // default subdir, where generic etc. are stored
+	}
+
+	// vendor kernels may also be published under the "-edge" and "-fips" variants of the flavor subdir,
+	// tried last, where the common headers package is named after the variant itself
+	// examples:
+	// 		https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-aws-edge/linux-aws-edge-headers-4.15.0-1019_4.15.0-1019.19_all.deb
+	// 		https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-azure-fips/linux-azure-fips-headers-5.4.0-1022_5.4.0-1022.22_all.deb
+	variantSubDirs := ubuntuVariantSubDirs(flavor)
+
+	// the generic-hwe-<release> kernels are published under the hwe pool subdir of their release,
+	// where the common headers package is named after the subdir itself
+	// example:
+	// 		https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-hwe-22.04/linux-hwe-22.04-headers-6.2.0-26_6.2.0-26.26~22.04.1_all.deb
+	if release, ok := ubuntuHWERelease(strings.TrimPrefix(kr.FullExtraversion, "-")); ok {
+		variantSubDirs = []string{fmt.Sprintf("linux-hwe-%s", release)}
+	}
+	return possibleSubDirs, variantSubDirs
+}
+
+func fetchUbuntuKernelURL(baseURL string, kr kernelrelease.KernelRelease, kernelVersion string) ([]string, error) {
+	// parse the extra number and flavor for the kernelrelease extraversion
+	firstExtra, ubuntuFlavor, err := parseUbuntuExtraVersion(kr.Extraversion)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUbuntuKernelVersion(kr, firstExtra, kernelVersion); err != nil {
+		return nil, err
 	}
 
+	possibleSubDirs, variantSubDirs := ubuntuPoolSubDirs(kr, ubuntuFlavor)
+
 	// build all possible full URLs with the flavor subdirs
 	possibleFullURLs := []string{}
 	for _, subdir := range possibleSubDirs {
@@ -153,6 +490,22 @@ func fetchUbuntuKernelURL(baseURL string, kr kernelrelease.KernelRelease, kernel
 			kernelVersion,
 			kr.Architecture.String(),
 		),
+	}
+	if ubuntuHWEFlavors[ubuntuFlavor] {
+		// hwe kernels ship the generic headers
+		// Example: linux-hwe-edge/linux-headers-5.3.0-19-generic_5.3.0-19.20~18.04.2_amd64.deb
+		packageNamePatterns = append(packageNamePatterns,
+			fmt.Sprintf(
+				"linux-headers-%s-%s-generic_%s-%s.%s_%s.deb",
+				kr.Fullversion,
+				firstExtra,
+				kr.Fullversion,
+				firstExtra,
+				kernelVersion,
+				kr.Architecture.String(),
+			))
+	}
+	packageNamePatterns = append(packageNamePatterns,
 		fmt.Sprintf(
 			"linux-%s-headers-%s-%s_%s-%s.%s_all.deb",
 			ubuntuFlavor,
@@ -171,7 +524,7 @@ func fetchUbuntuKernelURL(baseURL string, kr kernelrelease.KernelRelease, kernel
 			kernelVersion,
 			kr.Architecture.String(),
 		),
-	}
+	)
 
 	if ubuntuFlavor == "generic" {
 		packageNamePatterns = append(packageNamePatterns,
@@ -195,54 +548,284 @@ func fetchUbuntuKernelURL(baseURL string, kr kernelrelease.KernelRelease, kernel
 			)
 		}
 	}
+	for _, subdir := range variantSubDirs {
+		variantPackageNamePatterns := append([]string{}, packageNamePatterns...)
+		variantPackageNamePatterns = append(variantPackageNamePatterns,
+			fmt.Sprintf(
+				"%s-headers-%s-%s_%s-%s.%s_all.deb",
+				subdir,
+				kr.Fullversion,
+				firstExtra,
+				kr.Fullversion,
+				firstExtra,
+				kernelVersion,
+			))
+		for _, packageName := range variantPackageNamePatterns {
+			packageFullURLs = append(
+				packageFullURLs,
+				fmt.Sprintf("%s/%s/%s", baseURL, subdir, packageName),
+			)
+		}
+	}
 
 	// return out the deduplicated url list
-	return deduplicateURLs(packageFullURLs), nil
+	return DeduplicateURLs(packageFullURLs), nil
+}
+
+var ubuntuListingHrefRegex = regexp.MustCompile(`href="([^"?/]+\.deb)"`)
+
+// fetchUbuntuKernelURLByListing looks for the headers packages of kr into the HTML listings of the pool subdirs of baseURL,
+// for when their names cannot be guessed, e.g. because of an unexpected kernel version suffix like ~18.04.1.
+// The subdirs are listed from the most specific one, until one of them hosts both the _{arch}.deb package
+// and the _all.deb one of the same version, preferably the given kernel version, the last listed one otherwise.
+func fetchUbuntuKernelURLByListing(ctx context.Context, b *Build, baseURL string, kr kernelrelease.KernelRelease, kernelVersion string) ([]string, error) {
+	firstExtra, flavor, err := parseUbuntuExtraVersion(kr.Extraversion)
+	if err != nil {
+		return nil, err
+	}
+	// hwe kernels ship the generic headers
+	headersFlavor := flavor
+	if ubuntuHWEFlavors[flavor] {
+		headersFlavor = "generic"
+	}
+	release := regexp.QuoteMeta(fmt.Sprintf("%s-%s", kr.Fullversion, firstExtra))
+	archPattern := regexp.MustCompile(fmt.Sprintf(`^linux-headers-%s-%s_%s\.([^_]+)_%s\.deb$`,
+		release, regexp.QuoteMeta(headersFlavor), release, regexp.QuoteMeta(kr.Architecture.String())))
+	// the kernel version may lack the suffix of the package one
+	// Example: 25 for 25~18.04.1
+	isKernelVersion := func(v string) bool {
+		return len(kernelVersion) > 0 && (v == kernelVersion || strings.HasPrefix(v, kernelVersion+"~"))
+	}
+
+	possibleSubDirs, variantSubDirs := ubuntuPoolSubDirs(kr, flavor)
+	client := b.mirrorClient()
+	for _, subdir := range DeduplicateURLs(append(possibleSubDirs, variantSubDirs...)) {
+		dirURL := rewriteURLs(b.URLRewrite, []string{fmt.Sprintf("%s/%s/", baseURL, subdir)})[0]
+		names, err := fetchUbuntuListing(ctx, client, dirURL)
+		if err != nil {
+			b.log().WithError(err).WithField("url", RedactURL(dirURL)).Debug("kernel headers listing not available")
+			continue
+		}
+
+		var archName, version string
+		for _, name := range names {
+			if m := archPattern.FindStringSubmatch(name); m != nil && !isKernelVersion(version) {
+				archName, version = name, m[1]
+			}
+		}
+		if len(archName) == 0 {
+			continue
+		}
+		allPattern := regexp.MustCompile(fmt.Sprintf(`^linux[a-z0-9.-]*-headers-%s_%s\.%s_all\.deb$`, release, release, regexp.QuoteMeta(version)))
+		for _, name := range names {
+			if allPattern.MatchString(name) {
+				b.log().WithField("url", RedactURL(dirURL)).WithField("kernelversion", version).Debug("kernel headers found in the listing")
+				return []string{dirURL + archName, dirURL + name}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("kernel headers not found in the listings of %s", RedactURL(baseURL))
+}
+
+// fetchUbuntuListing returns the names of the packages linked by the HTML listing at u, unescaped.
+func fetchUbuntuListing(ctx context.Context, client *http.Client, u string) ([]string, error) {
+	resp, err := httpGet(ctx, client, u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, m := range ubuntuListingHrefRegex.FindAllStringSubmatch(string(body), -1) {
+		if name, err := url.PathUnescape(m[1]); err == nil {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// ubuntuHWEFlavors are the flavors of the hardware enablement kernels, published under their own pool subdir
+// (i.e. linux-hwe and linux-hwe-edge) with their own ABI progression, but shipping the generic headers.
+var ubuntuHWEFlavors = map[string]bool{
+	"hwe":         true,
+	"hwe-edge":    true,
+	"generic-hwe": true,
 }
 
-// deduplicate the array of URLs to ensure we are
-// only get unique resolving URLs for packages
-func deduplicateURLs(urls []string) []string {
-	keys := make(map[string]bool)
-	dedupURLs := []string{}
+var ubuntuHWEReleaseRegex = regexp.MustCompile(`^\d+-generic-hwe-(\d+\.\d+)$`)
 
-	// loop over the URL list
-	// set a flag for new URLs in list, add to dedup list
-	// do nothing if URL is duplicate
-	for _, url := range urls {
-		if _, value := keys[url]; !value {
-			keys[url] = true
-			dedupURLs = append(dedupURLs, url)
+// ubuntuHWERelease returns the Ubuntu release of the generic-hwe-<release> flavored extraversions,
+// whose flavor is parsed as "generic-hwe".
+// Example: Input -> "26-generic-hwe-22.04", Output -> "22.04", true
+func ubuntuHWERelease(extraversion string) (string, bool) {
+	m := ubuntuHWEReleaseRegex.FindStringSubmatch(extraversion)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// ubuntuRealtimeFlavor tells whether the given flavor is the one of a realtime (PREEMPT_RT) kernel,
+// published under the linux-<flavor> and linux-<flavor>-X.Y pool subdirs.
+// Examples: realtime, intel-iot-realtime
+func ubuntuRealtimeFlavor(flavor string) bool {
+	return flavor == "realtime" || strings.HasSuffix(flavor, "-realtime")
+}
+
+// ubuntuVariantSubDirs returns the "-edge" and "-fips" pool subdirs for the given flavor, if applicable:
+// the generic, hwe and realtime kernels have none (hwe-edge being a flavor by itself),
+// and flavors that already are a variant are covered by the "linux-<flavor>" subdir.
+func ubuntuVariantSubDirs(flavor string) []string {
+	if flavor == "generic" || ubuntuHWEFlavors[flavor] || ubuntuRealtimeFlavor(flavor) {
+		return nil
+	}
+	variants := []string{"edge", "fips"}
+	for _, variant := range variants {
+		if flavor == variant || strings.HasSuffix(flavor, "-"+variant) {
+			return nil
 		}
 	}
-	return dedupURLs
+	subdirs := []string{}
+	for _, variant := range variants {
+		subdirs = append(subdirs, fmt.Sprintf("linux-%s-%s", flavor, variant))
+	}
+	return subdirs
 }
 
+// ubuntuFlavorRegex extracts the flavor from the part of the extraversion following the ABI number.
+// Ubuntu has these named in 3 (known) styles, examples:
+//  1. "generic"
+//  2. "generic-5"
+//  3. "generic-5.15"
+//
+// but some come in with multi-part names, such as "intel-iotg-5.15", which must be handled as well.
+var ubuntuFlavorRegex = regexp.MustCompile(`^([a-z](?:[a-z-]*[a-z])?)-*\d?.*$`)
+
 // parse the extraversion from the kernelrelease to retrieve the extraNumber and flavor
 // assume the flavor is "generic" if unable to parse the flavor
 // Example: Input -> "188-generic", Output -> "188", "generic"
-// NOTE: make sure the kernelrelease passed in appears *exactly* as `uname -r` output
-func parseUbuntuExtraVersion(extraversion string) (string, string) {
-	if strings.Contains(extraversion, "-") {
-		split := strings.Split(extraversion, "-")
-
-		extraNumber := split[0]
-		flavorText := strings.Join(split[1:], "-") // back half of text
-
-		// extract the flavor from the flavorText using a regex
-		// ubuntu has these named in 3 (known) styles, examples:
-		// 		1. "generic"
-		// 		2. "generic-5"
-		// 		3. "generic-5.15"
-		// but some come in with multi-part names, such as:
-		// 		"intel-iotg-5.15"
-		// which must be handled as well - easier to do with regex
-		r, _ := regexp.Compile("^([a-z-]+[a-z])-*\\d?.*$")
-		flavor := r.FindStringSubmatch(flavorText)[1] // match should be second index
-
-		return extraNumber, flavor
-	}
-
-	// if unable to parse a flavor assume "generic" and return back the extraversion passed in
-	return extraversion, "generic"
+// NOTE: make sure the kernelrelease passed in appears *exactly* as `uname -r` output,
+// the malformed ones (e.g. truncated) are an error.
+func parseUbuntuExtraVersion(extraversion string) (string, string, error) {
+	if len(extraversion) == 0 {
+		return "", "", fmt.Errorf("empty ubuntu kernel extraversion, expected <abi>-<flavor>")
+	}
+	extraNumber, flavorText, ok := strings.Cut(extraversion, "-")
+	if !ok {
+		// if unable to parse a flavor assume "generic" and return back the extraversion passed in
+		return extraversion, "generic", nil
+	}
+	if len(extraNumber) == 0 {
+		return "", "", fmt.Errorf("malformed ubuntu kernel extraversion %q, missing the abi number", extraversion)
+	}
+	match := ubuntuFlavorRegex.FindStringSubmatch(flavorText)
+	if match == nil {
+		return "", "", fmt.Errorf("malformed ubuntu kernel extraversion %q, missing the flavor", extraversion)
+	}
+	return extraNumber, match[1], nil
+}
+
+// ValidateUbuntuExtraversion fails when the given extraversion of an ubuntu kernel release, e.g. 52-generic,
+// cannot be parsed into its abi number and flavor, e.g. because the kernel release got truncated.
+func ValidateUbuntuExtraversion(extraversion string) error {
+	_, _, err := parseUbuntuExtraVersion(extraversion)
+	return err
+}
+
+var (
+	// ubuntuUploadRegex matches the upload numbers of the ubuntu kernel packages, e.g. 58 or 25~18.04.1
+	ubuntuUploadRegex = regexp.MustCompile(`^\d+(~[0-9A-Za-z.+]+)?$`)
+	// ubuntuPackageVersionRegex matches the versions of the ubuntu kernel packages, e.g. 5.15.0-52.58
+	ubuntuPackageVersionRegex = regexp.MustCompile(`^(\d+\.\d+\.\d+-\d+)\.(\d+(~[0-9A-Za-z.+]+)?)$`)
+	// ubuntuUnameVersionRegex matches the kernel versions printed by uname -v, e.g. #58-Ubuntu SMP ...
+	ubuntuUnameVersionRegex = regexp.MustCompile(`^#(\d+(~[0-9A-Za-z.+]+)?)-Ubuntu`)
+)
+
+// checkUbuntuKernelVersion fails when the given kernel version cannot be the upload number of the packages of kr,
+// whose abi number is firstExtra, telling the one to pass instead when it can be guessed.
+// Such a kernel version would never match the packages of kr, or match the ones of another kernel out of the listings.
+func checkUbuntuKernelVersion(kr kernelrelease.KernelRelease, firstExtra, kernelVersion string) error {
+	if len(kernelVersion) == 0 || ubuntuUploadRegex.MatchString(kernelVersion) {
+		return nil
+	}
+	release := kr.Fullversion + "-" + firstExtra
+	if m := ubuntuPackageVersionRegex.FindStringSubmatch(kernelVersion); m != nil {
+		if m[1] != release {
+			return fmt.Errorf("kernel version %q is the one of the %s kernel, not of %s", kernelVersion, m[1], kr.Fullversion+kr.FullExtraversion)
+		}
+		return fmt.Errorf("kernel version %q is the version of the package, use its upload number %q", kernelVersion, m[2])
+	}
+	if m := ubuntuUnameVersionRegex.FindStringSubmatch(kernelVersion); m != nil {
+		return fmt.Errorf("kernel version %q is the output of uname -v, use its upload number %q", kernelVersion, m[1])
+	}
+	return fmt.Errorf("kernel version %q is not the upload number of the ubuntu packages, e.g. 58 for the %s.58 ones", kernelVersion, release)
+}
+
+// ubuntuPackagePrefixes are the prefixes of the names of the Ubuntu kernel packages, followed by the kernel release.
+var ubuntuPackagePrefixes = []string{
+	"linux-image-unsigned-",
+	"linux-image-",
+	"linux-headers-",
+	"linux-modules-extra-",
+	"linux-modules-",
+}
+
+// UbuntuPackage is the build key parsed out of the name of an Ubuntu kernel package.
+type UbuntuPackage struct {
+	// KernelRelease is the one reported by `uname -r`, e.g. 5.15.0-52-generic.
+	KernelRelease string
+	// Version is the upstream version, e.g. 5.15.0.
+	Version string
+	// Extra is the ABI number, e.g. 52.
+	Extra  string
+	Flavor string
+	// Architecture is the debian one, e.g. amd64.
+	Architecture kernelrelease.Architecture
+	// KernelVersion is the one following the ABI number into the package version, e.g. 58.
+	KernelVersion string
+}
+
+// ParseUbuntuPackageName parses the build key out of the name of an Ubuntu kernel package,
+// as the inverse of the patterns of fetchUbuntuKernelURL.
+// Example: linux-image-5.15.0-52-generic_5.15.0-52.58_amd64.deb
+func ParseUbuntuPackageName(name string) (*UbuntuPackage, error) {
+	fields := strings.Split(strings.TrimSuffix(path.Base(name), ".deb"), "_")
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("not an ubuntu kernel package name: %s", name)
+	}
+	release := ""
+	for _, prefix := range ubuntuPackagePrefixes {
+		if strings.HasPrefix(fields[0], prefix) {
+			release = strings.TrimPrefix(fields[0], prefix)
+			break
+		}
+	}
+	kr := kernelrelease.FromString(release)
+	if len(kr.Fullversion) == 0 || !strings.Contains(kr.Extraversion, "-") {
+		return nil, fmt.Errorf("not an ubuntu kernel package name: %s", name)
+	}
+	extra, flavor, err := parseUbuntuExtraVersion(kr.Extraversion)
+	if err != nil {
+		return nil, fmt.Errorf("not an ubuntu kernel package name: %s: %w", name, err)
+	}
+
+	// the package version is <version>-<extra>.<kernelversion>
+	kv := strings.TrimPrefix(fields[1], fmt.Sprintf("%s-%s.", kr.Fullversion, extra))
+	if kv == fields[1] || len(kv) == 0 {
+		return nil, fmt.Errorf("unexpected version %s of the ubuntu kernel package %s", fields[1], name)
+	}
+	return &UbuntuPackage{
+		KernelRelease: release,
+		Version:       kr.Fullversion,
+		Extra:         extra,
+		Flavor:        flavor,
+		Architecture:  kernelrelease.Architecture(fields[2]),
+		KernelVersion: kv,
+	}, nil
 }
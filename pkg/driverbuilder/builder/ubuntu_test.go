@@ -1,11 +1,24 @@
 package builder
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/blang/semver"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder/buildertest"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
@@ -43,13 +56,13 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188_4.15.0-188.199_all.deb"},
-			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188_4.15.0-188.199_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-generic-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188_4.15.0-188.199_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-generic-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188_4.15.0-188.199_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-generic-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188_4.15.0-188.199_all.deb"},
+			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188_4.15.0-188.199_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-generic-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-4.15/linux-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188_4.15.0-188.199_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-generic-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188_4.15.0-188.199_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188-generic_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-generic-headers-4.15.0-188_4.15.0-188.199_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188_4.15.0-188.199_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-4.15.0-188_4.15.0-188.199_all.deb"},
 			gccVersion: semver.Version{
 				Major: 8,
 			},
 			firstExtra: "188",
 			flavor:     "generic",
-			err:        fmt.Errorf("kernel headers not found"),
+			err:        HeadersNotFoundErr,
 		},
 	},
 	{
@@ -74,7 +87,7 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{"http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-aws-headers-4.15.0-1140_4.15.0-1140.151_all.deb"},
-			urls:        []string{"http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux/linux-aws-headers-4.15.0-1140_4.15.0-1140.151_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-aws-headers-4.15.0-1140_4.15.0-1140.151_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-4.15/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-4.15/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-4.15/linux-aws-headers-4.15.0-1140_4.15.0-1140.151_all.deb"},
+			urls:        []string{"http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-4.15/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-4.15/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-4.15/linux-aws-headers-4.15.0-1140_4.15.0-1140.151_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws/linux-aws-headers-4.15.0-1140_4.15.0-1140.151_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux/linux-aws-headers-4.15.0-1140_4.15.0-1140.151_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-edge/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-edge/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-edge/linux-aws-headers-4.15.0-1140_4.15.0-1140.151_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-edge/linux-aws-edge-headers-4.15.0-1140_4.15.0-1140.151_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-fips/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-fips/linux-headers-4.15.0-1140-aws_4.15.0-1140.151_arm64.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-fips/linux-aws-headers-4.15.0-1140_4.15.0-1140.151_all.deb", "http://ports.ubuntu.com/ubuntu-ports/pool/main/l/linux-aws-fips/linux-aws-fips-headers-4.15.0-1140_4.15.0-1140.151_all.deb"},
 			gccVersion: semver.Version{
 				Major: 8,
 			},
@@ -105,7 +118,7 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb"},
-			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-5.15/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-5.15/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-5.15/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb"},
+			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-5.15/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-5.15/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-5.15/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-edge/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-edge/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-edge/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-edge/linux-intel-iotg-edge-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-fips/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-fips/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-fips/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg-fips/linux-intel-iotg-fips-headers-5.15.0-1004_5.15.0-1004.6_all.deb"},
 			gccVersion: semver.Version{
 				Major: 11,
 			},
@@ -136,13 +149,13 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{},
-			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb"},
+			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-5.15/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-edge/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-edge/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-edge/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-edge/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-edge/linux-lowlatency-hwe-edge-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-fips/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-fips/linux-headers-5.15.0-24-lowlatency-hwe_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-fips/linux-lowlatency-hwe-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-fips/linux-headers-5.15.0-24-lowlatency-hwe-5.15_5.15.0-24.24~20.04.3_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lowlatency-hwe-fips/linux-lowlatency-hwe-fips-headers-5.15.0-24_5.15.0-24.24~20.04.3_all.deb"},
 			gccVersion: semver.Version{
 				Major: 11,
 			},
 			firstExtra: "24",
 			flavor:     "lowlatency-hwe",
-			err:        fmt.Errorf("kernel headers not found"),
+			err:        HeadersNotFoundErr,
 		},
 	},
 	{
@@ -167,7 +180,7 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100-generic_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100_3.13.0-100.147_all.deb"},
-			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100_3.13.0-100.147_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100-generic_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-generic-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100_3.13.0-100.147_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100-generic_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-generic-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100_3.13.0-100.147_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100-generic_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-generic-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100_3.13.0-100.147_all.deb"},
+			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100_3.13.0-100.147_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100-generic_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-generic-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic-3.13/linux-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100_3.13.0-100.147_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100-generic_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-generic-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-generic/linux-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100_3.13.0-100.147_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100-generic_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-generic-headers-3.13.0-100_3.13.0-100.147_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100_3.13.0-100.147_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.13.0-100_3.13.0-100.147_all.deb"},
 			gccVersion: semver.Version{
 				Major: 4,
 				Minor: 8,
@@ -199,7 +212,7 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{},
-			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-3.16/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-3.16/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-3.16/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb"},
+			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-3.16/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-3.16/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-3.16/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-edge/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-edge/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-edge/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-edge/linux-lts-utopic-edge-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-fips/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-fips/linux-headers-3.16.0-38-lts-utopic_3.16.0-38.52~14.04.1_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-fips/linux-lts-utopic-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-lts-utopic-fips/linux-lts-utopic-fips-headers-3.16.0-38_3.16.0-38.52~14.04.1_all.deb"},
 			gccVersion: semver.Version{
 				Major: 6,
 			},
@@ -230,7 +243,7 @@ var tests = []struct {
 			err         error
 		}{
 			headersURLs: []string{},
-			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-kvm-headers-5.19.0-1006_5.19.0-1006.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-kvm-headers-5.19.0-1006_5.19.0-1006.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-5.19/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-5.19/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-5.19/linux-kvm-headers-5.19.0-1006_5.19.0-1006.6_all.deb"},
+			urls:        []string{"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-5.19/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-5.19/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-5.19/linux-kvm-headers-5.19.0-1006_5.19.0-1006.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm/linux-kvm-headers-5.19.0-1006_5.19.0-1006.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux/linux-kvm-headers-5.19.0-1006_5.19.0-1006.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-edge/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-edge/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-edge/linux-kvm-headers-5.19.0-1006_5.19.0-1006.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-edge/linux-kvm-edge-headers-5.19.0-1006_5.19.0-1006.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-fips/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-fips/linux-headers-5.19.0-1006-kvm_5.19.0-1006.6_amd64.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-fips/linux-kvm-headers-5.19.0-1006_5.19.0-1006.6_all.deb", "https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-kvm-fips/linux-kvm-fips-headers-5.19.0-1006_5.19.0-1006.6_all.deb"},
 			gccVersion: semver.Version{
 				Major: 12,
 			},
@@ -254,12 +267,21 @@ func TestUbuntuHeadersURLFromRelease(t *testing.T) {
 			test.kernelversion,
 		}
 
+		// serve the expected packages only
+		mirror := buildertest.NewMirror()
+		for _, u := range expected {
+			parsed, err := url.Parse(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mirror.Packages(parsed.Path)
+		}
+		b := &Build{URLResolver: NewURLResolverWithClient(mirror.Client())}
+
 		// call function
-		gotURLs, err := ubuntuHeadersURLFromRelease(input.config, input.kv)
+		gotURLs, err := ubuntuHeadersURLFromRelease(context.Background(), b, input.config, input.kv)
 		// compare errors
-		// there are no official errors, so comparing fmt.Errorf() doesn't really work
-		// compare error message text instead
-		if err != nil && test.expected.err != nil && err.Error() != test.expected.err.Error() {
+		if err != nil && test.expected.err != nil && !errors.Is(err, test.expected.err) {
 			t.Fatalf("Unexpected error encountered with Test Input: '%v' | Error: '%s'", input, err)
 		}
 
@@ -330,7 +352,10 @@ func TestFetchUbuntuKernelURL(t *testing.T) {
 func TestParseUbuntuExtraVersion(t *testing.T) {
 	for _, test := range tests {
 		input := test.config.Extraversion
-		gotFirstExtra, gotFlavor := parseUbuntuExtraVersion(input)
+		gotFirstExtra, gotFlavor, err := parseUbuntuExtraVersion(input)
+		if err != nil {
+			t.Errorf("Test Input: [ '%s' ] | Unexpected error: %s", input, err)
+		}
 		if gotFirstExtra != test.expected.firstExtra {
 			t.Errorf(
 				"Test Input: [ '%s' ] | Got: [ '%s' ] / Want: [ '%s' ]",
@@ -349,3 +374,1280 @@ func TestParseUbuntuExtraVersion(t *testing.T) {
 		}
 	}
 }
+
+func TestUbuntuTemplateContainerWorkDir(t *testing.T) {
+	kr := kernelrelease.FromString("5.15.0-1004-intel-iotg")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	urls := []string{
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb",
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb",
+	}
+
+	for _, test := range []struct {
+		workDir  string
+		expected string
+	}{
+		{"", "/tmp"},
+		{"/mnt/scratch", "/mnt/scratch"},
+		{"/mnt/scratch/", "/mnt/scratch"},
+	} {
+		c := newTestConfig(TargetTypeUbuntu)
+		c.ContainerWorkDir = test.workDir
+
+		script, err := renderScript(&ubuntu{}, c, kr, urls)
+		if err != nil {
+			t.Fatalf("Unexpected error rendering template with work dir '%s': %s", test.workDir, err)
+		}
+		for _, cmd := range []string{
+			"mkdir -p " + test.expected + "/module-download",
+			"tar -xzf - -C " + test.expected + "/module-download",
+			"mkdir " + test.expected + "/kernel-download",
+			"cd " + test.expected + "/kernel-download/usr/src/",
+		} {
+			if !strings.Contains(script, cmd) {
+				t.Errorf("Rendered template for work dir '%s' does not contain '%s'", test.workDir, cmd)
+			}
+		}
+		if test.expected != "/tmp" && strings.Contains(script, "/tmp/kernel-download") {
+			t.Errorf("Rendered template for work dir '%s' still uses the default download directory", test.workDir)
+		}
+	}
+}
+
+// ubuntuFixtureBaseURL is the base url of the pool of the buildertest mirrors.
+const ubuntuFixtureBaseURL = "https://mirror.example.com"
+
+// ubuntuFixtureURLs returns the urls of the given pool paths, served by a buildertest mirror.
+func ubuntuFixtureURLs(paths ...string) []string {
+	urls := make([]string, 0, len(paths))
+	for _, p := range paths {
+		urls = append(urls, ubuntuFixtureBaseURL+p)
+	}
+	return urls
+}
+
+// newUbuntuFixtureMirror returns a mirror serving only the given pool paths.
+func newUbuntuFixtureMirror(paths ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range paths {
+			if r.URL.Path == p {
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+func TestFetchUbuntuKernelURLVariants(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		kernelrelease string
+		kernelversion string
+		packages      []string
+	}{
+		{
+			name:          "aws-edge",
+			kernelrelease: "4.15.0-1019-aws",
+			kernelversion: "19",
+			packages: []string{
+				"/linux-aws-edge/linux-headers-4.15.0-1019-aws_4.15.0-1019.19_amd64.deb",
+				"/linux-aws-edge/linux-aws-edge-headers-4.15.0-1019_4.15.0-1019.19_all.deb",
+			},
+		},
+		{
+			name:          "azure-fips",
+			kernelrelease: "5.4.0-1022-azure",
+			kernelversion: "22",
+			packages: []string{
+				"/linux-azure-fips/linux-headers-5.4.0-1022-azure_5.4.0-1022.22_amd64.deb",
+				"/linux-azure-fips/linux-azure-fips-headers-5.4.0-1022_5.4.0-1022.22_all.deb",
+			},
+		},
+		{
+			name:          "gcp",
+			kernelrelease: "5.15.0-1021-gcp",
+			kernelversion: "28",
+			packages: []string{
+				"/linux-gcp/linux-headers-5.15.0-1021-gcp_5.15.0-1021.28_amd64.deb",
+				"/linux-gcp/linux-gcp-headers-5.15.0-1021_5.15.0-1021.28_all.deb",
+			},
+		},
+		{
+			name:          "aws-fips-flavor",
+			kernelrelease: "5.15.0-1009-aws-fips",
+			kernelversion: "9",
+			packages: []string{
+				"/linux-aws-fips/linux-headers-5.15.0-1009-aws-fips_5.15.0-1009.9_amd64.deb",
+				"/linux-aws-fips/linux-aws-fips-headers-5.15.0-1009_5.15.0-1009.9_all.deb",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := &Build{URLResolver: NewURLResolverWithClient(buildertest.NewMirror().Packages(test.packages...).Client())}
+
+			kr := kernelrelease.FromString(test.kernelrelease)
+			kr.Architecture = kernelrelease.ArchitectureAmd64
+
+			possibleURLs, err := fetchUbuntuKernelURL(ubuntuFixtureBaseURL, kr, test.kernelversion)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			gotURLs, err := getResolvingURLs(context.Background(), b, possibleURLs)
+			buildertest.AssertURLs(t, gotURLs, err, ubuntuFixtureURLs(test.packages...))
+		})
+	}
+}
+
+func TestUbuntuFIPSFlavor(t *testing.T) {
+	kr := kernelrelease.FromString("5.15.0-1009-aws-fips")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+
+	extraNumber, flavor, _ := parseUbuntuExtraVersion(kr.Extraversion)
+	if extraNumber != "1009" || flavor != "aws-fips" {
+		t.Fatalf("Expected 1009 and aws-fips, got %s and %s", extraNumber, flavor)
+	}
+	// the fips flavors are published under their own subdir, not under the variants of it
+	if subdirs := ubuntuVariantSubDirs(flavor); len(subdirs) != 0 {
+		t.Errorf("Expected no variant subdirs, got %v", subdirs)
+	}
+
+	urls, err := fetchUbuntuKernelURL("https://mirrors.edge.kernel.org/ubuntu/pool/main/l", kr, "9")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	subdirs := map[string]bool{}
+	for _, u := range urls {
+		subdirs[path.Base(path.Dir(u))] = true
+	}
+	if !reflect.DeepEqual(subdirs, map[string]bool{"linux": true, "linux-aws-fips": true, "linux-aws-fips-5.15": true}) {
+		t.Errorf("Unexpected subdirs: %v", subdirs)
+	}
+	for _, want := range []string{
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-aws-fips/linux-headers-5.15.0-1009-aws-fips_5.15.0-1009.9_amd64.deb",
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-aws-fips/linux-aws-fips-headers-5.15.0-1009_5.15.0-1009.9_all.deb",
+	} {
+		var found bool
+		for _, u := range urls {
+			found = found || u == want
+		}
+		if !found {
+			t.Errorf("Expected %s among the candidates %v", want, urls)
+		}
+	}
+}
+
+func TestUbuntuRealtimeFlavor(t *testing.T) {
+	kr := kernelrelease.FromString("5.15.0-1004-realtime")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+
+	extraNumber, flavor, _ := parseUbuntuExtraVersion(kr.Extraversion)
+	if extraNumber != "1004" || flavor != "realtime" {
+		t.Fatalf("Expected 1004 and realtime, got %s and %s", extraNumber, flavor)
+	}
+	// the realtime kernels have no edge nor fips variants
+	if subdirs := ubuntuVariantSubDirs(flavor); len(subdirs) != 0 {
+		t.Errorf("Expected no variant subdirs, got %v", subdirs)
+	}
+
+	packages := []string{
+		"/linux-realtime/linux-headers-5.15.0-1004-realtime_5.15.0-1004.4_amd64.deb",
+		"/linux-realtime/linux-realtime-headers-5.15.0-1004_5.15.0-1004.4_all.deb",
+	}
+	b := &Build{
+		Mirrors:     []string{ubuntuFixtureBaseURL},
+		URLResolver: NewURLResolverWithClient(buildertest.NewMirror().Packages(packages...).Client()),
+	}
+	urls, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "4")
+	buildertest.AssertURLs(t, urls, err, ubuntuFixtureURLs(packages...))
+
+	c := newTestConfig(TargetTypeUbuntu)
+	td := (&ubuntu{}).TemplateData(c, kr, urls).(ubuntuTemplateData)
+	if td.KernelHeadersPattern != "linux-headers*realtime*" || td.KernelLocalVersion != "-1004-realtime" {
+		t.Fatalf("Unexpected template data: headers pattern '%s', local version '%s'", td.KernelHeadersPattern, td.KernelLocalVersion)
+	}
+	script, err := renderScript(&ubuntu{}, c, kr, urls)
+	if err != nil {
+		t.Fatalf("Unexpected error rendering the template: %s", err)
+	}
+	if !strings.Contains(script, "linux-headers*realtime*") {
+		t.Errorf("Expected the realtime headers to be looked for, got:\n%s", script)
+	}
+}
+
+func TestUbuntuBaseURLsPreferSecurityMirror(t *testing.T) {
+	defaultTracker := mirrorLatencies
+	mirrorLatencies = newLatencyTracker()
+	defer func() { mirrorLatencies = defaultTracker }()
+
+	for _, test := range []struct {
+		arch           kernelrelease.Architecture
+		preferSecurity bool
+		expected       []string
+	}{
+		{kernelrelease.ArchitectureAmd64, false, []string{ubuntuEdgeMirror, ubuntuSecurityMirror}},
+		{kernelrelease.ArchitectureAmd64, true, []string{ubuntuSecurityMirror, ubuntuEdgeMirror}},
+		{kernelrelease.ArchitectureArm64, false, []string{ubuntuPortsMirror}},
+		{kernelrelease.ArchitectureArm64, true, []string{ubuntuPortsMirror}},
+	} {
+		kr := kernelrelease.FromString("5.15.0-1004-intel-iotg")
+		kr.Architecture = test.arch
+		b := &Build{PreferSecurityMirror: test.preferSecurity}
+
+		got := ubuntuBaseURLs(b, kr)
+		if len(got) != len(test.expected) {
+			t.Fatalf("Slice sizes don't match! Arch: '%s', prefer security: %t | Got: '%v' / Want: '%v'", test.arch, test.preferSecurity, got, test.expected)
+		}
+		for i, v := range got {
+			if v != test.expected[i] {
+				t.Fatalf("Slice values don't match! Arch: '%s', prefer security: %t | Got: '%v' / Want: '%v'", test.arch, test.preferSecurity, got, test.expected)
+			}
+		}
+	}
+}
+
+func TestUbuntuBaseURLsByArch(t *testing.T) {
+	defaultTracker := mirrorLatencies
+	mirrorLatencies = newLatencyTracker()
+	defer func() { mirrorLatencies = defaultTracker }()
+
+	for arch, expected := range map[kernelrelease.Architecture][]string{
+		kernelrelease.ArchitectureAmd64: {ubuntuEdgeMirror, ubuntuSecurityMirror},
+		kernelrelease.ArchitectureArm64: {ubuntuPortsMirror},
+		"riscv64":                       {ubuntuPortsMirror},
+		"s390x":                         {ubuntuPortsMirror},
+		"unknown":                       {ubuntuPortsMirror},
+	} {
+		kr := kernelrelease.FromString("5.15.0-52-generic")
+		kr.Architecture = arch
+
+		got := ubuntuBaseURLs(nil, kr)
+		if strings.Join(got, ",") != strings.Join(expected, ",") {
+			t.Fatalf("Unexpected mirrors! Arch: '%s' | Got: '%v' / Want: '%v'", arch, got, expected)
+		}
+	}
+}
+
+func TestUbuntuTemplateParallelArtifacts(t *testing.T) {
+	kr := kernelrelease.FromString("5.15.0-1004-intel-iotg")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	urls := []string{
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb",
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-intel-iotg/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb",
+	}
+	parallelCmds := []string{
+		") > /tmp/module-build.log 2>&1 &\nmodule_pid=$!\n",
+		") > /tmp/probe-build.log 2>&1 &\nprobe_pid=$!\n",
+		"wait $module_pid || status=$?\n",
+		"wait $probe_pid || status=$?\n",
+	}
+
+	for _, test := range []struct {
+		parallel bool
+		probe    bool
+		expected bool
+	}{
+		{parallel: false, probe: true, expected: false},
+		{parallel: true, probe: true, expected: true},
+		// nothing to parallelize with the module only
+		{parallel: true, probe: false, expected: false},
+	} {
+		c := newTestConfig(TargetTypeUbuntu)
+		c.ParallelArtifacts = test.parallel
+		if !test.probe {
+			c.ProbeFilePath = ""
+		}
+
+		script, err := renderScript(&ubuntu{}, c, kr, urls)
+		if err != nil {
+			t.Fatalf("Unexpected error rendering template: %s", err)
+		}
+		for _, cmd := range parallelCmds {
+			if strings.Contains(script, cmd) != test.expected {
+				t.Errorf("Rendered template with parallel: %t, probe: %t contains '%s': %t / Want: %t", test.parallel, test.probe, cmd, !test.expected, test.expected)
+			}
+		}
+		if !strings.Contains(script, "make CC=/usr/bin/gcc-") {
+			t.Errorf("Rendered template with parallel: %t, probe: %t does not build the module", test.parallel, test.probe)
+		}
+	}
+}
+
+func TestFetchUbuntuKernelURLHWE(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		kernelrelease  string
+		kernelversion  string
+		packages       []string
+		headersPattern string
+	}{
+		{
+			name:          "hwe",
+			kernelrelease: "4.18.0-24-hwe",
+			kernelversion: "25~18.04.1",
+			packages: []string{
+				"/linux-hwe/linux-headers-4.18.0-24-generic_4.18.0-24.25~18.04.1_amd64.deb",
+				"/linux-hwe/linux-hwe-headers-4.18.0-24_4.18.0-24.25~18.04.1_all.deb",
+			},
+			headersPattern: "linux-headers*generic",
+		},
+		{
+			name:          "hwe-edge",
+			kernelrelease: "5.3.0-19-hwe-edge",
+			kernelversion: "20~18.04.2",
+			packages: []string{
+				"/linux-hwe-edge/linux-headers-5.3.0-19-generic_5.3.0-19.20~18.04.2_amd64.deb",
+				"/linux-hwe-edge/linux-hwe-edge-headers-5.3.0-19_5.3.0-19.20~18.04.2_all.deb",
+			},
+			headersPattern: "linux-headers*generic",
+		},
+		{
+			name:          "generic-hwe-22.04",
+			kernelrelease: "6.2.0-26-generic-hwe-22.04",
+			kernelversion: "26~22.04.1",
+			packages: []string{
+				"/linux-hwe-22.04/linux-headers-6.2.0-26-generic_6.2.0-26.26~22.04.1_amd64.deb",
+				"/linux-hwe-22.04/linux-hwe-22.04-headers-6.2.0-26_6.2.0-26.26~22.04.1_all.deb",
+			},
+			headersPattern: "linux-headers*generic",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// both the hwe and hwe-edge pools are served, each kernel must resolve in its own one
+			mirror := buildertest.NewMirror().Packages(
+				"/linux-hwe/linux-headers-4.18.0-24-generic_4.18.0-24.25~18.04.1_amd64.deb",
+				"/linux-hwe/linux-hwe-headers-4.18.0-24_4.18.0-24.25~18.04.1_all.deb",
+				"/linux-hwe-edge/linux-headers-4.18.0-24-generic_4.18.0-24.25~18.04.1_amd64.deb",
+				"/linux-hwe-edge/linux-hwe-edge-headers-4.18.0-24_4.18.0-24.25~18.04.1_all.deb",
+				"/linux-hwe-edge/linux-headers-5.3.0-19-generic_5.3.0-19.20~18.04.2_amd64.deb",
+				"/linux-hwe-edge/linux-hwe-edge-headers-5.3.0-19_5.3.0-19.20~18.04.2_all.deb",
+				"/linux-hwe-22.04/linux-headers-6.2.0-26-generic_6.2.0-26.26~22.04.1_amd64.deb",
+				"/linux-hwe-22.04/linux-hwe-22.04-headers-6.2.0-26_6.2.0-26.26~22.04.1_all.deb",
+			)
+			b := &Build{URLResolver: NewURLResolverWithClient(mirror.Client())}
+
+			kr := kernelrelease.FromString(test.kernelrelease)
+			kr.Architecture = kernelrelease.ArchitectureAmd64
+
+			possibleURLs, err := fetchUbuntuKernelURL(ubuntuFixtureBaseURL, kr, test.kernelversion)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			gotURLs, err := getResolvingURLs(context.Background(), b, possibleURLs)
+			buildertest.AssertURLs(t, gotURLs, err, ubuntuFixtureURLs(test.packages...))
+
+			td := (&ubuntu{}).TemplateData(newTestConfig(TargetTypeUbuntu), kr, gotURLs).(ubuntuTemplateData)
+			if td.KernelHeadersPattern != test.headersPattern {
+				t.Fatalf("Headers pattern doesn't match! Got: '%s' / Want: '%s'", td.KernelHeadersPattern, test.headersPattern)
+			}
+		})
+	}
+}
+
+func TestUbuntuHeadersURLFromFlavors(t *testing.T) {
+	// the generic flavor only has its common package, the lowlatency one has both
+	packages := []string{
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+		"/linux-lowlatency/linux-headers-5.15.0-52-lowlatency_5.15.0-52.58_amd64.deb",
+		"/linux-lowlatency/linux-lowlatency-headers-5.15.0-52_5.15.0-52.58_all.deb",
+	}
+	b := &Build{URLResolver: NewURLResolverWithClient(buildertest.NewMirror().Packages(packages...).Client())}
+
+	kr := kernelrelease.FromString("5.15.0-52")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+
+	tried := []string{}
+	gotURLs, err := ubuntuHeadersURLFromFlavors([]string{"generic", "lowlatency"}, kr, func(flavored kernelrelease.KernelRelease) ([]string, error) {
+		tried = append(tried, flavored.Extraversion)
+		possibleURLs, err := fetchUbuntuKernelURL(ubuntuFixtureBaseURL, flavored, "58")
+		if err != nil {
+			return nil, err
+		}
+		return getResolvingURLs(context.Background(), b, possibleURLs)
+	})
+	buildertest.AssertURLs(t, gotURLs, err, ubuntuFixtureURLs(packages[1], packages[2]))
+	if strings.Join(tried, ",") != "52-generic,52-lowlatency" {
+		t.Fatalf("Unexpected flavors tried: '%v'", tried)
+	}
+
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelFlavors = []string{"generic", "lowlatency"}
+	td := (&ubuntu{}).TemplateData(c, kr, gotURLs).(ubuntuTemplateData)
+	if td.KernelLocalVersion != "-52-lowlatency" {
+		t.Fatalf("Local version doesn't match! Got: '%s' / Want: '-52-lowlatency'", td.KernelLocalVersion)
+	}
+	if td.KernelHeadersPattern != "linux-headers*lowlatency*" {
+		t.Fatalf("Headers pattern doesn't match! Got: '%s' / Want: 'linux-headers*lowlatency*'", td.KernelHeadersPattern)
+	}
+
+	// the probes of all the flavors are kept when none resolves, the miss being cacheable
+	kr = kernelrelease.FromString("5.15.0-53")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	var candidates []string
+	_, err = ubuntuHeadersURLFromFlavors([]string{"generic", "aws"}, kr, func(flavored kernelrelease.KernelRelease) ([]string, error) {
+		possibleURLs, err := fetchUbuntuKernelURL(ubuntuFixtureBaseURL, flavored, "58")
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, possibleURLs...)
+		return getResolvingURLs(context.Background(), b, possibleURLs)
+	})
+	if !errors.Is(err, HeadersNotFoundErr) {
+		t.Fatalf("Expected the headers not to be found, got %v", err)
+	}
+	var resErr *URLResolutionError
+	if !errors.As(err, &resErr) {
+		t.Fatalf("Expected an URLResolutionError, got %T", err)
+	}
+	if len(resErr.Tried) != len(candidates) {
+		t.Fatalf("Expected the %d candidates of both flavors to be tried, got %d", len(candidates), len(resErr.Tried))
+	}
+
+	// other errors are not hidden behind the missing headers
+	_, err = ubuntuHeadersURLFromFlavors([]string{"generic", "lowlatency"}, kr, func(flavored kernelrelease.KernelRelease) ([]string, error) {
+		return nil, context.Canceled
+	})
+	if !errors.Is(err, context.Canceled) || errors.Is(err, HeadersNotFoundErr) {
+		t.Fatalf("Expected the cancellation to be returned, got %v", err)
+	}
+}
+
+func TestUbuntuFlavorFallbackGeneric(t *testing.T) {
+	// only the generic flavor is available
+	packages := []string{
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+	}
+	b := &Build{URLResolver: NewURLResolverWithClient(buildertest.NewMirror().Packages(packages...).Client())}
+
+	kr := kernelrelease.FromString("5.15.0-52-aws")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	resolve := func(flavored kernelrelease.KernelRelease) ([]string, error) {
+		possibleURLs, err := fetchUbuntuKernelURL(ubuntuFixtureBaseURL, flavored, "58")
+		if err != nil {
+			return nil, err
+		}
+		return getResolvingURLs(context.Background(), b, possibleURLs)
+	}
+
+	c := newTestConfig(TargetTypeUbuntu)
+	if flavors := ubuntuCandidateFlavors(c, kr); len(flavors) != 0 {
+		t.Fatalf("Unexpected candidate flavors without the fallback: '%v'", flavors)
+	}
+	if urls, err := resolve(kr); err == nil {
+		t.Fatalf("Expected the aws flavor not to resolve, got: '%v'", urls)
+	}
+
+	c.FlavorFallbackGeneric = true
+	flavors := ubuntuCandidateFlavors(c, kr)
+	if strings.Join(flavors, ",") != "aws,generic" {
+		t.Fatalf("Unexpected candidate flavors: '%v'", flavors)
+	}
+	gotURLs, err := ubuntuHeadersURLFromFlavors(flavors, kr, resolve)
+	buildertest.AssertURLs(t, gotURLs, err, ubuntuFixtureURLs(packages...))
+
+	// the generic headers are used to build for the aws kernel release
+	td := (&ubuntu{}).TemplateData(c, kr, gotURLs).(ubuntuTemplateData)
+	if td.KernelHeadersPattern != "linux-headers*generic*" {
+		t.Fatalf("Headers pattern doesn't match! Got: '%s' / Want: 'linux-headers*generic*'", td.KernelHeadersPattern)
+	}
+	if td.HeadersRelease != "5.15.0-52-generic" || td.UTSRelease != "5.15.0-52-aws" {
+		t.Fatalf("Unexpected releases: headers '%s', uts '%s'", td.HeadersRelease, td.UTSRelease)
+	}
+	script, err := renderScript(&ubuntu{}, c, kr, gotURLs)
+	if err != nil {
+		t.Fatalf("Unexpected error rendering the template: %s", err)
+	}
+	if !strings.Contains(script, `sed -i 's/"5.15.0-52-generic"/"5.15.0-52-aws"/' $sourcedir/include/generated/utsrelease.h`) {
+		t.Fatalf("Rendered template does not build for the requested kernel release:\n%s", script)
+	}
+
+	// neither the aws flavor nor the generic one resolves: the urls tried for both are reported
+	kr = kernelrelease.FromString("5.15.0-53-aws")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	var candidates []string
+	_, err = ubuntuHeadersURLFromFlavors(ubuntuCandidateFlavors(c, kr), kr, func(flavored kernelrelease.KernelRelease) ([]string, error) {
+		possibleURLs, err := fetchUbuntuKernelURL(ubuntuFixtureBaseURL, flavored, "58")
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, possibleURLs...)
+		return getResolvingURLs(context.Background(), b, possibleURLs)
+	})
+	var resErr *URLResolutionError
+	if !errors.Is(err, HeadersNotFoundErr) || !errors.As(err, &resErr) {
+		t.Fatalf("Expected an URLResolutionError, got %v", err)
+	}
+	tried := make([]string, len(resErr.Tried))
+	for i, p := range resErr.Tried {
+		tried[i] = p.URL
+	}
+	if strings.Join(tried, ",") != strings.Join(candidates, ",") {
+		t.Fatalf("Expected the candidates of both flavors to be tried, got %v", tried)
+	}
+	if !strings.Contains(strings.Join(tried, ","), "linux-headers-5.15.0-53-aws_") || !strings.Contains(strings.Join(tried, ","), "linux-headers-5.15.0-53-generic_") {
+		t.Fatalf("Expected the aws and generic packages to be tried, got %v", tried)
+	}
+}
+
+func TestUbuntuPackageKernelVersionMismatch(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	packages := []string{
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.59_all.deb",
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.59_amd64.deb",
+	}
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelRelease = "5.15.0-52-generic"
+	c.KernelVersion = "58"
+	c.KernelUrls = ubuntuFixtureURLs(packages...)
+	c.URLResolver = NewURLResolverWithClient(buildertest.NewMirror().Packages(packages...).Client())
+
+	// a package resolved for another kernel version triggers a warning
+	urls, err := KernelURLs(context.Background(), BuilderByTarget[TargetTypeUbuntu], c, kr)
+	if err != nil || len(urls) != len(packages) {
+		t.Fatalf("Expected a warning only, got %v (%v)", err, urls)
+	}
+	var warned []string
+	for _, e := range hook.AllEntries() {
+		if e.Level == logrus.WarnLevel {
+			warned = append(warned, fmt.Sprint(e.Data["package_kernelversion"]))
+		}
+	}
+	if len(warned) != len(packages) || warned[0] != "59" {
+		t.Errorf("Expected a kernel version mismatch warning for each package, got %v", warned)
+	}
+
+	// and fails the build when strict
+	c.StrictKernelVersion = true
+	if _, err := KernelURLs(context.Background(), BuilderByTarget[TargetTypeUbuntu], c, kr); err == nil || !strings.Contains(err.Error(), "kernel version 59, not 58") {
+		t.Errorf("Expected a kernel version mismatch error, got %v", err)
+	}
+
+	// while matching packages pass silently
+	hook.Reset()
+	c.KernelVersion = "59"
+	if _, err := KernelURLs(context.Background(), BuilderByTarget[TargetTypeUbuntu], c, kr); err != nil || len(hook.AllEntries()) != 0 {
+		t.Errorf("Expected no mismatch, got %v (%v)", err, hook.AllEntries())
+	}
+}
+
+func TestUbuntuArm64ExtraMirrors(t *testing.T) {
+	defaultTracker := mirrorLatencies
+	mirrorLatencies = newLatencyTracker()
+	defer func() { mirrorLatencies = defaultTracker }()
+
+	// ports misses the headers, the secondary host has them
+	ports := newUbuntuFixtureMirror()
+	defer ports.Close()
+	secondary := newUbuntuFixtureMirror(
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_arm64.deb",
+	)
+	defer secondary.Close()
+
+	defaultMirrors := ubuntuMirrorsByArch[kernelrelease.ArchitectureArm64]
+	ubuntuMirrorsByArch[kernelrelease.ArchitectureArm64] = []string{ports.URL}
+	defer func() { ubuntuMirrorsByArch[kernelrelease.ArchitectureArm64] = defaultMirrors }()
+
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureArm64
+	b := &Build{
+		ExtraMirrors: map[string][]string{
+			kernelrelease.ArchitectureArm64: {secondary.URL},
+			kernelrelease.ArchitectureAmd64: {"http://amd64.example.com"},
+		},
+	}
+
+	if got := ubuntuBaseURLs(b, kr); !reflect.DeepEqual(got, []string{ports.URL, secondary.URL}) {
+		t.Fatalf("Expected the secondary host to be tried after ports, got %v", got)
+	}
+	urls, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "58")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, secondary.URL+"/") {
+			t.Errorf("Expected %s to be served by the secondary host", u)
+		}
+	}
+	var portsProbed bool
+	for _, p := range b.URLProbes {
+		portsProbed = portsProbed || strings.HasPrefix(p.URL, ports.URL+"/")
+	}
+	if !portsProbed {
+		t.Errorf("Expected ports to be tried first")
+	}
+}
+
+func TestParseUbuntuPackageName(t *testing.T) {
+	for name, expected := range map[string]UbuntuPackage{
+		"linux-image-5.15.0-52-generic_5.15.0-52.58_amd64.deb": {
+			KernelRelease: "5.15.0-52-generic", Version: "5.15.0", Extra: "52", Flavor: "generic", Architecture: "amd64", KernelVersion: "58",
+		},
+		"linux-image-unsigned-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb": {
+			KernelRelease: "5.15.0-1004-intel-iotg", Version: "5.15.0", Extra: "1004", Flavor: "intel-iotg", Architecture: "amd64", KernelVersion: "6",
+		},
+		"https://mirrors.edge.kernel.org/ubuntu/pool/main/l/linux-hwe-edge/linux-image-5.3.0-19-generic_5.3.0-19.20~18.04.2_amd64.deb": {
+			KernelRelease: "5.3.0-19-generic", Version: "5.3.0", Extra: "19", Flavor: "generic", Architecture: "amd64", KernelVersion: "20~18.04.2",
+		},
+		"linux-image-5.4.0-1022-aws_5.4.0-1022.22_arm64.deb": {
+			KernelRelease: "5.4.0-1022-aws", Version: "5.4.0", Extra: "1022", Flavor: "aws", Architecture: "arm64", KernelVersion: "22",
+		},
+		"linux-headers-4.15.0-188-lowlatency_4.15.0-188.199_amd64.deb": {
+			KernelRelease: "4.15.0-188-lowlatency", Version: "4.15.0", Extra: "188", Flavor: "lowlatency", Architecture: "amd64", KernelVersion: "199",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseUbuntuPackageName(name)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if *got != expected {
+				t.Errorf("Expected %+v, got %+v", expected, *got)
+			}
+			// the parsed fields generate the headers package name back
+			kr := kernelrelease.FromString(got.KernelRelease)
+			kr.Architecture = got.Architecture
+			headers := fmt.Sprintf("linux-headers-%s_%s-%s.%s_%s.deb", got.KernelRelease, got.Version, got.Extra, got.KernelVersion, got.Architecture)
+			urls, _ := fetchUbuntuKernelURL("https://mirror.example.com", kr, got.KernelVersion)
+			var found bool
+			for _, u := range urls {
+				found = found || path.Base(u) == headers
+			}
+			if !found {
+				t.Errorf("Expected %s among the generated urls %v", headers, urls)
+			}
+		})
+	}
+
+	for _, name := range []string{
+		"linux-image-generic_5.15.0.52.49_amd64.deb",
+		"linux-image-5.15.0-52-generic_5.15.0-53.59_amd64.deb",
+		"kernel-5.15.0-52-generic.rpm",
+	} {
+		if _, err := ParseUbuntuPackageName(name); err == nil {
+			t.Errorf("Expected an error parsing %s", name)
+		}
+	}
+}
+
+func TestUbuntuMirrorsInOrder(t *testing.T) {
+	defaultTracker := mirrorLatencies
+	mirrorLatencies = newLatencyTracker()
+	defer func() { mirrorLatencies = defaultTracker }()
+
+	// the first mirror misses the headers, the second one has them
+	first := newUbuntuFixtureMirror()
+	defer first.Close()
+	second := newUbuntuFixtureMirror(
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+	)
+	defer second.Close()
+
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	b := &Build{Mirrors: []string{first.URL, second.URL}}
+
+	// the given order holds, even once the second mirror answered faster
+	mirrorLatencies.observe(second.URL, time.Millisecond)
+	mirrorLatencies.observe(first.URL, time.Second)
+	if got := ubuntuBaseURLs(b, kr); !reflect.DeepEqual(got, []string{first.URL, second.URL}) {
+		t.Fatalf("Expected the mirrors in the given order, got %v", got)
+	}
+	urls, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "58")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, second.URL+"/") {
+			t.Errorf("Expected %s to be served by the second mirror", u)
+		}
+	}
+	if len(b.URLProbes) == 0 || !strings.HasPrefix(b.URLProbes[0].URL, first.URL+"/") {
+		t.Errorf("Expected the first mirror to be tried first, got %v", b.URLProbes)
+	}
+}
+
+func TestUbuntuResolutionParallel(t *testing.T) {
+	defaultTracker := mirrorLatencies
+	mirrorLatencies = newLatencyTracker()
+	defer func() { mirrorLatencies = defaultTracker }()
+
+	var mu sync.Mutex
+	inFlight, maxInFlight, requests := 0, 0, 0
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		switch path.Base(r.URL.Path) {
+		case "arch.deb":
+			// answering after the _all.deb one
+			time.Sleep(50 * time.Millisecond)
+		case "headers_all.deb":
+		default:
+			time.Sleep(10 * time.Millisecond)
+			http.NotFound(w, r)
+		}
+	}))
+	defer mirror.Close()
+
+	var candidates []string
+	for i := 0; i < 40; i++ {
+		name := fmt.Sprintf("missing-%d.deb", i)
+		switch i {
+		case 1:
+			name = "arch.deb"
+		case 3:
+			name = "headers_all.deb"
+		}
+		candidates = append(candidates, mirror.URL+"/"+name)
+	}
+
+	b := &Build{ResolveParallelism: 4}
+	urls, tried := resolveURLs(context.Background(), b, candidates, ubuntuPairResolved)
+	if !reflect.DeepEqual(tried, b.URLProbes) {
+		t.Errorf("Expected the probes tried to be the recorded ones, got %v", tried)
+	}
+	// the order is the one of the candidates, not the one of the answers
+	if !reflect.DeepEqual(urls, []string{candidates[1], candidates[3]}) {
+		t.Errorf("Expected the pair in the candidates order, got %v", urls)
+	}
+	if len(b.URLProbes) != 4 || b.URLProbes[1].URL != candidates[1] {
+		t.Errorf("Expected the probes up to the pair to be recorded in order, got %v", b.URLProbes)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if maxInFlight < 2 || maxInFlight > 4 {
+		t.Errorf("Expected up to 4 concurrent probes, got %d", maxInFlight)
+	}
+	// the probes left are cancelled once the pair is found
+	if requests >= len(candidates) {
+		t.Errorf("Expected the remaining probes to be cancelled, %d were sent", requests)
+	}
+}
+
+func TestUbuntuHeadersPairOrder(t *testing.T) {
+	kr := kernelrelease.FromString("5.15.0-1022-aws")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	specific := []string{
+		"/linux-aws-5.15/linux-headers-5.15.0-1022-aws_5.15.0-1022.26_amd64.deb",
+		"/linux-aws-5.15/linux-aws-headers-5.15.0-1022_5.15.0-1022.26_all.deb",
+	}
+
+	for _, test := range []struct {
+		name     string
+		mirror   *buildertest.Mirror
+		expected []string
+	}{
+		{
+			name: "most specific subdir",
+			mirror: buildertest.NewMirror().Packages(
+				"/linux/linux-headers-5.15.0-1022-aws_5.15.0-1022.26_amd64.deb",
+				"/linux/linux-aws-headers-5.15.0-1022_5.15.0-1022.26_all.deb",
+				"/linux-aws/linux-headers-5.15.0-1022-aws_5.15.0-1022.26_amd64.deb",
+				"/linux-aws/linux-aws-headers-5.15.0-1022_5.15.0-1022.26_all.deb",
+			).Packages(specific...),
+			expected: ubuntuFixtureURLs(specific...),
+		},
+		{
+			// whatever the latency of the most specific subdir
+			name: "slower specific subdir",
+			mirror: buildertest.NewMirror().Packages(
+				"/linux-aws/linux-headers-5.15.0-1022-aws_5.15.0-1022.26_amd64.deb",
+				"/linux-aws/linux-aws-headers-5.15.0-1022_5.15.0-1022.26_all.deb",
+			).Handle(specific[0], buildertest.Response{Delay: 50 * time.Millisecond}).
+				Handle(specific[1], buildertest.Response{Delay: 50 * time.Millisecond}),
+			expected: ubuntuFixtureURLs(specific...),
+		},
+		{
+			// the _{arch}.deb package first, then the first _all.deb one
+			name: "canonical pair",
+			mirror: buildertest.NewMirror().Packages(
+				"/linux-aws/linux-headers-5.15.0-1022-aws_5.15.0-1022.26_amd64_all.deb",
+				"/linux-aws/linux-aws-headers-5.15.0-1022_5.15.0-1022.26_all.deb",
+				"/linux-aws/linux-headers-5.15.0-1022-aws_5.15.0-1022.26_amd64.deb",
+			),
+			expected: ubuntuFixtureURLs(
+				"/linux-aws/linux-headers-5.15.0-1022-aws_5.15.0-1022.26_amd64.deb",
+				"/linux-aws/linux-headers-5.15.0-1022-aws_5.15.0-1022.26_amd64_all.deb",
+			),
+		},
+		{
+			// a subdir missing one of the packages is not mixed with the next ones
+			name: "incomplete subdir",
+			mirror: buildertest.NewMirror().Packages(
+				"/linux-aws/linux-aws-headers-5.15.0-1022_5.15.0-1022.26_all.deb",
+				"/linux/linux-headers-5.15.0-1022-aws_5.15.0-1022.26_amd64.deb",
+				"/linux/linux-aws-headers-5.15.0-1022_5.15.0-1022.26_all.deb",
+			),
+			expected: ubuntuFixtureURLs(
+				"/linux/linux-headers-5.15.0-1022-aws_5.15.0-1022.26_amd64.deb",
+				"/linux/linux-aws-headers-5.15.0-1022_5.15.0-1022.26_all.deb",
+			),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := &Build{
+				Mirrors:            []string{ubuntuFixtureBaseURL},
+				ResolveParallelism: 8,
+				URLResolver:        NewURLResolverWithClient(test.mirror.Client()),
+			}
+			urls, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "26")
+			buildertest.AssertURLs(t, urls, err, test.expected)
+		})
+	}
+}
+
+func TestUbuntuListingFallback(t *testing.T) {
+	kr := kernelrelease.FromString("4.18.0-24-hwe")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+
+	// the kernel version suffix cannot be guessed from the kernel version, the listing escapes it
+	listing := `<html><body><pre>
+<a href="../">../</a>
+<a href="linux-headers-4.18.0-24-generic_4.18.0-24.25%7E18.04.1_amd64.deb">linux-headers-4.18.0-24-generic_4.18.0-24.25~18.04.1_amd64.deb</a>
+<a href="linux-headers-4.18.0-24-generic_4.18.0-24.25%7E18.04.1_arm64.deb">linux-headers-4.18.0-24-generic_4.18.0-24.25~18.04.1_arm64.deb</a>
+<a href="linux-hwe-headers-4.18.0-24_4.18.0-24.25%7E18.04.1_all.deb">linux-hwe-headers-4.18.0-24_4.18.0-24.25~18.04.1_all.deb</a>
+<a href="linux-headers-4.18.0-24-generic_4.18.0-24.26%7E18.04.1_amd64.deb">linux-headers-4.18.0-24-generic_4.18.0-24.26~18.04.1_amd64.deb</a>
+<a href="linux-headers-4.18.0-25-generic_4.18.0-25.26%7E18.04.1_amd64.deb">linux-headers-4.18.0-25-generic_4.18.0-25.26~18.04.1_amd64.deb</a>
+</pre></body></html>`
+	mirror := buildertest.NewMirror().
+		Handle("/linux-hwe/", buildertest.Response{Body: listing}).
+		Packages(
+			"/linux-hwe/linux-headers-4.18.0-24-generic_4.18.0-24.25~18.04.1_amd64.deb",
+			"/linux-hwe/linux-hwe-headers-4.18.0-24_4.18.0-24.25~18.04.1_all.deb",
+		)
+	b := &Build{
+		Mirrors:     []string{ubuntuFixtureBaseURL},
+		URLResolver: NewURLResolverWithClient(mirror.Client()),
+	}
+
+	// the names guessed from the kernel version do not resolve
+	if urls, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "25"); err == nil {
+		t.Fatalf("Expected the headers not to be found without the listing fallback, got %v", urls)
+	}
+
+	// the _all.deb package of the given kernel version is picked, the one of the 26 version missing
+	b.ListingFallback = true
+	urls, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "25")
+	buildertest.AssertURLs(t, urls, err, ubuntuFixtureURLs(
+		"/linux-hwe/linux-headers-4.18.0-24-generic_4.18.0-24.25~18.04.1_amd64.deb",
+		"/linux-hwe/linux-hwe-headers-4.18.0-24_4.18.0-24.25~18.04.1_all.deb",
+	))
+	urls, err = ubuntuHeadersURLFromRelease(context.Background(), b, kr, "")
+	if err == nil {
+		t.Errorf("Expected the last listed version, missing its _all.deb package, not to resolve, got %v", urls)
+	}
+
+	// the listings are tried from the most specific subdir
+	var listings []string
+	for _, r := range mirror.Requests() {
+		if strings.HasSuffix(r, "/") {
+			listings = append(listings, strings.TrimPrefix(r, ubuntuFixtureBaseURL))
+		}
+	}
+	if !reflect.DeepEqual(listings[:2], []string{"/linux-hwe-4.18/", "/linux-hwe/"}) {
+		t.Errorf("Unexpected listings requested: %v", listings)
+	}
+}
+
+func TestUbuntuURLResolutionError(t *testing.T) {
+	// only the _all.deb package is there
+	mirror := buildertest.NewMirror().Packages("/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb")
+
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	b := &Build{Mirrors: []string{ubuntuFixtureBaseURL}, URLResolver: NewURLResolverWithClient(mirror.Client())}
+	_, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "58")
+	if !errors.Is(err, HeadersNotFoundErr) {
+		t.Fatalf("Expected the headers not to be found, got %v", err)
+	}
+	var resErr *URLResolutionError
+	if !errors.As(err, &resErr) {
+		t.Fatalf("Expected an URLResolutionError, got %T", err)
+	}
+	if resErr.Target != TargetTypeUbuntu {
+		t.Errorf("Expected the ubuntu target, got %s", resErr.Target)
+	}
+	candidates, err := fetchUbuntuKernelURL(ubuntuFixtureBaseURL, kr, "58")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(resErr.Tried) != len(candidates) {
+		t.Fatalf("Expected all the %d candidates to be tried, got %v", len(candidates), resErr.Tried)
+	}
+	found := 0
+	for i, p := range resErr.Tried {
+		if p.URL != candidates[i] {
+			t.Errorf("Expected %s to be tried, got %s", candidates[i], p.URL)
+		}
+		if p.StatusCode == http.StatusOK {
+			found++
+		} else if p.StatusCode != http.StatusNotFound {
+			t.Errorf("Unexpected status %d for %s", p.StatusCode, p.URL)
+		}
+	}
+	if found != 1 {
+		t.Errorf("Expected only the _all.deb package to be found, got %d", found)
+	}
+	if msg := resErr.Error(); !strings.Contains(msg, "for target ubuntu") || !strings.Contains(msg, candidates[0]+" (404)") {
+		t.Errorf("Expected the error to list the tried urls, got %s", msg)
+	}
+}
+
+func TestUbuntuS390x(t *testing.T) {
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureS390x
+
+	// s390x packages are hosted on the ports mirror
+	if got := ubuntuBaseURLs(nil, kr); !reflect.DeepEqual(got, []string{ubuntuPortsMirror}) {
+		t.Fatalf("Expected the ports mirror, got %v", got)
+	}
+	packages := []string{
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_s390x.deb",
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+	}
+	possibleURLs, err := fetchUbuntuKernelURL(ubuntuPortsMirror, kr, "58")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, p := range packages {
+		found := false
+		for _, u := range possibleURLs {
+			found = found || u == ubuntuPortsMirror+p
+		}
+		if !found {
+			t.Errorf("Expected %s to be a candidate, got %v", ubuntuPortsMirror+p, possibleURLs)
+		}
+	}
+
+	b := &Build{
+		Mirrors:     []string{ubuntuFixtureBaseURL},
+		URLResolver: NewURLResolverWithClient(buildertest.NewMirror().Packages(packages...).Client()),
+	}
+	urls, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "58")
+	buildertest.AssertURLs(t, urls, err, ubuntuFixtureURLs(packages...))
+
+	c := newTestConfig(TargetTypeUbuntu)
+	c.Build.Architecture = kernelrelease.ArchitectureS390x
+	res, err := renderScript(&ubuntu{}, c, kr, urls)
+	if err != nil {
+		t.Fatalf("Unexpected error rendering the template: %s", err)
+	}
+	if !strings.Contains(res, "ARCH=s390 KERNELDIR=$sourcedir") {
+		t.Errorf("Expected the s390 kernel ARCH to be passed to make, got:\n%s", res)
+	}
+}
+
+func TestUbuntuRiscv64(t *testing.T) {
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureRiscv64
+
+	// riscv64 packages are hosted on the ports mirror too
+	if got := ubuntuBaseURLs(nil, kr); !reflect.DeepEqual(got, []string{ubuntuPortsMirror}) {
+		t.Fatalf("Expected the ports mirror, got %v", got)
+	}
+	packages := []string{
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_riscv64.deb",
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+	}
+	possibleURLs, err := fetchUbuntuKernelURL(ubuntuPortsMirror, kr, "58")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, p := range packages {
+		found := false
+		for _, u := range possibleURLs {
+			found = found || u == ubuntuPortsMirror+p
+		}
+		if !found {
+			t.Errorf("Expected %s to be a candidate, got %v", ubuntuPortsMirror+p, possibleURLs)
+		}
+	}
+
+	b := &Build{
+		Mirrors:     []string{ubuntuFixtureBaseURL},
+		URLResolver: NewURLResolverWithClient(buildertest.NewMirror().Packages(packages...).Client()),
+	}
+	urls, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "58")
+	buildertest.AssertURLs(t, urls, err, ubuntuFixtureURLs(packages...))
+
+	c := newTestConfig(TargetTypeUbuntu)
+	c.Build.Architecture = kernelrelease.ArchitectureRiscv64
+	res, err := renderScript(&ubuntu{}, c, kr, urls)
+	if err != nil {
+		t.Fatalf("Unexpected error rendering the template: %s", err)
+	}
+	// the drivers get cross-compiled into the amd64 builder images
+	for _, s := range []string{"CC=/usr/bin/riscv64-linux-gnu-gcc-", "ARCH=riscv CROSS_COMPILE=riscv64-linux-gnu- KERNELDIR=$sourcedir", "riscv64-linux-gnu-objcopy"} {
+		if !strings.Contains(res, s) {
+			t.Errorf("Expected %q in the script, got:\n%s", s, res)
+		}
+	}
+	if a := c.Build.BuilderArchitecture(); a != kernelrelease.ArchitectureAmd64 {
+		t.Errorf("Expected the amd64 builder images, got %s", a)
+	}
+}
+
+func TestUbuntuHeadersPattern(t *testing.T) {
+	for _, test := range []struct {
+		kernelrelease  string
+		headersPattern string
+	}{
+		{"5.15.0-52-generic", "linux-headers*generic*"},
+		{"4.18.0-24-hwe", "linux-headers*generic"},
+		{"5.3.0-19-hwe-edge", "linux-headers*generic"},
+		{"6.2.0-26-generic-hwe-22.04", "linux-headers*generic"},
+		{"5.15.0-52-lowlatency", "linux-headers*lowlatency*"},
+		{"5.15.0-24-lowlatency-hwe-5.15", "linux-headers*lowlatency"},
+		{"5.15.0-1004-intel-iotg", "linux-headers*intel*"},
+		{"5.15.0-1004-realtime", "linux-headers*realtime*"},
+		{"5.15.0-1043-intel-iot-realtime", "linux-headers*realtime*"},
+	} {
+		t.Run(test.kernelrelease, func(t *testing.T) {
+			kr := kernelrelease.FromString(test.kernelrelease)
+			kr.Architecture = kernelrelease.ArchitectureAmd64
+			td := (&ubuntu{}).TemplateData(newTestConfig(TargetTypeUbuntu), kr, nil).(ubuntuTemplateData)
+			if td.KernelHeadersPattern != test.headersPattern {
+				t.Errorf("Headers pattern doesn't match! Got: '%s' / Want: '%s'", td.KernelHeadersPattern, test.headersPattern)
+			}
+		})
+	}
+}
+
+func TestUbuntuResolutionLogs(t *testing.T) {
+	kr := kernelrelease.FromString("5.15.0-1004-realtime")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	packages := []string{
+		"/linux-realtime/linux-headers-5.15.0-1004-realtime_5.15.0-1004.4_amd64.deb",
+		"/linux-realtime/linux-realtime-headers-5.15.0-1004_5.15.0-1004.4_all.deb",
+	}
+	l, hook := test.NewNullLogger()
+	l.SetLevel(logrus.DebugLevel)
+	b := &Build{
+		TargetType:    TargetTypeUbuntu,
+		KernelRelease: "5.15.0-1004-realtime",
+		Architecture:  kernelrelease.ArchitectureAmd64,
+		Mirrors:       []string{ubuntuFixtureBaseURL},
+		URLResolver:   NewURLResolverWithClient(buildertest.NewMirror().Packages(packages...).Client()),
+		Logger:        l,
+	}
+	urls, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "4")
+	buildertest.AssertURLs(t, urls, err, ubuntuFixtureURLs(packages...))
+
+	// each candidate is logged at debug, the pair at info, all of them identifying the build
+	tried := map[string]bool{}
+	var resolved []*logrus.Entry
+	for _, e := range hook.AllEntries() {
+		if e.Data["target"] != TargetTypeUbuntu || e.Data["kernelrelease"] != "5.15.0-1004-realtime" || e.Data["architecture"] != kernelrelease.ArchitectureAmd64 {
+			t.Errorf("Expected the entry to identify the build, got %v", e.Data)
+		}
+		switch e.Message {
+		case "kernel header url tried":
+			if e.Level != logrus.DebugLevel {
+				t.Errorf("Expected the candidates to be logged at debug, got %s", e.Level)
+			}
+			tried[e.Data["url"].(string)] = true
+		case "ubuntu kernel headers pair resolved":
+			resolved = append(resolved, e)
+		}
+	}
+	for _, u := range urls {
+		if !tried[u] {
+			t.Errorf("Expected the candidate %s to be logged", u)
+		}
+	}
+	if len(resolved) != 1 || resolved[0].Level != logrus.InfoLevel || !reflect.DeepEqual(resolved[0].Data["urls"], urls) {
+		t.Errorf("Expected the pair to be logged once at info, got %v", resolved)
+	}
+}
+
+func TestValidateUbuntuExtraversion(t *testing.T) {
+	tests := map[string]struct {
+		extraversion string
+		firstExtra   string
+		flavor       string
+		err          bool
+	}{
+		"empty":               {extraversion: "", err: true},
+		"dash only":           {extraversion: "-", err: true},
+		"flavor only":         {extraversion: "-only", err: true},
+		"truncated flavor":    {extraversion: "52-", err: true},
+		"numeric flavor":      {extraversion: "52-5", err: true},
+		"abi only":            {extraversion: "52", firstExtra: "52", flavor: "generic"},
+		"flavor":              {extraversion: "52-generic", firstExtra: "52", flavor: "generic"},
+		"multi-dash flavor":   {extraversion: "1010-intel-iotg", firstExtra: "1010", flavor: "intel-iotg"},
+		"multi-dash versions": {extraversion: "1010-intel-iotg-5.15", firstExtra: "1010", flavor: "intel-iotg"},
+		"trailing dashes":     {extraversion: "52-generic--", firstExtra: "52", flavor: "generic"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateUbuntuExtraversion(tt.extraversion)
+			if tt.err != (err != nil) {
+				t.Fatalf("Expected error %v, got %v", tt.err, err)
+			}
+			firstExtra, flavor, _ := parseUbuntuExtraVersion(tt.extraversion)
+			if firstExtra != tt.firstExtra || flavor != tt.flavor {
+				t.Errorf("Expected %q and %q, got %q and %q", tt.firstExtra, tt.flavor, firstExtra, flavor)
+			}
+		})
+	}
+
+	// the malformed kernel releases fail the resolution cleanly
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	kr.Extraversion = "52-"
+	if _, err := BuilderByTarget[TargetTypeUbuntu].URLs(context.Background(), newTestConfig(TargetTypeUbuntu), kr); err == nil {
+		t.Errorf("Expected a malformed extraversion to fail")
+	}
+	if _, err := ParseUbuntuPackageName("linux-headers-5.15.0-52-_5.15.0-52.58_amd64.deb"); err == nil {
+		t.Errorf("Expected a malformed package name to fail")
+	}
+}
+
+func TestUbuntuBaseURLsArchitectureAliases(t *testing.T) {
+	for alias, arch := range map[string]string{
+		"x86_64":  kernelrelease.ArchitectureAmd64,
+		"aarch64": kernelrelease.ArchitectureArm64,
+	} {
+		b := &Build{KernelRelease: "5.15.0-52-generic", Architecture: alias}
+		kr := b.KernelReleaseFromBuildConfig()
+		if kr.Architecture.String() != arch {
+			t.Fatalf("architecture %q: got %q, want %q", alias, kr.Architecture, arch)
+		}
+		if got := ubuntuBaseURLs(b, kr); !reflect.DeepEqual(got, ubuntuMirrorsByArch[arch]) {
+			t.Errorf("architecture %q: got mirrors %v, want %v", alias, got, ubuntuMirrorsByArch[arch])
+		}
+	}
+}
+
+func TestUbuntuPartialPair(t *testing.T) {
+	kr := kernelrelease.FromString("5.15.0-1019-aws")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	arch := "/linux-aws-5.15/linux-headers-5.15.0-1019-aws_5.15.0-1019.23~20.04.1_amd64.deb"
+	all := "/linux-aws-5.15/linux-aws-headers-5.15.0-1019_5.15.0-1019.23~20.04.1_all.deb"
+
+	for _, test := range []struct {
+		served  string
+		missing string
+	}{
+		{arch, "_all.deb"},
+		{all, "_amd64.deb"},
+	} {
+		b := &Build{
+			Mirrors:     []string{ubuntuFixtureBaseURL},
+			URLResolver: NewURLResolverWithClient(buildertest.NewMirror().Packages(test.served).Client()),
+		}
+		_, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "23~20.04.1")
+		var resErr *URLResolutionError
+		if !errors.As(err, &resErr) || !errors.Is(err, HeadersNotFoundErr) {
+			t.Fatalf("Expected a resolution error, got %v", err)
+		}
+		if !reflect.DeepEqual(resErr.Resolved, ubuntuFixtureURLs(test.served)) || !reflect.DeepEqual(resErr.Missing, []string{test.missing}) {
+			t.Errorf("Expected %s to be resolved and the %s package to be missing, got %v and %v", test.served, test.missing, resErr.Resolved, resErr.Missing)
+		}
+		if !strings.Contains(err.Error(), "missing the "+test.missing+" package") {
+			t.Errorf("Expected the error to tell the missing package: %s", err)
+		}
+	}
+
+	// nothing resolved, nothing to tell
+	b := &Build{
+		Mirrors:     []string{ubuntuFixtureBaseURL},
+		URLResolver: NewURLResolverWithClient(buildertest.NewMirror().Client()),
+	}
+	_, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "23~20.04.1")
+	var resErr *URLResolutionError
+	if !errors.As(err, &resErr) || resErr.Resolved != nil || resErr.Missing != nil || strings.Contains(err.Error(), "missing") {
+		t.Errorf("Expected a plain resolution error, got %v", err)
+	}
+}
+
+func TestCheckUbuntuKernelVersion(t *testing.T) {
+	tests := map[string]struct {
+		kernelVersion string
+		err           string
+	}{
+		"empty":                    {kernelVersion: ""},
+		"upload number":            {kernelVersion: "58"},
+		"hwe upload number":        {kernelVersion: "58~20.04.1"},
+		"package version":          {kernelVersion: "5.15.0-52.58", err: `use its upload number "58"`},
+		"hwe package version":      {kernelVersion: "5.15.0-52.58~20.04.1", err: `use its upload number "58~20.04.1"`},
+		"another package version":  {kernelVersion: "5.15.0-53.59", err: "is the one of the 5.15.0-53 kernel, not of 5.15.0-52-generic"},
+		"another kernel version":   {kernelVersion: "5.4.0-52.58", err: "is the one of the 5.4.0-52 kernel"},
+		"uname":                    {kernelVersion: "#58-Ubuntu SMP Thu Oct 13 08:03:55 UTC 2022", err: `output of uname -v, use its upload number "58"`},
+		"hwe uname":                {kernelVersion: "#58~20.04.1-Ubuntu SMP", err: `use its upload number "58~20.04.1"`},
+		"garbage":                  {kernelVersion: "latest", err: "e.g. 58 for the 5.15.0-52.58 ones"},
+		"abi and upload separated": {kernelVersion: "52-58", err: "not the upload number"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			kr := kernelrelease.FromString("5.15.0-52-generic")
+			kr.Architecture = kernelrelease.ArchitectureAmd64
+			urls, err := fetchUbuntuKernelURL(ubuntuFixtureBaseURL, kr, tt.kernelVersion)
+			if len(tt.err) == 0 {
+				if err != nil || len(urls) == 0 {
+					t.Fatalf("Expected the candidate urls, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	// nothing gets probed for a kernel version that cannot match
+	mirror := buildertest.NewMirror()
+	b := &Build{Mirrors: []string{ubuntuFixtureBaseURL}, URLResolver: NewURLResolverWithClient(mirror.Client())}
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	if _, err := ubuntuHeadersURLFromRelease(context.Background(), b, kr, "5.15.0-53.59"); err == nil || errors.Is(err, HeadersNotFoundErr) {
+		t.Errorf("Expected the kernel version to be rejected, got %v", err)
+	}
+	if len(mirror.Requests()) > 0 {
+		t.Errorf("Expected no request to be sent, got %v", mirror.Requests())
+	}
+}
+
+func TestUbuntuFlavors(t *testing.T) {
+	for _, f := range (&ubuntu{}).Flavors() {
+		t.Run(f.Name, func(t *testing.T) {
+			kr := kernelrelease.FromString(f.Example)
+			kr.Architecture = kernelrelease.ArchitectureAmd64
+			if _, flavor, err := parseUbuntuExtraVersion(kr.Extraversion); err != nil || flavor != f.Name {
+				t.Fatalf("Expected the example to be of the %s flavor, got %s (%v)", f.Name, flavor, err)
+			}
+			subdir := "linux-" + f.Name
+			switch f.Name {
+			case "generic":
+				subdir = "linux"
+			case "generic-hwe":
+				subdir = "linux-hwe-22.04"
+			}
+			urls, err := fetchUbuntuKernelURL(ubuntuFixtureBaseURL, kr, "1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, u := range urls {
+				if strings.HasPrefix(u, ubuntuFixtureBaseURL+"/"+subdir+"/") {
+					return
+				}
+			}
+			t.Errorf("Expected the headers to be looked for into the %s subdir, got %v", subdir, urls)
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	logger "github.com/sirupsen/logrus"
+)
+
+// DefaultURLCacheTTL is how long the resolved kernel headers urls are reused when no TTL is set.
+const DefaultURLCacheTTL = 24 * time.Hour
+
+// DefaultUnresolvedURLCacheTTL is how long the kernels known not to resolve fail fast when no TTL is set.
+const DefaultUnresolvedURLCacheTTL = time.Hour
+
+// urlCacheEntry is the content of the files of the url cache.
+// The entries without urls record the kernels whose headers are not on any mirror, along with the Error of their resolution.
+type urlCacheEntry struct {
+	URLs       []string  `json:"urls"`
+	Error      string    `json:"error,omitempty"`
+	ResolvedAt time.Time `json:"resolvedAt"`
+}
+
+// urlCachePath returns the path of the file caching the kernel headers urls of the build of b for kr,
+// keyed by target, kernel release, kernel version and architecture, and by the options of b driving the resolution,
+// not to reuse the urls resolved, or the kernels found unresolvable, with other mirrors or flavors.
+func (b *Build) urlCachePath(kr kernelrelease.KernelRelease) string {
+	name := fmt.Sprintf("%s_%s%s_%s_%s_%s.json", b.TargetType, kr.Fullversion, kr.FullExtraversion, b.KernelVersion, kr.Architecture, b.resolutionDigest())
+	return filepath.Join(b.CacheDir, "urls", strings.ReplaceAll(name, string(filepath.Separator), "_"))
+}
+
+// resolutionDigest returns a short digest of the options of b driving the resolution of the kernel headers urls.
+func (b *Build) resolutionDigest() string {
+	rewrites := make([]string, len(b.URLRewrite))
+	for i, r := range b.URLRewrite {
+		rewrites[i] = r.Pattern.String() + "=>" + r.Replacement
+	}
+	// maps get marshalled with their keys sorted
+	data, _ := json.Marshal(struct {
+		Mirrors               []string
+		ExtraMirrors          map[string][]string
+		ListingFallback       bool
+		KernelFlavors         []string
+		FlavorFallbackGeneric bool
+		DebianVendorPool      string
+		DebianVendorFlavors   map[string]string
+		PreferSecurityMirror  bool
+		ResolverEndpoint      string
+		URLRewrite            []string
+		MinPackageSize        int64
+		StrictContentCheck    bool
+	}{
+		b.Mirrors,
+		b.ExtraMirrors,
+		b.ListingFallback,
+		b.KernelFlavors,
+		b.FlavorFallbackGeneric,
+		b.DebianVendorPool,
+		b.DebianVendorFlavors,
+		b.PreferSecurityMirror,
+		b.ResolverEndpoint,
+		rewrites,
+		b.MinPackageSize,
+		b.StrictContentCheck,
+	})
+	return fmt.Sprintf("%x", sha256.Sum256(data))[:12]
+}
+
+// urlCacheable tells whether the urls resolved for b can be cached,
+// i.e. a cache is configured and they do not come from the build itself.
+func (b *Build) urlCacheable() bool {
+	return b != nil && len(b.CacheDir) > 0 && b.KernelUrls == nil
+}
+
+// loadURLCache returns the kernel headers urls cached for the build of b for kr, unless expired,
+// or an error when the kernel is cached as unresolvable, until UnresolvedCacheTTL expires.
+func (b *Build) loadURLCache(kr kernelrelease.KernelRelease) ([]string, bool, error) {
+	if !b.urlCacheable() || b.NoCache {
+		return nil, false, nil
+	}
+	p := b.urlCachePath(kr)
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, false, nil
+	}
+	var e urlCacheEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		logger.WithError(err).WithField("path", p).Warn("ignoring invalid url cache entry")
+		return nil, false, nil
+	}
+	if len(e.URLs) == 0 {
+		ttl := b.UnresolvedCacheTTL
+		if ttl <= 0 {
+			ttl = DefaultUnresolvedURLCacheTTL
+		}
+		if len(e.Error) == 0 || time.Since(e.ResolvedAt) > ttl {
+			return nil, false, nil
+		}
+		logger.WithField("path", p).Debug("kernel cached as unresolvable")
+		return nil, false, fmt.Errorf("%w, cached as unresolvable until %s (skip the cache to resolve it again): %s",
+			HeadersNotFoundErr, e.ResolvedAt.Add(ttl).Format(time.RFC3339), e.Error)
+	}
+	ttl := b.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultURLCacheTTL
+	}
+	if time.Since(e.ResolvedAt) > ttl {
+		return nil, false, nil
+	}
+	logger.WithField("path", p).Debug("kernel header urls found into the cache")
+	return e.URLs, true, nil
+}
+
+// storeURLCache caches the kernel headers urls resolved for the build of b for kr, if a cache is configured.
+// The failures are logged only, the cache being an optimization.
+func (b *Build) storeURLCache(kr kernelrelease.KernelRelease, urls []string) {
+	if !b.urlCacheable() {
+		return
+	}
+	p := b.urlCachePath(kr)
+	if err := writeURLCacheEntry(p, urlCacheEntry{URLs: urls, ResolvedAt: time.Now()}); err != nil {
+		logger.WithError(err).WithField("path", p).Warn("error caching the kernel header urls")
+	}
+}
+
+// storeUnresolvedURLCache caches the kernel kr of the build of b as unresolvable, if a cache is configured,
+// when err tells its headers are not on any mirror, i.e. every candidate probed was answered with a 404.
+// The network errors and the other statuses are not cached, as the next runs could resolve the kernel.
+func (b *Build) storeUnresolvedURLCache(kr kernelrelease.KernelRelease, err error) {
+	if !b.urlCacheable() || !errors.Is(err, HeadersNotFoundErr) {
+		return
+	}
+	for _, probe := range b.URLProbes {
+		if len(probe.Error) > 0 || probe.StatusCode != http.StatusNotFound {
+			return
+		}
+	}
+	p := b.urlCachePath(kr)
+	if err := writeURLCacheEntry(p, urlCacheEntry{Error: err.Error(), ResolvedAt: time.Now()}); err != nil {
+		logger.WithError(err).WithField("path", p).Warn("error caching the unresolvable kernel")
+	}
+}
+
+// writeURLCacheEntry writes e to p through a temporary file,
+// not to leave partial entries to the concurrent builds.
+func writeURLCacheEntry(p string, e urlCacheEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
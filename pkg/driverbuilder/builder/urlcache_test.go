@@ -0,0 +1,173 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder/buildertest"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+func TestKernelURLsCache(t *testing.T) {
+	packages := []string{
+		"/linux/linux-headers-5.15.0-52_5.15.0-52.58_all.deb",
+		"/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb",
+	}
+	mirror := newUbuntuFixtureMirror(packages...)
+
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	newConfig := func() Config {
+		c := newTestConfig(TargetTypeUbuntu)
+		c.KernelRelease = "5.15.0-52-generic"
+		c.KernelVersion = "58"
+		c.Mirrors = []string{mirror.URL}
+		c.CacheDir = t.TempDir()
+		return c
+	}
+	c := newConfig()
+	b := BuilderByTarget[TargetTypeUbuntu]
+
+	resolved, err := KernelURLs(context.Background(), b, c, kr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	p := c.Build.urlCachePath(kr)
+	if _, err := os.Stat(p); err != nil {
+		t.Fatalf("Expected the urls to be cached: %s", err)
+	}
+
+	// the next runs reuse them, even once the mirror is gone
+	mirror.Close()
+	c.URLProbes = nil
+	cached, err := KernelURLs(context.Background(), b, c, kr)
+	if err != nil || !reflect.DeepEqual(resolved, cached) {
+		t.Fatalf("Expected the cached urls %v, got %v (%v)", resolved, cached, err)
+	}
+	if len(c.URLProbes) != 0 {
+		t.Errorf("Expected no probe, got %v", c.URLProbes)
+	}
+
+	// unless the cache is bypassed
+	c.NoCache = true
+	if _, err := KernelURLs(context.Background(), b, c, kr); err == nil {
+		t.Errorf("Expected the resolution to fail without the mirror")
+	}
+	c.NoCache = false
+
+	// or expired
+	if err := writeURLCacheEntry(p, urlCacheEntry{URLs: resolved, ResolvedAt: time.Now().Add(-2 * DefaultURLCacheTTL)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := KernelURLs(context.Background(), b, c, kr); err == nil {
+		t.Errorf("Expected the expired urls not to be reused")
+	}
+	c.CacheTTL = 3 * DefaultURLCacheTTL
+	if _, err := KernelURLs(context.Background(), b, c, kr); err != nil {
+		t.Errorf("Expected the urls to be reused with a longer TTL, got %v", err)
+	}
+
+	// the entries are keyed by kernel version too
+	other := newConfig()
+	other.CacheDir = c.CacheDir
+	other.KernelVersion = "59"
+	if other.Build.urlCachePath(kr) == p {
+		t.Errorf("Expected another entry for another kernel version")
+	}
+	// and by the options driving the resolution
+	for name, mutate := range map[string]func(b *Build){
+		"mirrors":          func(b *Build) { b.Mirrors = []string{"https://mirror.example.com/ubuntu"} },
+		"extra mirrors":    func(b *Build) { b.ExtraMirrors = map[string][]string{"ubuntu": {"https://mirror.example.com/ubuntu"}} },
+		"listing fallback": func(b *Build) { b.ListingFallback = true },
+		"kernel flavors":   func(b *Build) { b.KernelFlavors = []string{"aws"} },
+		"flavor fallback":  func(b *Build) { b.FlavorFallbackGeneric = true },
+		"vendor pool":      func(b *Build) { b.DebianVendorPool = "https://mirror.example.com/pool" },
+		"url rewrite": func(b *Build) {
+			rule, _ := ParseURLRewriteRule("^https://archive.ubuntu.com/=>https://mirror.example.com/")
+			b.URLRewrite = []URLRewriteRule{rule}
+		},
+	} {
+		other := newConfig()
+		other.CacheDir = c.CacheDir
+		mutate(other.Build)
+		if other.Build.urlCachePath(kr) == p {
+			t.Errorf("Expected another entry for other %s", name)
+		}
+	}
+	same := newConfig()
+	same.CacheDir = c.CacheDir
+	if same.Build.urlCachePath(kr) != p {
+		t.Errorf("Expected the same entry for the same options")
+	}
+	data, _ := os.ReadFile(p)
+	var e urlCacheEntry
+	if err := json.Unmarshal(data, &e); err != nil || !reflect.DeepEqual(e.URLs, resolved) {
+		t.Errorf("Unexpected cache entry: %s", data)
+	}
+}
+
+func TestKernelURLsUnresolvedCache(t *testing.T) {
+	kr := kernelrelease.FromString("5.15.0-52-generic")
+	kr.Architecture = kernelrelease.ArchitectureAmd64
+	mirror := buildertest.NewMirror()
+	c := newTestConfig(TargetTypeUbuntu)
+	c.KernelRelease = "5.15.0-52-generic"
+	c.KernelVersion = "58"
+	c.Mirrors = []string{ubuntuFixtureBaseURL}
+	c.URLResolver = NewURLResolverWithClient(mirror.Client())
+	c.CacheDir = t.TempDir()
+	b := BuilderByTarget[TargetTypeUbuntu]
+
+	// every candidate answered 404, the kernel is cached as unresolvable
+	if _, err := KernelURLs(context.Background(), b, c, kr); !errors.Is(err, HeadersNotFoundErr) {
+		t.Fatalf("Expected the headers not to be found, got %v", err)
+	}
+	requests := len(mirror.Requests())
+	if requests == 0 {
+		t.Fatal("Expected the candidates to be probed")
+	}
+	_, err := KernelURLs(context.Background(), b, c, kr)
+	if !errors.Is(err, HeadersNotFoundErr) || !strings.Contains(err.Error(), "cached as unresolvable") {
+		t.Errorf("Expected the kernel to fail fast, got %v", err)
+	}
+	if len(mirror.Requests()) != requests {
+		t.Errorf("Expected no more requests, got %v", mirror.Requests()[requests:])
+	}
+
+	// unless the cache is bypassed
+	c.NoCache = true
+	if _, err := KernelURLs(context.Background(), b, c, kr); err == nil || strings.Contains(err.Error(), "cached as unresolvable") {
+		t.Errorf("Expected the kernel to be resolved again, got %v", err)
+	}
+	if len(mirror.Requests()) == requests {
+		t.Errorf("Expected the candidates to be probed again")
+	}
+	c.NoCache = false
+
+	// or expired
+	p := c.Build.urlCachePath(kr)
+	if err := writeURLCacheEntry(p, urlCacheEntry{Error: "not found", ResolvedAt: time.Now().Add(-2 * DefaultUnresolvedURLCacheTTL)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := KernelURLs(context.Background(), b, c, kr); err == nil || strings.Contains(err.Error(), "cached as unresolvable") {
+		t.Errorf("Expected the expired entry to be ignored, got %v", err)
+	}
+
+	// the kernels failing for other reasons than their absence are not cached
+	os.Remove(p)
+	mirror.Handle("/linux/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb", buildertest.Response{StatusCode: http.StatusServiceUnavailable})
+	c.URLProbes = nil
+	if _, err := KernelURLs(context.Background(), b, c, kr); err == nil {
+		t.Fatal("Expected the resolution to fail")
+	}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Errorf("Expected the kernel not to be cached, got %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package builder
+
+import "strings"
+
+// DeduplicateURLs returns urls without the duplicates, keeping the first occurrence of each one,
+// so that the priority of the candidates is preserved.
+func DeduplicateURLs(urls []string) []string {
+	return deduplicateURLsBy(urls, func(u string) string { return u })
+}
+
+// DeduplicateURLsFold is DeduplicateURLs comparing the urls case-insensitively,
+// for the mirrors serving the same path whatever its casing.
+// The first occurrence of each url is kept as is.
+func DeduplicateURLsFold(urls []string) []string {
+	return deduplicateURLsBy(urls, strings.ToLower)
+}
+
+func deduplicateURLsBy(urls []string, key func(string) string) []string {
+	seen := make(map[string]struct{}, len(urls))
+	res := []string{}
+	for _, u := range urls {
+		k := key(u)
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		res = append(res, u)
+	}
+	return res
+}
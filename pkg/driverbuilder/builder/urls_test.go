@@ -0,0 +1,56 @@
+package builder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeduplicateURLs(t *testing.T) {
+	tests := map[string]struct {
+		urls     []string
+		expected []string
+		fold     []string
+	}{
+		"empty": {
+			urls:     nil,
+			expected: []string{},
+			fold:     []string{},
+		},
+		"no duplicates": {
+			urls:     []string{"https://a.example.com/x.deb", "https://b.example.com/x.deb"},
+			expected: []string{"https://a.example.com/x.deb", "https://b.example.com/x.deb"},
+			fold:     []string{"https://a.example.com/x.deb", "https://b.example.com/x.deb"},
+		},
+		"all duplicates": {
+			urls:     []string{"https://a.example.com/x.deb", "https://a.example.com/x.deb", "https://a.example.com/x.deb"},
+			expected: []string{"https://a.example.com/x.deb"},
+			fold:     []string{"https://a.example.com/x.deb"},
+		},
+		"interleaved": {
+			urls: []string{
+				"https://a.example.com/x.deb",
+				"https://b.example.com/x.deb",
+				"https://a.example.com/x.deb",
+				"https://c.example.com/x.deb",
+				"https://b.example.com/x.deb",
+			},
+			expected: []string{"https://a.example.com/x.deb", "https://b.example.com/x.deb", "https://c.example.com/x.deb"},
+			fold:     []string{"https://a.example.com/x.deb", "https://b.example.com/x.deb", "https://c.example.com/x.deb"},
+		},
+		"casing": {
+			urls:     []string{"https://a.example.com/Linux-Headers.deb", "https://a.example.com/linux-headers.deb", "https://A.example.com/LINUX-HEADERS.deb"},
+			expected: []string{"https://a.example.com/Linux-Headers.deb", "https://a.example.com/linux-headers.deb", "https://A.example.com/LINUX-HEADERS.deb"},
+			fold:     []string{"https://a.example.com/Linux-Headers.deb"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := DeduplicateURLs(tt.urls); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("DeduplicateURLs: expected %v, got %v", tt.expected, got)
+			}
+			if got := DeduplicateURLsFold(tt.urls); !reflect.DeepEqual(got, tt.fold) {
+				t.Errorf("DeduplicateURLsFold: expected %v, got %v", tt.fold, got)
+			}
+		})
+	}
+}
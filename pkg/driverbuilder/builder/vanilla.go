@@ -1,13 +1,14 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
-//go:embed templates/vanilla.sh
-var vanillaTemplate string
+//go:embed templates/vanilla.sh.gz
+var vanillaTemplate []byte
 
 // vanilla is a driverkit target.
 type vanilla struct {
@@ -30,11 +31,15 @@ func (v *vanilla) Name() string {
 	return TargetTypeVanilla.String()
 }
 
+func (v *vanilla) SupportedArchitectures() []kernelrelease.Architecture {
+	return DefaultArchitectures()
+}
+
 func (v *vanilla) TemplateScript() string {
-	return vanillaTemplate
+	return decompressTemplate(vanillaTemplate)
 }
 
-func (v *vanilla) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (v *vanilla) URLs(_ context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
 	return []string{fetchVanillaKernelURLFromKernelVersion(kr)}, nil
 }
 
@@ -0,0 +1,130 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// buildDiagnostics collects, while a build runs, what is needed to reproduce it.
+type buildDiagnostics struct {
+	proxy        string
+	script       string
+	containerLog bytes.Buffer
+}
+
+// reproConfig is the effective configuration of a build, as written into the reproduction bundles.
+type reproConfig struct {
+	Target                string   `json:"target"`
+	KernelRelease         string   `json:"kernelrelease"`
+	KernelVersion         string   `json:"kernelversion"`
+	Architecture          string   `json:"architecture"`
+	DriverVersion         string   `json:"driverversion"`
+	KernelConfigData      string   `json:"kernelconfigdata,omitempty"`
+	KernelUrls            []string `json:"kernelurls,omitempty"`
+	HeaderURLs            []string `json:"headerurls,omitempty"`
+	ModuleDriverName      string   `json:"moduledrivername"`
+	ModuleDeviceName      string   `json:"moduledevicename"`
+	ModuleFilePath        string   `json:"output-module,omitempty"`
+	ProbeFilePath         string   `json:"output-probe,omitempty"`
+	BuilderImage          string   `json:"builderimage,omitempty"`
+	BuilderRepos          []string `json:"builderrepos,omitempty"`
+	GCCVersion            string   `json:"gccversion,omitempty"`
+	RepoOrg               string   `json:"repo-org"`
+	RepoName              string   `json:"repo-name"`
+	ContainerWorkDir      string   `json:"containerworkdir,omitempty"`
+	RequiredKernelConfigs []string `json:"requiredkernelconfigs,omitempty"`
+	Proxy                 string   `json:"proxy,omitempty"`
+}
+
+func redactURLs(urls []string) []string {
+	var res []string
+	for _, u := range urls {
+		res = append(res, builder.RedactURL(u))
+	}
+	return res
+}
+
+// redactText hides the passwords of the given URLs from text.
+func redactText(text string, urls []string) string {
+	for _, u := range urls {
+		if r := builder.RedactURL(u); r != u {
+			text = strings.ReplaceAll(text, u, r)
+		}
+	}
+	return text
+}
+
+// writeReproBundle writes to b.RepoBundleOnFailure a gzipped tarball containing the effective
+// configuration of the failed build, the probed candidate URLs, the rendered build script
+// and the logs of the builder container, if any.
+func writeReproBundle(b *builder.Build, d *buildDiagnostics, buildErr error) error {
+	config, err := json.MarshalIndent(reproConfig{
+		Target:                b.TargetType.String(),
+		KernelRelease:         b.KernelRelease,
+		KernelVersion:         b.KernelVersion,
+		Architecture:          b.Architecture,
+		DriverVersion:         b.DriverVersion,
+		KernelConfigData:      b.KernelConfigData,
+		KernelUrls:            redactURLs(b.KernelUrls),
+		HeaderURLs:            redactURLs(b.HeaderURLs),
+		ModuleDriverName:      b.ModuleDriverName,
+		ModuleDeviceName:      b.ModuleDeviceName,
+		ModuleFilePath:        b.ModuleFilePath,
+		ProbeFilePath:         b.ProbeFilePath,
+		BuilderImage:          b.BuilderImage,
+		BuilderRepos:          b.BuilderRepos,
+		GCCVersion:            b.GCCVersion,
+		RepoOrg:               b.RepoOrg,
+		RepoName:              b.RepoName,
+		ContainerWorkDir:      b.ContainerWorkDir,
+		RequiredKernelConfigs: b.RequiredKernelConfigs,
+		Proxy:                 builder.RedactURL(d.proxy),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	probes := make([]builder.URLProbe, 0, len(b.URLProbes))
+	for _, p := range b.URLProbes {
+		p.URL = builder.RedactURL(p.URL)
+		probes = append(probes, p)
+	}
+	urls, err := json.MarshalIndent(probes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// the rendered script and the logs contain the urls too
+	secrets := append([]string{d.proxy}, b.KernelUrls...)
+	secrets = append(secrets, b.HeaderURLs...)
+	for _, p := range b.URLProbes {
+		secrets = append(secrets, p.URL)
+	}
+	files := []dockerCopyFile{
+		{"config.json", string(config)},
+		{"urls.json", string(urls)},
+		{"error.txt", redactText(buildErr.Error(), secrets)},
+	}
+	if len(d.script) > 0 {
+		files = append(files, dockerCopyFile{"driverkit.sh", redactText(d.script, secrets)})
+	}
+	if d.containerLog.Len() > 0 {
+		files = append(files, dockerCopyFile{"container.log", redactText(d.containerLog.String(), secrets)})
+	}
+
+	out, err := os.Create(b.RepoBundleOnFailure)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gw := gzip.NewWriter(out)
+	if err := tarWriterFiles(gw, files); err != nil {
+		return err
+	}
+	return gw.Close()
+}
@@ -0,0 +1,88 @@
+package driverbuilder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	logger "github.com/sirupsen/logrus"
+)
+
+// Labels of the build containers telling apart the driverkit run they belong to.
+const (
+	falcoBuilderHostLabel = "org.falcosecurity/driverkit-host"
+	falcoBuilderPIDLabel  = "org.falcosecurity/driverkit-pid"
+)
+
+// runLabels returns the labels of the build containers created by the current driverkit run.
+func runLabels(uid string) map[string]string {
+	hostname, _ := os.Hostname()
+	return map[string]string{
+		falcoBuilderUIDLabel:  uid,
+		falcoBuilderHostLabel: hostname,
+		falcoBuilderPIDLabel:  strconv.Itoa(os.Getpid()),
+	}
+}
+
+// runAlive tells whether the driverkit run that created a build container with the given labels is still alive.
+// The runs of other hosts are always considered alive, as there is no way to tell.
+func runAlive(labels map[string]string) bool {
+	hostname, _ := os.Hostname()
+	if labels[falcoBuilderHostLabel] != hostname {
+		return true
+	}
+	pid, err := strconv.Atoi(labels[falcoBuilderPIDLabel])
+	if err != nil || pid <= 0 {
+		return true
+	}
+	err = syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// dockerContainersClient is the subset of the docker client needed to clean up the build containers.
+type dockerContainersClient interface {
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+	ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error
+}
+
+// CleanupDockerContainers removes the build containers left behind by the driverkit runs that are not alive anymore
+// (e.g. killed before stopping them), returning their IDs. With dryRun, they are only returned.
+func CleanupDockerContainers(ctx context.Context, dryRun bool) ([]string, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return nil, err
+	}
+	return cleanupDanglingContainers(ctx, cli, dryRun, runAlive)
+}
+
+func cleanupDanglingContainers(ctx context.Context, cli dockerContainersClient, dryRun bool, alive func(map[string]string) bool) ([]string, error) {
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", falcoBuilderUIDLabel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var dangling []string
+	for _, c := range containers {
+		if _, ok := c.Labels[falcoBuilderUIDLabel]; !ok || alive(c.Labels) {
+			continue
+		}
+		l := logger.WithField("container_id", c.ID).WithField("driverkit_pid", c.Labels[falcoBuilderPIDLabel])
+		if dryRun {
+			l.Info("dangling build container found")
+		} else {
+			if err := cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true}); err != nil && !client.IsErrNotFound(err) {
+				return dangling, err
+			}
+			l.Info("dangling build container removed")
+		}
+		dangling = append(dangling, c.ID)
+	}
+	return dangling, nil
+}
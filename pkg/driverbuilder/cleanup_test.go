@@ -0,0 +1,78 @@
+package driverbuilder
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+// stubContainersClient is a docker client listing the given containers, and recording the removed ones.
+type stubContainersClient struct {
+	containers []types.Container
+	removed    []string
+}
+
+func (c *stubContainersClient) ContainerList(_ context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	if !options.All || !options.Filters.ExactMatch("label", falcoBuilderUIDLabel) {
+		return nil, nil
+	}
+	return c.containers, nil
+}
+
+func (c *stubContainersClient) ContainerRemove(_ context.Context, containerID string, _ types.ContainerRemoveOptions) error {
+	c.removed = append(c.removed, containerID)
+	return nil
+}
+
+func TestCleanupDanglingContainers(t *testing.T) {
+	// a process that is over, standing for a killed driverkit run
+	over := exec.Command("true")
+	if err := over.Run(); err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	newStub := func() *stubContainersClient {
+		return &stubContainersClient{containers: []types.Container{
+			{ID: "alive", Labels: runLabels("alive")},
+			{ID: "dangling", Labels: map[string]string{
+				falcoBuilderUIDLabel:  "dangling",
+				falcoBuilderHostLabel: hostname,
+				falcoBuilderPIDLabel:  strconv.Itoa(over.Process.Pid),
+			}},
+			{ID: "otherhost", Labels: map[string]string{
+				falcoBuilderUIDLabel:  "otherhost",
+				falcoBuilderHostLabel: hostname + "-other",
+				falcoBuilderPIDLabel:  strconv.Itoa(over.Process.Pid),
+			}},
+		}}
+	}
+
+	cli := newStub()
+	dangling, err := cleanupDanglingContainers(context.Background(), cli, false, runAlive)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Join(dangling, ",") != "dangling" {
+		t.Fatalf("Unexpected dangling containers: %v", dangling)
+	}
+	if strings.Join(cli.removed, ",") != "dangling" {
+		t.Fatalf("Unexpected removed containers: %v", cli.removed)
+	}
+
+	cli = newStub()
+	dangling, err = cleanupDanglingContainers(context.Background(), cli, true, runAlive)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Join(dangling, ",") != "dangling" {
+		t.Fatalf("Unexpected dangling containers: %v", dangling)
+	}
+	if len(cli.removed) != 0 {
+		t.Fatalf("Expected no container to be removed in dry run, got: %v", cli.removed)
+	}
+}
@@ -13,8 +13,11 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -30,7 +33,6 @@ import (
 const DockerBuildProcessorName = "docker"
 
 type DockerBuildProcessor struct {
-	clean   bool
 	timeout int
 	proxy   string
 }
@@ -103,8 +105,69 @@ func mustCheckArchUseQemu(ctx context.Context, b *builder.Build, cli *client.Cli
 	}
 }
 
+// ensureBuilderImage pulls the builder image for the given architecture, unless already available.
+func ensureBuilderImage(ctx context.Context, cli *client.Client, image, arch string) error {
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, image)
+	if err == nil && inspect.Architecture == arch {
+		return nil
+	}
+
+	logger.
+		WithField("image", image).
+		WithField("arch", arch).
+		Debug("pulling builder image")
+
+	pullRes, err := cli.ImagePull(ctx, image, types.ImagePullOptions{Platform: arch})
+	if err != nil {
+		return err
+	}
+	defer pullRes.Close()
+	_, err = io.Copy(ioutil.Discard, pullRes)
+	return err
+}
+
+// checkBuilderImage warns when the builder image, with the given labels, is older than the one needed by v,
+// failing instead when b asks for it.
+func checkBuilderImage(b *builder.Build, v builder.Builder, image string, labels map[string]string) error {
+	err := builder.CheckBuilderImageVersion(v, image, labels)
+	if err == nil || b.StrictBuilderImageVersion {
+		return err
+	}
+	logger.WithError(err).Warn("builder image version mismatch, the build may miss the tools it needs")
+	return nil
+}
+
 // Start the docker processor
 func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
+	return bp.StartWithContext(context.Background(), b)
+}
+
+// StartWithContext starts the docker processor, stopping the build container as soon as ctx is done.
+func (bp *DockerBuildProcessor) StartWithContext(ctx context.Context, b *builder.Build) error {
+	ctx, span := startBuildSpan(ctx, b)
+	defer span.End()
+
+	d := &buildDiagnostics{proxy: bp.proxy}
+	err := bp.start(ctx, b, d)
+	if err != nil {
+		span.RecordError(err)
+	} else {
+		b.Progress(builder.PhaseDone, "done")
+	}
+	if err != nil && len(b.RepoBundleOnFailure) > 0 {
+		if bErr := writeReproBundle(b, d, err); bErr != nil {
+			logger.WithError(bErr).Error("error writing reproduction bundle")
+		} else {
+			logger.WithField("path", b.RepoBundleOnFailure).Info("reproduction bundle available")
+		}
+	}
+	return err
+}
+
+func (bp *DockerBuildProcessor) start(ctx context.Context, b *builder.Build, d *buildDiagnostics) error {
+	if skipPublished(ctx, b) {
+		return nil
+	}
 	logger.Debug("doing a new docker build")
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
@@ -114,17 +177,33 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 	kr := b.KernelReleaseFromBuildConfig()
 
 	// create a builder based on the choosen build type
-	v, err := builder.Factory(b.TargetType)
+	v, err := builder.BuilderForTarget(b.TargetType)
 	if err != nil {
 		return err
 	}
 	c := b.ToConfig()
+	if len(b.DockerfilePath) > 0 && len(b.LocalHeaders) > 0 {
+		return fmt.Errorf("the local kernel headers cannot be mounted into dockerfile builds")
+	}
+	if len(b.DockerfilePath) > 0 && len(c.PackageCacheDir) > 0 {
+		logger.Warn("the package cache cannot be mounted into dockerfile builds, ignoring it")
+		c.PackageCacheDir = ""
+	}
+	if len(b.DockerfilePath) > 0 && c.MirrorAuth {
+		logger.Warn("the mirror credentials are not written into dockerfiles, the kernel headers get downloaded without them")
+		c.MirrorAuth = false
+	}
 
 	// Generate the build script from the builder
-	driverkitScript, err := builder.Script(v, c, kr)
+	var driverkitScript string
+	err = traceStage(ctx, "resolution", func(ctx context.Context) error {
+		driverkitScript, err = builder.Script(ctx, v, c, kr)
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	d.script = driverkitScript
 
 	// Prepare driver config template
 	bufFillDriverConfig := bytes.NewBuffer(nil)
@@ -151,29 +230,55 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 
 	builderImage := b.GetBuilderImage()
 
+	files := []dockerCopyFile{
+		{"/driverkit/kernel.config", string(configDecoded)},
+		{"/driverkit/module-Makefile", bufMakefile.String()},
+		{"/driverkit/fill-driver-config.sh", bufFillDriverConfig.String()},
+	}
+	if c.MirrorAuth {
+		files = append(files, dockerCopyFile{builder.MirrorAuthConfigPath, builder.MirrorAuthConfig(b)})
+	}
+	if len(c.CACertPath) > 0 {
+		caCert, err := builder.CACert(b)
+		if err != nil {
+			return err
+		}
+		files = append(files, dockerCopyFile{builder.ContainerCACertPath, caCert})
+	}
+
+	if len(b.DockerfilePath) > 0 {
+		files = append([]dockerCopyFile{{"/driverkit/driverkit.sh", driverkitScript}}, files...)
+		if err := writeDockerfile(b, builderImage, files); err != nil {
+			return err
+		}
+		logger.WithField("path", b.DockerfilePath).Info("dockerfile available")
+		return nil
+	}
+
 	// Create the container
-	ctx := context.Background()
 	ctx = signals.WithStandardSignals(ctx)
 
 	mustCheckArchUseQemu(ctx, b, cli)
 
-	var inspect types.ImageInspect
-	if inspect, _, err = cli.ImageInspectWithRaw(ctx, builderImage); client.IsErrNotFound(err) ||
-		inspect.Architecture != b.Architecture {
-
-		logger.
-			WithField("image", builderImage).
-			WithField("arch", b.Architecture).
-			Debug("pulling builder image")
-
-		pullRes, err := cli.ImagePull(ctx, builderImage, types.ImagePullOptions{Platform: b.Architecture})
-		if err != nil {
-			return err
-		}
-		defer pullRes.Close()
-		_, err = io.Copy(ioutil.Discard, pullRes)
-		if err != nil {
-			return err
+	if err := ensureBuilderImage(ctx, cli, builderImage, b.BuilderArchitecture()); err != nil {
+		return err
+	}
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, builderImage)
+	if err != nil {
+		return err
+	}
+	var labels map[string]string
+	if inspect.Config != nil {
+		labels = inspect.Config.Labels
+	}
+	if err := checkBuilderImage(b, v, builderImage, labels); err != nil {
+		return err
+	}
+	imageDigest := inspect.ID
+	for _, d := range inspect.RepoDigests {
+		if _, digest, ok := strings.Cut(d, "@"); ok {
+			imageDigest = digest
+			break
 		}
 	}
 
@@ -181,31 +286,54 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		WithField("image", builderImage).
 		Debug("starting container")
 
+	uid := uuid.NewUUID()
+	name := fmt.Sprintf("driverkit-%s", string(uid))
+
 	containerCfg := &container.Config{
-		Tty:   true,
-		Cmd:   []string{"/bin/sleep", strconv.Itoa(bp.timeout)},
-		Image: builderImage,
+		Tty:    true,
+		Cmd:    []string{"/bin/sleep", strconv.Itoa(bp.timeout)},
+		Image:  builderImage,
+		Labels: runLabels(string(uid)),
 	}
 
 	hostCfg := &container.HostConfig{
 		AutoRemove: true,
 	}
-	uid := uuid.NewUUID()
-	name := fmt.Sprintf("driverkit-%s", string(uid))
+	if len(c.PackageCacheDir) > 0 {
+		cacheDir, err := filepath.Abs(c.PackageCacheDir)
+		if err != nil {
+			return err
+		}
+		hostCfg.Binds = []string{cacheDir + ":" + builder.ContainerPackageCacheDir + ":ro"}
+	}
+	if len(b.LocalHeaders) > 0 {
+		binds, err := builder.LocalHeadersBinds(b.LocalHeaders)
+		if err != nil {
+			return err
+		}
+		hostCfg.Binds = append(hostCfg.Binds, binds...)
+	}
 
-	cdata, err := cli.ContainerCreate(ctx, containerCfg, hostCfg, nil, &v1.Platform{Architecture: b.Architecture, OS: "linux"}, name)
+	cdata, err := cli.ContainerCreate(ctx, containerCfg, hostCfg, nil, &v1.Platform{Architecture: b.BuilderArchitecture(), OS: "linux"}, name)
 	if err != nil {
 		return err
 	}
 
-	defer bp.cleanup(cli, cdata.ID)
+	var cleanupOnce sync.Once
+	cleanup := func() {
+		cleanupOnce.Do(func() {
+			bp.cleanup(cli, cdata.ID)
+		})
+	}
+	done := make(chan struct{})
+	defer close(done)
+	defer cleanup()
 	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				bp.cleanup(cli, cdata.ID)
-				return
-			}
+		select {
+		case <-ctx.Done():
+			logger.Debug("context canceled")
+			cleanup()
+		case <-done:
 		}
 	}()
 
@@ -214,12 +342,7 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		return err
 	}
 
-	files := []dockerCopyFile{
-		{"/driverkit/driverkit.sh", driverkitScript},
-		{"/driverkit/kernel.config", string(configDecoded)},
-		{"/driverkit/module-Makefile", bufMakefile.String()},
-		{"/driverkit/fill-driver-config.sh", bufFillDriverConfig.String()},
-	}
+	files, cmd, stdin := dockerExecPlan(b, driverkitScript, files)
 
 	var buf bytes.Buffer
 	err = tarWriterFiles(&buf, files)
@@ -245,15 +368,12 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 	edata, err := cli.ContainerExecCreate(ctx, cdata.ID, types.ExecConfig{
 		Privileged:   false,
 		Tty:          false,
-		AttachStdin:  false,
+		AttachStdin:  stdin != nil,
 		AttachStderr: true,
 		AttachStdout: true,
 		Detach:       true,
 		Env:          envs,
-		Cmd: []string{
-			"/bin/bash",
-			"/driverkit/driverkit.sh",
-		},
+		Cmd:          cmd,
 	})
 
 	if err != nil {
@@ -266,23 +386,53 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 	}
 	defer hr.Close()
 
-	forwardLogs(hr.Reader)
-
-	if len(b.ModuleFilePath) > 0 {
-		if err := copyFromContainer(ctx, cli, cdata.ID, builder.ModuleFullPath, b.ModuleFilePath); err != nil {
+	if stdin != nil {
+		if _, err := io.Copy(hr.Conn, stdin); err != nil {
+			return err
+		}
+		if err := hr.CloseWrite(); err != nil {
 			return err
 		}
-		logger.WithField("path", b.ModuleFilePath).Info("kernel module available")
 	}
 
-	if len(b.ProbeFilePath) > 0 {
-		if err := copyFromContainer(ctx, cli, cdata.ID, builder.ProbeFullPath, b.ProbeFilePath); err != nil {
+	stages := newStageTracer(ctx, b)
+	forwardLogs(io.TeeReader(hr.Reader, &d.containerLog), stages.observe)
+	stages.end()
+
+	return traceStage(ctx, "output", func(ctx context.Context) error {
+		if len(b.ModuleFilePath) > 0 {
+			if err := copyFromContainer(ctx, cli, cdata.ID, builder.ModuleFullPath, b.ModuleFilePath); err != nil {
+				return err
+			}
+			logger.WithField("path", b.ModuleFilePath).Info("kernel module available")
+		}
+
+		if len(b.ProbeFilePath) > 0 {
+			if err := copyFromContainer(ctx, cli, cdata.ID, builder.ProbeFullPath, b.ProbeFilePath); err != nil {
+				return err
+			}
+			logger.WithField("path", b.ProbeFilePath).Info("eBPF probe available")
+		}
+		if err := attestArtifacts(ctx, b, builderImage, imageDigest); err != nil {
 			return err
 		}
-		logger.WithField("path", b.ProbeFilePath).Info("eBPF probe available")
-	}
+		if err := writeManifest(b); err != nil {
+			return err
+		}
+		return rsyncArtifacts(ctx, b)
+	})
+}
 
-	return nil
+// dockerExecPlan returns the files to copy into the builder container
+// and the command running the build script, with its stdin if any.
+//
+// In streaming mode the build script is not staged into the container but fed to the shell through its stdin.
+func dockerExecPlan(b *builder.Build, script string, files []dockerCopyFile) ([]dockerCopyFile, []string, io.Reader) {
+	if b.Streaming {
+		return files, []string{"/bin/bash", "-s"}, strings.NewReader(script)
+	}
+	files = append([]dockerCopyFile{{"/driverkit/driverkit.sh", script}}, files...)
+	return files, []string{"/bin/bash", "/driverkit/driverkit.sh"}, nil
 }
 
 func copyFromContainer(ctx context.Context, cli *client.Client, ID, from, to string) error {
@@ -302,13 +452,9 @@ func copyFromContainer(ctx context.Context, cli *client.Client, ID, from, to str
 }
 
 func (bp *DockerBuildProcessor) cleanup(cli *client.Client, ID string) {
-	if !bp.clean {
-		bp.clean = true
-		logger.Debug("context canceled")
-		duration := time.Second
-		if err := cli.ContainerStop(context.Background(), ID, &duration); err != nil && !client.IsErrNotFound(err) {
-			logger.WithError(err).WithField("container_id", ID).Error("error stopping container")
-		}
+	duration := time.Second
+	if err := cli.ContainerStop(context.Background(), ID, &duration); err != nil && !client.IsErrNotFound(err) {
+		logger.WithError(err).WithField("container_id", ID).Error("error stopping container")
 	}
 }
 
@@ -336,12 +482,16 @@ func tarWriterFiles(buf io.Writer, files []dockerCopyFile) error {
 	return nil
 }
 
-func forwardLogs(logPipe io.Reader) {
+// forwardLogs logs the lines read from logPipe, passing each one of them to the given observers too.
+func forwardLogs(logPipe io.Reader, observers ...func(line []byte)) {
 	lineReader := bufio.NewReader(logPipe)
 	for {
 		line, err := lineReader.ReadBytes('\n')
 		if len(line) > 0 {
 			logger.Debugf("%s", line)
+			for _, observe := range observers {
+				observe(line)
+			}
 		}
 		if err == io.EOF {
 			logger.WithError(err).Debug("log pipe close")
@@ -0,0 +1,55 @@
+package driverbuilder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestCheckBuilderImageVersion(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	v, err := builder.Factory(builder.TargetTypeUbuntu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := "docker.io/falcosecurity/driverkit-builder-any-x86_64_gcc12.0.0:latest"
+	old := map[string]string{builder.BuilderImageVersionLabel: "0.9.0"}
+
+	// an older image triggers a warning
+	if err := checkBuilderImage(&builder.Build{}, v, image, old); err != nil {
+		t.Fatalf("expected a warning only, got %v", err)
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Level != logrus.WarnLevel {
+		t.Fatalf("expected a version mismatch warning, got %v", entry)
+	}
+	var tooOld *builder.BuilderImageTooOldErr
+	if err, _ := entry.Data[logrus.ErrorKey].(error); !errors.As(err, &tooOld) || tooOld.Version != "0.9.0" {
+		t.Errorf("unexpected warning error: %v", entry.Data[logrus.ErrorKey])
+	}
+
+	// and fails the build when strict
+	hook.Reset()
+	if err := checkBuilderImage(&builder.Build{StrictBuilderImageVersion: true}, v, image, old); !errors.As(err, &tooOld) {
+		t.Errorf("expected a version mismatch error, got %v", err)
+	}
+
+	// unlabeled images are considered too old
+	if err := checkBuilderImage(&builder.Build{StrictBuilderImageVersion: true}, v, image, nil); !errors.As(err, &tooOld) {
+		t.Errorf("expected a version mismatch error for an unlabeled image, got %v", err)
+	}
+
+	// recent enough images are fine
+	recent := map[string]string{builder.BuilderImageVersionLabel: "1.0.0"}
+	if err := checkBuilderImage(&builder.Build{StrictBuilderImageVersion: true}, v, image, recent); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(hook.Entries) != 0 {
+		t.Errorf("unexpected log entries: %v", hook.Entries)
+	}
+}
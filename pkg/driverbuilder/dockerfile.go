@@ -0,0 +1,87 @@
+package driverbuilder
+
+import (
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// dockerfileHeredocDelimiter terminates the heredocs embedding the driverkit files into the Dockerfile.
+const dockerfileHeredocDelimiter = "DRIVERKIT_EOF"
+
+type dockerfileData struct {
+	Target        string
+	KernelRelease string
+	Architecture  string
+	// BuilderArchitecture is the platform of the builder image, amd64 for the cross-compiled architectures.
+	BuilderArchitecture string
+	BuilderImage        string
+	Files               []dockerCopyFile
+	ModuleFullPath      string
+	ProbeFullPath       string
+	Delimiter           string
+}
+
+// dockerfileTemplate embeds the very same files the docker processor copies into the builder container,
+// so that building the image runs the build script (downloading the resolved kernel URLs) in a RUN step.
+// Heredocs need BuildKit, hence the syntax directive.
+const dockerfileTemplate = `# syntax=docker/dockerfile:1.4
+# Generated by driverkit for target {{ .Target }}, kernel release {{ .KernelRelease }} ({{ .Architecture }}).
+FROM --platform=linux/{{ .BuilderArchitecture }} {{ .BuilderImage }}
+{{ range .Files }}
+COPY <<"{{ $.Delimiter }}" {{ .Name }}
+{{ .Body }}
+{{ $.Delimiter }}
+{{ end }}
+RUN /bin/bash /driverkit/driverkit.sh
+{{- if .ModuleFullPath }}
+
+# The kernel module is available at {{ .ModuleFullPath }}
+{{- end }}
+{{- if .ProbeFullPath }}
+
+# The eBPF probe is available at {{ .ProbeFullPath }}
+{{- end }}
+`
+
+func renderDockerfile(w io.Writer, dd dockerfileData) error {
+	t := template.New("dockerfile")
+	parsed, err := t.Parse(dockerfileTemplate)
+	if err != nil {
+		return err
+	}
+	return parsed.Execute(w, dd)
+}
+
+// writeDockerfile writes to b.DockerfilePath a Dockerfile that builds the drivers
+// in builderImage, using the given files.
+func writeDockerfile(b *builder.Build, builderImage string, files []dockerCopyFile) error {
+	dd := dockerfileData{
+		Target:              string(b.TargetType),
+		KernelRelease:       b.KernelRelease,
+		Architecture:        b.Architecture,
+		BuilderArchitecture: b.BuilderArchitecture(),
+		BuilderImage:        builderImage,
+		Delimiter:           dockerfileHeredocDelimiter,
+	}
+	for _, f := range files {
+		// the heredoc delimiter must be on its own line
+		dd.Files = append(dd.Files, dockerCopyFile{f.Name, strings.TrimSuffix(f.Body, "\n")})
+	}
+	if len(b.ModuleFilePath) > 0 {
+		dd.ModuleFullPath = builder.ModuleFullPath
+	}
+	if len(b.ProbeFilePath) > 0 {
+		dd.ProbeFullPath = builder.ProbeFullPath
+	}
+
+	out, err := os.Create(b.DockerfilePath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return renderDockerfile(out, dd)
+}
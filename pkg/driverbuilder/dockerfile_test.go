@@ -0,0 +1,94 @@
+package driverbuilder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+type testImagesLister struct{}
+
+func (l *testImagesLister) LoadImages() []builder.Image {
+	return []builder.Image{
+		{
+			Target:     builder.Type("any"),
+			GCCVersion: semver.MustParse("8.0.0"),
+			Name:       "docker.io/falcosecurity/driverkit-builder-any-x86_64_gcc8.0.0",
+		},
+	}
+}
+
+func TestWriteDockerfileUbuntu(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer mirror.Close()
+
+	urls := []string{
+		mirror.URL + "/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb",
+		mirror.URL + "/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_all.deb",
+	}
+	b := &builder.Build{
+		TargetType:       builder.Type("ubuntu"),
+		KernelRelease:    "5.15.0-1004-intel-iotg",
+		KernelVersion:    "6",
+		Architecture:     "amd64",
+		KernelConfigData: "bm8tZGF0YQ==",
+		DriverVersion:    "master",
+		ModuleFilePath:   "/tmp/falco.ko",
+		ModuleDriverName: "falco",
+		ModuleDeviceName: "falco",
+		RepoOrg:          "falcosecurity",
+		RepoName:         "libs",
+		KernelUrls:       urls,
+		ImagesListers:    []builder.ImagesLister{&testImagesLister{}},
+		Images:           make(builder.ImagesMap),
+		DockerfilePath:   filepath.Join(t.TempDir(), "Dockerfile"),
+	}
+
+	v, err := builder.Factory(b.TargetType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := builder.Script(context.Background(), v, b.ToConfig(), b.KernelReleaseFromBuildConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []dockerCopyFile{
+		{"/driverkit/driverkit.sh", script},
+		{"/driverkit/kernel.config", "no-data"},
+	}
+	if err := writeDockerfile(b, b.GetBuilderImage(), files); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(b.DockerfilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dockerfile := string(data)
+
+	expected := []string{
+		"FROM --platform=linux/amd64 docker.io/falcosecurity/driverkit-builder-any-x86_64_gcc8.0.0:latest\n",
+		"COPY <<\"" + dockerfileHeredocDelimiter + "\" /driverkit/driverkit.sh\n",
+		"COPY <<\"" + dockerfileHeredocDelimiter + "\" /driverkit/kernel.config\nno-data\n" + dockerfileHeredocDelimiter + "\n",
+		"curl --silent -o kernel.deb -SL " + urls[0] + "\n",
+		"curl --silent -o kernel.deb -SL " + urls[1] + "\n",
+		"make CC=/usr/bin/gcc-8.0.0 ARCH=x86 KERNELDIR=$sourcedir\n",
+		"RUN /bin/bash /driverkit/driverkit.sh\n",
+		"# The kernel module is available at " + builder.ModuleFullPath + "\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(dockerfile, e) {
+			t.Errorf("expected the Dockerfile to contain %q, got:\n%s", e, dockerfile)
+		}
+	}
+	if strings.Contains(dockerfile, "eBPF probe") {
+		t.Errorf("expected the Dockerfile not to mention the eBPF probe, got:\n%s", dockerfile)
+	}
+}
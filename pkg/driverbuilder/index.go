@@ -0,0 +1,138 @@
+package driverbuilder
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	logger "github.com/sirupsen/logrus"
+)
+
+// Index is the catalog of the artifacts of a drivers distribution, by target, architecture and kernel release.
+type Index map[string]map[string]map[string][]IndexEntry
+
+// IndexEntry is an artifact of the catalog.
+type IndexEntry struct {
+	DriverVersion string `json:"driverversion"`
+	KernelVersion string `json:"kernelversion"`
+	// Kind is either "module" or "probe".
+	Kind string `json:"kind"`
+	// Path is relative to the root of the distribution.
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// canonicalArtifact is the build key parsed out of a canonical artifact path.
+type canonicalArtifact struct {
+	DriverVersion string
+	Architecture  string
+	DriverName    string
+	TargetType    builder.Type
+	KernelRelease string
+	KernelVersion string
+	Kind          string
+}
+
+// parseCanonicalArtifactPath parses the build key out of the given path, relative to the root of a drivers distribution,
+// laid out with either naming strategy. The artifacts named with the legacy one are x86_64 ones,
+// as it predates the multi-arch support.
+func parseCanonicalArtifactPath(p string) (*canonicalArtifact, bool) {
+	a := &canonicalArtifact{}
+	switch filepath.Ext(p) {
+	case ".ko":
+		a.Kind = "module"
+	case ".o":
+		a.Kind = "probe"
+	default:
+		return nil, false
+	}
+
+	dirs := strings.Split(filepath.ToSlash(filepath.Dir(p)), "/")
+	switch len(dirs) {
+	case 1:
+		a.DriverVersion, a.Architecture = dirs[0], kernelrelease.Architecture(kernelrelease.ArchitectureAmd64).ToNonDeb()
+	case 2:
+		a.DriverVersion, a.Architecture = dirs[0], dirs[1]
+	default:
+		return nil, false
+	}
+	if a.DriverVersion == "." {
+		return nil, false
+	}
+
+	// <drivername>_<target>_<kernelrelease>_<kernelversion>, the target telling apart where the driver name ends
+	fields := strings.Split(strings.TrimSuffix(filepath.Base(p), filepath.Ext(p)), "_")
+	for i := 1; i < len(fields)-2; i++ {
+		if _, ok := builder.BuilderByTarget[builder.Type(fields[i])]; ok {
+			a.DriverName = strings.Join(fields[:i], "_")
+			a.TargetType = builder.Type(fields[i])
+			a.KernelRelease = strings.Join(fields[i+1:len(fields)-1], "_")
+			a.KernelVersion = fields[len(fields)-1]
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// BuildIndex scans the drivers distribution rooted at dir, as laid out by the rsync output,
+// returning the catalog of the artifacts found, in lexical order. Other files are skipped.
+func BuildIndex(dir string) (Index, error) {
+	index := make(Index)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		a, ok := parseCanonicalArtifactPath(rel)
+		if !ok {
+			logger.WithField("path", rel).Debug("not an artifact, skipping it")
+			return nil
+		}
+		sum, err := fileSHA256(p)
+		if err != nil {
+			return err
+		}
+
+		target := a.TargetType.String()
+		if index[target] == nil {
+			index[target] = make(map[string]map[string][]IndexEntry)
+		}
+		if index[target][a.Architecture] == nil {
+			index[target][a.Architecture] = make(map[string][]IndexEntry)
+		}
+		index[target][a.Architecture][a.KernelRelease] = append(index[target][a.Architecture][a.KernelRelease], IndexEntry{
+			DriverVersion: a.DriverVersion,
+			KernelVersion: a.KernelVersion,
+			Kind:          a.Kind,
+			Path:          filepath.ToSlash(rel),
+			SHA256:        sum,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func fileSHA256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
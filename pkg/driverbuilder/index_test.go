@@ -0,0 +1,74 @@
+package driverbuilder
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+func TestBuildIndex(t *testing.T) {
+	dir := t.TempDir()
+	write := func(rel, content string) string {
+		p := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+	}
+
+	ubuntu := &builder.Build{
+		TargetType:       builder.TargetTypeUbuntu,
+		KernelRelease:    "5.15.0-52-generic",
+		KernelVersion:    "58",
+		Architecture:     "arm64",
+		DriverVersion:    "2.0.0+driver",
+		ModuleDriverName: "falco",
+	}
+	centos := &builder.Build{
+		TargetType:       builder.TargetTypeCentos,
+		KernelRelease:    "3.10.0-1160.el7.x86_64",
+		KernelVersion:    "1",
+		DriverVersion:    "1.0.0",
+		ModuleDriverName: "falco",
+		NamingStrategy:   builder.NamingStrategyLegacy,
+	}
+	moduleSum := write(CanonicalArtifactPath(ubuntu, ".ko"), "module")
+	probeSum := write(CanonicalArtifactPath(ubuntu, ".o"), "probe")
+	legacySum := write(CanonicalArtifactPath(centos, ".ko"), "legacy")
+	write("README.md", "not an artifact")
+	write("2.0.0+driver/aarch64/falco.ko", "not a canonical name")
+
+	index, err := BuildIndex(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := Index{
+		"ubuntu": {
+			"aarch64": {
+				"5.15.0-52-generic": {
+					{DriverVersion: "2.0.0+driver", KernelVersion: "58", Kind: "module", Path: "2.0.0+driver/aarch64/falco_ubuntu_5.15.0-52-generic_58.ko", SHA256: moduleSum},
+					{DriverVersion: "2.0.0+driver", KernelVersion: "58", Kind: "probe", Path: "2.0.0+driver/aarch64/falco_ubuntu_5.15.0-52-generic_58.o", SHA256: probeSum},
+				},
+			},
+		},
+		"centos": {
+			"x86_64": {
+				"3.10.0-1160.el7.x86_64": {
+					{DriverVersion: "1.0.0", KernelVersion: "1", Kind: "module", Path: "1.0.0/falco_centos_3.10.0-1160.el7.x86_64_1.ko", SHA256: legacySum},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(expected, index) {
+		t.Errorf("Expected index %+v, got %+v", expected, index)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/signals"
 	"os"
+	"strings"
 	"time"
 
 	logger "github.com/sirupsen/logrus"
@@ -59,11 +60,22 @@ func (bp *KubernetesBuildProcessor) String() string {
 }
 
 func (bp *KubernetesBuildProcessor) Start(b *builder.Build) error {
+	ctx, span := startBuildSpan(context.Background(), b)
+	defer span.End()
+
+	if skipPublished(ctx, b) {
+		return nil
+	}
 	logger.Debug("doing a new kubernetes build")
-	return bp.buildModule(b)
+	if err := bp.buildModule(ctx, b); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	b.Progress(builder.PhaseDone, "done")
+	return nil
 }
 
-func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
+func (bp *KubernetesBuildProcessor) buildModule(ctx context.Context, b *builder.Build) error {
 	deadline := int64(bp.timeout)
 	namespace := bp.namespace
 	uid := uuid.NewUUID()
@@ -73,17 +85,32 @@ func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
 	configClient := bp.coreV1Client.ConfigMaps(namespace)
 
 	kr := b.KernelReleaseFromBuildConfig()
+	ctx = signals.WithStandardSignals(ctx)
 
 	// create a builder based on the chosen build type
-	v, err := builder.Factory(b.TargetType)
+	v, err := builder.BuilderForTarget(b.TargetType)
 	if err != nil {
 		return err
 	}
 
 	c := b.ToConfig()
+	if len(b.LocalHeaders) > 0 {
+		return fmt.Errorf("the local kernel headers cannot be mounted into kubernetes builds")
+	}
+	if len(c.PackageCacheDir) > 0 {
+		logger.Warn("the package cache cannot be mounted into kubernetes builds, ignoring it")
+		c.PackageCacheDir = ""
+	}
+	if c.MirrorAuth {
+		return fmt.Errorf("the mirror credentials cannot be passed to kubernetes builds")
+	}
 
 	// generate the build script from the builder
-	res, err := builder.Script(v, c, kr)
+	var res string
+	err = traceStage(ctx, string(builder.PhaseResolution), func(ctx context.Context) error {
+		res, err = builder.Script(ctx, v, c, kr)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -148,6 +175,11 @@ func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
 			"unlock.sh":             deleteLock,
 		},
 	}
+	if len(c.CACertPath) > 0 {
+		if cm.Data["ca.pem"], err = builder.CACert(b); err != nil {
+			return err
+		}
+	}
 	// Construct environment variable array of corev1.EnvVar
 	var envs []corev1.EnvVar
 	// Add http_porxy and https_proxy environment variable
@@ -218,8 +250,6 @@ func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
 		},
 	}
 
-	ctx := context.Background()
-	ctx = signals.WithStandardSignals(ctx)
 	_, err = configClient.Create(ctx, cm, metav1.CreateOptions{})
 	if err != nil {
 		return err
@@ -230,25 +260,39 @@ func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
 		return err
 	}
 	defer podClient.Delete(ctx, pod.Name, metav1.DeleteOptions{})
-	return bp.copyModuleAndProbeFromPodWithUID(ctx, b, namespace, string(uid))
+
+	// the pod logs are not followed, the download of the kernel headers is traced along with the compilation
+	var p *corev1.Pod
+	err = traceStage(ctx, string(builder.PhaseCompile), func(ctx context.Context) error {
+		p, err = bp.copyModuleAndProbeFromPodWithUID(ctx, b, namespace, string(uid))
+		return err
+	})
+	if err != nil || p == nil {
+		return err
+	}
+	return traceStage(ctx, "output", func(ctx context.Context) error {
+		return outputPodArtifacts(ctx, b, builderImage, p)
+	})
 }
 
-func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context.Context, build *builder.Build, namespace string, falcoBuilderUID string) error {
+// copyModuleAndProbeFromPodWithUID copies the drivers out of the builder pod labeled with falcoBuilderUID,
+// returning the pod they were copied from, nil if it did not run.
+func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context.Context, build *builder.Build, namespace string, falcoBuilderUID string) (*corev1.Pod, error) {
 	namespacedClient := bp.coreV1Client.Pods(namespace)
 	watch, err := namespacedClient.Watch(ctx, metav1.ListOptions{
 		LabelSelector: fmt.Sprintf("%s=%s", falcoBuilderUIDLabel, falcoBuilderUID),
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Give it ten minutes to complete, if it doesn't give an error
 	// TODO(fntlnz): maybe pass this from the outside?
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
 	defer cancel()
 	for {
 		select {
 		case <-ctx.Done():
-			return errors.New("module copy from pod interrupted before the copy was complete")
+			return nil, errors.New("module copy from pod interrupted before the copy was complete")
 		default:
 			event := <-watch.ResultChan()
 			p, ok := event.Object.(*corev1.Pod)
@@ -264,26 +308,57 @@ func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context
 				if builder.ModuleFullPath != "" {
 					err = copySingleFileFromPod(build.ModuleFilePath, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, builder.ModuleFullPath, moduleLockFile)
 					if err != nil {
-						return err
+						return nil, err
 					}
 					logger.Info("Kernel Module extraction successful")
 				}
 				if builder.ProbeFullPath != "" {
 					err = copySingleFileFromPod(build.ProbeFilePath, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, builder.ProbeFullPath, probeLockFile)
 					if err != nil {
-						return err
+						return nil, err
 					}
 					logger.Info("Probe Module extraction successful")
 				}
 				err = unlockPod(bp.coreV1Client, bp.clientConfig, p)
 				if err != nil {
-					return err
+					return nil, err
 				}
 				logger.WithField(falcoBuilderUIDLabel, falcoBuilderUID).Info("completed downloading from pod")
+				return p, nil
 			}
-			return nil
+			return nil, nil
+		}
+	}
+}
+
+// outputPodArtifacts attests, lists and syncs the drivers of the build copied out of the builder pod p.
+func outputPodArtifacts(ctx context.Context, build *builder.Build, builderImage string, p *corev1.Pod) error {
+	if imageDigest := podImageDigest(p); len(imageDigest) > 0 {
+		if err := attestArtifacts(ctx, build, builderImage, imageDigest); err != nil {
+			return err
+		}
+	} else if build.Attest {
+		logger.Warn("the digest of the builder image pulled by the node is unknown, the artifacts are not attested")
+	}
+	if err := writeManifest(build); err != nil {
+		return err
+	}
+	return rsyncArtifacts(ctx, build)
+}
+
+// podImageDigest returns the digest of the image of the builder container of the pod p, as pulled by its node, if known,
+// e.g. from the docker-pullable://docker.io/falcosecurity/driverkit-builder@sha256:... image id.
+func podImageDigest(p *corev1.Pod) string {
+	for _, status := range p.Status.ContainerStatuses {
+		id := status.ImageID
+		if i := strings.LastIndex(id, "@"); i >= 0 {
+			id = id[i+1:]
+		}
+		if strings.HasPrefix(id, "sha256:") {
+			return id
 		}
 	}
+	return ""
 }
 
 func unlockPod(podClient v1.PodsGetter, clientConfig *restclient.Config, pod *corev1.Pod) error {
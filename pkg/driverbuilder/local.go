@@ -0,0 +1,361 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/signals"
+	logger "github.com/sirupsen/logrus"
+)
+
+// LocalBuildProcessorName is a constant containing the local name.
+const LocalBuildProcessorName = "local"
+
+// LocalBuildProcessor runs the build scripts directly on the host, with its own toolchain,
+// e.g. on dedicated build hosts without a docker daemon.
+// As the scripts expect the layout of the builder containers, the files they need get written under /driverkit,
+// and the drivers get built under /tmp/driver.
+type LocalBuildProcessor struct {
+	timeout int
+	// root is where the files needed by the build scripts get written, / but for the tests.
+	root string
+	// driverDir is where the drivers get built, builder.DriverDirectory but for the tests.
+	driverDir string
+	// lookPath finds the build tools on the host.
+	lookPath func(file string) (string, error)
+}
+
+// NewLocalBuildProcessor constructs a LocalBuildProcessor whose builds get killed after timeout seconds, if positive.
+func NewLocalBuildProcessor(timeout int) *LocalBuildProcessor {
+	return &LocalBuildProcessor{
+		timeout:   timeout,
+		root:      "/",
+		driverDir: builder.DriverDirectory,
+		lookPath:  exec.LookPath,
+	}
+}
+
+func (bp *LocalBuildProcessor) String() string {
+	return LocalBuildProcessorName
+}
+
+// Start the local processor
+func (bp *LocalBuildProcessor) Start(b *builder.Build) error {
+	_, err := bp.Run(context.Background(), b)
+	return err
+}
+
+// StartWithContext starts the local processor, killing the build script as soon as ctx is done.
+func (bp *LocalBuildProcessor) StartWithContext(ctx context.Context, b *builder.Build) error {
+	_, err := bp.Run(ctx, b)
+	return err
+}
+
+// Run builds the drivers of b on the host, returning the paths of the artifacts produced.
+func (bp *LocalBuildProcessor) Run(ctx context.Context, b *builder.Build) ([]string, error) {
+	ctx, span := startBuildSpan(ctx, b)
+	defer span.End()
+
+	artifacts, err := bp.run(ctx, b)
+	if err != nil {
+		span.RecordError(err)
+	} else {
+		b.Progress(builder.PhaseDone, "done")
+	}
+	return artifacts, err
+}
+
+func (bp *LocalBuildProcessor) run(ctx context.Context, b *builder.Build) ([]string, error) {
+	if skipPublished(ctx, b) {
+		return nil, nil
+	}
+	logger.Debug("doing a new local build")
+
+	kr := b.KernelReleaseFromBuildConfig()
+	v, err := builder.BuilderForTarget(b.TargetType)
+	if err != nil {
+		return nil, err
+	}
+	c := b.ToConfig()
+	c.DriverBuildDir = bp.driverDir
+
+	var script string
+	err = traceStage(ctx, "resolution", func(ctx context.Context) error {
+		script, err = builder.Script(ctx, v, c, kr)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if missing := bp.missingBuildTools(c, script); len(missing) > 0 {
+		return nil, fmt.Errorf("missing build tools on the host: %s", strings.Join(missing, ", "))
+	}
+
+	files, err := localBuildFiles(b, c)
+	if err != nil {
+		return nil, err
+	}
+	links := map[string]string{}
+	if len(c.PackageCacheDir) > 0 {
+		if links[builder.ContainerPackageCacheDir], err = filepath.Abs(c.PackageCacheDir); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range b.LocalHeaders {
+		if links[path.Join(builder.ContainerLocalHeadersDir, filepath.Base(p))], err = filepath.Abs(p); err != nil {
+			return nil, err
+		}
+	}
+	cleanup, err := bp.writeLayout(files, links)
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = signals.WithStandardSignals(ctx)
+	if bp.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(bp.timeout)*time.Second)
+		defer cancel()
+	}
+	if err := runLocalScript(ctx, b, script); err != nil {
+		return nil, err
+	}
+
+	var artifacts []string
+	err = traceStage(ctx, "output", func(ctx context.Context) error {
+		for _, a := range []struct{ from, to, msg string }{
+			{builder.ModulePath(bp.driverDir), b.ModuleFilePath, "kernel module available"},
+			{builder.ProbePath(bp.driverDir), b.ProbeFilePath, "eBPF probe available"},
+		} {
+			if len(a.to) == 0 {
+				continue
+			}
+			if err := copyLocalFile(a.from, a.to); err != nil {
+				return err
+			}
+			logger.WithField("path", a.to).Info(a.msg)
+			artifacts = append(artifacts, a.to)
+		}
+		if err := attestArtifacts(ctx, b, "", ""); err != nil {
+			return err
+		}
+		if err := writeManifest(b); err != nil {
+			return err
+		}
+		return rsyncArtifacts(ctx, b)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+// localBuildFiles returns the files the build script of b expects to find, along with their content.
+func localBuildFiles(b *builder.Build, c builder.Config) ([]dockerCopyFile, error) {
+	bufFillDriverConfig := bytes.NewBuffer(nil)
+	if err := renderFillDriverConfig(bufFillDriverConfig, driverConfigData{DriverVersion: c.DriverVersion, DriverName: c.DriverName, DeviceName: c.DeviceName}); err != nil {
+		return nil, err
+	}
+	objList, err := LoadMakefileObjList(c)
+	if err != nil {
+		return nil, err
+	}
+	bufMakefile := bytes.NewBuffer(nil)
+	if err := renderMakefile(bufMakefile, makefileData{ModuleName: c.DriverName, ModuleBuildDir: c.DriverBuildDir, MakeObjList: objList}); err != nil {
+		return nil, err
+	}
+	configDecoded, err := base64.StdEncoding.DecodeString(b.KernelConfigData)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []dockerCopyFile{
+		{"/driverkit/kernel.config", string(configDecoded)},
+		{"/driverkit/module-Makefile", bufMakefile.String()},
+		{"/driverkit/fill-driver-config.sh", bufFillDriverConfig.String()},
+	}
+	if c.MirrorAuth {
+		files = append(files, dockerCopyFile{builder.MirrorAuthConfigPath, builder.MirrorAuthConfig(b)})
+	}
+	if len(c.CACertPath) > 0 {
+		caCert, err := builder.CACert(b)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, dockerCopyFile{builder.ContainerCACertPath, caCert})
+	}
+	return files, nil
+}
+
+// writeLayout writes the given files, and links the given paths to their targets, under the root of bp,
+// returning the func removing them along with the directories created for them.
+func (bp *LocalBuildProcessor) writeLayout(files []dockerCopyFile, links map[string]string) (func(), error) {
+	var created []string
+	cleanup := func() {
+		for i := len(created) - 1; i >= 0; i-- {
+			os.Remove(created[i])
+		}
+	}
+	write := func(name string, fn func(p string) error) error {
+		p := filepath.Join(bp.root, name)
+		dirs, err := mkdirAll(filepath.Dir(p))
+		created = append(created, dirs...)
+		if err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+		created = append(created, p)
+		return nil
+	}
+	for _, f := range files {
+		body := []byte(f.Body)
+		if err := write(f.Name, func(p string) error { return os.WriteFile(p, body, 0600) }); err != nil {
+			return cleanup, err
+		}
+	}
+	for name, target := range links {
+		target := target
+		if err := write(name, func(p string) error { return os.Symlink(target, p) }); err != nil {
+			return cleanup, err
+		}
+	}
+	return cleanup, nil
+}
+
+// mkdirAll creates the directory at p along with its missing parents, returning the ones it created, outermost first.
+func mkdirAll(p string) ([]string, error) {
+	var missing []string
+	for dir := p; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		missing = append([]string{dir}, missing...)
+	}
+	if err := os.MkdirAll(p, 0755); err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
+// localBuildTools are the tools the build scripts may run, needed on the host when they do.
+var localBuildTools = []string{"bash", "curl", "tar", "bsdtar", "ar", "rpm2cpio", "cpio", "xz", "make", "objcopy", "find", "readlink", "sed"}
+
+// localCompilerRegex matches the compiler the build scripts pass to make.
+var localCompilerRegex = regexp.MustCompile(`CC=(\S+)`)
+
+// localToolchain are the tools a host needs at least for the local builds to be worth trying.
+var localToolchain = []string{"bash", "curl", "tar", "make", "gcc"}
+
+// HasToolchain tells whether the host has the basic tools of the local builds,
+// the ones each build script needs being checked before running it.
+func (bp *LocalBuildProcessor) HasToolchain() bool {
+	for _, tool := range localToolchain {
+		if _, err := bp.lookPath(tool); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// missingBuildTools returns the tools the given script, building the drivers of c, needs and the host lacks.
+func (bp *LocalBuildProcessor) missingBuildTools(c builder.Config, script string) []string {
+	var tools []string
+	for _, tool := range localBuildTools {
+		if regexp.MustCompile(`(^|[\s;&|(])` + regexp.QuoteMeta(tool) + `(\s|$)`).MatchString(script) {
+			tools = append(tools, tool)
+		}
+	}
+	for _, m := range localCompilerRegex.FindAllStringSubmatch(script, -1) {
+		tools = append(tools, m[1])
+	}
+	// the probe makefile of the driver sources runs them
+	if len(c.ProbeFilePath) > 0 {
+		tools = append(tools, "clang", "llc")
+	}
+
+	var missing []string
+	seen := map[string]bool{}
+	for _, tool := range tools {
+		if seen[tool] {
+			continue
+		}
+		seen[tool] = true
+		if _, err := bp.lookPath(tool); err != nil {
+			missing = append(missing, tool)
+		}
+	}
+	return missing
+}
+
+// runLocalScript runs the script of b with bash, logging its output, until ctx is done.
+func runLocalScript(ctx context.Context, b *builder.Build, script string) error {
+	f, err := os.CreateTemp("", "driverkit-*.sh")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(script); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/bash", f.Name())
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+
+	stages := newStageTracer(ctx, b)
+	forwardLogs(pr, stages.observe)
+	stages.end()
+	if err := <-waitErr; err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("build script failed: %w", err)
+	}
+	return nil
+}
+
+// copyLocalFile copies the file at from to the path to.
+func copyLocalFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,146 @@
+package driverbuilder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder/buildertest"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// localTestBuilder builds the module out of the makefile written for the build script.
+type localTestBuilder struct{ script string }
+
+func (l *localTestBuilder) Name() string { return "localtest" }
+
+func (l *localTestBuilder) TemplateScript() string { return l.script }
+
+func (l *localTestBuilder) URLs(_ context.Context, _ builder.Config, _ kernelrelease.KernelRelease) ([]string, error) {
+	return []string{"https://localtest.example.com/kernel-headers.rpm"}, nil
+}
+
+func (l *localTestBuilder) TemplateData(c builder.Config, _ kernelrelease.KernelRelease, _ []string) interface{} {
+	return map[string]string{"DriverBuildDir": c.DriverBuildDir}
+}
+
+func (l *localTestBuilder) SupportedArchitectures() []kernelrelease.Architecture {
+	return []kernelrelease.Architecture{kernelrelease.ArchitectureAmd64}
+}
+
+func newLocalTestBuild(t *testing.T, script string) *builder.Build {
+	const target builder.Type = "localtest"
+	if err := builder.RegisterBuilder(target, &localTestBuilder{script: script}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { delete(builder.BuilderByTarget, target) })
+
+	mirror := buildertest.NewMirror().
+		Packages("/kernel-headers.rpm").
+		Handle("/falcosecurity/libs/master/driver/Makefile.in", buildertest.Response{Body: "@DRIVER_NAME@-y += main.o\n"})
+	return &builder.Build{
+		TargetType:       target,
+		KernelRelease:    "5.10.0-1-amd64",
+		KernelVersion:    "1",
+		Architecture:     "amd64",
+		KernelConfigData: "bm8tZGF0YQ==",
+		DriverVersion:    "master",
+		ModuleFilePath:   filepath.Join(t.TempDir(), "falco.ko"),
+		ModuleDriverName: "falco",
+		ModuleDeviceName: "falco",
+		RepoOrg:          "falcosecurity",
+		RepoName:         "libs",
+		Transport:        mirror,
+	}
+}
+
+func TestLocalBuildProcessorRun(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("LOCAL_ROOT", root)
+	b := newLocalTestBuild(t, `{{ template "stage" "compile" }}
+mkdir -p {{ .DriverBuildDir }}
+cat "$LOCAL_ROOT/driverkit/module-Makefile" "$LOCAL_ROOT/driverkit/kernel.config" > {{ .DriverBuildDir }}/module.ko
+`)
+
+	var events []builder.Event
+	b.ProgressFn = func(e builder.Event) { events = append(events, e) }
+
+	bp := NewLocalBuildProcessor(0)
+	bp.root = root
+	bp.driverDir = t.TempDir()
+	artifacts, err := bp.Run(context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(artifacts, []string{b.ModuleFilePath}) {
+		t.Errorf("expected the module as the only artifact, got %v", artifacts)
+	}
+	data, err := os.ReadFile(b.ModuleFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "falco-y += main.o") || !strings.Contains(string(data), bp.driverDir) || !strings.HasSuffix(string(data), "no-data") {
+		t.Errorf("expected the module built out of the written makefile and kernel config, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "driverkit")); !os.IsNotExist(err) {
+		t.Errorf("expected the build files to be removed after the build, got %v", err)
+	}
+	var phases []string
+	for _, e := range events {
+		phases = append(phases, string(e.Phase)+": "+e.Message)
+	}
+	if strings.Join(phases, ", ") != "resolution: resolving URLs, resolution: resolved 1 headers packages, compile: compiling module, done: done" {
+		t.Errorf("unexpected progress of the build: %v", phases)
+	}
+}
+
+func TestLocalBuildProcessorRunFailure(t *testing.T) {
+	b := newLocalTestBuild(t, "exit 3")
+
+	bp := NewLocalBuildProcessor(0)
+	bp.root = t.TempDir()
+	bp.driverDir = t.TempDir()
+	if _, err := bp.Run(context.Background(), b); err == nil || !strings.Contains(err.Error(), "build script failed") {
+		t.Errorf("expected the failure of the build script, got %v", err)
+	}
+	if _, err := os.Stat(b.ModuleFilePath); !os.IsNotExist(err) {
+		t.Errorf("expected no module, got %v", err)
+	}
+}
+
+func TestLocalMissingBuildTools(t *testing.T) {
+	host := map[string]bool{"bash": true, "curl": true, "tar": true, "make": true, "clang": true}
+	bp := &LocalBuildProcessor{lookPath: func(file string) (string, error) {
+		if host[file] {
+			return "/usr/bin/" + file, nil
+		}
+		return "", errors.New("not found")
+	}}
+
+	script := `curl --silent -o kernel.deb -SL https://example.com/kernel.deb
+ar x kernel.deb
+tar -xf data.tar.xz
+make CC=/usr/bin/gcc-12 KERNELDIR=/tmp/kernel
+`
+	missing := bp.missingBuildTools(builder.Config{Build: &builder.Build{ProbeFilePath: "/tmp/probe.o"}}, script)
+	if !reflect.DeepEqual(missing, []string{"ar", "/usr/bin/gcc-12", "llc"}) {
+		t.Errorf("unexpected missing tools: %v", missing)
+	}
+	if missing := bp.missingBuildTools(builder.Config{Build: &builder.Build{}}, "make -C /tmp/driver"); len(missing) > 0 {
+		t.Errorf("expected no missing tools, got %v", missing)
+	}
+
+	// the host has no toolchain without a compiler
+	if bp.HasToolchain() {
+		t.Errorf("expected no toolchain without gcc")
+	}
+	host["gcc"] = true
+	if !bp.HasToolchain() {
+		t.Errorf("expected a toolchain")
+	}
+}
@@ -0,0 +1,39 @@
+package driverbuilder
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+)
+
+// writeManifest writes the manifest recorded while rendering the build of b, along with the digests of its artifacts,
+// when b asks for it.
+func writeManifest(b *builder.Build) error {
+	if len(b.ManifestPath) == 0 || b.Manifest == nil {
+		return nil
+	}
+	m := *b.Manifest
+	m.Artifacts = nil
+	for _, p := range []string{b.ModuleFilePath, b.ProbeFilePath} {
+		if len(p) == 0 {
+			continue
+		}
+		sum, err := fileSHA256(p)
+		if err != nil {
+			return err
+		}
+		m.Artifacts = append(m.Artifacts, builder.ManifestArtifact{Name: filepath.Base(p), SHA256: sum})
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(b.ManifestPath, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	logger.WithField("path", b.ManifestPath).Info("build manifest available")
+	return nil
+}
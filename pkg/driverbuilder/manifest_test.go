@@ -0,0 +1,50 @@
+package driverbuilder
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+func TestWriteManifest(t *testing.T) {
+	out := t.TempDir()
+	b := &builder.Build{
+		ModuleFilePath: filepath.Join(out, "falco.ko"),
+		ManifestPath:   filepath.Join(out, "falco.json"),
+		Manifest: &builder.Manifest{
+			Target:             "ubuntu",
+			KernelRelease:      "5.15.0-52-generic",
+			KernelVersion:      "58",
+			Architecture:       "amd64",
+			Flavor:             "generic",
+			DriverVersion:      "2.0.0+driver",
+			KernelDownloadURLs: []string{"https://mirror.example.com/linux-headers-5.15.0-52-generic_5.15.0-52.58_amd64.deb"},
+		},
+	}
+	if err := os.WriteFile(b.ModuleFilePath, []byte("module"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeManifest(b); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	data, err := os.ReadFile(b.ManifestPath)
+	if err != nil {
+		t.Fatalf("Expected the manifest to be written: %s", err)
+	}
+	var got builder.Manifest
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Invalid manifest: %s", err)
+	}
+	expected := *b.Manifest
+	expected.Artifacts = []builder.ManifestArtifact{{Name: "falco.ko", SHA256: fmt.Sprintf("%x", sha256.Sum256([]byte("module")))}}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected manifest %+v, got %+v", expected, got)
+	}
+}
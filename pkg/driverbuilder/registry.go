@@ -0,0 +1,199 @@
+package driverbuilder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+)
+
+// manifestMediaTypes are the manifests the registries are asked for when looking for published artifacts.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.artifact.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+var invalidTagChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// maxTagLength is the maximum length of the tags allowed by the OCI distribution spec.
+const maxTagLength = 128
+
+// canonicalArtifactTag returns the tag the artifact of b with the given extension gets published with,
+// derived from its canonical path; when too long for a tag, the digest of the path is used instead.
+//
+// Example: master_x86_64_falco_ubuntu_5.15.0-52-generic_58.ko
+func canonicalArtifactTag(b *builder.Build, ext string) string {
+	p := CanonicalArtifactPath(b, ext)
+	tag := invalidTagChars.ReplaceAllString(strings.ReplaceAll(p, "/", "_"), "_")
+	if len(tag) > maxTagLength {
+		return fmt.Sprintf("sha256-%x", sha256.Sum256([]byte(p)))
+	}
+	return tag
+}
+
+// registryManifestURL returns the URL of the manifest of the given tag in the repository reference,
+// e.g. registry.example.com/falcosecurity/drivers, defaulting to https when it has no scheme.
+func registryManifestURL(reference, tag string) (string, error) {
+	scheme := "https"
+	if s, rest, ok := strings.Cut(reference, "://"); ok {
+		scheme, reference = s, rest
+	}
+	host, repo, ok := strings.Cut(strings.Trim(reference, "/"), "/")
+	if !ok || len(host) == 0 || len(repo) == 0 {
+		return "", fmt.Errorf("invalid publish registry reference: %s", reference)
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, host, repo, tag), nil
+}
+
+// errPublishedUnknown is returned when the registry does not let anonymous users look for the artifacts.
+var errPublishedUnknown = errors.New("registry requiring credentials")
+
+var bearerChallengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// registryToken exchanges the bearer challenge of the given WWW-Authenticate header for an anonymous token,
+// as done by the token-authenticated registries (e.g. Docker Hub, ghcr.io).
+//
+// Example: Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:falcosecurity/drivers:pull"
+func registryToken(ctx context.Context, client *http.Client, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "bearer") {
+		return "", errPublishedUnknown
+	}
+	values := url.Values{}
+	var realm string
+	for _, m := range bearerChallengeParam.FindAllStringSubmatch(params, -1) {
+		if m[1] == "realm" {
+			realm = m[2]
+		} else {
+			values.Set(m[1], m[2])
+		}
+	}
+	if len(realm) == 0 {
+		return "", fmt.Errorf("invalid registry authentication challenge: %s", challenge)
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	tokenURL.RawQuery = values.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", errPublishedUnknown
+	default:
+		return "", fmt.Errorf("unexpected status getting a registry token from %s: %s", realm, res.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if len(token.Token) > 0 {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// isPublished tells whether all the artifacts requested by b are already published into its registry,
+// getting an anonymous token when the registry asks for one.
+func isPublished(ctx context.Context, b *builder.Build) (bool, error) {
+	var exts []string
+	if len(b.ModuleFilePath) > 0 {
+		exts = append(exts, ".ko")
+	}
+	if len(b.ProbeFilePath) > 0 {
+		exts = append(exts, ".o")
+	}
+	if len(exts) == 0 {
+		return false, nil
+	}
+	client := b.HTTPClient()
+	var token string
+	for _, ext := range exts {
+		u, err := registryManifestURL(b.PublishRegistry, canonicalArtifactTag(b, ext))
+		if err != nil {
+			return false, err
+		}
+		head := func() (*http.Response, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+			if len(token) > 0 {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			res, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			res.Body.Close()
+			return res, nil
+		}
+		res, err := head()
+		if err != nil {
+			return false, err
+		}
+		if res.StatusCode == http.StatusUnauthorized && len(token) == 0 {
+			// the token is scoped to the repository, the same for all the artifacts
+			if token, err = registryToken(ctx, client, res.Header.Get("WWW-Authenticate")); err != nil {
+				return false, err
+			}
+			if res, err = head(); err != nil {
+				return false, err
+			}
+		}
+		switch res.StatusCode {
+		case http.StatusOK:
+			continue
+		case http.StatusNotFound:
+			return false, nil
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return false, errPublishedUnknown
+		default:
+			return false, fmt.Errorf("unexpected status looking for %s: %s", u, res.Status)
+		}
+	}
+	return true, nil
+}
+
+// skipPublished tells whether the build b can be skipped, its artifacts being already published.
+// Failing to ask the registry does not prevent the build.
+func skipPublished(ctx context.Context, b *builder.Build) bool {
+	if !b.SkipIfPublished {
+		return false
+	}
+	published, err := isPublished(ctx, b)
+	if errors.Is(err, errPublishedUnknown) {
+		logger.WithField("registry", b.PublishRegistry).Warn("unknown whether the artifacts are published, the registry requiring credentials, building them")
+		return false
+	}
+	if err != nil {
+		logger.WithError(err).WithField("registry", b.PublishRegistry).Warn("error looking for published artifacts, building them")
+		return false
+	}
+	if published {
+		logger.WithField("registry", b.PublishRegistry).Info("artifacts already published, skipping the build")
+	}
+	return published
+}
@@ -0,0 +1,136 @@
+package driverbuilder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// newFixtureRegistry returns a registry only serving the manifests of the given tags of the drivers repository.
+func newFixtureRegistry(t *testing.T, tags ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.manifest.v1+json") {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.Header.Get("Accept"))
+		}
+		for _, tag := range tags {
+			if r.URL.Path == "/v2/falcosecurity/drivers/manifests/"+tag {
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+func newPublishedTestBuild(registry string) *builder.Build {
+	return &builder.Build{
+		TargetType:       builder.TargetTypeUbuntu,
+		KernelRelease:    "5.15.0-52-generic",
+		KernelVersion:    "58",
+		Architecture:     "amd64",
+		DriverVersion:    "2.0.0+driver",
+		ModuleDriverName: "falco",
+		ModuleFilePath:   "/tmp/falco.ko",
+		ProbeFilePath:    "/tmp/falco.o",
+		SkipIfPublished:  true,
+		PublishRegistry:  registry + "/falcosecurity/drivers",
+	}
+}
+
+func TestCanonicalArtifactTag(t *testing.T) {
+	b := newPublishedTestBuild("")
+	if got, want := canonicalArtifactTag(b, ".ko"), "2.0.0_driver_x86_64_falco_ubuntu_5.15.0-52-generic_58.ko"; got != want {
+		t.Fatalf("Unexpected tag: got %s, want %s", got, want)
+	}
+	b.KernelRelease = strings.Repeat("5.15.0-52-generic", 10)
+	if got := canonicalArtifactTag(b, ".ko"); !strings.HasPrefix(got, "sha256-") || len(got) > maxTagLength {
+		t.Fatalf("Unexpected tag for a long release: %s", got)
+	}
+}
+
+func TestSkipIfPublished(t *testing.T) {
+	b := newPublishedTestBuild("")
+	registry := newFixtureRegistry(t, canonicalArtifactTag(b, ".ko"), canonicalArtifactTag(b, ".o"))
+	defer registry.Close()
+	b.PublishRegistry = registry.URL + "/falcosecurity/drivers"
+
+	published, err := isPublished(context.Background(), b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !published {
+		t.Fatal("Expected the artifacts to be published")
+	}
+
+	// the build is skipped before even reaching the docker daemon
+	t.Setenv("DOCKER_HOST", "tcp://127.0.0.1:1")
+	if err := NewDockerBuildProcessor(60, "").Start(b); err != nil {
+		t.Fatalf("Expected the build to be skipped, got: %s", err)
+	}
+}
+
+func TestSkipIfPublishedMissingArtifact(t *testing.T) {
+	b := newPublishedTestBuild("")
+	// only the module is published
+	registry := newFixtureRegistry(t, canonicalArtifactTag(b, ".ko"))
+	defer registry.Close()
+	b.PublishRegistry = registry.URL + "/falcosecurity/drivers"
+
+	if skipPublished(context.Background(), b) {
+		t.Fatal("Expected the build not to be skipped, the probe not being published")
+	}
+
+	b.ProbeFilePath = ""
+	if !skipPublished(context.Background(), b) {
+		t.Fatal("Expected the build to be skipped, the module being published")
+	}
+}
+
+func TestSkipIfPublishedTokenAuthentication(t *testing.T) {
+	b := newPublishedTestBuild("")
+	published := newFixtureRegistry(t, canonicalArtifactTag(b, ".ko"), canonicalArtifactTag(b, ".o"))
+	defer published.Close()
+	var registry *httptest.Server
+	registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.URL.Query().Get("scope") != "repository:falcosecurity/drivers:pull" {
+				t.Errorf("Unexpected token scope: %s", r.URL.Query().Get("scope"))
+			}
+			w.Write([]byte(`{"token": "anonymous"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer anonymous" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+registry.URL+`/token",service="registry",scope="repository:falcosecurity/drivers:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		published.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer registry.Close()
+	b.PublishRegistry = registry.URL + "/falcosecurity/drivers"
+
+	if !skipPublished(context.Background(), b) {
+		t.Fatal("Expected the build to be skipped, the registry giving anonymous tokens")
+	}
+}
+
+func TestSkipIfPublishedUnauthorized(t *testing.T) {
+	b := newPublishedTestBuild("")
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer registry.Close()
+	b.PublishRegistry = registry.URL + "/falcosecurity/drivers"
+
+	if _, err := isPublished(context.Background(), b); !errors.Is(err, errPublishedUnknown) {
+		t.Fatalf("Expected the published artifacts to be unknown, got: %v", err)
+	}
+	if skipPublished(context.Background(), b) {
+		t.Fatal("Expected the build not to be skipped")
+	}
+}
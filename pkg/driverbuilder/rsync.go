@@ -0,0 +1,99 @@
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	logger "github.com/sirupsen/logrus"
+)
+
+// CanonicalArtifactPath returns the path, relative to the root of a drivers distribution,
+// of the artifact of b with the given extension (i.e. ".ko" or ".o"), following its naming strategy.
+//
+// Example: master/x86_64/falco_ubuntu_5.15.0-52-generic_58.ko
+func CanonicalArtifactPath(b *builder.Build, ext string) string {
+	name := fmt.Sprintf("%s_%s_%s_%s%s", b.ModuleDriverName, b.TargetType, b.KernelRelease, b.KernelVersion, ext)
+	if b.NamingStrategy == builder.NamingStrategyLegacy {
+		return filepath.Join(b.DriverVersion, name)
+	}
+	return filepath.Join(b.DriverVersion, kernelrelease.Architecture(b.Architecture).ToNonDeb(), name)
+}
+
+// rsyncArtifacts transfers the artifacts built for b to its rsync destination, if any,
+// with the canonical layout of the drivers distributions.
+func rsyncArtifacts(ctx context.Context, b *builder.Build) error {
+	if len(b.RsyncDestination) == 0 {
+		return nil
+	}
+
+	// stage the artifacts with the canonical layout, for rsync to create the remote directories
+	staging, err := os.MkdirTemp("", "driverkit-rsync-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	args := []string{"--archive", "--relative"}
+	if len(b.RsyncSSHOptions) > 0 {
+		args = append(args, "--rsh", "ssh "+b.RsyncSSHOptions)
+	}
+	var sources []string
+	for _, artifact := range []struct{ path, ext string }{
+		{b.ModuleFilePath, ".ko"},
+		{b.ProbeFilePath, ".o"},
+	} {
+		if len(artifact.path) == 0 {
+			continue
+		}
+		rel := CanonicalArtifactPath(b, artifact.ext)
+		if err := stageFile(artifact.path, filepath.Join(staging, rel)); err != nil {
+			return err
+		}
+		// the "/./" marks where the relative path to recreate remotely starts
+		sources = append(sources, staging+"/./"+rel)
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	args = append(args, sources...)
+	args = append(args, b.RsyncDestination)
+
+	out, err := exec.CommandContext(ctx, "rsync", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rsync to %s failed: %w: %s", b.RsyncDestination, err, strings.TrimSpace(string(out)))
+	}
+	for _, source := range sources {
+		logger.WithField("destination", b.RsyncDestination).
+			WithField("path", strings.SplitN(source, "/./", 2)[1]).
+			Info("artifact transferred")
+	}
+	return nil
+}
+
+// stageFile copies the file at src to dst, creating its parent directories.
+func stageFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,110 @@
+package driverbuilder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// fakeRsync is a stub of rsync recording its arguments and copying the "--relative" sources
+// to the local destination, as rsync would do remotely.
+const fakeRsync = `#!/bin/sh
+echo "$@" > "$RSYNC_ARGS"
+for dest; do :; done
+for arg; do
+  case "$arg" in
+    */./*) rel="${arg#*/./}"; mkdir -p "$dest/$(dirname "$rel")" && cp "$arg" "$dest/$rel" || exit 1;;
+  esac
+done
+`
+
+func TestRsyncArtifacts(t *testing.T) {
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "rsync"), []byte(fakeRsync), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("RSYNC_ARGS", argsFile)
+
+	out := t.TempDir()
+	module := filepath.Join(out, "falco.ko")
+	probe := filepath.Join(out, "falco.o")
+	for _, p := range []string{module, probe} {
+		if err := os.WriteFile(p, []byte(filepath.Base(p)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	remote := t.TempDir()
+	b := &builder.Build{
+		TargetType:       builder.TargetTypeUbuntu,
+		KernelRelease:    "5.15.0-52-generic",
+		KernelVersion:    "58",
+		Architecture:     "amd64",
+		DriverVersion:    "2.0.0+driver",
+		ModuleDriverName: "falco",
+		ModuleFilePath:   module,
+		ProbeFilePath:    probe,
+		RsyncDestination: remote,
+		RsyncSSHOptions:  "-p 2222",
+	}
+	if err := rsyncArtifacts(context.Background(), b); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for rel, want := range map[string]string{
+		"2.0.0+driver/x86_64/falco_ubuntu_5.15.0-52-generic_58.ko": "falco.ko",
+		"2.0.0+driver/x86_64/falco_ubuntu_5.15.0-52-generic_58.o":  "falco.o",
+	} {
+		got, err := os.ReadFile(filepath.Join(remote, rel))
+		if err != nil {
+			t.Fatalf("Expected %s to be transferred: %s", rel, err)
+		}
+		if string(got) != want {
+			t.Fatalf("Unexpected content of %s: got %q, want %q", rel, got, want)
+		}
+	}
+
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(args), "--archive --relative --rsh ssh -p 2222 ") {
+		t.Fatalf("Unexpected rsync arguments: %s", args)
+	}
+}
+
+func TestRsyncArtifactsNoDestination(t *testing.T) {
+	// without a destination, rsync is not even looked for
+	t.Setenv("PATH", t.TempDir())
+	b := &builder.Build{ModuleFilePath: "/nonexistent/falco.ko"}
+	if err := rsyncArtifacts(context.Background(), b); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
+
+func TestCanonicalArtifactPathNamingStrategy(t *testing.T) {
+	for strategy, want := range map[builder.NamingStrategy]string{
+		"":                            "2.0.0+driver/x86_64/falco_ubuntu_5.15.0-52-generic_58.ko",
+		builder.NamingStrategyCurrent: "2.0.0+driver/x86_64/falco_ubuntu_5.15.0-52-generic_58.ko",
+		builder.NamingStrategyLegacy:  "2.0.0+driver/falco_ubuntu_5.15.0-52-generic_58.ko",
+	} {
+		b := &builder.Build{
+			TargetType:       builder.TargetTypeUbuntu,
+			KernelRelease:    "5.15.0-52-generic",
+			KernelVersion:    "58",
+			Architecture:     "amd64",
+			DriverVersion:    "2.0.0+driver",
+			ModuleDriverName: "falco",
+			NamingStrategy:   strategy,
+		}
+		if got := CanonicalArtifactPath(b, ".ko"); got != want {
+			t.Errorf("Expected %s with the %q naming strategy, got %s", want, strategy, got)
+		}
+	}
+}
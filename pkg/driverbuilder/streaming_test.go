@@ -0,0 +1,91 @@
+package driverbuilder
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+func TestDockerExecPlanStreaming(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer mirror.Close()
+
+	urls := []string{
+		mirror.URL + "/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb",
+		mirror.URL + "/pool/main/l/linux/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb",
+	}
+	supportFiles := []dockerCopyFile{
+		{"/driverkit/kernel.config", "no-data"},
+	}
+
+	for _, streaming := range []bool{false, true} {
+		b := &builder.Build{
+			TargetType:       builder.Type("ubuntu"),
+			KernelRelease:    "5.15.0-1004-intel-iotg",
+			KernelVersion:    "6",
+			Architecture:     "amd64",
+			KernelConfigData: "bm8tZGF0YQ==",
+			DriverVersion:    "master",
+			ModuleFilePath:   "/tmp/falco.ko",
+			ModuleDriverName: "falco",
+			ModuleDeviceName: "falco",
+			RepoOrg:          "falcosecurity",
+			RepoName:         "libs",
+			KernelUrls:       urls,
+			ImagesListers:    []builder.ImagesLister{&testImagesLister{}},
+			Images:           make(builder.ImagesMap),
+			Streaming:        streaming,
+		}
+
+		v, err := builder.Factory(b.TargetType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		script, err := builder.Script(context.Background(), v, b.ToConfig(), b.KernelReleaseFromBuildConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, cmd, stdin := dockerExecPlan(b, script, supportFiles)
+
+		staged := false
+		for _, f := range files {
+			if f.Name == "/driverkit/driverkit.sh" {
+				staged = true
+			}
+		}
+		if staged == streaming {
+			t.Errorf("streaming: %t, expected the build script to be staged: %t, got files %v", streaming, !streaming, files)
+		}
+		if strings.Contains(script, "-o kernel.deb") == streaming {
+			t.Errorf("streaming: %t, expected the kernel headers packages to be staged: %t, got:\n%s", streaming, !streaming, script)
+		}
+
+		if !streaming {
+			if stdin != nil || strings.Join(cmd, " ") != "/bin/bash /driverkit/driverkit.sh" {
+				t.Errorf("expected the staged build script to be run, got %v", cmd)
+			}
+			continue
+		}
+		if strings.Join(cmd, " ") != "/bin/bash -s" || stdin == nil {
+			t.Fatalf("expected the build script to be fed through stdin, got %v", cmd)
+		}
+		fed, err := io.ReadAll(stdin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(fed) != script {
+			t.Errorf("expected the build script to be fed through stdin, got:\n%s", fed)
+		}
+		for _, u := range urls {
+			e := "curl --silent -SL " + u + " | bsdtar -xOf - 'data.tar.*' | bsdtar -xf -\n"
+			if !strings.Contains(script, e) {
+				t.Errorf("expected the script to stream %q, got:\n%s", e, script)
+			}
+		}
+	}
+}
@@ -3,7 +3,6 @@ package driverbuilder
 import (
 	"fmt"
 	"io"
-	"net/http"
 	"strings"
 	"text/template"
 
@@ -56,6 +55,11 @@ KERNELDIR ?= /lib/modules/$(shell uname -r)/build
 
 all:
 	make -C $(KERNELDIR) M={{ .ModuleBuildDir }} modules
+ifneq ($(DRIVERKIT_BUILD),)
+	objcopy --dump-section .modinfo={{ .ModuleName }}.modinfo {{ .ModuleName }}.ko
+	printf '` + builder.ModuleBuildTag + `=%s\000' '$(DRIVERKIT_BUILD)' >> {{ .ModuleName }}.modinfo
+	objcopy --update-section .modinfo={{ .ModuleName }}.modinfo {{ .ModuleName }}.ko
+endif
 
 clean:
 	make -C $(KERNELDIR) M={{ .ModuleBuildDir }} clean
@@ -72,7 +76,7 @@ func renderMakefile(w io.Writer, md makefileData) error {
 
 func LoadMakefileObjList(c builder.Config) (string, error) {
 	makefileUrl := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/driver/Makefile.in", c.RepoOrg, c.RepoName, c.DriverVersion)
-	resp, err := http.Get(makefileUrl)
+	resp, err := c.HTTPClient().Get(makefileUrl)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,129 @@
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/falcosecurity/driverkit/pkg/driverbuilder"
+
+// SetupTracing exports the traces of the builds to the OTLP/HTTP collector at endpoint
+// (e.g. http://localhost:4318), returning a function flushing and stopping the export.
+//
+// Without calling it, tracing is a no-op.
+func SetupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid otel endpoint: %s", endpoint)
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(u.Path) > 0 && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("driverkit"))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// startBuildSpan starts the span of the whole build b.
+func startBuildSpan(ctx context.Context, b *builder.Build) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "build", trace.WithAttributes(
+		attribute.String("driverkit.target", b.TargetType.String()),
+		attribute.String("driverkit.architecture", b.Architecture),
+		attribute.String("driverkit.kernelrelease", b.KernelRelease),
+		attribute.String("driverkit.kernelversion", b.KernelVersion),
+	))
+}
+
+// traceStage runs fn into the span of the given build stage.
+func traceStage(ctx context.Context, stage string, fn func(ctx context.Context) error) error {
+	ctx, span := tracer().Start(ctx, stage)
+	defer span.End()
+	err := fn(ctx)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
+
+var stageMarkerRegex = regexp.MustCompile(regexp.QuoteMeta(builder.StageMarker) + `(\w+)\s*$`)
+
+// stageTracer keeps track of the stages the build scripts go through, from their logs,
+// reporting them to the progress func of the build too.
+type stageTracer struct {
+	ctx   context.Context
+	b     *builder.Build
+	stage string
+	span  trace.Span
+}
+
+func newStageTracer(ctx context.Context, b *builder.Build) *stageTracer {
+	return &stageTracer{ctx: ctx, b: b}
+}
+
+// observe starts the span of the stage entered by the build script printing line, if any.
+func (st *stageTracer) observe(line []byte) {
+	m := stageMarkerRegex.FindSubmatch(line)
+	if m == nil || string(m[1]) == st.stage {
+		return
+	}
+	st.end()
+	st.stage = string(m[1])
+	_, st.span = tracer().Start(st.ctx, st.stage)
+	st.b.Progress(builder.Phase(st.stage), stageProgressMessage(st.b, st.stage))
+}
+
+// stageProgressMessage returns the progress message of the build b entering the given stage.
+func stageProgressMessage(b *builder.Build, stage string) string {
+	switch builder.Phase(stage) {
+	case builder.PhaseDownload:
+		return "downloading headers"
+	case builder.PhaseCompile:
+		var drivers []string
+		if len(b.ModuleFilePath) > 0 {
+			drivers = append(drivers, "module")
+		}
+		if len(b.ProbeFilePath) > 0 {
+			drivers = append(drivers, "probe")
+		}
+		return "compiling " + strings.Join(drivers, " and ")
+	}
+	return stage
+}
+
+// end ends the span of the current stage, if any.
+func (st *stageTracer) end() {
+	if st.span != nil {
+		st.span.End()
+		st.span = nil
+	}
+}
@@ -0,0 +1,110 @@
+package driverbuilder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestBuildSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	defaultProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(defaultProvider)
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer mirror.Close()
+
+	b := &builder.Build{
+		TargetType:       builder.Type("ubuntu"),
+		KernelRelease:    "5.15.0-1004-intel-iotg",
+		KernelVersion:    "6",
+		Architecture:     "amd64",
+		KernelConfigData: "bm8tZGF0YQ==",
+		DriverVersion:    "master",
+		ModuleFilePath:   "/tmp/falco.ko",
+		ModuleDriverName: "falco",
+		ModuleDeviceName: "falco",
+		RepoOrg:          "falcosecurity",
+		RepoName:         "libs",
+		KernelUrls: []string{
+			mirror.URL + "/pool/main/l/linux/linux-headers-5.15.0-1004-intel-iotg_5.15.0-1004.6_amd64.deb",
+			mirror.URL + "/pool/main/l/linux/linux-intel-iotg-headers-5.15.0-1004_5.15.0-1004.6_all.deb",
+		},
+		ImagesListers: []builder.ImagesLister{&testImagesLister{}},
+		Images:        make(builder.ImagesMap),
+	}
+
+	// run the stages of a docker build, with the container logs of the build script
+	ctx, span := startBuildSpan(context.Background(), b)
+	var script string
+	err := traceStage(ctx, "resolution", func(ctx context.Context) error {
+		v, err := builder.Factory(b.TargetType)
+		if err != nil {
+			return err
+		}
+		script, err = builder.Script(ctx, v, b.ToConfig(), b.KernelReleaseFromBuildConfig())
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stage := range []string{"download", "compile"} {
+		if !strings.Contains(script, "echo \""+builder.StageMarker+stage+"\"\n") {
+			t.Fatalf("expected the script to print the %s stage marker, got:\n%s", stage, script)
+		}
+	}
+	containerLog := strings.Join([]string{
+		"+ mkdir /tmp/kernel-download",
+		"+ echo 'driverkit-stage: download'",
+		"driverkit-stage: download",
+		"+ curl --silent -o kernel.deb -SL " + b.KernelUrls[0],
+		"+ echo 'driverkit-stage: compile'",
+		"driverkit-stage: compile",
+		"+ make CC=/usr/bin/gcc-8.0.0 KERNELDIR=/tmp/kernel",
+		"",
+	}, "\n")
+	stages := newStageTracer(ctx, b)
+	forwardLogs(strings.NewReader(containerLog), stages.observe)
+	stages.end()
+	if err := traceStage(ctx, "output", func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	span.End()
+
+	spans := recorder.Ended()
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.Name())
+	}
+	if strings.Join(names, ",") != "resolution,download,compile,output,build" {
+		t.Fatalf("expected the resolution, download, compile and output spans of the build, got %v", names)
+	}
+	build := spans[len(spans)-1]
+	for _, s := range spans[:len(spans)-1] {
+		if s.Parent().SpanID() != build.SpanContext().SpanID() {
+			t.Errorf("expected the %s span to be a child of the build span", s.Name())
+		}
+	}
+	attrs := map[attribute.Key]string{}
+	for _, a := range build.Attributes() {
+		attrs[a.Key] = a.Value.AsString()
+	}
+	for k, v := range map[attribute.Key]string{
+		"driverkit.target":        "ubuntu",
+		"driverkit.architecture":  "amd64",
+		"driverkit.kernelrelease": "5.15.0-1004-intel-iotg",
+	} {
+		if attrs[k] != v {
+			t.Errorf("expected the build span attribute %s to be %s, got %s", k, v, attrs[k])
+		}
+	}
+}
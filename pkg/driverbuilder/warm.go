@@ -0,0 +1,98 @@
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/docker/docker/client"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+)
+
+// imagePuller pulls a builder image for the given architecture.
+type imagePuller func(ctx context.Context, image, arch string) error
+
+// Warm prepares the given builds before running them: it pulls their builder images into the docker daemon
+// and fetches their kernel headers packages into their package cache, concurrently.
+// Up to parallelism kernels are fetched at once, sharing their URLResolver.
+func Warm(ctx context.Context, builds []*builder.Build, parallelism int) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	return warm(ctx, builds, parallelism, func(ctx context.Context, image, arch string) error {
+		return ensureBuilderImage(ctx, cli, image, arch)
+	})
+}
+
+func warm(ctx context.Context, builds []*builder.Build, parallelism int, pull imagePuller) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	shareURLResolvers(builds)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	fail := func(l *logger.Entry, err error, msg string) {
+		mu.Lock()
+		failed++
+		mu.Unlock()
+		l.WithError(err).Error(msg)
+	}
+
+	type imageKey struct{ image, arch string }
+	pulled := make(map[imageKey]bool)
+	slots := make(chan struct{}, parallelism)
+	for _, b := range builds {
+		l := logger.WithField("target", b.TargetType).WithField("kernelrelease", b.KernelRelease)
+		v, err := builder.BuilderForTarget(b.TargetType)
+		if err != nil {
+			fail(l, err, "error warming up the build")
+			continue
+		}
+		kr := b.KernelReleaseFromBuildConfig()
+
+		key := imageKey{b.ResolveBuilderImage(v, kr), b.BuilderArchitecture()}
+		if !pulled[key] {
+			pulled[key] = true
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				l := logger.WithField("image", key.image).WithField("arch", key.arch)
+				if err := pull(ctx, key.image, key.arch); err != nil {
+					fail(l, err, "error pulling the builder image")
+					return
+				}
+				l.Info("builder image available")
+			}()
+		}
+
+		wg.Add(1)
+		go func(b *builder.Build) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			if err := ctx.Err(); err != nil {
+				fail(l, err, "error fetching the kernel headers")
+				return
+			}
+			urls, err := builder.KernelURLs(ctx, v, b.ToConfig(), kr)
+			if err == nil {
+				_, err = builder.FetchPackages(ctx, b, urls)
+			}
+			if err != nil {
+				fail(l, err, "error fetching the kernel headers")
+				return
+			}
+			l.Info("kernel headers cached")
+		}(b)
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		return fmt.Errorf("%d warm-up steps failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package driverbuilder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+func TestWarmPopulatesPackageCache(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("package " + path.Base(r.URL.Path)))
+	}))
+	defer mirror.Close()
+
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	newBuild := func(kr string) *builder.Build {
+		return &builder.Build{
+			TargetType:    builder.Type("ubuntu"),
+			KernelRelease: kr,
+			KernelVersion: "1",
+			Architecture:  "amd64",
+			DriverVersion: "master",
+			KernelUrls: []string{
+				mirror.URL + "/pool/main/l/linux/linux-headers-" + kr + "_amd64.deb",
+				mirror.URL + "/pool/main/l/linux/linux-headers-" + strings.TrimSuffix(kr, "-generic") + "_all.deb",
+			},
+			PackageCacheDir: cacheDir,
+			ImagesListers:   []builder.ImagesLister{&testImagesLister{}},
+			Images:          make(builder.ImagesMap),
+		}
+	}
+	builds := []*builder.Build{newBuild("5.15.0-52-generic"), newBuild("5.19.0-41-generic")}
+
+	var mu sync.Mutex
+	var pulls []string
+	pull := func(ctx context.Context, image, arch string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		pulls = append(pulls, image+"/"+arch)
+		return nil
+	}
+	if err := warm(context.Background(), builds, 2, pull); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pulls) != 1 {
+		t.Errorf("expected the shared builder image to be pulled once, got %v", pulls)
+	}
+	for _, b := range builds {
+		for _, u := range b.KernelUrls {
+			name := path.Base(u)
+			data, err := os.ReadFile(filepath.Join(cacheDir, name))
+			if err != nil {
+				t.Fatalf("package %s not cached: %v", name, err)
+			}
+			if string(data) != "package "+name {
+				t.Errorf("unexpected content of the cached %s: %q", name, data)
+			}
+		}
+	}
+
+	// the builds now use the cached packages
+	v, err := builder.Factory(builds[0].TargetType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := builder.Script(context.Background(), v, builds[0].ToConfig(), builds[0].KernelReleaseFromBuildConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := "file://" + builder.ContainerPackageCacheDir + "/" + path.Base(builds[0].KernelUrls[0])
+	if !strings.Contains(script, cached) {
+		t.Errorf("expected the script to use %s", cached)
+	}
+}
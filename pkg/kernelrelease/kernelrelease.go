@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -11,12 +12,14 @@ import (
 )
 
 var (
-	kernelVersionPattern = regexp.MustCompile(`(?P<fullversion>^(?P<version>0|[1-9]\d*)\.(?P<patchlevel>0|[1-9]\d*)[.+]?(?P<sublevel>0|[1-9]\d*)?)(?P<fullextraversion>[-.+](?P<extraversion>0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)([\.+~](0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-_]*))*)?(\+[0-9a-zA-Z-]+(\.[0-9a-zA-Z-]+)*)?$`)
+	kernelVersionPattern = regexp.MustCompile(`(?P<fullversion>^(?P<version>0|[1-9]\d*)\.(?P<patchlevel>0|[1-9]\d*)[.+]?(?P<sublevel>0|[1-9]\d*)?)(?P<fullextraversion>[-.+](?P<extraversion>0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)([\.+~](\d+|\d*[a-zA-Z-][0-9a-zA-Z-_]*))*)?(\+[0-9a-zA-Z-]+(\.[0-9a-zA-Z-]+)*)?$`)
 )
 
 const (
-	ArchitectureAmd64 = "amd64"
-	ArchitectureArm64 = "arm64"
+	ArchitectureAmd64   = "amd64"
+	ArchitectureArm64   = "arm64"
+	ArchitectureS390x   = "s390x"
+	ArchitectureRiscv64 = "riscv64"
 )
 
 // Architectures is a Map [Architecture] -> non-deb-ArchitectureString
@@ -24,8 +27,18 @@ type Architectures map[Architecture]string
 
 // SupportedArchs enforces the duality of architecture->non-deb one when adding a new one
 var SupportedArchs = Architectures{
-	ArchitectureAmd64: "x86_64",
-	ArchitectureArm64: "aarch64",
+	ArchitectureAmd64:   "x86_64",
+	ArchitectureArm64:   "aarch64",
+	ArchitectureS390x:   "s390x",
+	ArchitectureRiscv64: "riscv64",
+}
+
+// kernelArchs maps each supported architecture to its name into the kernel build system, i.e. its ARCH
+var kernelArchs = map[Architecture]string{
+	ArchitectureAmd64:   "x86",
+	ArchitectureArm64:   "arm64",
+	ArchitectureS390x:   "s390",
+	ArchitectureRiscv64: "riscv",
 }
 
 // Privately cached at startup for quicker access
@@ -35,16 +48,20 @@ var supportedArchsSlice []string
 // is supported, depending on the architecture.
 // See compatibility matrix: https://falco.org/docs/event-sources/drivers/
 var moduleMinKernelVersion = map[Architecture]semver.Version{
-	ArchitectureAmd64: semver.MustParse("2.6.0"),
-	ArchitectureArm64: semver.MustParse("3.16.0"),
+	ArchitectureAmd64:   semver.MustParse("2.6.0"),
+	ArchitectureArm64:   semver.MustParse("3.16.0"),
+	ArchitectureS390x:   semver.MustParse("3.10.0"),
+	ArchitectureRiscv64: semver.MustParse("5.0.0"),
 }
 
 // Represents the minimum kernel version for which building the probe
 // is supported, depending on the architecture.
 // See compatibility matrix: https://falco.org/docs/event-sources/drivers/
 var probeMinKernelVersion = map[Architecture]semver.Version{
-	ArchitectureAmd64: semver.MustParse("4.14.0"),
-	ArchitectureArm64: semver.MustParse("4.17.0"),
+	ArchitectureAmd64:   semver.MustParse("4.14.0"),
+	ArchitectureArm64:   semver.MustParse("4.17.0"),
+	ArchitectureS390x:   semver.MustParse("5.5.0"),
+	ArchitectureRiscv64: semver.MustParse("5.5.0"),
 }
 
 func init() {
@@ -54,6 +71,7 @@ func init() {
 		supportedArchsSlice[i] = k.String()
 		i++
 	}
+	sort.Strings(supportedArchsSlice)
 }
 
 func (aa Architectures) String() string {
@@ -66,6 +84,19 @@ func (aa Architectures) Strings() []string {
 
 type Architecture string
 
+// ParseArchitecture returns the canonical architecture for the given name,
+// accepting the non-deb names too, i.e. x86_64 for amd64 and aarch64 for arm64.
+// Unknown names are returned as they are, for the validation to reject them.
+func ParseArchitecture(name string) Architecture {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for arch, nonDeb := range SupportedArchs {
+		if name == arch.String() || name == nonDeb {
+			return arch
+		}
+	}
+	return Architecture(name)
+}
+
 func (a Architecture) ToNonDeb() string {
 	if val, ok := SupportedArchs[a]; ok {
 		return val
@@ -73,6 +104,14 @@ func (a Architecture) ToNonDeb() string {
 	panic(fmt.Errorf("missing non-deb name for arch: %s", a.String()))
 }
 
+// ToKernel returns the name of the architecture into the kernel build system, i.e. its ARCH.
+func (a Architecture) ToKernel() string {
+	if val, ok := kernelArchs[a]; ok {
+		return val
+	}
+	panic(fmt.Errorf("missing kernel name for arch: %s", a.String()))
+}
+
 func (a Architecture) String() string {
 	return string(a)
 }
@@ -131,3 +170,44 @@ func (k *KernelRelease) SupportsModule() bool {
 func (k *KernelRelease) SupportsProbe() bool {
 	return k.GTE(probeMinKernelVersion[k.Architecture])
 }
+
+var (
+	procVersionPattern       = regexp.MustCompile(`^Linux version (\S+) .*?#(\d+)`)
+	procVersionUbuntuPattern = regexp.MustCompile(`\(Ubuntu \d+\.\d+\.\d+-\d+\.(\d+)\S* \S+\)\s*$`)
+	flavorPattern            = regexp.MustCompile(`^\d+-([a-zA-Z][0-9a-zA-Z-]*)$`)
+)
+
+// ProcVersion contains the kernel release parts found in a /proc/version string.
+type ProcVersion struct {
+	KernelRelease
+	// Flavor is the flavor of the kernel, following the ABI number in its extraversion (eg: generic, aws, amd64).
+	Flavor string
+	// KernelVersion is the build number of the kernel (i.e. `uname -v`); for Ubuntu kernels,
+	// it is the upload number of the kernel package, as found in the version signature.
+	KernelVersion string
+}
+
+// ParseProcVersion extracts a ProcVersion object from a /proc/version string.
+//
+// eg: Linux version 5.15.0-52-generic (buildd@lcy02-amd64-045) (gcc (Ubuntu 11.2.0-19ubuntu1) 11.2.0, GNU ld (GNU Binutils for Ubuntu) 2.38) #58-Ubuntu SMP Thu Oct 13 08:03:55 UTC 2022 (Ubuntu 5.15.0-52.58-generic 5.15.60)
+func ParseProcVersion(s string) (ProcVersion, error) {
+	s = strings.TrimSpace(s)
+	match := procVersionPattern.FindStringSubmatch(s)
+	if match == nil {
+		return ProcVersion{}, fmt.Errorf("not a /proc/version string: %s", s)
+	}
+	pv := ProcVersion{
+		KernelRelease: FromString(match[1]),
+		KernelVersion: match[2],
+	}
+	if len(pv.Fullversion) == 0 {
+		return ProcVersion{}, fmt.Errorf("invalid kernel release: %s", match[1])
+	}
+	if m := flavorPattern.FindStringSubmatch(strings.TrimPrefix(pv.FullExtraversion, "-")); m != nil {
+		pv.Flavor = m[1]
+	}
+	if m := procVersionUbuntuPattern.FindStringSubmatch(s); m != nil {
+		pv.KernelVersion = m[1]
+	}
+	return pv, nil
+}
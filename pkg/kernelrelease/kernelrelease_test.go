@@ -26,6 +26,19 @@ func TestFromString(t *testing.T) {
 				FullExtraversion: "-arch1-1",
 			},
 		},
+		"version with a zero-padded release suffix": {
+			kernelVersionStr: "6.2.0-26-generic-hwe-22.04",
+			want: KernelRelease{
+				Fullversion: "6.2.0",
+				Version: semver.Version{
+					Major: 6,
+					Minor: 2,
+					Patch: 0,
+				},
+				Extraversion:     "26-generic-hwe-22",
+				FullExtraversion: "-26-generic-hwe-22.04",
+			},
+		},
 		"just kernel version": {
 			kernelVersionStr: "5.5.2",
 			want: KernelRelease{
@@ -235,6 +248,14 @@ func TestSupportsModule(t *testing.T) {
 			Version:      semver.Version{Major: 3, Minor: 15, Patch: 99},
 			Architecture: ArchitectureArm64,
 		},
+		{
+			Version:      semver.Version{Major: 3, Minor: 9, Patch: 0},
+			Architecture: ArchitectureS390x,
+		},
+		{
+			Version:      semver.Version{Major: 4, Minor: 19, Patch: 0},
+			Architecture: ArchitectureRiscv64,
+		},
 	}
 	supported := []KernelRelease{
 		{
@@ -265,6 +286,14 @@ func TestSupportsModule(t *testing.T) {
 			Version:      semver.Version{Major: 5, Minor: 0, Patch: 0},
 			Architecture: ArchitectureArm64,
 		},
+		{
+			Version:      semver.Version{Major: 3, Minor: 10, Patch: 0},
+			Architecture: ArchitectureS390x,
+		},
+		{
+			Version:      semver.Version{Major: 5, Minor: 0, Patch: 0},
+			Architecture: ArchitectureRiscv64,
+		},
 	}
 
 	for _, r := range unsupported {
@@ -340,3 +369,101 @@ func TestSupportsProbe(t *testing.T) {
 		}
 	}
 }
+
+func TestParseProcVersion(t *testing.T) {
+	tests := map[string]struct {
+		procVersion string
+		want        ProcVersion
+	}{
+		"ubuntu generic": {
+			procVersion: "Linux version 5.15.0-52-generic (buildd@lcy02-amd64-045) (gcc (Ubuntu 11.2.0-19ubuntu1) 11.2.0, GNU ld (GNU Binutils for Ubuntu) 2.38) #58-Ubuntu SMP Thu Oct 13 08:03:55 UTC 2022 (Ubuntu 5.15.0-52.58-generic 5.15.60)\n",
+			want: ProcVersion{
+				KernelRelease: KernelRelease{
+					Fullversion:      "5.15.0",
+					Version:          semver.Version{Major: 5, Minor: 15, Patch: 0},
+					Extraversion:     "52-generic",
+					FullExtraversion: "-52-generic",
+				},
+				Flavor:        "generic",
+				KernelVersion: "58",
+			},
+		},
+		"ubuntu vendor kernel": {
+			procVersion: "Linux version 5.15.0-1004-intel-iotg (buildd@lcy02-amd64-010) (gcc (Ubuntu 11.2.0-17ubuntu1) 11.2.0, GNU ld (GNU Binutils for Ubuntu) 2.38) #6-Ubuntu SMP Thu Mar 3 16:23:34 UTC 2022 (Ubuntu 5.15.0-1004.6-intel-iotg 5.15.25)",
+			want: ProcVersion{
+				KernelRelease: KernelRelease{
+					Fullversion:      "5.15.0",
+					Version:          semver.Version{Major: 5, Minor: 15, Patch: 0},
+					Extraversion:     "1004-intel-iotg",
+					FullExtraversion: "-1004-intel-iotg",
+				},
+				Flavor:        "intel-iotg",
+				KernelVersion: "6",
+			},
+		},
+		"ubuntu hwe": {
+			procVersion: "Linux version 5.4.0-131-generic (buildd@lcy02-amd64-108) (gcc version 7.5.0 (Ubuntu 7.5.0-3ubuntu1~18.04)) #147~18.04.1-Ubuntu SMP Sat Oct 15 13:10:18 UTC 2022 (Ubuntu 5.4.0-131.147~18.04.1-generic 5.4.210)",
+			want: ProcVersion{
+				KernelRelease: KernelRelease{
+					Fullversion:      "5.4.0",
+					Version:          semver.Version{Major: 5, Minor: 4, Patch: 0},
+					Extraversion:     "131-generic",
+					FullExtraversion: "-131-generic",
+				},
+				Flavor:        "generic",
+				KernelVersion: "147",
+			},
+		},
+		"debian": {
+			procVersion: "Linux version 5.10.0-21-amd64 (debian-kernel@lists.debian.org) (gcc-10 (Debian 10.2.1-6) 10.2.1 20210110, GNU ld (GNU Binutils for Debian) 2.35.2) #1 SMP Debian 5.10.162-1 (2023-01-21)",
+			want: ProcVersion{
+				KernelRelease: KernelRelease{
+					Fullversion:      "5.10.0",
+					Version:          semver.Version{Major: 5, Minor: 10, Patch: 0},
+					Extraversion:     "21-amd64",
+					FullExtraversion: "-21-amd64",
+				},
+				Flavor:        "amd64",
+				KernelVersion: "1",
+			},
+		},
+		"centos": {
+			procVersion: "Linux version 4.18.0-348.el8.x86_64 (mockbuild@kbuilder.bsys.centos.org) (gcc version 8.5.0 20210514 (Red Hat 8.5.0-4) (GCC)) #1 SMP Tue Nov 16 14:09:11 UTC 2021",
+			want: ProcVersion{
+				KernelRelease: KernelRelease{
+					Fullversion:      "4.18.0",
+					Version:          semver.Version{Major: 4, Minor: 18, Patch: 0},
+					Extraversion:     "348",
+					FullExtraversion: "-348.el8.x86_64",
+				},
+				KernelVersion: "1",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseProcVersion(tt.procVersion)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.want, got)
+		})
+	}
+
+	_, err := ParseProcVersion("5.15.0-52-generic")
+	assert.ErrorContains(t, err, "not a /proc/version string")
+}
+
+func TestParseArchitecture(t *testing.T) {
+	for name, want := range map[string]Architecture{
+		"amd64":   ArchitectureAmd64,
+		"x86_64":  ArchitectureAmd64,
+		"X86_64":  ArchitectureAmd64,
+		"arm64":   ArchitectureArm64,
+		"aarch64": ArchitectureArm64,
+		"s390x":   ArchitectureS390x,
+		"riscv64": ArchitectureRiscv64,
+	} {
+		if got := ParseArchitecture(name); got != want {
+			t.Errorf("ParseArchitecture(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
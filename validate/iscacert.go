@@ -0,0 +1,22 @@
+package validate
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/go-playground/validator/v10"
+)
+
+func isCACert(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.String:
+		data, err := os.ReadFile(field.String())
+		return err == nil && x509.NewCertPool().AppendCertsFromPEM(data)
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
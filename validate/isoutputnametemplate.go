@@ -0,0 +1,21 @@
+package validate
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/go-playground/validator/v10"
+)
+
+func isOutputNameTemplate(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.String:
+		_, err := builder.ParseOutputNameTemplate(field.String())
+		return err == nil
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
@@ -2,6 +2,7 @@ package validate
 
 import (
 	"fmt"
+	"net/url"
 	"reflect"
 	"regexp"
 
@@ -15,7 +16,8 @@ func isProxy(fl validator.FieldLevel) bool {
 
 	switch field.Kind() {
 	case reflect.String:
-		return proxyRegex.MatchString(field.String())
+		_, err := url.Parse(field.String())
+		return proxyRegex.MatchString(field.String()) && err == nil
 	}
 
 	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
@@ -0,0 +1,23 @@
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// rateRegex matches the transfer rates understood by curl --limit-rate (eg: 500k, 5m, 1g)
+var rateRegex = regexp.MustCompile("^[1-9][0-9]*[kKmMgG]?$")
+
+func isRate(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.String:
+		return rateRegex.MatchString(field.String())
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
@@ -41,7 +41,11 @@ func init() {
 	V.RegisterValidation("semver", isSemVer)
 	V.RegisterValidation("semvertolerant", isSemVerTolerant)
 	V.RegisterValidation("proxy", isProxy)
+	V.RegisterValidation("cacert", isCACert)
 	V.RegisterValidation("imagename", isImageName)
+	V.RegisterValidation("rate", isRate)
+	V.RegisterValidation("urlrewrite", isURLRewrite)
+	V.RegisterValidation("outputnametemplate", isOutputNameTemplate)
 
 	eng := en.New()
 	uni := ut.New(eng, eng)
@@ -61,6 +65,58 @@ func init() {
 		},
 	)
 
+	V.RegisterTranslation(
+		"rate",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("rate", "{0} must be a valid transfer rate in bytes per second, with an optional k, m or g suffix (eg: 5m)", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("rate", fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"urlrewrite",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("urlrewrite", "{0} must be valid url rewrite rules, as regex=>replacement (eg: ^https://mirrors.edge.kernel.org/=>https://cdn.example.com/)", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("urlrewrite", fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"outputnametemplate",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("outputnametemplate", "{0} must be a valid template of the base name of the artifacts (eg: falco_{{ .Target }}_{{ .KernelRelease }}_{{ .Arch }})", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("outputnametemplate", fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"excluded_with",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("excluded_with", "{0} must not be set along with {1}", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("excluded_with", fe.Field(), fe.Param())
+
+			return t
+		},
+	)
+
 	V.RegisterTranslation(
 		"target",
 		T,
@@ -203,4 +259,17 @@ func init() {
 			return t
 		},
 	)
+
+	V.RegisterTranslation(
+		"cacert",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("cacert", "{0} must be a PEM bundle of CA certificates", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
 }